@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/tragicpixel/fruitbar/pkg/client"
+)
+
+// newLoginCommand returns the command logging in to the users service and keeping the token in the profile.
+func (a *app) newLoginCommand() *cobra.Command {
+	var username string
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in, and keep the token in the profile",
+		Long: "Log in to the users service, and keep the token in the profile for the commands that follow. Tokens last 24 hours.\n" +
+			"The password is read from " + passwordEnv + " if set, and prompted for otherwise. It is never kept.",
+		Args: cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			profile, err := a.profile()
+			if err != nil {
+				return err
+			}
+			if username == "" {
+				username = profile.Username
+			}
+			if username == "" {
+				return errors.New("no user to log in as (pass --username)")
+			}
+			password := os.Getenv(passwordEnv)
+			if a.dryRun {
+				password = "PASSWORD"
+			} else if password == "" {
+				if password, err = promptPassword(username); err != nil {
+					return err
+				}
+			}
+			config := client.Config{UsersURL: profile.UsersURL, Username: username, Password: password}
+			if a.dryRun {
+				config.HTTPClient, config.MaxRetries = newClientDryRun()
+			}
+			c := client.New(config)
+			if err := c.Login(ctx); errors.Is(err, errDryRun) {
+				return err
+			} else if err != nil {
+				return fmt.Errorf("failed to log in as %s: %s", username, err.Error())
+			}
+			return a.updateProfile(func(profile *Profile) {
+				profile.Username, profile.Token = username, c.Token()
+			})
+		}),
+	}
+	cmd.Flags().StringVarP(&username, "username", "u", "", "name of the user to log in as (defaults to the user of the profile)")
+	return cmd
+}
+
+// promptPassword prompts for the password of the supplied user on the standard error, and reads it from the standard input.
+func promptPassword(username string) (string, error) {
+	fmt.Fprintf(os.Stderr, "Password for %s: ", username)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read the password: %s", err.Error())
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// newLogoutCommand returns the command dropping the token of the profile.
+func (a *app) newLogoutCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logout",
+		Short: "Drop the token kept in the profile",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(_ context.Context, cmd *cobra.Command, args []string) error {
+			if _, err := a.profile(); err != nil {
+				return err
+			}
+			return a.updateProfile(func(profile *Profile) {
+				profile.Token = ""
+			})
+		}),
+	}
+}
+
+// newHealthCommand returns the command checking the health of the services.
+func (a *app) newHealthCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "health",
+		Short: "Check the health of the services",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			// Health checks need no login.
+			config, err := a.clientConfig()
+			if err != nil {
+				return err
+			}
+			c := client.New(config)
+			type health struct {
+				Service string `json:"service"`
+				Healthy bool   `json:"healthy"`
+				Error   string `json:"error,omitempty"`
+			}
+			checks := []struct {
+				service string
+				check   func(context.Context) (bool, error)
+			}{{"orders", c.OrdersHealthy}, {"users", c.UsersHealthy}, {"products", c.ProductsHealthy}}
+			var results []health
+			t := table{header: []string{"SERVICE", "HEALTHY", "ERROR"}}
+			unhealthy := 0
+			for _, check := range checks {
+				ok, err := check.check(ctx)
+				if errors.Is(err, errDryRun) {
+					continue
+				}
+				result := health{Service: check.service, Healthy: ok && err == nil}
+				if err != nil {
+					result.Error = err.Error()
+				}
+				if !result.Healthy {
+					unhealthy++
+				}
+				results = append(results, result)
+				t.rows = append(t.rows, []string{result.Service, fmt.Sprint(result.Healthy), result.Error})
+			}
+			if a.dryRun {
+				return nil
+			}
+			if err := a.print(results, t); err != nil {
+				return err
+			}
+			if unhealthy > 0 {
+				return fmt.Errorf("%d of %d services unhealthy", unhealthy, len(checks))
+			}
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Name of the profile created on first use.
+const defaultProfileName = "default"
+
+// Base URLs of the services when run locally. (see cmd/orders, cmd/users and cmd/products)
+const (
+	defaultOrdersURL   = "http://localhost:8000"
+	defaultUsersURL    = "http://localhost:8001"
+	defaultProductsURL = "http://localhost:8002"
+)
+
+// Config holds the configuration file of the command line: a set of profiles, one of which is current.
+type Config struct {
+	// Name of the profile used unless another is chosen with --profile.
+	Current  string              `yaml:"current"`
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// Profile holds the services to talk to, and who to talk to them as.
+type Profile struct {
+	OrdersURL   string `yaml:"ordersURL"`
+	UsersURL    string `yaml:"usersURL"`
+	ProductsURL string `yaml:"productsURL"`
+	// Name of the user to log in as.
+	Username string `yaml:"username,omitempty"`
+	// Token of the last login. The password is never kept.
+	Token string `yaml:"token,omitempty"`
+}
+
+// newProfile returns a new profile for the services run locally.
+func newProfile() *Profile {
+	return &Profile{OrdersURL: defaultOrdersURL, UsersURL: defaultUsersURL, ProductsURL: defaultProductsURL}
+}
+
+// defaultConfigPath returns the path of the configuration file in the user's configuration directory.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "fruitbar", "fruitbarctl.yaml")
+}
+
+// loadConfig reads the configuration file. A missing file is an empty configuration, with a default profile.
+func (a *app) loadConfig() (*Config, error) {
+	config := &Config{Current: defaultProfileName, Profiles: map[string]*Profile{}}
+	data, err := ioutil.ReadFile(a.configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		config.Profiles[defaultProfileName] = newProfile()
+		return config, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration file: %s", err.Error())
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration file %s: %s", a.configPath, err.Error())
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]*Profile{}
+	}
+	return config, nil
+}
+
+// saveConfig writes the supplied configuration to the configuration file. Only the user can read it, as it holds tokens.
+func (a *app) saveConfig(config *Config) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(a.configPath), 0700); err != nil {
+		return fmt.Errorf("failed to create the configuration directory: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(a.configPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write the configuration file: %s", err.Error())
+	}
+	return nil
+}
+
+// profileNameIn returns the name of the selected profile in the supplied configuration: the one chosen with --profile, or the current one.
+func (a *app) profileNameIn(config *Config) string {
+	if a.profileName != "" {
+		return a.profileName
+	}
+	if config.Current != "" {
+		return config.Current
+	}
+	return defaultProfileName
+}
+
+// profile returns the selected profile.
+func (a *app) profile() (*Profile, error) {
+	config, err := a.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	name := a.profileNameIn(config)
+	profile, ok := config.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile '%s' does not exist (create it with 'fruitbarctl profile set %s')", name, name)
+	}
+	return profile, nil
+}
+
+// updateProfile applies the supplied change to the selected profile, creating it if needed, and saves it.
+func (a *app) updateProfile(change func(profile *Profile)) error {
+	config, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	name := a.profileNameIn(config)
+	profile, ok := config.Profiles[name]
+	if !ok {
+		profile = newProfile()
+		config.Profiles[name] = profile
+	}
+	change(profile)
+	return a.saveConfig(config)
+}
+
+// newProfileCommand returns the command managing profiles.
+func (a *app) newProfileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage the profiles holding the services to talk to and the login",
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the profiles",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(_ context.Context, cmd *cobra.Command, args []string) error {
+			config, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			// Tokens are left out, as they would let anyone reading the output act as the user.
+			type summary struct {
+				Name        string `json:"name"`
+				Current     bool   `json:"current"`
+				Username    string `json:"username"`
+				LoggedIn    bool   `json:"loggedIn"`
+				OrdersURL   string `json:"ordersURL"`
+				UsersURL    string `json:"usersURL"`
+				ProductsURL string `json:"productsURL"`
+			}
+			current := a.profileNameIn(config)
+			var profiles []summary
+			t := table{header: []string{"CURRENT", "NAME", "USERNAME", "LOGGED IN", "USERS URL"}}
+			for _, name := range profileNames(config) {
+				p := config.Profiles[name]
+				profiles = append(profiles, summary{name, name == current, p.Username, p.Token != "", p.OrdersURL, p.UsersURL, p.ProductsURL})
+				t.rows = append(t.rows, []string{mark(name == current), name, p.Username, mark(p.Token != ""), p.UsersURL})
+			}
+			return a.print(profiles, t)
+		}),
+	}
+
+	var set Profile
+	setCmd := &cobra.Command{
+		Use:   "set NAME",
+		Short: "Create a profile, or change the services or user of one",
+		Long:  "Create a profile, or change the services or user of one. A new profile talks to the services run locally, unless told otherwise.",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(_ context.Context, cmd *cobra.Command, args []string) error {
+			a.profileName = args[0]
+			return a.updateProfile(func(profile *Profile) {
+				for _, field := range []struct {
+					value *string
+					set   string
+				}{{&profile.OrdersURL, set.OrdersURL}, {&profile.UsersURL, set.UsersURL}, {&profile.ProductsURL, set.ProductsURL}} {
+					if field.set != "" {
+						*field.value = strings.TrimSuffix(field.set, "/")
+					}
+				}
+				if set.Username != "" && set.Username != profile.Username {
+					profile.Username, profile.Token = set.Username, ""
+				}
+			})
+		}),
+	}
+	setCmd.Flags().StringVar(&set.OrdersURL, "orders-url", "", "base URL of the orders service")
+	setCmd.Flags().StringVar(&set.UsersURL, "users-url", "", "base URL of the users service")
+	setCmd.Flags().StringVar(&set.ProductsURL, "products-url", "", "base URL of the products service")
+	setCmd.Flags().StringVar(&set.Username, "username", "", "name of the user to log in as")
+
+	use := &cobra.Command{
+		Use:               "use NAME",
+		Short:             "Make a profile the current one",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: a.completeProfiles,
+		RunE: a.run(func(_ context.Context, cmd *cobra.Command, args []string) error {
+			config, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Profiles[args[0]]; !ok {
+				return fmt.Errorf("profile '%s' does not exist", args[0])
+			}
+			config.Current = args[0]
+			return a.saveConfig(config)
+		}),
+	}
+
+	deleteCmd := &cobra.Command{
+		Use:               "delete NAME",
+		Short:             "Delete a profile",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: a.completeProfiles,
+		RunE: a.run(func(_ context.Context, cmd *cobra.Command, args []string) error {
+			config, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			if _, ok := config.Profiles[args[0]]; !ok {
+				return fmt.Errorf("profile '%s' does not exist", args[0])
+			}
+			delete(config.Profiles, args[0])
+			if config.Current == args[0] {
+				config.Current = defaultProfileName
+			}
+			return a.saveConfig(config)
+		}),
+	}
+
+	cmd.AddCommand(list, setCmd, use, deleteCmd)
+	return cmd
+}
+
+// profileNames returns the names of the profiles of the supplied configuration, sorted.
+func profileNames(config *Config) []string {
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeProfiles completes the names of the profiles.
+func (a *app) completeProfiles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := a.loadConfig()
+	if err != nil || len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return profileNames(config), cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// Token sent in dry runs when the profile isn't logged in.
+const dryRunToken = "TOKEN"
+
+// errDryRun is returned for a request printed instead of sent.
+var errDryRun = errors.New("dry run: request not sent")
+
+// sendKey is the context key marking requests sent even in a dry run.
+type sendKey struct{}
+
+// reading returns a copy of the supplied context whose requests are sent even in a dry run.
+// Used for the reads a command needs to build the request it changes something with, such as the current version of a record.
+func reading(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sendKey{}, true)
+}
+
+// newClientDryRun returns the http client and number of retries of a client doing a dry run: printing its requests to the standard output, and never retrying them.
+func newClientDryRun() (*http.Client, int) {
+	return &http.Client{Transport: &dryRunTransport{out: os.Stdout, next: http.DefaultTransport}}, -1
+}
+
+// dryRunTransport is an http transport printing the requests it is given instead of sending them, unless they are reads. (see reading)
+type dryRunTransport struct {
+	out  io.Writer
+	next http.RoundTripper
+}
+
+// RoundTrip prints the supplied request and returns errDryRun, or sends it if it is a read.
+func (t *dryRunTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if send, _ := r.Context().Value(sendKey{}).(bool); send {
+		return t.next.RoundTrip(r)
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintf(t.out, "%s %s\n", r.Method, r.URL.String())
+	var names []string
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			if name == "Authorization" && value != "Bearer "+dryRunToken {
+				value = "Bearer REDACTED"
+			}
+			fmt.Fprintf(t.out, "%s: %s\n", name, value)
+		}
+	}
+	if len(body) > 0 {
+		var indented bytes.Buffer
+		if encjson.Indent(&indented, body, "", "  ") == nil {
+			body = indented.Bytes()
+		}
+		fmt.Fprintf(t.out, "\n%s\n", body)
+	}
+	return nil, errDryRun
+}
@@ -0,0 +1,125 @@
+// Command fruitbarctl administers the fruitbar services from the command line: users, products, orders and their health.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/tragicpixel/fruitbar/pkg/client"
+)
+
+// Environment variable holding the password to log in with, instead of prompting for it.
+const passwordEnv = "FRUITBAR_PASSWORD"
+
+// app holds the global options of the command line, and the state shared by its commands.
+type app struct {
+	// Path of the configuration file holding the profiles.
+	configPath string
+	// Name of the profile to use. (defaults to the current profile)
+	profileName string
+	// Format to print records in: table, json or yaml.
+	output string
+	// Whether to print the requests that would be sent instead of sending them.
+	dryRun bool
+	// Timeout of each command.
+	timeout time.Duration
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := newRootCommand().ExecuteContext(ctx); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newRootCommand returns the root command of the command line, with all its subcommands.
+func newRootCommand() *cobra.Command {
+	a := &app{}
+	root := &cobra.Command{
+		Use:   "fruitbarctl",
+		Short: "Administer the fruitbar services",
+		Long: "fruitbarctl administers the fruitbar services: it manages users, products and orders, and checks the health of the services.\n" +
+			"Log in first with 'fruitbarctl login'. The services to talk to and the token are kept in profiles. (see 'fruitbarctl profile')",
+		SilenceUsage: true,
+	}
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.configPath, "config", defaultConfigPath(), "path of the configuration file holding the profiles")
+	flags.StringVarP(&a.profileName, "profile", "p", "", "profile to use (defaults to the current profile)")
+	flags.StringVarP(&a.output, "output", "o", outputTable, "format to print records in: table, json or yaml")
+	flags.BoolVar(&a.dryRun, "dry-run", false, "print the HTTP requests that would be sent instead of sending them")
+	flags.DurationVar(&a.timeout, "timeout", 30*time.Second, "time allowed for the command")
+	root.RegisterFlagCompletionFunc("output", fixedCompletions(outputTable, outputJSON, outputYAML))
+	root.RegisterFlagCompletionFunc("profile", a.completeProfiles)
+
+	root.AddCommand(
+		a.newLoginCommand(),
+		a.newLogoutCommand(),
+		a.newProfileCommand(),
+		a.newUsersCommand(),
+		a.newProductsCommand(),
+		a.newOrdersCommand(),
+		a.newHealthCommand(),
+	)
+	return root
+}
+
+// run returns the function running a command: the supplied one, bounded by the timeout of the command.
+// A dry run ending on the request it printed is not an error, and a refused token points at the login command.
+func (a *app) run(fn func(ctx context.Context, cmd *cobra.Command, args []string) error) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := context.WithTimeout(cmd.Context(), a.timeout)
+		defer cancel()
+		err := fn(ctx, cmd, args)
+		switch {
+		case errors.Is(err, errDryRun):
+			return nil
+		case errors.Is(err, client.ErrUnauthorized):
+			return fmt.Errorf("%s (log in again with 'fruitbarctl login', or set %s)", err.Error(), passwordEnv)
+		}
+		return err
+	}
+}
+
+// newClient returns a client for the services of the selected profile, authenticating with its token.
+// The client logs in again on its own only if the password is set in the environment.
+func (a *app) newClient() (*client.Client, error) {
+	config, err := a.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Token == "" && config.Username == "" {
+		return nil, fmt.Errorf("not logged in (log in with 'fruitbarctl login', or set %s)", passwordEnv)
+	}
+	return client.New(config), nil
+}
+
+// clientConfig returns the configuration of a client for the services of the selected profile. (see newClient)
+func (a *app) clientConfig() (client.Config, error) {
+	profile, err := a.profile()
+	if err != nil {
+		return client.Config{}, err
+	}
+	config := client.Config{
+		OrdersURL:   profile.OrdersURL,
+		UsersURL:    profile.UsersURL,
+		ProductsURL: profile.ProductsURL,
+		Token:       profile.Token,
+	}
+	if password := os.Getenv(passwordEnv); password != "" {
+		config.Username, config.Password = profile.Username, password
+	}
+	if a.dryRun {
+		config.HTTPClient, config.MaxRetries = newClientDryRun()
+		if config.Token == "" {
+			// Print the requests as they would be sent once logged in, rather than the login.
+			config.Token = dryRunToken
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/tragicpixel/fruitbar/pkg/client"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// newOrdersCommand returns the command managing orders.
+func (a *app) newOrdersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "orders",
+		Aliases: []string{"order"},
+		Short:   "Manage orders",
+	}
+
+	var list listFlags
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List orders",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			var orders []*models.Order
+			it := c.ListOrders(ctx, list.options())
+			for list.more(len(orders)) && it.Next() {
+				orders = append(orders, it.Order())
+			}
+			if err := it.Err(); err != nil {
+				return err
+			}
+			return a.print(orders, ordersTable(orders...))
+		}),
+	}
+	list.register(listCmd)
+
+	var include string
+	get := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show an order",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			order, err := c.GetOrder(ctx, id, &client.ReadOptions{Include: include})
+			if err != nil {
+				return err
+			}
+			return a.print(order, ordersTable(order))
+		}),
+	}
+	get.Flags().StringVar(&include, "include", "", "related records to embed, separated by commas: owner, items.product (for the json and yaml output)")
+	get.RegisterFlagCompletionFunc("include", fixedCompletions("owner", "items.product"))
+
+	var createFile string
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create an order from a JSON file",
+		Long:  "Create an order from a JSON file holding its items and payment information. Its subtotal, tax and total are calculated by the orders service.",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			var order models.Order
+			if err := readRecord(createFile, &order); err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			created, err := c.CreateOrder(ctx, &order)
+			if err != nil {
+				return err
+			}
+			return a.print(created, ordersTable(created))
+		}),
+	}
+	registerFileFlag(create, &createFile)
+
+	var updateFile string
+	update := &cobra.Command{
+		Use:   "update",
+		Short: "Replace an order with the one in a JSON file",
+		Long:  "Replace an order with the one in a JSON file, which must hold its id and the version it was changed from. (as printed by 'orders get ID -o json')",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			var order models.Order
+			if err := readRecord(updateFile, &order); err != nil {
+				return err
+			}
+			if order.ID == 0 {
+				return errors.New("the order to update has no id")
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			updated, err := c.UpdateOrder(ctx, &order)
+			if err != nil {
+				return err
+			}
+			return a.print(updated, ordersTable(updated))
+		}),
+	}
+	registerFileFlag(update, &updateFile)
+
+	var deleteVersion uint
+	deleteCmd := &cobra.Command{
+		Use:   "delete ID",
+		Short: "Delete an order, along with its items",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			return fromVersion(ctx, deleteVersion, orderVersion(c, id), func(version uint) error {
+				return c.DeleteOrder(ctx, id, version)
+			})
+		}),
+	}
+	registerVersionFlag(deleteCmd, &deleteVersion)
+
+	var repriceVersion uint
+	reprice := &cobra.Command{
+		Use:   "reprice ID",
+		Short: "Charge the items of a pending order the current price of their products",
+		Long:  "Charge each item of a pending order the current price of its product. Items are otherwise charged the price of their product when they were ordered.",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			var order *models.Order
+			err = fromVersion(ctx, repriceVersion, orderVersion(c, id), func(version uint) error {
+				order, err = c.RepriceOrder(ctx, id, version)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return a.print(order, ordersTable(order))
+		}),
+	}
+	registerVersionFlag(reprice, &repriceVersion)
+
+	cmd.AddCommand(listCmd, get, create, update, deleteCmd, reprice)
+	return cmd
+}
+
+// orderVersion returns a function reading the current version of the order with the supplied id.
+func orderVersion(c *client.Client, id uint) func(ctx context.Context) (uint, error) {
+	return func(ctx context.Context) (uint, error) {
+		order, err := c.GetOrder(ctx, id, nil)
+		if err != nil {
+			return 0, err
+		}
+		return order.Version, nil
+	}
+}
+
+// ordersTable returns the supplied orders as a table.
+func ordersTable(orders ...*models.Order) table {
+	t := table{header: []string{"ID", "OWNER", "ITEMS", "TOTAL", "STATUS", "CREATED", "VERSION"}}
+	for _, o := range orders {
+		t.rows = append(t.rows, []string{uintString(o.ID), optionalUintString(o.OwnerID), uintString(uint(len(o.Items))), money(o.Total), o.Status, timeString(o.CreatedAt), uintString(o.Version)})
+	}
+	return t
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats records can be printed in.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// table holds records laid out as rows of columns, to print as a table.
+type table struct {
+	header []string
+	rows   [][]string
+}
+
+// print prints the supplied records to the standard output in the chosen format: the supplied table, or the records encoded as JSON or YAML.
+func (a *app) print(records interface{}, t table) error {
+	return a.printTo(os.Stdout, records, t)
+}
+
+// printTo prints the supplied records to the supplied writer in the chosen format. (see print)
+func (a *app) printTo(out io.Writer, records interface{}, t table) error {
+	switch a.output {
+	case outputTable:
+		w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, strings.Join(t.header, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	case outputJSON:
+		data, err := encjson.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case outputYAML:
+		data, err := toYAML(records)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown output format '%s', expected one of: %s, %s, %s", a.output, outputTable, outputJSON, outputYAML)
+	}
+}
+
+// toYAML encodes the supplied records as YAML, with the same field names and order as their JSON encoding.
+func toYAML(records interface{}) ([]byte, error) {
+	data, err := encjson.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, and decoding it into a yaml.MapSlice keeps the fields in order.
+	var value interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var fields yaml.MapSlice
+		err = yaml.Unmarshal(data, &fields)
+		value = fields
+	} else {
+		var items []yaml.MapSlice
+		if err = yaml.Unmarshal(data, &items); err != nil {
+			err = yaml.Unmarshal(data, &value)
+		} else {
+			value = items
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(value)
+}
+
+// uintString returns the supplied unsigned integer as a string.
+func uintString(n uint) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+// optionalUintString returns the supplied unsigned integer as a string, or "-" if it is nil.
+func optionalUintString(n *uint) string {
+	if n == nil {
+		return "-"
+	}
+	return uintString(*n)
+}
+
+// money returns the supplied amount of dollars as a string.
+func money(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// timeString returns the supplied time as a string, in the local time zone.
+func timeString(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// mark returns a mark for a true column value, and nothing for a false one.
+func mark(b bool) string {
+	if b {
+		return "*"
+	}
+	return ""
+}
+
+// fixedCompletions returns a completion function completing the supplied values.
+func fixedCompletions(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
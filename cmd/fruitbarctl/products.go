@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/tragicpixel/fruitbar/pkg/client"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// newProductsCommand returns the command managing products.
+func (a *app) newProductsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "products",
+		Aliases: []string{"product"},
+		Short:   "Manage products and their stock",
+	}
+
+	var list listFlags
+	var status string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List products",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			opts := list.options()
+			opts.Status = status
+			var products []*models.Product
+			it := c.ListProducts(ctx, opts)
+			for list.more(len(products)) && it.Next() {
+				products = append(products, it.Product())
+			}
+			if err := it.Err(); err != nil {
+				return err
+			}
+			return a.print(products, productsTable(products...))
+		}),
+	}
+	list.register(listCmd)
+	listCmd.Flags().StringVar(&status, "status", "", "products to list by status: active (default), inactive (archived) or all")
+	listCmd.RegisterFlagCompletionFunc("status", fixedCompletions("active", "inactive", "all"))
+
+	get := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show a product",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			product, err := c.GetProduct(ctx, id, nil)
+			if err != nil {
+				return err
+			}
+			return a.print(product, productsTable(product))
+		}),
+	}
+
+	var createFile string
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create a product from a JSON file",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			var product models.Product
+			if err := readRecord(createFile, &product); err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			created, err := c.CreateProduct(ctx, &product)
+			if err != nil {
+				return err
+			}
+			return a.print(created, productsTable(created))
+		}),
+	}
+	registerFileFlag(create, &createFile)
+
+	var updateFile string
+	update := &cobra.Command{
+		Use:   "update",
+		Short: "Replace a product with the one in a JSON file",
+		Long:  "Replace a product with the one in a JSON file, which must hold its id and the version it was changed from. (as printed by 'products get ID -o json')",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			var product models.Product
+			if err := readRecord(updateFile, &product); err != nil {
+				return err
+			}
+			if product.ID == 0 {
+				return errors.New("the product to update has no id")
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			updated, err := c.UpdateProduct(ctx, &product)
+			if err != nil {
+				return err
+			}
+			return a.print(updated, productsTable(updated))
+		}),
+	}
+	registerFileFlag(update, &updateFile)
+
+	var deleteVersion uint
+	deleteCmd := &cobra.Command{
+		Use:   "delete ID",
+		Short: "Archive a product",
+		Long:  "Archive a product: it can no longer be ordered, but the orders it is in keep it, and it can be restored with 'fruitbarctl products restore'.",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			return fromVersion(ctx, deleteVersion, productVersion(c, id), func(version uint) error {
+				return c.DeleteProduct(ctx, id, version)
+			})
+		}),
+	}
+	registerVersionFlag(deleteCmd, &deleteVersion)
+
+	restore := &cobra.Command{
+		Use:   "restore ID",
+		Short: "Put an archived product back in the catalog",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			product, err := c.RestoreProduct(ctx, id)
+			if err != nil {
+				return err
+			}
+			return a.print(product, productsTable(product))
+		}),
+	}
+
+	purge := &cobra.Command{
+		Use:   "purge ID",
+		Short: "Remove an archived product for good",
+		Long:  "Remove an archived product for good. Products that are in any order can't be purged.",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			return c.PurgeProduct(ctx, id)
+		}),
+	}
+
+	var adjustBy, adjustTo int
+	var adjustVersion uint
+	adjust := &cobra.Command{
+		Use:   "adjust-stock ID",
+		Short: "Change the number of a product in stock",
+		Long:  "Change the number of a product in stock, either by a number of units (--by 10, --by -3) or to a number of units. (--to 25)",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			by, to := cmd.Flags().Changed("by"), cmd.Flags().Changed("to")
+			if by == to {
+				return errors.New("pass either --by or --to")
+			}
+			if to && adjustTo < 0 {
+				return fmt.Errorf("the number in stock can't be negative, got %d", adjustTo)
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			var current, product *models.Product
+			read := func(ctx context.Context) (uint, error) {
+				if current, err = c.GetProduct(ctx, id, nil); err != nil {
+					return 0, err
+				}
+				return current.Version, nil
+			}
+			err = fromVersion(ctx, 0, read, func(version uint) error {
+				if adjustVersion != 0 && version != adjustVersion {
+					return fmt.Errorf("product %d has changed since version %d (it is at version %d)", id, adjustVersion, version)
+				}
+				stock := adjustTo
+				if by {
+					if stock = current.NumInStock + adjustBy; stock < 0 {
+						return fmt.Errorf("product %d has only %d in stock", id, current.NumInStock)
+					}
+				}
+				product, err = c.PatchProduct(ctx, id, version, client.MergePatch(map[string]int{"numInStock": stock}))
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return a.print(product, productsTable(product))
+		}),
+	}
+	adjust.Flags().IntVar(&adjustBy, "by", 0, "number of units to add, or remove if negative")
+	adjust.Flags().IntVar(&adjustTo, "to", 0, "number of units to set the stock to")
+	registerVersionFlag(adjust, &adjustVersion)
+
+	cmd.AddCommand(listCmd, get, create, update, deleteCmd, restore, purge, adjust)
+	return cmd
+}
+
+// productVersion returns a function reading the current version of the product with the supplied id.
+func productVersion(c *client.Client, id uint) func(ctx context.Context) (uint, error) {
+	return func(ctx context.Context) (uint, error) {
+		product, err := c.GetProduct(ctx, id, nil)
+		if err != nil {
+			return 0, err
+		}
+		return product.Version, nil
+	}
+}
+
+// productsTable returns the supplied products as a table.
+func productsTable(products ...*models.Product) table {
+	t := table{header: []string{"ID", "NAME", "SYMBOL", "PRICE", "IN STOCK", "VERSION"}}
+	for _, p := range products {
+		t.rows = append(t.rows, []string{uintString(p.ID), p.Name, p.Symbol, money(p.Price), fmt.Sprint(p.NumInStock), uintString(p.Version)})
+	}
+	return t
+}
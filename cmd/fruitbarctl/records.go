@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/tragicpixel/fruitbar/pkg/client"
+)
+
+// Number of times a change made from the current version of a record is retried when the record changes in between.
+const maxVersionConflicts = 3
+
+// listFlags holds the flags of the commands listing records.
+type listFlags struct {
+	filter string
+	sort   string
+	fields string
+	max    int
+}
+
+// register registers the flags on the supplied command.
+func (f *listFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.filter, "filter", "", "only list records matching all of these conditions, separated by semicolons (e.g. 'price>1;name~apple')")
+	cmd.Flags().StringVar(&f.sort, "sort", "", "fields to sort by, separated by commas and prefixed with - to sort descending (e.g. -price)")
+	cmd.Flags().StringVar(&f.fields, "fields", "", "fields to return, separated by commas (for the json and yaml output)")
+	cmd.Flags().IntVar(&f.max, "max", 0, "maximum number of records to list (0 for all of them)")
+}
+
+// options returns the flags as the options of a listing.
+func (f *listFlags) options() *client.ListOptions {
+	opts := &client.ListOptions{Filter: f.filter, Sort: f.sort, Fields: f.fields}
+	if f.max > 0 {
+		opts.Limit = f.max
+	}
+	return opts
+}
+
+// more determines whether a listing that has listed the supplied number of records should list more.
+func (f *listFlags) more(listed int) bool {
+	return f.max <= 0 || listed < f.max
+}
+
+// parseID parses the supplied command line argument as the id of a record.
+func parseID(arg string) (uint, error) {
+	id, err := strconv.ParseUint(arg, 10, 0)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid id '%s', expected a positive integer", arg)
+	}
+	return uint(id), nil
+}
+
+// readRecord decodes the JSON record in the file at the supplied path (- for the standard input) into the supplied destination.
+func readRecord(path string, record interface{}) error {
+	if path == "" {
+		return errors.New("no record to read (pass --file, or --file - for the standard input)")
+	}
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the record: %s", err.Error())
+	}
+	if err := encjson.Unmarshal(data, record); err != nil {
+		return fmt.Errorf("failed to decode the record in %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// registerFileFlag registers the flag naming the file holding the record of the supplied command.
+func registerFileFlag(cmd *cobra.Command, path *string) {
+	cmd.Flags().StringVarP(path, "file", "f", "", "file holding the record as JSON (- for the standard input)")
+	cmd.MarkFlagRequired("file")
+	cmd.MarkFlagFilename("file", "json")
+}
+
+// registerVersionFlag registers the flag holding the version of the record the supplied command changes.
+func registerVersionFlag(cmd *cobra.Command, version *uint) {
+	cmd.Flags().UintVar(version, "version", 0, "version of the record the change is made from, refused if it has changed since (defaults to the current version)")
+}
+
+// fromVersion runs the supplied change from the supplied version of a record, or from its current version, read with the supplied function, if none is supplied.
+// When the record changes between the read and the change, it is read again and the change retried.
+func fromVersion(ctx context.Context, version uint, current func(ctx context.Context) (uint, error), change func(version uint) error) error {
+	if version != 0 {
+		return change(version)
+	}
+	for conflicts := 0; ; conflicts++ {
+		version, err := current(reading(ctx))
+		if err != nil {
+			return err
+		}
+		err = change(version)
+		if !errors.Is(err, client.ErrPreconditionFailed) || conflicts >= maxVersionConflicts {
+			return err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/tragicpixel/fruitbar/pkg/client"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+)
+
+// newUsersCommand returns the command managing users.
+func (a *app) newUsersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "users",
+		Aliases: []string{"user"},
+		Short:   "Manage users and their roles",
+	}
+
+	var list listFlags
+	var status string
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			opts := list.options()
+			opts.Status = status
+			var users []*models.User
+			it := c.ListUsers(ctx, opts)
+			for list.more(len(users)) && it.Next() {
+				users = append(users, it.User())
+			}
+			if err := it.Err(); err != nil {
+				return err
+			}
+			return a.print(users, usersTable(users...))
+		}),
+	}
+	list.register(listCmd)
+	listCmd.Flags().StringVar(&status, "status", "", "users to list by status: active (default), inactive or all")
+	listCmd.RegisterFlagCompletionFunc("status", fixedCompletions("active", "inactive", "all"))
+
+	get := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			user, err := c.GetUser(ctx, id, nil)
+			if err != nil {
+				return err
+			}
+			return a.print(user, usersTable(user))
+		}),
+	}
+
+	var createFile string
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user from a JSON file",
+		Long:  "Create a user from a JSON file holding its name, password and role. (see 'fruitbarctl users password-format')",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			var user models.User
+			if err := readRecord(createFile, &user); err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			created, err := c.CreateUser(ctx, &user)
+			if err != nil {
+				return err
+			}
+			return a.print(created, usersTable(created))
+		}),
+	}
+	registerFileFlag(create, &createFile)
+
+	var updateFile string
+	update := &cobra.Command{
+		Use:   "update",
+		Short: "Replace a user with the one in a JSON file",
+		Long:  "Replace a user with the one in a JSON file, which must hold its id and the version it was changed from. (as printed by 'users get ID -o json')",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			var user models.User
+			if err := readRecord(updateFile, &user); err != nil {
+				return err
+			}
+			if user.ID == 0 {
+				return errors.New("the user to update has no id")
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			updated, err := c.UpdateUser(ctx, &user)
+			if err != nil {
+				return err
+			}
+			return a.print(updated, usersTable(updated))
+		}),
+	}
+	registerFileFlag(update, &updateFile)
+
+	var deleteVersion uint
+	deleteCmd := &cobra.Command{
+		Use:   "delete ID",
+		Short: "Deactivate a user",
+		Long:  "Deactivate a user: it can no longer log in, but it is kept and can be restored with 'fruitbarctl users restore'.",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			return fromVersion(ctx, deleteVersion, userVersion(c, id), func(version uint) error {
+				return c.DeleteUser(ctx, id, version)
+			})
+		}),
+	}
+	registerVersionFlag(deleteCmd, &deleteVersion)
+
+	restore := &cobra.Command{
+		Use:   "restore ID",
+		Short: "Reactivate a deactivated user",
+		Args:  cobra.ExactArgs(1),
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			user, err := c.RestoreUser(ctx, id)
+			if err != nil {
+				return err
+			}
+			return a.print(user, usersTable(user))
+		}),
+	}
+
+	var roleVersion uint
+	setRole := &cobra.Command{
+		Use:   "set-role ID ROLE",
+		Short: "Change the role of a user",
+		Long:  "Change the role of a user to one of: " + roles.ValidRolesMsg(),
+		Args:  cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 1 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return roles.ValidRoles(), cobra.ShellCompDirectiveNoFileComp
+		},
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			id, err := parseID(args[0])
+			if err != nil {
+				return err
+			}
+			role := args[1]
+			if err := roles.IsValid(role); err != nil {
+				return err
+			}
+			c, err := a.newClient()
+			if err != nil {
+				return err
+			}
+			var user *models.User
+			err = fromVersion(ctx, roleVersion, userVersion(c, id), func(version uint) error {
+				user, err = c.PatchUser(ctx, id, version, client.MergePatch(map[string]string{"role": role}))
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			return a.print(user, usersTable(user))
+		}),
+	}
+	registerVersionFlag(setRole, &roleVersion)
+
+	passwordFormat := &cobra.Command{
+		Use:   "password-format",
+		Short: "Show the requirements a password must meet",
+		Args:  cobra.NoArgs,
+		RunE: a.run(func(ctx context.Context, cmd *cobra.Command, args []string) error {
+			// The requirements are public, so need no login.
+			config, err := a.clientConfig()
+			if err != nil {
+				return err
+			}
+			requirements, err := client.New(config).PasswordFormat(ctx)
+			if err != nil {
+				return err
+			}
+			t := table{header: []string{"REQUIREMENT"}}
+			for _, requirement := range requirements {
+				t.rows = append(t.rows, []string{requirement})
+			}
+			return a.print(requirements, t)
+		}),
+	}
+
+	cmd.AddCommand(listCmd, get, create, update, deleteCmd, restore, setRole, passwordFormat)
+	return cmd
+}
+
+// userVersion returns a function reading the current version of the user with the supplied id.
+func userVersion(c *client.Client, id uint) func(ctx context.Context) (uint, error) {
+	return func(ctx context.Context) (uint, error) {
+		user, err := c.GetUser(ctx, id, nil)
+		if err != nil {
+			return 0, err
+		}
+		return user.Version, nil
+	}
+}
+
+// usersTable returns the supplied users as a table.
+func usersTable(users ...*models.User) table {
+	t := table{header: []string{"ID", "NAME", "ROLE", "CREATED", "VERSION"}}
+	for _, u := range users {
+		t.rows = append(t.rows, []string{uintString(u.ID), u.Name, u.Role, timeString(u.CreatedAt), uintString(u.Version)})
+	}
+	return t
+}
@@ -2,12 +2,16 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	"github.com/tragicpixel/fruitbar/pkg/repository/cache"
 	"github.com/tragicpixel/fruitbar/pkg/service"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
 
 	"github.com/sirupsen/logrus"
 )
@@ -15,6 +19,17 @@ import (
 func main() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 
+	// Records are kept in postgres, unless --storage=memory is passed, for tests and local development,
+	// or --storage=sqlite, to keep them in the SQLite database file at FRUITBAR_DB_FILE (fruitbar.db by default), which the other services can share.
+	// The admin user the service then starts with has the password in FRUITBAR_ADMIN_PASSWORD, or a random one which is logged.
+	storageName := flag.String("storage", string(service.StoragePostgres), "where to keep the records: postgres, sqlite or memory")
+	flag.Parse()
+	storage, err := service.ParseStorage(*storageName)
+	if err != nil {
+		logrus.Error("failed to parse --storage:" + err.Error())
+		panic("failed to parse --storage:" + err.Error())
+	}
+
 	connection := pgdriver.PostgresConnectionConfig{
 		Host:     os.Getenv("FRUITBAR_DATABASE_SERVICE_NAME"),
 		Port:     "5423",
@@ -23,8 +38,47 @@ func main() {
 		Password: "fruitbar",
 	}
 
+	// Responses to requests made with an Idempotency-Key are kept for a day, unless FRUITBAR_IDEMPOTENCY_KEY_TTL is set. (e.g. 1h)
+	var idempotencyKeyTTL time.Duration
+	if ttl := os.Getenv("FRUITBAR_IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		var err error
+		idempotencyKeyTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			logrus.Error("failed to parse FRUITBAR_IDEMPOTENCY_KEY_TTL:" + err.Error())
+			panic("failed to parse FRUITBAR_IDEMPOTENCY_KEY_TTL:" + err.Error())
+		}
+	}
+
+	// Products read from postgres or SQLite are cached for 10 seconds, unless FRUITBAR_PRODUCT_CACHE_TTL is set. (e.g. 1m)
+	// Products changed through the products service are only seen here once their cached copy expires.
+	var productCacheTTL time.Duration
+	if ttl := os.Getenv("FRUITBAR_PRODUCT_CACHE_TTL"); ttl != "" {
+		var err error
+		productCacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			logrus.Error("failed to parse FRUITBAR_PRODUCT_CACHE_TTL:" + err.Error())
+			panic("failed to parse FRUITBAR_PRODUCT_CACHE_TTL:" + err.Error())
+		}
+	}
+
+	// Traffic isn't validated against the service's OpenAPI document, unless FRUITBAR_OPENAPI_VALIDATION is set. (requests, or test to validate the responses too)
+	openAPIValidation, err := openapi.ParseMode(os.Getenv("FRUITBAR_OPENAPI_VALIDATION"))
+	if err != nil {
+		logrus.Error("failed to parse FRUITBAR_OPENAPI_VALIDATION:" + err.Error())
+		panic("failed to parse FRUITBAR_OPENAPI_VALIDATION:" + err.Error())
+	}
+
+	config := service.OrdersServiceConfig{
+		DatabaseConnection: &connection,
+		Port:               8000,
+		IdempotencyKeyTTL:  idempotencyKeyTTL,
+		OpenAPIValidation:  openAPIValidation,
+		Storage:            storage,
+		AdminPassword:      os.Getenv("FRUITBAR_ADMIN_PASSWORD"),
+		ProductCache:       cache.Config{TTL: productCacheTTL},
+	}
 	// TODO: Wait time + Retry count for connecting to DB, don't just immediately fail.
-	FruitBarOrdersService, err := service.NewOrdersService(8000, &connection)
+	FruitBarOrdersService, err := service.NewOrdersService(&config)
 	if err != nil {
 		logrus.Error("failed to create the orders service:" + err.Error())
 		panic("failed to create the orders service:" + err.Error())
@@ -2,12 +2,16 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
 	"github.com/tragicpixel/fruitbar/pkg/service"
 	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
 )
 
 const (
@@ -31,6 +35,47 @@ func main() {
 	config := service.ProductsServiceConfig{
 		Port: 8002,
 	}
+	// Records are kept in postgres, unless --storage=memory is passed, for tests and local development,
+	// or --storage=sqlite, to keep them in the SQLite database file at FRUITBAR_DB_FILE (fruitbar.db by default), which the other services can share.
+	// The admin user the service then starts with has the password in FRUITBAR_ADMIN_PASSWORD, or a random one which is logged.
+	storageName := flag.String("storage", string(service.StoragePostgres), "where to keep the records: postgres, sqlite or memory")
+	flag.Parse()
+	storage, err := service.ParseStorage(*storageName)
+	if err != nil {
+		msg := "failed to parse --storage:"
+		log.Error(msg + err.Error())
+		panic(msg + err.Error())
+	}
+	config.Storage = storage
+	config.AdminPassword = os.Getenv("FRUITBAR_ADMIN_PASSWORD")
+	// Responses to requests made with an Idempotency-Key are kept for a day, unless FRUITBAR_IDEMPOTENCY_KEY_TTL is set. (e.g. 1h)
+	if ttl := os.Getenv("FRUITBAR_IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		idempotencyKeyTTL, err := time.ParseDuration(ttl)
+		if err != nil {
+			msg := "failed to parse FRUITBAR_IDEMPOTENCY_KEY_TTL:"
+			log.Error(msg + err.Error())
+			panic(msg + err.Error())
+		}
+		config.IdempotencyKeyTTL = idempotencyKeyTTL
+	}
+	// Products read from postgres or SQLite are cached for 10 seconds, unless FRUITBAR_PRODUCT_CACHE_TTL is set. (e.g. 1m)
+	if ttl := os.Getenv("FRUITBAR_PRODUCT_CACHE_TTL"); ttl != "" {
+		productCacheTTL, err := time.ParseDuration(ttl)
+		if err != nil {
+			msg := "failed to parse FRUITBAR_PRODUCT_CACHE_TTL:"
+			log.Error(msg + err.Error())
+			panic(msg + err.Error())
+		}
+		config.ProductCache.TTL = productCacheTTL
+	}
+	// Traffic isn't validated against the service's OpenAPI document, unless FRUITBAR_OPENAPI_VALIDATION is set. (requests, or test to validate the responses too)
+	openAPIValidation, err := openapi.ParseMode(os.Getenv("FRUITBAR_OPENAPI_VALIDATION"))
+	if err != nil {
+		msg := "failed to parse FRUITBAR_OPENAPI_VALIDATION:"
+		log.Error(msg + err.Error())
+		panic(msg + err.Error())
+	}
+	config.OpenAPIValidation = openAPIValidation
 	connection := pgdriver.PostgresConnectionConfig{
 		Host:     "localhost", // just for testing the API functionality, once that's ironed out, go back to docker method
 		Port:     "5423",
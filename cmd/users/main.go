@@ -2,12 +2,17 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	"github.com/tragicpixel/fruitbar/pkg/handler"
 	"github.com/tragicpixel/fruitbar/pkg/service"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
 
 	"github.com/sirupsen/logrus"
 )
@@ -15,6 +20,17 @@ import (
 func main() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 
+	// Records are kept in postgres, unless --storage=memory is passed, for tests and local development,
+	// or --storage=sqlite, to keep them in the SQLite database file at FRUITBAR_DB_FILE (fruitbar.db by default), which the other services can share.
+	// The admin user the service then starts with has the password in FRUITBAR_ADMIN_PASSWORD, or a random one which is logged.
+	storageName := flag.String("storage", string(service.StoragePostgres), "where to keep the records: postgres, sqlite or memory")
+	flag.Parse()
+	storage, err := service.ParseStorage(*storageName)
+	if err != nil {
+		logrus.Error("failed to parse --storage:" + err.Error())
+		panic("failed to parse --storage:" + err.Error())
+	}
+
 	// Unless you set these on your local machine, the service won't be able to run...but you should be running it in docker.
 	connection := pgdriver.PostgresConnectionConfig{
 		Host:     os.Getenv("FRUITBAR_DATABASE_SERVICE_NAME"),
@@ -24,7 +40,41 @@ func main() {
 		Password: "fruitbar",
 	}
 
-	FruitbarUsersService, err := service.NewUsersService(8001, &connection)
+	// OIDC sign in is only enabled if FRUITBAR_OIDC_ISSUER_URL is set.
+	oidcConfig, err := oidc.NewConfigFromEnv()
+	if err != nil {
+		logrus.Error("failed to configure OIDC sign in:" + err.Error())
+		panic("failed to configure OIDC sign in:" + err.Error())
+	}
+
+	// Responses to requests made with an Idempotency-Key are kept for a day, unless FRUITBAR_IDEMPOTENCY_KEY_TTL is set. (e.g. 1h)
+	var idempotencyKeyTTL time.Duration
+	if ttl := os.Getenv("FRUITBAR_IDEMPOTENCY_KEY_TTL"); ttl != "" {
+		idempotencyKeyTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			logrus.Error("failed to parse FRUITBAR_IDEMPOTENCY_KEY_TTL:" + err.Error())
+			panic("failed to parse FRUITBAR_IDEMPOTENCY_KEY_TTL:" + err.Error())
+		}
+	}
+
+	// Traffic isn't validated against the service's OpenAPI document, unless FRUITBAR_OPENAPI_VALIDATION is set. (requests, or test to validate the responses too)
+	openAPIValidation, err := openapi.ParseMode(os.Getenv("FRUITBAR_OPENAPI_VALIDATION"))
+	if err != nil {
+		logrus.Error("failed to parse FRUITBAR_OPENAPI_VALIDATION:" + err.Error())
+		panic("failed to parse FRUITBAR_OPENAPI_VALIDATION:" + err.Error())
+	}
+
+	config := service.UsersServiceConfig{
+		DatabaseConnection: &connection,
+		Port:               8001,
+		OIDC:               oidcConfig,
+		OrderRetention:     handler.OrderRetentionPolicy(os.Getenv("FRUITBAR_ORDER_RETENTION_POLICY")), // retain or delete
+		IdempotencyKeyTTL:  idempotencyKeyTTL,
+		OpenAPIValidation:  openAPIValidation,
+		Storage:            storage,
+		AdminPassword:      os.Getenv("FRUITBAR_ADMIN_PASSWORD"),
+	}
+	FruitbarUsersService, err := service.NewUsersService(&config)
 	if err != nil {
 		logrus.Error("failed to create the users service:" + err.Error())
 		panic("failed to create the users service:" + err.Error())
@@ -1,27 +1,6 @@
 // Package fruitbar Fruitbar API
 //
 // Allows access to an API for managing fruit orders, product listings, and users.
-//
-//   version: 1.0.0
-//   title: Fruitbar Authentication Service
-//  Schemes:
-//    -http
-//    -https
-//  Host: localhost:8001
-//  BasePath: /
-//	Consumes:
-//	  - application/json
-//  Produces:
-//    - application/json
-// Security:
-// - bearer
-//
-// SecurityDefinitions:
-// bearer:
-//    type: apiKey
-//    in: header
-//    name: authorization
-//    bearerFormat: JWT
-//    description: JSON Web Token
-// swagger:meta
+// Each service describes its own endpoints in an OpenAPI 3.1 document served at /openapi.json, built from its route table. (see pkg/utils/openapi)
+// Errors are sent as application/problem+json to clients whose Accept header prefers it.
 package main
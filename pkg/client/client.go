@@ -0,0 +1,373 @@
+// Package client provides a typed Go client for the fruitbar orders, products and users services.
+// It logs in and refreshes its token as needed, retries requests that failed with a server error or were rate limited,
+// iterates over paginated listings and returns the errors sent by the services as *Error values.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+const (
+	// Number of times a failed request is retried, unless configured otherwise.
+	defaultMaxRetries = 3
+	// Delay before the first retry of a failed request, unless configured otherwise. Doubled on every retry after that.
+	defaultRetryBaseDelay = 200 * time.Millisecond
+	// Longest delay between two tries of a request, unless configured otherwise.
+	defaultRetryMaxDelay = 5 * time.Second
+	// How long before its token expires the client logs in again.
+	tokenRefreshMargin = time.Minute
+)
+
+// Config holds the configuration of a client.
+type Config struct {
+	// Base URLs of the services. (e.g. http://localhost:8000) Only the services the client calls need to be set.
+	OrdersURL   string
+	UsersURL    string
+	ProductsURL string
+	// Credentials the client logs in with when it first needs a token, and again whenever its token expires or is refused.
+	Username string
+	Password string
+	// Token to authenticate with instead of logging in, e.g. one issued for impersonation. It is never refreshed. (optional)
+	Token string
+	// Client making the http requests. (defaults to http.DefaultClient)
+	HTTPClient *http.Client
+	// Number of times a request failing with a server error, a 429 Too Many Requests or a network error is retried. (defaults to 3, negative to never retry)
+	MaxRetries int
+	// Delay before the first retry, doubled on every retry after that. A Retry-After header sent by the service takes precedence. (defaults to 200ms)
+	RetryBaseDelay time.Duration
+	// Longest delay between two tries of a request. (defaults to 5s)
+	RetryMaxDelay time.Duration
+}
+
+// Client makes requests to the fruitbar services. It is safe for concurrent use.
+type Client struct {
+	config Config
+	http   *http.Client
+
+	mu sync.Mutex
+	// Token the client authenticates with, and when it expires. (zero if it doesn't)
+	token          string
+	tokenExpiresAt time.Time
+}
+
+// New creates and returns a new client with the supplied configuration.
+func New(config Config) *Client {
+	c := &Client{config: config, http: config.HTTPClient, token: config.Token}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	if c.config.MaxRetries == 0 {
+		c.config.MaxRetries = defaultMaxRetries
+	}
+	if c.config.RetryBaseDelay == 0 {
+		c.config.RetryBaseDelay = defaultRetryBaseDelay
+	}
+	if c.config.RetryMaxDelay == 0 {
+		c.config.RetryMaxDelay = defaultRetryMaxDelay
+	}
+	if c.token != "" {
+		c.tokenExpiresAt = tokenExpiry(c.token)
+	}
+	return c
+}
+
+// request holds a request to one of the services.
+type request struct {
+	method  string
+	baseURL string
+	path    string
+	query   url.Values
+	header  http.Header
+	// Body of the request, encoded as JSON unless it is already encoded. (nil if there is none)
+	body interface{}
+	// Media type of the body. (defaults to application/json)
+	contentType string
+	// Whether the request needs the client's token.
+	auth bool
+}
+
+// response holds the response to a request, read in full.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// envelope holds the standard response of the services, with its data left encoded until the caller knows its type. (see json.Response)
+type envelope struct {
+	Data  encjson.RawMessage  `json:"data"`
+	Id    string              `json:"id"`
+	Token string              `json:"token"`
+	Error *json.ErrorResponse `json:"error"`
+	Page  *json.Page          `json:"page,omitempty"`
+}
+
+// decode decodes the response as a standard response, and its data into the supplied destination. (if not nil)
+func (r *response) decode(data interface{}) (*envelope, error) {
+	var env envelope
+	if err := encjson.Unmarshal(r.body, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %s", err.Error())
+	}
+	if data != nil && len(env.Data) > 0 {
+		if err := encjson.Unmarshal(env.Data, data); err != nil {
+			return nil, fmt.Errorf("failed to decode response data: %s", err.Error())
+		}
+	}
+	return &env, nil
+}
+
+// do sends the supplied request and returns the response, once it is neither a server error nor rate limited or the retries run out.
+// Unsafe requests are sent with an Idempotency-Key header, the same on every retry, so a retried request is never applied twice.
+// When the token of the client is refused, the client logs in again and sends the request once more.
+// Returns an *Error if the response is an error.
+func (c *Client) do(ctx context.Context, req *request) (*response, error) {
+	if req.baseURL == "" {
+		return nil, errors.New("the base URL of the service is not configured")
+	}
+	target := strings.TrimSuffix(req.baseURL, "/") + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	var body []byte
+	if req.body != nil {
+		if encoded, ok := req.body.([]byte); ok {
+			body = encoded
+		} else {
+			var err error
+			if body, err = encjson.Marshal(req.body); err != nil {
+				return nil, fmt.Errorf("failed to encode request: %s", err.Error())
+			}
+		}
+	}
+	var idempotencyKey string
+	if req.method != http.MethodGet {
+		idempotencyKey = newIdempotencyKey()
+	}
+
+	reauthenticated := false
+	for attempt := 0; ; attempt++ {
+		r, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range req.header {
+			r.Header[name] = values
+		}
+		// Ask for errors in the problem details format, which tells kinds of errors apart.
+		r.Header.Set("Accept", json.ProblemMediaType+", application/json;q=0.9")
+		if body != nil {
+			contentType := req.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			r.Header.Set("Content-Type", contentType)
+		}
+		if idempotencyKey != "" {
+			r.Header.Set(httputils.IdempotencyKeyHeader, idempotencyKey)
+		}
+		if req.auth {
+			token, err := c.authToken(ctx)
+			if err != nil {
+				return nil, err
+			}
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := c.http.Do(r)
+		if err != nil {
+			if ctx.Err() != nil || attempt >= c.config.MaxRetries {
+				return nil, err
+			}
+			if err := c.wait(ctx, c.retryDelay(attempt, nil)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response: %s", err.Error())
+		}
+		res := &response{status: resp.StatusCode, header: resp.Header, body: data}
+
+		if res.status == http.StatusUnauthorized && req.auth && !reauthenticated && c.canLogin() {
+			// The token expired early or was revoked: log in again, without counting it as a retry.
+			c.forgetToken()
+			reauthenticated = true
+			attempt--
+			continue
+		}
+		if retryable(res) && attempt < c.config.MaxRetries {
+			if err := c.wait(ctx, c.retryDelay(attempt, res.header)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if res.status >= http.StatusBadRequest {
+			return nil, newError(res)
+		}
+		return res, nil
+	}
+}
+
+// retryable determines whether the supplied response is worth retrying the request for: a server error, a 429 Too Many Requests,
+// or a 409 Conflict the service asked to retry later, sent when the first try of the request is still being handled.
+func retryable(res *response) bool {
+	switch {
+	case res.status >= http.StatusInternalServerError, res.status == http.StatusTooManyRequests:
+		return true
+	case res.status == http.StatusConflict:
+		return res.header.Get("Retry-After") != ""
+	default:
+		return false
+	}
+}
+
+// retryDelay returns how long to wait before retrying a request for the supplied time, after a response with the supplied headers. (nil if there was none)
+func (c *Client) retryDelay(attempt int, header http.Header) time.Duration {
+	if header != nil {
+		if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	delay := time.Duration(float64(c.config.RetryBaseDelay) * math.Pow(2, float64(attempt)))
+	if delay > c.config.RetryMaxDelay || delay <= 0 {
+		delay = c.config.RetryMaxDelay
+	}
+	return delay
+}
+
+// wait waits for the supplied duration, or until the supplied context is done.
+func (c *Client) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// newIdempotencyKey returns a new random key for an unsafe request.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Login logs in to the users service with the credentials of the client, and keeps the token for the requests that follow.
+// Clients log in on their own when they first need a token, so this is only needed to check the credentials early.
+func (c *Client) Login(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.login(ctx)
+}
+
+// login logs in to the users service with the credentials of the client. The caller must hold the client's lock.
+func (c *Client) login(ctx context.Context) error {
+	credentials := struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}{c.config.Username, c.config.Password}
+	res, err := c.do(ctx, &request{method: http.MethodPost, baseURL: c.config.UsersURL, path: usersLoginPath, body: credentials})
+	if err != nil {
+		return err
+	}
+	env, err := res.decode(nil)
+	if err != nil {
+		return err
+	}
+	if env.Token == "" {
+		return errors.New("login response holds no token")
+	}
+	c.token, c.tokenExpiresAt = env.Token, tokenExpiry(env.Token)
+	return nil
+}
+
+// Token returns the token the client currently authenticates with, e.g. to keep it for a later client. (empty if it has none yet)
+func (c *Client) Token() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// authToken returns the token to authenticate with, logging in first if the client has none or it is about to expire.
+func (c *Client) authToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expiring := !c.tokenExpiresAt.IsZero() && time.Until(c.tokenExpiresAt) < tokenRefreshMargin
+	if (c.token == "" || expiring) && c.canLogin() {
+		if err := c.login(ctx); err != nil {
+			return "", err
+		}
+	}
+	if c.token == "" {
+		return "", errors.New("no credentials to log in with")
+	}
+	return c.token, nil
+}
+
+// canLogin determines whether the client has credentials to log in with.
+func (c *Client) canLogin() bool {
+	return c.config.Username != ""
+}
+
+// forgetToken drops the token of the client, so it logs in again before its next request.
+func (c *Client) forgetToken() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.tokenExpiresAt = "", time.Time{}
+}
+
+// tokenExpiry returns when the supplied JSON Web Token expires, read from its exp claim without verifying it. (zero if unknown)
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		ExpiresAt int64 `json:"exp"`
+	}
+	if err := encjson.Unmarshal(payload, &claims); err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// idPath returns the supplied path with the supplied ids appended as segments.
+func idPath(path string, ids ...uint) string {
+	for _, id := range ids {
+		path += "/" + strconv.FormatUint(uint64(id), 10)
+	}
+	return path
+}
+
+// ifMatch returns the headers of a request changing the record with the supplied version, which is refused if the record has changed since.
+func ifMatch(version uint) http.Header {
+	return http.Header{"If-Match": {httputils.ETag(version)}}
+}
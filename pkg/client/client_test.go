@@ -0,0 +1,447 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/jsonpatch"
+	"gorm.io/gorm"
+)
+
+// Most tests run the client against a fake server speaking the services' wire format: the same response envelopes, error formats
+// and headers, written with the same json and http utilities, so the tests control exactly what the client receives.
+// services_test.go runs it against the real services, keeping their records in memory.
+
+// fakeServices emulates the endpoints of the services the tests need, and records the requests it receives.
+type fakeServices struct {
+	t      *testing.T
+	router *mux.Router
+
+	mu sync.Mutex
+	// Token the server accepts, and the tokens it hands out on each login, in order. (the last one is repeated)
+	validToken  string
+	loginTokens []string
+	logins      int
+	// Requests received on the routes registered with handle, by route name.
+	requests map[string][]*http.Request
+}
+
+// newFakeServices starts a server emulating the services, and returns it along with a client configured to use it.
+func newFakeServices(t *testing.T, config Config) (*fakeServices, *Client) {
+	s := &fakeServices{t: t, router: mux.NewRouter(), requests: map[string][]*http.Request{}}
+	s.router.HandleFunc(usersLoginPath, s.login).Methods(http.MethodPost)
+	server := httptest.NewServer(httputils.RequestID(json.NegotiateErrorFormat(s.router)))
+	t.Cleanup(server.Close)
+	config.OrdersURL, config.UsersURL, config.ProductsURL = server.URL, server.URL, server.URL
+	if config.RetryBaseDelay == 0 {
+		config.RetryBaseDelay = time.Millisecond
+	}
+	return s, New(config)
+}
+
+// handle registers the supplied handler for the supplied method and path, behind the token check, and records the requests it receives.
+func (s *fakeServices) handle(method string, path string, handler func(w http.ResponseWriter, r *http.Request, try int)) {
+	s.router.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		name := method + " " + path
+		s.requests[name] = append(s.requests[name], r)
+		try, valid := len(s.requests[name]), r.Header.Get("Authorization") == "Bearer "+s.validToken
+		s.mu.Unlock()
+		if !valid {
+			json.WriteErrorResponse(w, http.StatusUnauthorized, "Invalid token")
+			return
+		}
+		handler(w, r, try)
+	}).Methods(method)
+}
+
+// received returns the requests received for the supplied method and path.
+func (s *fakeServices) received(method string, path string) []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method+" "+path]
+}
+
+// login hands out the next login token, and accepts only that one from then on.
+func (s *fakeServices) login(w http.ResponseWriter, r *http.Request) {
+	var credentials struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := encjson.NewDecoder(r.Body).Decode(&credentials); err != nil || credentials.Name != "admin" || credentials.Password != "secret" {
+		json.WriteErrorResponse(w, http.StatusUnauthorized, "Invalid credentials")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := s.loginTokens[len(s.loginTokens)-1]
+	if s.logins < len(s.loginTokens) {
+		token = s.loginTokens[s.logins]
+	}
+	s.logins++
+	s.validToken = token
+	json.WriteResponse(w, http.StatusOK, json.Response{Token: token})
+}
+
+// loginCount returns the number of successful logins.
+func (s *fakeServices) loginCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// newToken returns an unsigned JSON Web Token for the supplied subject, expiring at the supplied time.
+func newToken(subject string, expiresAt time.Time) string {
+	encode := func(v interface{}) string {
+		b, _ := encjson.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(map[string]interface{}{"sub": subject, "exp": expiresAt.Unix()}) + ".sig"
+}
+
+// writeProduct writes the product with the supplied id as a response.
+func writeProduct(w http.ResponseWriter, id uint, version uint) {
+	w.Header().Set("ETag", httputils.ETag(version))
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: []*models.Product{{Model: gorm.Model{ID: id}, Name: "Apple", Price: 1.5, Version: version}}})
+}
+
+func TestLoginAndTokenRefresh(t *testing.T) {
+	expiring, fresh := newToken("a", time.Now().Add(30*time.Second)), newToken("b", time.Now().Add(time.Hour))
+	s, c := newFakeServices(t, Config{Username: "admin", Password: "secret"})
+	s.loginTokens = []string{expiring, fresh}
+	s.handle(http.MethodGet, productsPath+"/{id}", func(w http.ResponseWriter, r *http.Request, try int) {
+		writeProduct(w, 1, 1)
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		product, err := c.GetProduct(ctx, 1, nil)
+		if err != nil {
+			t.Fatalf("get %d: unexpected error: %s", i, err.Error())
+		}
+		if product.ID != 1 || product.Name != "Apple" {
+			t.Errorf("get %d: unexpected product %+v", i, product)
+		}
+	}
+	// The first token expires within the refresh margin, so the client logs in again before its second request, and keeps the second token.
+	if got := s.loginCount(); got != 2 {
+		t.Errorf("expected 2 logins, got %d", got)
+	}
+
+	_, c = newFakeServices(t, Config{Username: "admin", Password: "wrong"})
+	if err := c.Login(ctx); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected login with wrong credentials to fail with 401, got %v", err)
+	}
+}
+
+func TestReloginOnUnauthorized(t *testing.T) {
+	s, c := newFakeServices(t, Config{Username: "admin", Password: "secret", Token: newToken("revoked", time.Now().Add(time.Hour))})
+	s.loginTokens = []string{newToken("a", time.Now().Add(time.Hour))}
+	s.handle(http.MethodGet, productsPath+"/{id}", func(w http.ResponseWriter, r *http.Request, try int) {
+		writeProduct(w, 1, 1)
+	})
+
+	if _, err := c.GetProduct(context.Background(), 1, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got := s.loginCount(); got != 1 {
+		t.Errorf("expected 1 login, got %d", got)
+	}
+	if got := len(s.received(http.MethodGet, productsPath+"/{id}")); got != 2 {
+		t.Errorf("expected the request to be sent twice, got %d", got)
+	}
+
+	// Without credentials, the refused token is an error.
+	s, c = newFakeServices(t, Config{Token: "revoked"})
+	s.handle(http.MethodGet, productsPath+"/{id}", func(w http.ResponseWriter, r *http.Request, try int) {
+		writeProduct(w, 1, 1)
+	})
+	if _, err := c.GetProduct(context.Background(), 1, nil); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected 401, got %v", err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := map[string]struct {
+		maxRetries int
+		// Statuses of the failed tries before the one that succeeds, or of every try if the retries run out.
+		failures []int
+		header   http.Header
+		tries    int
+		status   int
+	}{
+		"server error":      {failures: []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, tries: 3},
+		"rate limited":      {failures: []int{http.StatusTooManyRequests}, header: http.Header{"Retry-After": {"0"}}, tries: 2},
+		"still in progress": {failures: []int{http.StatusConflict}, header: http.Header{"Retry-After": {"0"}}, tries: 2},
+		"conflict":          {failures: []int{http.StatusConflict}, tries: 1, status: http.StatusConflict},
+		"client error":      {failures: []int{http.StatusBadRequest}, tries: 1, status: http.StatusBadRequest},
+		"retries run out":   {maxRetries: 2, failures: []int{503, 503, 503, 503}, tries: 3, status: http.StatusServiceUnavailable},
+		"no retries":        {maxRetries: -1, failures: []int{http.StatusServiceUnavailable}, tries: 1, status: http.StatusServiceUnavailable},
+	}
+	for name, test := range tests {
+		s, c := newFakeServices(t, Config{Token: "token", MaxRetries: test.maxRetries})
+		s.validToken = "token"
+		s.handle(http.MethodPost, ordersPath, func(w http.ResponseWriter, r *http.Request, try int) {
+			if try <= len(test.failures) {
+				for name, values := range test.header {
+					w.Header()[name] = values
+				}
+				json.WriteErrorResponse(w, test.failures[try-1], "Failed")
+				return
+			}
+			json.WriteResponse(w, http.StatusCreated, json.Response{Data: []*models.Order{{Model: gorm.Model{ID: 7}}}})
+		})
+
+		order, err := c.CreateOrder(context.Background(), &models.Order{})
+		if test.status == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", name, err.Error())
+			} else if order.ID != 7 {
+				t.Errorf("%s: expected order 7, got %d", name, order.ID)
+			}
+		} else {
+			var e *Error
+			if !errors.As(err, &e) || e.StatusCode != test.status {
+				t.Errorf("%s: expected error with status %d, got %v", name, test.status, err)
+			}
+		}
+		requests := s.received(http.MethodPost, ordersPath)
+		if len(requests) != test.tries {
+			t.Errorf("%s: expected %d tries, got %d", name, test.tries, len(requests))
+			continue
+		}
+		key := requests[0].Header.Get(httputils.IdempotencyKeyHeader)
+		if key == "" {
+			t.Errorf("%s: expected an idempotency key", name)
+		}
+		for i, r := range requests {
+			if got := r.Header.Get(httputils.IdempotencyKeyHeader); got != key {
+				t.Errorf("%s: expected try %d to reuse idempotency key %s, got %s", name, i+1, key, got)
+			}
+		}
+	}
+}
+
+func TestListOrders(t *testing.T) {
+	s, c := newFakeServices(t, Config{Token: "token"})
+	s.validToken = "token"
+	const total, limit = 5, 2
+	s.handle(http.MethodGet, ordersPath, func(w http.ResponseWriter, r *http.Request, try int) {
+		first := 1
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			first, _ = strconv.Atoi(cursor)
+		}
+		var orders []*models.Order
+		for id := first; id < first+limit && id <= total; id++ {
+			orders = append(orders, &models.Order{Model: gorm.Model{ID: uint(id)}})
+		}
+		page := &json.Page{Limit: limit, Count: len(orders), Total: total}
+		if next := first + limit; next <= total {
+			page.Next = strconv.Itoa(next)
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("orders=%d-%d/%d", orders[0].ID, orders[len(orders)-1].ID, total))
+		json.WriteResponse(w, http.StatusOK, json.Response{Data: orders, Page: page})
+	})
+
+	it := c.ListOrders(context.Background(), &ListOptions{Limit: limit, Sort: "id"})
+	var ids []uint
+	for it.Next() {
+		ids = append(ids, it.Order().ID)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if fmt.Sprint(ids) != "[1 2 3 4 5]" {
+		t.Errorf("expected orders [1 2 3 4 5], got %v", ids)
+	}
+	if it.Total() != total {
+		t.Errorf("expected a total of %d, got %d", total, it.Total())
+	}
+	requests := s.received(http.MethodGet, ordersPath)
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 pages to be fetched, got %d", len(requests))
+	}
+	for i, r := range requests {
+		if r.URL.Query().Get("limit") != "2" || r.URL.Query().Get("sort") != "id" {
+			t.Errorf("page %d: expected the listing options to be kept, got %s", i+1, r.URL.RawQuery)
+		}
+	}
+
+	s.handle(http.MethodGet, productsPath, func(w http.ResponseWriter, r *http.Request, try int) {
+		json.WriteErrorResponse(w, http.StatusBadRequest, "Invalid filter")
+	})
+	products := c.ListProducts(context.Background(), &ListOptions{Filter: "price>>1"})
+	if products.Next() {
+		t.Error("expected no products")
+	}
+	if !errors.Is(products.Err(), ErrBadRequest) {
+		t.Errorf("expected 400, got %v", products.Err())
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, c := newFakeServices(t, Config{Token: "token"})
+	s.validToken = "token"
+	notFound := json.ErrorType{Name: "order-not-found", Title: "Order not found"}
+	s.handle(http.MethodGet, ordersPath+"/{id}", func(w http.ResponseWriter, r *http.Request, try int) {
+		switch mux.Vars(r)["id"] {
+		case "1":
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, notFound, "Order 1 not found")
+		case "2":
+			json.WriteError(w, json.Error{Status: http.StatusBadRequest, Message: "Invalid query", InvalidParams: []json.InvalidParam{{Name: "fields", Reason: "unknown field"}}})
+		default:
+			// Like a service predating the problem details format.
+			json.WriteResponse(w, http.StatusForbidden, json.Response{Error: &json.ErrorResponse{Code: http.StatusForbidden, Message: "Not your order", Errors: []json.ErrorResponseItem{{Code: "403", Message: "owner"}}}})
+		}
+	})
+
+	ctx := context.Background()
+	_, err := c.GetOrder(ctx, 1, nil)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, &Error{Type: notFound.URI()}) || errors.Is(err, &Error{Type: "/problems/product-not-found"}) {
+		t.Errorf("expected a %s error, got %+v", notFound.URI(), e)
+	}
+	if e.Message != "Order 1 not found" || e.RequestID == "" {
+		t.Errorf("expected the message and request id of the problem, got %+v", e)
+	}
+
+	_, err = c.GetOrder(ctx, 2, &ReadOptions{Fields: "nope"})
+	if !errors.As(err, &e) || e.Type != "" || len(e.InvalidParams) != 1 || e.InvalidParams[0].Name != "fields" {
+		t.Errorf("expected an untyped error with the invalid fields parameter, got %v", err)
+	}
+
+	_, err = c.GetOrder(ctx, 3, nil)
+	if !errors.Is(err, ErrForbidden) || errors.Is(err, ErrNotFound) {
+		t.Errorf("expected 403, got %v", err)
+	}
+	if errors.As(err, &e) && (e.Message != "Not your order" || len(e.Errors) != 1) {
+		t.Errorf("expected the message and errors of the standard response, got %+v", e)
+	}
+}
+
+func TestPatchAndPreconditions(t *testing.T) {
+	s, c := newFakeServices(t, Config{Token: "token"})
+	s.validToken = "token"
+	s.handle(http.MethodPatch, productsPath+"/{id}", func(w http.ResponseWriter, r *http.Request, try int) {
+		if r.Header.Get("If-Match") != httputils.ETag(3) {
+			json.WriteErrorResponse(w, http.StatusPreconditionFailed, "Product has changed")
+			return
+		}
+		writeProduct(w, 1, 4)
+	})
+	s.handle(http.MethodDelete, productsPath+"/{id}", func(w http.ResponseWriter, r *http.Request, try int) {
+		json.WriteResponse(w, http.StatusOK, json.Response{})
+	})
+
+	ctx := context.Background()
+	patches := map[string]Patch{
+		jsonpatch.MergePatchMediaType: MergePatch(map[string]interface{}{"price": 2}),
+		jsonpatch.JSONPatchMediaType:  JSONPatch(PatchOperation{Op: "replace", Path: "/price", Value: 2}),
+	}
+	for mediaType, patch := range patches {
+		product, err := c.PatchProduct(ctx, 1, 3, patch)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", mediaType, err.Error())
+		}
+		if product.Version != 4 {
+			t.Errorf("%s: expected version 4, got %d", mediaType, product.Version)
+		}
+		requests := s.received(http.MethodPatch, productsPath+"/{id}")
+		if got := requests[len(requests)-1].Header.Get("Content-Type"); got != mediaType {
+			t.Errorf("expected content type %s, got %s", mediaType, got)
+		}
+	}
+	if _, err := c.PatchProduct(ctx, 1, 2, MergePatch(map[string]interface{}{"price": 2})); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected 412, got %v", err)
+	}
+
+	if err := c.DeleteProduct(ctx, 1, 4); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	if got := s.received(http.MethodDelete, productsPath+"/{id}")[0].Header.Get("If-Match"); got != httputils.ETag(4) {
+		t.Errorf("expected If-Match %s, got %s", httputils.ETag(4), got)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s, c := newFakeServices(t, Config{Token: "token"})
+	s.validToken = "token"
+	s.handle(http.MethodPost, usersPath+batchSubpath, func(w http.ResponseWriter, r *http.Request, try int) {
+		var batch models.Batch
+		encjson.NewDecoder(r.Body).Decode(&batch)
+		if !batch.Atomic {
+			json.WriteResponse(w, http.StatusOK, json.Response{Data: []json.BatchResult{{Status: http.StatusCreated, Response: json.Response{Data: []*models.User{{Model: gorm.Model{ID: 9}}}}}}})
+			return
+		}
+		results := []json.BatchResult{
+			{Status: http.StatusFailedDependency, Response: json.NewResponseWithError(http.StatusFailedDependency, "Not applied")},
+			{Status: http.StatusConflict, Response: json.NewResponseWithError(http.StatusConflict, "Name taken")},
+		}
+		json.WriteResponse(w, http.StatusConflict, json.Response{Data: results, Error: &json.ErrorResponse{Code: http.StatusConflict, Message: "Operation 1 failed"}})
+	})
+
+	ctx := context.Background()
+	create := models.BatchOperation{Op: models.BatchOpCreate, Data: encjson.RawMessage(`{"name":"bob"}`)}
+	results, err := c.BatchUsers(ctx, models.Batch{Operations: []models.BatchOperation{create}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	var users []*models.User
+	if len(results) != 1 || results[0].Status != http.StatusCreated || encjson.Unmarshal(results[0].Data, &users) != nil || users[0].ID != 9 {
+		t.Errorf("expected user 9 to be created, got %+v", results)
+	}
+
+	results, err = c.BatchUsers(ctx, models.Batch{Atomic: true, Operations: []models.BatchOperation{create, create}})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("expected 409, got %v", err)
+	}
+	if len(results) != 2 || results[0].Status != http.StatusFailedDependency || results[1].Status != http.StatusConflict || results[1].Error.Message != "Name taken" {
+		t.Errorf("expected the results of the failed batch, got %+v", results)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := map[string]struct {
+		value string
+		want  ContentRange
+		err   bool
+	}{
+		"page":          {value: "orders=1-50/1234", want: ContentRange{Unit: "orders", First: 1, Last: 50, Total: 1234}},
+		"empty listing": {value: "products=0-0/0", want: ContentRange{Unit: "products"}},
+		"no unit":       {value: "1-50/1234", err: true},
+		"no total":      {value: "orders=1-50", err: true},
+		"no range":      {value: "orders=/1234", err: true},
+		"not a number":  {value: "orders=a-b/c", err: true},
+		"empty":         {value: "", err: true},
+	}
+	for name, test := range tests {
+		got, err := ParseContentRange(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err.Error())
+		} else if got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", name, test.want, got)
+		}
+	}
+}
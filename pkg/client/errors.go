@@ -0,0 +1,92 @@
+package client
+
+import (
+	encjson "encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// Error holds an error response sent by a service, in either of the formats the services send errors in. (see json.WriteError)
+type Error struct {
+	// HTTP status code of the response.
+	StatusCode int
+	// URI identifying the kind of error. (e.g. /problems/order-not-found, empty if the service sent none)
+	Type string
+	// Error message.
+	Message string
+	// Additional errors, from the errors of a standard response.
+	Errors []json.ErrorResponseItem
+	// Parameters of the request that were invalid, and why.
+	InvalidParams []json.InvalidParam
+	// ID of the request that failed, to find it in the logs of the service.
+	RequestID string
+
+	// Body of the response, for the requests with data in their error responses. (e.g. the results of a failed atomic batch)
+	body []byte
+}
+
+// Errors matching the error responses with a given status, to use with errors.Is. (e.g. errors.Is(err, client.ErrNotFound))
+var (
+	ErrBadRequest           = &Error{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized         = &Error{StatusCode: http.StatusUnauthorized}
+	ErrForbidden            = &Error{StatusCode: http.StatusForbidden}
+	ErrNotFound             = &Error{StatusCode: http.StatusNotFound}
+	ErrConflict             = &Error{StatusCode: http.StatusConflict}
+	ErrGone                 = &Error{StatusCode: http.StatusGone}
+	ErrPreconditionFailed   = &Error{StatusCode: http.StatusPreconditionFailed}
+	ErrUnprocessableEntity  = &Error{StatusCode: http.StatusUnprocessableEntity}
+	ErrPreconditionRequired = &Error{StatusCode: http.StatusPreconditionRequired}
+	ErrTooManyRequests      = &Error{StatusCode: http.StatusTooManyRequests}
+	ErrInternalServerError  = &Error{StatusCode: http.StatusInternalServerError}
+)
+
+// Error returns an error string for the error.
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if len(e.InvalidParams) > 0 {
+		reasons := make([]string, len(e.InvalidParams))
+		for i, param := range e.InvalidParams {
+			reasons[i] = param.Name + ": " + param.Reason
+		}
+		msg += " (" + strings.Join(reasons, "; ") + ")"
+	}
+	return msg
+}
+
+// Is determines whether the error matches the supplied target error: an *Error with the same status code and type, where set.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return (t.StatusCode == 0 || t.StatusCode == e.StatusCode) && (t.Type == "" || t.Type == e.Type)
+}
+
+// newError returns the error held in the supplied error response, whichever format it is in.
+func newError(res *response) *Error {
+	e := &Error{StatusCode: res.status, RequestID: res.header.Get(httputils.RequestIDHeader), body: res.body}
+	if strings.HasPrefix(res.header.Get("Content-Type"), json.ProblemMediaType) {
+		var problem json.Problem
+		if err := encjson.Unmarshal(res.body, &problem); err == nil {
+			if problem.Type != "about:blank" {
+				e.Type = problem.Type
+			}
+			e.Message, e.InvalidParams = problem.Detail, problem.InvalidParams
+			return e
+		}
+	}
+	var r json.Response
+	if err := encjson.Unmarshal(res.body, &r); err == nil && r.Error != nil {
+		e.Message, e.Errors = r.Error.Message, r.Error.Errors
+	} else {
+		e.Message = strings.TrimSpace(string(res.body))
+	}
+	return e
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// CreateOrder creates the supplied order, and returns it as it was stored. Its subtotal, tax and total are calculated by the service.
+func (c *Client) CreateOrder(ctx context.Context, order *models.Order) (*models.Order, error) {
+	return c.order(ctx, &request{method: http.MethodPost, baseURL: c.config.OrdersURL, path: ordersPath, body: order, auth: true})
+}
+
+// ListOrders returns an iterator over the orders matching the supplied options. (nil to list them all)
+// Customers only ever see their own orders.
+func (c *Client) ListOrders(ctx context.Context, opts *ListOptions) *OrderIterator {
+	return &OrderIterator{pager: c.newPager(ctx, c.config.OrdersURL, ordersPath, opts.query())}
+}
+
+// GetOrder returns the order with the supplied id, with the fields and related records chosen in the supplied options. (nil for the defaults)
+func (c *Client) GetOrder(ctx context.Context, id uint, opts *ReadOptions) (*models.Order, error) {
+	return c.order(ctx, &request{method: http.MethodGet, baseURL: c.config.OrdersURL, path: idPath(ordersPath, id), query: opts.query(), auth: true})
+}
+
+// GetOrderItems returns the items of the order with the supplied id.
+func (c *Client) GetOrderItems(ctx context.Context, orderID uint) ([]*models.Item, error) {
+	var items []*models.Item
+	err := c.record(ctx, &request{method: http.MethodGet, baseURL: c.config.OrdersURL, path: idPath(ordersPath, orderID) + "/items", auth: true}, &items)
+	return items, err
+}
+
+// GetOrderItem returns the item with the supplied id of the order with the supplied id.
+func (c *Client) GetOrderItem(ctx context.Context, orderID uint, itemID uint) (*models.Item, error) {
+	var items []*models.Item
+	err := c.record(ctx, &request{method: http.MethodGet, baseURL: c.config.OrdersURL, path: idPath(idPath(ordersPath, orderID)+"/items", itemID), auth: true}, &items)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errNoRecord
+	}
+	return items[0], nil
+}
+
+// UpdateOrder replaces the order with the id of the supplied order with it, and returns it as it was stored.
+// The update is refused with ErrPreconditionFailed if the order has changed since the version of the supplied order was read.
+func (c *Client) UpdateOrder(ctx context.Context, order *models.Order) (*models.Order, error) {
+	return c.order(ctx, &request{method: http.MethodPut, baseURL: c.config.OrdersURL, path: idPath(ordersPath, order.ID), header: ifMatch(order.Version), body: order, auth: true})
+}
+
+// PatchOrder applies the supplied patch to the order with the supplied id, and returns the patched order.
+// The patch is refused with ErrPreconditionFailed if the order has changed since the supplied version was read.
+func (c *Client) PatchOrder(ctx context.Context, id uint, version uint, patch Patch) (*models.Order, error) {
+	return c.order(ctx, &request{method: http.MethodPatch, baseURL: c.config.OrdersURL, path: idPath(ordersPath, id), header: ifMatch(version), body: patch.body, contentType: patch.mediaType, auth: true})
+}
+
+// DeleteOrder deletes the order with the supplied id, along with its items.
+// The delete is refused with ErrPreconditionFailed if the order has changed since the supplied version was read.
+func (c *Client) DeleteOrder(ctx context.Context, id uint, version uint) error {
+	_, err := c.do(ctx, &request{method: http.MethodDelete, baseURL: c.config.OrdersURL, path: idPath(ordersPath, id), header: ifMatch(version), auth: true})
+	return err
+}
+
+// RepriceOrder charges each item of the pending order with the supplied id the current price of its product, and returns the repriced order.
+// The reprice is refused with ErrPreconditionFailed if the order has changed since the supplied version was read.
+func (c *Client) RepriceOrder(ctx context.Context, id uint, version uint) (*models.Order, error) {
+	return c.order(ctx, &request{method: http.MethodPost, baseURL: c.config.OrdersURL, path: idPath(ordersPath, id) + "/reprice", header: ifMatch(version), auth: true})
+}
+
+// BatchOrders runs the operations of the supplied batch on orders, and returns the result of each one, in order. The data of each result is a []*models.Order.
+// When an atomic batch fails, its results are returned along with the error.
+func (c *Client) BatchOrders(ctx context.Context, batch models.Batch) ([]BatchResult, error) {
+	return c.batch(ctx, c.config.OrdersURL, ordersPath, batch)
+}
+
+// OrdersPageMaxRecordLimit returns the maximum number of orders in a page of a listing.
+func (c *Client) OrdersPageMaxRecordLimit(ctx context.Context) (int, error) {
+	return c.pageMaxRecordLimit(ctx, c.config.OrdersURL, ordersPath)
+}
+
+// OrdersHealthy checks the health of the orders service.
+func (c *Client) OrdersHealthy(ctx context.Context) (bool, error) {
+	return c.healthy(ctx, c.config.OrdersURL, ordersPath)
+}
+
+// order sends the supplied request for a single order, and returns the order in the response.
+func (c *Client) order(ctx context.Context, req *request) (*models.Order, error) {
+	var orders []*models.Order
+	if err := c.record(ctx, req, &orders); err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, errNoRecord
+	}
+	return orders[0], nil
+}
@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// ListOptions holds the options of a listing of orders, products or users. Every option is optional.
+type ListOptions struct {
+	// Only list records matching all of these conditions, separated by semicolons. (e.g. total>20;createdat>=2026-10-01)
+	Filter string
+	// Fields to sort the listing by, separated by commas and prefixed with - to sort descending. (e.g. -total)
+	Sort string
+	// Maximum number of records in each page fetched. (defaults to the service's maximum)
+	Limit int
+	// Fields to return, separated by commas. The id is always returned.
+	Fields string
+	// Related records to embed, separated by commas. (e.g. items.product, not for products)
+	Include string
+	// Cursor of the page to start from, from the Next or Prev field of a page.
+	Cursor string
+	// Users or products to list by status: active (default), inactive or all. (inactive products are the archived ones; not for orders)
+	Status string
+}
+
+// query returns the options as the query parameters of a listing request.
+func (opts *ListOptions) query() url.Values {
+	query := url.Values{}
+	if opts == nil {
+		return query
+	}
+	setQuery(query, "filter", opts.Filter)
+	setQuery(query, "sort", opts.Sort)
+	setQuery(query, "fields", opts.Fields)
+	setQuery(query, "include", opts.Include)
+	setQuery(query, "cursor", opts.Cursor)
+	setQuery(query, "status", opts.Status)
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	return query
+}
+
+// ReadOptions holds the options of a read of a single order, product or user. Every option is optional.
+type ReadOptions struct {
+	// Fields to return, separated by commas. The id is always returned.
+	Fields string
+	// Related records to embed, separated by commas. (e.g. items.product, not for products)
+	Include string
+}
+
+// query returns the options as the query parameters of a read request.
+func (opts *ReadOptions) query() url.Values {
+	query := url.Values{}
+	if opts != nil {
+		setQuery(query, "fields", opts.Fields)
+		setQuery(query, "include", opts.Include)
+	}
+	return query
+}
+
+// AuditOptions holds the filters of a listing of the audit log. Every filter is optional.
+type AuditOptions struct {
+	// Only entries for actions performed by the user with this id.
+	ActorID uint
+	// Only entries for this action. (e.g. update)
+	Action string
+	// Only entries for actions on this type of entity, and this entity. (e.g. order and 3)
+	EntityType string
+	EntityID   uint
+	// Only entries for actions performed while this admin impersonated the actor.
+	ImpersonatorID uint
+	// Only entries for actions performed in the request with this id.
+	RequestID string
+	// Only entries for actions performed in this time range.
+	Since time.Time
+	Until time.Time
+	// Maximum number of entries in each page fetched. (defaults to the service's maximum)
+	Limit int
+}
+
+// query returns the filters as the query parameters of an audit log listing request.
+func (opts *AuditOptions) query() url.Values {
+	query := url.Values{}
+	if opts == nil {
+		return query
+	}
+	for name, id := range map[string]uint{"actorid": opts.ActorID, "entityid": opts.EntityID, "impersonatorid": opts.ImpersonatorID} {
+		if id != 0 {
+			query.Set(name, strconv.FormatUint(uint64(id), 10))
+		}
+	}
+	setQuery(query, "action", opts.Action)
+	setQuery(query, "entitytype", opts.EntityType)
+	setQuery(query, "requestid", opts.RequestID)
+	if !opts.Since.IsZero() {
+		query.Set("since", opts.Since.Format(time.RFC3339))
+	}
+	if !opts.Until.IsZero() {
+		query.Set("until", opts.Until.Format(time.RFC3339))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	return query
+}
+
+// setQuery sets the supplied query parameter to the supplied value, unless it is empty.
+func setQuery(query url.Values, name string, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
+
+// ContentRange holds the range of records in a page of a listing, as sent in the Content-Range header of the response. (e.g. orders=1-50/1234)
+type ContentRange struct {
+	// Kind of records listed. (e.g. orders)
+	Unit string
+	// IDs of the first and last records of the page. (zero if the page is empty)
+	First uint
+	Last  uint
+	// Number of records in the whole listing.
+	Total int64
+}
+
+// ParseContentRange parses the supplied Content-Range header value of a page of a listing.
+func ParseContentRange(value string) (ContentRange, error) {
+	var r ContentRange
+	unit, rest := splitOnce(value, "=")
+	span, total := splitOnce(rest, "/")
+	first, last := splitOnce(span, "-")
+	if unit == "" || span == "" || total == "" || last == "" {
+		return r, fmt.Errorf("invalid Content-Range '%s'", value)
+	}
+	firstID, err1 := strconv.ParseUint(first, 10, 0)
+	lastID, err2 := strconv.ParseUint(last, 10, 0)
+	count, err3 := strconv.ParseInt(total, 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return r, fmt.Errorf("invalid Content-Range '%s'", value)
+	}
+	return ContentRange{Unit: unit, First: uint(firstID), Last: uint(lastID), Total: count}, nil
+}
+
+// splitOnce splits the supplied string around the first instance of the supplied separator. The second part is empty if there is none.
+func splitOnce(s string, sep string) (string, string) {
+	parts := strings.SplitN(s, sep, 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// pager fetches the pages of a listing one after the other, following the cursor of each page to the next.
+type pager struct {
+	c       *Client
+	ctx     context.Context
+	baseURL string
+	path    string
+	query   url.Values
+	// Whether the last page has been fetched.
+	done bool
+	// Position of the last page fetched, and its range.
+	page         *json.Page
+	contentRange *ContentRange
+	err          error
+}
+
+// newPager returns a new pager over the listing at the supplied path, with the supplied query parameters.
+func (c *Client) newPager(ctx context.Context, baseURL string, path string, query url.Values) pager {
+	return pager{c: c, ctx: ctx, baseURL: baseURL, path: path, query: query}
+}
+
+// fetch fetches the next page of the listing, and decodes its records into the supplied destination.
+// Returns false if there are no more pages, or there was an error.
+func (p *pager) fetch(records interface{}) bool {
+	if p.done || p.err != nil {
+		return false
+	}
+	res, err := p.c.do(p.ctx, &request{method: http.MethodGet, baseURL: p.baseURL, path: p.path, query: p.query, auth: true})
+	if err != nil {
+		p.err = err
+		return false
+	}
+	env, err := res.decode(records)
+	if err != nil {
+		p.err = err
+		return false
+	}
+	p.page = env.Page
+	p.contentRange = nil
+	if value := res.header.Get("Content-Range"); value != "" {
+		if r, err := ParseContentRange(value); err == nil {
+			p.contentRange = &r
+		}
+	}
+	if p.page == nil || p.page.Next == "" {
+		p.done = true
+	} else {
+		p.query = cloneQuery(p.query)
+		p.query.Set("cursor", p.page.Next)
+	}
+	return true
+}
+
+// cloneQuery returns a copy of the supplied query parameters.
+func cloneQuery(query url.Values) url.Values {
+	clone := url.Values{}
+	for name, values := range query {
+		clone[name] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// Page returns the position of the last page fetched in the listing. (nil before the first page is fetched)
+func (p *pager) Page() *json.Page {
+	return p.page
+}
+
+// Total returns the number of records in the whole listing, as of the last page fetched.
+func (p *pager) Total() int64 {
+	if p.contentRange != nil {
+		return p.contentRange.Total
+	}
+	if p.page != nil {
+		return p.page.Total
+	}
+	return 0
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (p *pager) Err() error {
+	return p.err
+}
+
+// OrderIterator iterates over the orders of a listing, fetching its pages as needed:
+//
+//	it := c.ListOrders(ctx, nil)
+//	for it.Next() {
+//		fmt.Println(it.Order().Total)
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type OrderIterator struct {
+	pager
+	records []*models.Order
+	i       int
+}
+
+// Next advances the iterator to the next order, and returns false when there are none left or there was an error. (see Err)
+func (it *OrderIterator) Next() bool {
+	for it.i+1 >= len(it.records) {
+		it.records, it.i = nil, -1
+		if !it.fetch(&it.records) {
+			return false
+		}
+	}
+	it.i++
+	return true
+}
+
+// Order returns the current order of the iterator.
+func (it *OrderIterator) Order() *models.Order {
+	return it.records[it.i]
+}
+
+// ProductIterator iterates over the products of a listing, fetching its pages as needed. (see OrderIterator)
+type ProductIterator struct {
+	pager
+	records []*models.Product
+	i       int
+}
+
+// Next advances the iterator to the next product, and returns false when there are none left or there was an error. (see Err)
+func (it *ProductIterator) Next() bool {
+	for it.i+1 >= len(it.records) {
+		it.records, it.i = nil, -1
+		if !it.fetch(&it.records) {
+			return false
+		}
+	}
+	it.i++
+	return true
+}
+
+// Product returns the current product of the iterator.
+func (it *ProductIterator) Product() *models.Product {
+	return it.records[it.i]
+}
+
+// UserIterator iterates over the users of a listing, fetching its pages as needed. (see OrderIterator)
+type UserIterator struct {
+	pager
+	records []*models.User
+	i       int
+}
+
+// Next advances the iterator to the next user, and returns false when there are none left or there was an error. (see Err)
+func (it *UserIterator) Next() bool {
+	for it.i+1 >= len(it.records) {
+		it.records, it.i = nil, -1
+		if !it.fetch(&it.records) {
+			return false
+		}
+	}
+	it.i++
+	return true
+}
+
+// User returns the current user of the iterator.
+func (it *UserIterator) User() *models.User {
+	return it.records[it.i]
+}
+
+// AuditIterator iterates over the entries of the audit log, fetching its pages as needed. (see OrderIterator)
+type AuditIterator struct {
+	pager
+	records []*models.AuditEntry
+	i       int
+}
+
+// Next advances the iterator to the next entry, and returns false when there are none left or there was an error. (see Err)
+func (it *AuditIterator) Next() bool {
+	for it.i+1 >= len(it.records) {
+		it.records, it.i = nil, -1
+		if !it.fetch(&it.records) {
+			return false
+		}
+	}
+	it.i++
+	return true
+}
+
+// Entry returns the current entry of the iterator.
+func (it *AuditIterator) Entry() *models.AuditEntry {
+	return it.records[it.i]
+}
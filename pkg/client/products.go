@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// CreateProduct creates the supplied product, and returns it as it was stored.
+func (c *Client) CreateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodPost, baseURL: c.config.ProductsURL, path: productsPath, body: product, auth: true})
+}
+
+// ListProducts returns an iterator over the products matching the supplied options. (nil to list them all)
+func (c *Client) ListProducts(ctx context.Context, opts *ListOptions) *ProductIterator {
+	return &ProductIterator{pager: c.newPager(ctx, c.config.ProductsURL, productsPath, opts.query())}
+}
+
+// GetProduct returns the product with the supplied id, with the fields chosen in the supplied options. (nil for all of them)
+func (c *Client) GetProduct(ctx context.Context, id uint, opts *ReadOptions) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodGet, baseURL: c.config.ProductsURL, path: idPath(productsPath, id), query: opts.query(), auth: true})
+}
+
+// UpdateProduct replaces the product with the id of the supplied product with it, and returns it as it was stored.
+// The update is refused with ErrPreconditionFailed if the product has changed since the version of the supplied product was read.
+func (c *Client) UpdateProduct(ctx context.Context, product *models.Product) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodPut, baseURL: c.config.ProductsURL, path: idPath(productsPath, product.ID), header: ifMatch(product.Version), body: product, auth: true})
+}
+
+// PatchProduct applies the supplied patch to the product with the supplied id, and returns the patched product.
+// The patch is refused with ErrPreconditionFailed if the product has changed since the supplied version was read.
+func (c *Client) PatchProduct(ctx context.Context, id uint, version uint, patch Patch) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodPatch, baseURL: c.config.ProductsURL, path: idPath(productsPath, id), header: ifMatch(version), body: patch.body, contentType: patch.mediaType, auth: true})
+}
+
+// DeleteProduct archives the product with the supplied id: it can no longer be ordered, but the orders it is in still refer to it.
+// The delete is refused with ErrPreconditionFailed if the product has changed since the supplied version was read.
+func (c *Client) DeleteProduct(ctx context.Context, id uint, version uint) error {
+	_, err := c.do(ctx, &request{method: http.MethodDelete, baseURL: c.config.ProductsURL, path: idPath(productsPath, id), header: ifMatch(version), auth: true})
+	return err
+}
+
+// RestoreProduct puts the archived product with the supplied id back in the catalog, and returns it.
+func (c *Client) RestoreProduct(ctx context.Context, id uint) (*models.Product, error) {
+	return c.product(ctx, &request{method: http.MethodPost, baseURL: c.config.ProductsURL, path: idPath(productsPath, id) + "/restore", auth: true})
+}
+
+// PurgeProduct removes the archived product with the supplied id for good. It is refused with ErrConflict if the product is in any order.
+func (c *Client) PurgeProduct(ctx context.Context, id uint) error {
+	_, err := c.do(ctx, &request{method: http.MethodDelete, baseURL: c.config.ProductsURL, path: idPath(productsPath, id) + "/purge", auth: true})
+	return err
+}
+
+// BatchProducts runs the operations of the supplied batch on products, and returns the result of each one, in order. The data of each result is a []*models.Product.
+// When an atomic batch fails, its results are returned along with the error.
+func (c *Client) BatchProducts(ctx context.Context, batch models.Batch) ([]BatchResult, error) {
+	return c.batch(ctx, c.config.ProductsURL, productsPath, batch)
+}
+
+// ProductsPageMaxRecordLimit returns the maximum number of products in a page of a listing.
+func (c *Client) ProductsPageMaxRecordLimit(ctx context.Context) (int, error) {
+	return c.pageMaxRecordLimit(ctx, c.config.ProductsURL, productsPath)
+}
+
+// ProductsHealthy checks the health of the products service.
+func (c *Client) ProductsHealthy(ctx context.Context) (bool, error) {
+	return c.healthy(ctx, c.config.ProductsURL, productsPath)
+}
+
+// product sends the supplied request for a single product, and returns the product in the response.
+func (c *Client) product(ctx context.Context, req *request) (*models.Product, error) {
+	var products []*models.Product
+	if err := c.record(ctx, req, &products); err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, errNoRecord
+	}
+	return products[0], nil
+}
@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	encjson "encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/jsonpatch"
+)
+
+// Paths of the endpoints of the services.
+const (
+	ordersPath   = "/v1/orders"
+	productsPath = "/v1/products"
+	usersPath    = "/v1/users"
+	auditPath    = "/v1/audit"
+
+	usersLoginPath = usersPath + "/login"
+
+	batchSubpath              = "/batch"
+	pageMaxRecordLimitSubpath = "/page-max-record-limit"
+	healthSubpath             = "/health"
+)
+
+// errNoRecord is returned when a response that should hold a record holds none.
+var errNoRecord = errors.New("response holds no record")
+
+// Patch holds a patch to apply to a record: a JSON Merge Patch or a JSON Patch.
+type Patch struct {
+	mediaType string
+	body      interface{}
+}
+
+// MergePatch returns a JSON Merge Patch (RFC 7396) setting the fields of a record to the ones of the supplied value, e.g. a map of field names to values.
+// Fields set to nil are removed.
+func MergePatch(fields interface{}) Patch {
+	return Patch{mediaType: jsonpatch.MergePatchMediaType, body: fields}
+}
+
+// JSONPatch returns a JSON Patch (RFC 6902) applying the supplied operations to a record, in order.
+func JSONPatch(ops ...PatchOperation) Patch {
+	if ops == nil {
+		ops = []PatchOperation{}
+	}
+	return Patch{mediaType: jsonpatch.JSONPatchMediaType, body: ops}
+}
+
+// PatchOperation holds a single operation of a JSON Patch.
+type PatchOperation struct {
+	// Operation to apply: add, remove, replace, move, copy or test.
+	Op string `json:"op"`
+	// JSON Pointer to the field the operation applies to. (e.g. /paymentinfo/cash)
+	Path string `json:"path"`
+	// JSON Pointer to the field moved or copied from. (only for move and copy)
+	From string `json:"from,omitempty"`
+	// Value to add, replace with or test against.
+	Value interface{} `json:"value"`
+}
+
+// BatchResult holds the result of a single operation of a batch: its HTTP status code, and the records or error it would have responded with on its own.
+type BatchResult struct {
+	Status int
+	// Records returned by the operation, left encoded. (e.g. a []*models.Order for an orders batch)
+	Data encjson.RawMessage
+	// Error of the operation. (nil if it succeeded)
+	Error *json.ErrorResponse
+}
+
+// record sends the supplied request, and decodes the data of the response into the supplied destination.
+func (c *Client) record(ctx context.Context, req *request, records interface{}) error {
+	res, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = res.decode(records)
+	return err
+}
+
+// batch sends the supplied batch to the batch endpoint at the supplied base URL and path, and returns the result of each operation, in order.
+// When an atomic batch fails, the results are returned along with the error: the operation that failed has its own status, and every other one a 424.
+func (c *Client) batch(ctx context.Context, baseURL string, path string, batch models.Batch) ([]BatchResult, error) {
+	res, err := c.do(ctx, &request{method: http.MethodPost, baseURL: baseURL, path: path + batchSubpath, body: batch, auth: true})
+	var e *Error
+	if errors.As(err, &e) && len(e.body) > 0 {
+		res = &response{status: e.StatusCode, body: e.body}
+	} else if err != nil {
+		return nil, err
+	}
+	var data []struct {
+		Status int                 `json:"status"`
+		Data   encjson.RawMessage  `json:"data"`
+		Error  *json.ErrorResponse `json:"error"`
+	}
+	if _, decodeErr := res.decode(&data); decodeErr != nil && err == nil {
+		return nil, decodeErr
+	}
+	var results []BatchResult
+	for _, result := range data {
+		results = append(results, BatchResult{Status: result.Status, Data: result.Data, Error: result.Error})
+	}
+	return results, err
+}
+
+// pageMaxRecordLimit returns the maximum number of records in a page of the listing of the service at the supplied base URL and path.
+func (c *Client) pageMaxRecordLimit(ctx context.Context, baseURL string, path string) (int, error) {
+	var limit int
+	err := c.record(ctx, &request{method: http.MethodGet, baseURL: baseURL, path: path + pageMaxRecordLimitSubpath}, &limit)
+	return limit, err
+}
+
+// healthy checks the health of the service at the supplied base URL, whose endpoints are under the supplied path.
+func (c *Client) healthy(ctx context.Context, baseURL string, path string) (bool, error) {
+	res, err := c.do(ctx, &request{method: http.MethodGet, baseURL: baseURL, path: path + healthSubpath})
+	if err != nil {
+		return false, err
+	}
+	var health struct {
+		Ok bool `json:"ok"`
+	}
+	if err := encjson.Unmarshal(res.body, &health); err != nil {
+		return false, err
+	}
+	return health.Ok, nil
+}
@@ -0,0 +1,359 @@
+package client
+
+import (
+	"context"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	"github.com/tragicpixel/fruitbar/pkg/service"
+)
+
+// Password of the admin user the services start with.
+const servicesAdminPassword = "s3cret!password"
+
+// newServices starts the orders, users and products services, all keeping their records in the same in-memory store,
+// and returns a client configured to use them as their admin user.
+func newServices(t *testing.T) *Client {
+	store := memory.NewStore()
+	users, err := service.NewUsersService(&service.UsersServiceConfig{Storage: service.StorageMemory, MemoryStore: store, AdminPassword: servicesAdminPassword})
+	if err != nil {
+		t.Fatalf("failed to create the users service: %s", err.Error())
+	}
+	orders, err := service.NewOrdersService(&service.OrdersServiceConfig{Storage: service.StorageMemory, MemoryStore: store})
+	if err != nil {
+		t.Fatalf("failed to create the orders service: %s", err.Error())
+	}
+	products, err := service.NewProductsService(&service.ProductsServiceConfig{Storage: service.StorageMemory, MemoryStore: store})
+	if err != nil {
+		t.Fatalf("failed to create the products service: %s", err.Error())
+	}
+	config := Config{Username: "admin", Password: servicesAdminPassword, RetryBaseDelay: time.Millisecond}
+	for _, s := range []struct {
+		url     *string
+		handler *httptest.Server
+	}{{&config.UsersURL, httptest.NewServer(users.Router)}, {&config.OrdersURL, httptest.NewServer(orders.Router)}, {&config.ProductsURL, httptest.NewServer(products.Router)}} {
+		t.Cleanup(s.handler.Close)
+		*s.url = s.handler.URL
+	}
+	return New(config)
+}
+
+func TestServices(t *testing.T) {
+	c := newServices(t)
+	ctx := context.Background()
+
+	apple, err := c.CreateProduct(ctx, &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	order, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 2}}, PaymentInfo: models.PaymentInfo{Cash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error creating an order: %s", err.Error())
+	}
+	order, err = c.GetOrder(ctx, order.ID, &ReadOptions{Include: "items.product"})
+	if err != nil {
+		t.Fatalf("unexpected error reading the order: %s", err.Error())
+	}
+	if order.Subtotal != 3 || len(order.Items) != 1 || order.Items[0].Product == nil || order.Items[0].Product.Name != "apple" {
+		t.Errorf("expected an order of 2 apples, got %+v", order)
+	}
+	if item := order.Items[0]; item.UnitPrice != 1.5 || item.ProductName != "apple" || item.ProductSymbol != "🍎" || item.Total != 3 || order.Status != models.OrderStatusPending {
+		t.Errorf("expected a pending order of 2 apples at 1.5, got %+v with item %+v", order, item)
+	}
+
+	// Items keep the price they were ordered at, until the order is repriced.
+	apple, err = c.PatchProduct(ctx, apple.ID, apple.Version, MergePatch(map[string]float64{"price": 2}))
+	if err != nil {
+		t.Fatalf("unexpected error patching the product: %s", err.Error())
+	}
+	order, err = c.PatchOrder(ctx, order.ID, order.Version, MergePatch(map[string]interface{}{"items": []map[string]interface{}{{"id": order.Items[0].ID, "productid": apple.ID, "quantity": 3}}}))
+	if err != nil {
+		t.Fatalf("unexpected error patching the order: %s", err.Error())
+	}
+	if order.Subtotal != 4.5 || order.Items[0].UnitPrice != 1.5 {
+		t.Errorf("expected 3 apples at the price they were ordered at, got %+v with item %+v", order, order.Items[0])
+	}
+	order, err = c.RepriceOrder(ctx, order.ID, order.Version)
+	if err != nil {
+		t.Fatalf("unexpected error repricing the order: %s", err.Error())
+	}
+	if order.Subtotal != 6 || order.Items[0].UnitPrice != 2 || order.Items[0].Total != 6 {
+		t.Errorf("expected 3 apples at the current price, got %+v with item %+v", order, order.Items[0])
+	}
+	order, err = c.PatchOrder(ctx, order.ID, order.Version, MergePatch(map[string]string{"status": models.OrderStatusFulfilled}))
+	if err != nil {
+		t.Fatalf("unexpected error fulfilling the order: %s", err.Error())
+	}
+	if _, err := c.RepriceOrder(ctx, order.ID, order.Version); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected repricing a fulfilled order to fail with 409, got %v", err)
+	}
+
+	if _, err := c.PatchProduct(ctx, apple.ID, apple.Version+1, MergePatch(map[string]float64{"price": 2})); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("expected patching a version the product doesn't have to fail with 412, got %v", err)
+	}
+
+	// An atomic batch failing half way leaves nothing behind.
+	create := models.BatchOperation{Op: models.BatchOpCreate, Data: encjson.RawMessage(`{"name":"banana","symbol":"🍌","price":1,"numInStock":5}`)}
+	remove := models.BatchOperation{Op: models.BatchOpDelete, ID: 999, Version: 1}
+	if _, err := c.BatchProducts(ctx, models.Batch{Atomic: true, Operations: []models.BatchOperation{create, remove}}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the batch to fail with 404, got %v", err)
+	}
+	var names []string
+	it := c.ListProducts(ctx, nil)
+	for it.Next() {
+		names = append(names, it.Product().Name)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error listing the products: %s", err.Error())
+	}
+	if len(names) != 1 || names[0] != "apple" {
+		t.Errorf("expected only the apple to be listed, got %v", names)
+	}
+
+	// A deleted product is archived: its items are kept, but it can't be ordered again until it is restored.
+	if err := c.DeleteProduct(ctx, apple.ID, apple.Version); err != nil {
+		t.Fatalf("unexpected error deleting the product: %s", err.Error())
+	}
+	order, err = c.GetOrder(ctx, order.ID, &ReadOptions{Include: "items.product"})
+	if err != nil {
+		t.Fatalf("unexpected error reading the order: %s", err.Error())
+	}
+	if len(order.Items) != 1 || order.Items[0].Product == nil || order.Items[0].Product.Name != "apple" {
+		t.Errorf("expected the order to keep its item of the archived product, got %+v", order.Items)
+	}
+	if _, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 1}}, PaymentInfo: models.PaymentInfo{Cash: true}}); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected ordering an archived product to fail with 400, got %v", err)
+	}
+	var archived []string
+	it = c.ListProducts(ctx, &ListOptions{Status: "inactive"})
+	for it.Next() {
+		archived = append(archived, it.Product().Name)
+	}
+	if err := it.Err(); err != nil || len(archived) != 1 || archived[0] != "apple" {
+		t.Errorf("expected the apple to be listed as archived, got %v (%v)", archived, err)
+	}
+	if err := c.PurgeProduct(ctx, apple.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected purging an ordered product to fail with 409, got %v", err)
+	}
+	if apple, err = c.RestoreProduct(ctx, apple.ID); err != nil {
+		t.Fatalf("unexpected error restoring the product: %s", err.Error())
+	}
+	if _, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 1}}, PaymentInfo: models.PaymentInfo{Cash: true}}); err != nil {
+		t.Errorf("unexpected error ordering a restored product: %s", err.Error())
+	}
+
+	// Products that were never ordered can be purged once archived.
+	kiwi, err := c.CreateProduct(ctx, &models.Product{Name: "kiwi", Symbol: "🥝", Price: 1, NumInStock: 3})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	if err := c.PurgeProduct(ctx, kiwi.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected purging an active product to fail with 409, got %v", err)
+	}
+	if err := c.DeleteProduct(ctx, kiwi.ID, kiwi.Version); err != nil {
+		t.Fatalf("unexpected error deleting the product: %s", err.Error())
+	}
+	if err := c.PurgeProduct(ctx, kiwi.ID); err != nil {
+		t.Fatalf("unexpected error purging the product: %s", err.Error())
+	}
+	if _, err := c.RestoreProduct(ctx, kiwi.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected restoring a purged product to fail with 404, got %v", err)
+	}
+}
+
+func TestServices_deactivatedUserData(t *testing.T) {
+	c := newServices(t)
+	ctx := context.Background()
+
+	apple, err := c.CreateProduct(ctx, &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	user, err := c.CreateUser(ctx, &models.User{Name: "jdoe", Password: servicesAdminPassword, Role: roles.Customer})
+	if err != nil {
+		t.Fatalf("unexpected error creating a user: %s", err.Error())
+	}
+	token, err := c.ImpersonateUser(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("unexpected error impersonating the user: %s", err.Error())
+	}
+	config := c.config
+	config.Token = token
+	order, err := New(config).CreateOrder(ctx, &models.Order{OwnerID: &user.ID, Items: []*models.Item{{ProductID: apple.ID, Quantity: 2}}, PaymentInfo: models.PaymentInfo{Cash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error creating an order as the user: %s", err.Error())
+	}
+	if err := c.DeleteUser(ctx, user.ID, user.Version); err != nil {
+		t.Fatalf("unexpected error deleting the user: %s", err.Error())
+	}
+
+	// The data of deactivated users can still be exported and erased.
+	export, err := c.ExportUserData(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("unexpected error exporting the data of a deactivated user: %s", err.Error())
+	}
+	if export.User.Name != "jdoe" || len(export.Orders) != 1 || export.Orders[0].ID != order.ID || len(export.Orders[0].Items) != 1 {
+		t.Errorf("expected the export of jdoe and their order of apples, got user %+v and orders %+v", export.User, export.Orders)
+	}
+	if err := c.EraseUserData(ctx, user.ID); err != nil {
+		t.Fatalf("unexpected error erasing the data of a deactivated user: %s", err.Error())
+	}
+	if export, err = c.ExportUserData(ctx, user.ID); err != nil || export.User.Name != fmt.Sprintf("erased-user-%d", user.ID) {
+		t.Errorf("expected the user to be anonymized, got %+v (%v)", export, err)
+	}
+	if _, err := c.GetUser(ctx, user.ID, nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the erased user to stay deactivated, got %v", err)
+	}
+}
+
+func TestServices_updateOrderItems(t *testing.T) {
+	c := newServices(t)
+	ctx := context.Background()
+
+	apple, err := c.CreateProduct(ctx, &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	kiwi, err := c.CreateProduct(ctx, &models.Product{Name: "kiwi", Symbol: "🥝", Price: 2, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	first, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 1}}, PaymentInfo: models.PaymentInfo{Cash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error creating an order: %s", err.Error())
+	}
+	second, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 1}}, PaymentInfo: models.PaymentInfo{Cash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error creating an order: %s", err.Error())
+	}
+
+	// The items of an update belong to the updated order, even when they don't say which order they belong to.
+	second.Items = []*models.Item{{ProductID: kiwi.ID, Quantity: 2}}
+	second, err = c.UpdateOrder(ctx, second)
+	if err != nil {
+		t.Fatalf("unexpected error updating an order: %s", err.Error())
+	}
+	second, err = c.GetOrder(ctx, second.ID, nil)
+	if err != nil {
+		t.Fatalf("unexpected error reading the order: %s", err.Error())
+	}
+	if len(second.Items) != 1 || second.Items[0].ProductID != kiwi.ID || second.Items[0].OrderID != second.ID || second.Subtotal != 4 {
+		t.Errorf("expected the order to be 2 kiwis, got %+v with items %+v", second, second.Items)
+	}
+
+	// Items can't be moved from another order.
+	second.Items = []*models.Item{{OrderID: first.ID, ProductID: apple.ID, Quantity: 5}}
+	if _, err := c.UpdateOrder(ctx, second); !errors.Is(err, ErrBadRequest) {
+		t.Errorf("expected updating an order with an item of another order to fail with 400, got %v", err)
+	}
+	first, err = c.GetOrder(ctx, first.ID, nil)
+	if err != nil {
+		t.Fatalf("unexpected error reading the order: %s", err.Error())
+	}
+	if len(first.Items) != 1 || first.Items[0].Quantity != 1 || first.Subtotal != 1.5 {
+		t.Errorf("expected the other order to be left as it was, got %+v with items %+v", first, first.Items)
+	}
+}
+
+func TestServices_partiallyUpdateOrder(t *testing.T) {
+	c := newServices(t)
+	ctx := context.Background()
+
+	apple, err := c.CreateProduct(ctx, &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	kiwi, err := c.CreateProduct(ctx, &models.Product{Name: "kiwi", Symbol: "🥝", Price: 2, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	order, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 2}}, PaymentInfo: models.PaymentInfo{Cash: true}})
+	if err != nil {
+		t.Fatalf("unexpected error creating an order: %s", err.Error())
+	}
+
+	// A partial update (PUT with ?fields=) changes the items of the same product, adds the others, and stores the totals recalculated from all of them.
+	update := &models.Order{Model: order.Model, TaxRate: 0.5, Items: []*models.Item{{ProductID: kiwi.ID, Quantity: 1}}}
+	_, err = c.order(ctx, &request{method: http.MethodPut, baseURL: c.config.OrdersURL, path: idPath(ordersPath, order.ID), query: url.Values{"fields": {"items,taxrate"}}, header: ifMatch(order.Version), body: update, auth: true})
+	if err != nil {
+		t.Fatalf("unexpected error partially updating an order: %s", err.Error())
+	}
+	order, err = c.GetOrder(ctx, order.ID, nil)
+	if err != nil {
+		t.Fatalf("unexpected error reading the order: %s", err.Error())
+	}
+	if len(order.Items) != 2 || order.TaxRate != 0.5 || order.Subtotal != 5 || order.Tax != 2.5 || order.Total != 7.5 {
+		t.Errorf("expected the stored order to be 2 apples and a kiwi taxed at 50%%, got %+v with items %+v", order, order.Items)
+	}
+}
+
+func TestServices_auditRedactsCardDetails(t *testing.T) {
+	c := newServices(t)
+	ctx := context.Background()
+
+	apple, err := c.CreateProduct(ctx, &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	card := models.CreditCardInfo{Number: "4111111111111111", CardholderName: "Jane Cardholder", ExpirationDate: "12/30", Zipcode: "90210", Cvv: "737"}
+	order, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 1}}, PaymentInfo: models.PaymentInfo{CardInfo: card}})
+	if err != nil {
+		t.Fatalf("unexpected error creating an order: %s", err.Error())
+	}
+
+	it := c.ListAuditLog(ctx, &AuditOptions{Action: models.AuditActionCreate, EntityType: models.AuditEntityOrder, EntityID: order.ID})
+	if !it.Next() {
+		t.Fatalf("expected the order's creation to be audited (%v)", it.Err())
+	}
+	entry, err := encjson.Marshal(it.Entry())
+	if err != nil {
+		t.Fatalf("unexpected error encoding the audit entry: %s", err.Error())
+	}
+	for _, value := range []string{card.Number, card.CardholderName, card.ExpirationDate, card.Zipcode, card.Cvv} {
+		if strings.Contains(string(entry), value) {
+			t.Errorf("expected the audit entry to hold no card details, found %q in %s", value, entry)
+		}
+	}
+}
+
+func TestServices_listOrdersSortedByNestedField(t *testing.T) {
+	c := newServices(t)
+	ctx := context.Background()
+
+	apple, err := c.CreateProduct(ctx, &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	card := models.CreditCardInfo{Number: "4111111111111111", CardholderName: "Jane Cardholder", ExpirationDate: "12/30", Zipcode: "90210", Cvv: "737"}
+	for _, payment := range []models.PaymentInfo{{Cash: true}, {CardInfo: card}, {Cash: true}} {
+		if _, err := c.CreateOrder(ctx, &models.Order{Items: []*models.Item{{ProductID: apple.ID, Quantity: 1}}, PaymentInfo: payment}); err != nil {
+			t.Fatalf("unexpected error creating an order: %s", err.Error())
+		}
+	}
+
+	// Fields nested in the orders' JSON can be sorted on, one page at a time.
+	for name, field := range repository.OrderFields {
+		if field.JSON == "" {
+			continue
+		}
+		var ids []uint
+		it := c.ListOrders(ctx, &ListOptions{Sort: name, Limit: 1})
+		for it.Next() {
+			ids = append(ids, it.Order().ID)
+		}
+		if err := it.Err(); err != nil || len(ids) != 3 {
+			t.Errorf("expected 3 orders sorted by %s, one per page, got %v (%v)", name, ids, err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// Sign in through an external identity provider (/v1/users/oidc/login and /v1/users/oidc/callback) goes through a browser, so it has no method here.
+
+// CreateUser creates the supplied user, and returns it as it was stored. (without its password)
+func (c *Client) CreateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	return c.user(ctx, &request{method: http.MethodPost, baseURL: c.config.UsersURL, path: usersPath, body: user, auth: true})
+}
+
+// ListUsers returns an iterator over the users matching the supplied options. (nil to list all the active users)
+func (c *Client) ListUsers(ctx context.Context, opts *ListOptions) *UserIterator {
+	return &UserIterator{pager: c.newPager(ctx, c.config.UsersURL, usersPath, opts.query())}
+}
+
+// GetUser returns the user with the supplied id, with the fields and related records chosen in the supplied options. (nil for the defaults)
+func (c *Client) GetUser(ctx context.Context, id uint, opts *ReadOptions) (*models.User, error) {
+	return c.user(ctx, &request{method: http.MethodGet, baseURL: c.config.UsersURL, path: idPath(usersPath, id), query: opts.query(), auth: true})
+}
+
+// UpdateUser replaces the user with the id of the supplied user with it, and returns it as it was stored.
+// The update is refused with ErrPreconditionFailed if the user has changed since the version of the supplied user was read.
+func (c *Client) UpdateUser(ctx context.Context, user *models.User) (*models.User, error) {
+	return c.user(ctx, &request{method: http.MethodPut, baseURL: c.config.UsersURL, path: idPath(usersPath, user.ID), header: ifMatch(user.Version), body: user, auth: true})
+}
+
+// PatchUser applies the supplied patch to the user with the supplied id, and returns the patched user.
+// The patch is refused with ErrPreconditionFailed if the user has changed since the supplied version was read.
+func (c *Client) PatchUser(ctx context.Context, id uint, version uint, patch Patch) (*models.User, error) {
+	return c.user(ctx, &request{method: http.MethodPatch, baseURL: c.config.UsersURL, path: idPath(usersPath, id), header: ifMatch(version), body: patch.body, contentType: patch.mediaType, auth: true})
+}
+
+// DeleteUser deactivates the user with the supplied id: it can no longer log in, but it is kept and can be restored.
+// The delete is refused with ErrPreconditionFailed if the user has changed since the supplied version was read.
+func (c *Client) DeleteUser(ctx context.Context, id uint, version uint) error {
+	_, err := c.do(ctx, &request{method: http.MethodDelete, baseURL: c.config.UsersURL, path: idPath(usersPath, id), header: ifMatch(version), auth: true})
+	return err
+}
+
+// BatchUsers runs the operations of the supplied batch on users, and returns the result of each one, in order. The data of each result is a []*models.User.
+// When an atomic batch fails, its results are returned along with the error.
+func (c *Client) BatchUsers(ctx context.Context, batch models.Batch) ([]BatchResult, error) {
+	return c.batch(ctx, c.config.UsersURL, usersPath, batch)
+}
+
+// RestoreUser reactivates the deactivated user with the supplied id, and returns it.
+func (c *Client) RestoreUser(ctx context.Context, id uint) (*models.User, error) {
+	return c.user(ctx, &request{method: http.MethodPost, baseURL: c.config.UsersURL, path: idPath(usersPath, id) + "/restore", auth: true})
+}
+
+// PurgeUser removes the user with the supplied id for good. Its orders are kept or deleted, as the users service's order retention policy says.
+func (c *Client) PurgeUser(ctx context.Context, id uint) error {
+	_, err := c.do(ctx, &request{method: http.MethodDelete, baseURL: c.config.UsersURL, path: idPath(usersPath, id) + "/purge", auth: true})
+	return err
+}
+
+// ExportUserData returns all the personal data held about the user with the supplied id.
+func (c *Client) ExportUserData(ctx context.Context, id uint) (*models.UserDataExport, error) {
+	var export models.UserDataExport
+	if err := c.record(ctx, &request{method: http.MethodGet, baseURL: c.config.UsersURL, path: idPath(usersPath, id) + "/export", auth: true}, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// EraseUserData erases the personal data of the user with the supplied id, keeping its orders anonymously.
+func (c *Client) EraseUserData(ctx context.Context, id uint) error {
+	_, err := c.do(ctx, &request{method: http.MethodPost, baseURL: c.config.UsersURL, path: idPath(usersPath, id) + "/erase", auth: true})
+	return err
+}
+
+// ImpersonateUser returns a token to act as the user with the supplied id. Every action taken with it is audited as the admin's.
+// Pass it in the Token of the configuration of another client to use it.
+func (c *Client) ImpersonateUser(ctx context.Context, id uint) (string, error) {
+	res, err := c.do(ctx, &request{method: http.MethodPost, baseURL: c.config.UsersURL, path: idPath(usersPath, id) + "/impersonate", auth: true})
+	if err != nil {
+		return "", err
+	}
+	env, err := res.decode(nil)
+	if err != nil {
+		return "", err
+	}
+	if env.Token == "" {
+		return "", errors.New("impersonation response holds no token")
+	}
+	return env.Token, nil
+}
+
+// ListAuditLog returns an iterator over the entries of the audit log matching the supplied filters, oldest first. (nil to list them all)
+func (c *Client) ListAuditLog(ctx context.Context, opts *AuditOptions) *AuditIterator {
+	return &AuditIterator{pager: c.newPager(ctx, c.config.UsersURL, auditPath, opts.query())}
+}
+
+// VerifyAuditLog checks that no entry of the audit log was changed or removed, and returns the number of entries verified.
+// Returns an *Error with the status 409 Conflict if the audit log is broken.
+func (c *Client) VerifyAuditLog(ctx context.Context) (int, error) {
+	var verified int
+	err := c.record(ctx, &request{method: http.MethodGet, baseURL: c.config.UsersURL, path: auditPath + "/verify", auth: true}, &verified)
+	return verified, err
+}
+
+// PasswordFormat returns the requirements a password must meet, one per item.
+func (c *Client) PasswordFormat(ctx context.Context) ([]string, error) {
+	var requirements []string
+	err := c.record(ctx, &request{method: http.MethodGet, baseURL: c.config.UsersURL, path: usersPath + "/password-format"}, &requirements)
+	return requirements, err
+}
+
+// ListRoles returns the roles a user can have.
+func (c *Client) ListRoles(ctx context.Context) ([]string, error) {
+	var roles []string
+	err := c.record(ctx, &request{method: http.MethodGet, baseURL: c.config.UsersURL, path: usersPath + "/list-roles"}, &roles)
+	return roles, err
+}
+
+// UsersPageMaxRecordLimit returns the maximum number of users in a page of a listing.
+func (c *Client) UsersPageMaxRecordLimit(ctx context.Context) (int, error) {
+	return c.pageMaxRecordLimit(ctx, c.config.UsersURL, usersPath)
+}
+
+// UsersHealthy checks the health of the users service.
+func (c *Client) UsersHealthy(ctx context.Context) (bool, error) {
+	return c.healthy(ctx, c.config.UsersURL, usersPath)
+}
+
+// user sends the supplied request for a single user, and returns the user in the response.
+func (c *Client) user(ctx context.Context, req *request) (*models.User, error) {
+	var users []*models.User
+	if err := c.record(ctx, req, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errNoRecord
+	}
+	return users[0], nil
+}
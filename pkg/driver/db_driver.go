@@ -6,6 +6,16 @@ import "gorm.io/gorm"
 // DB holds the connections to different possible types of databases.
 type DB struct {
 	Postgres *gorm.DB
+	// Connection to an SQLite database file, set instead of Postgres when the records are kept in one.
+	SQLite *gorm.DB
 	// Mongo *mgo.database
 	// etc
 }
+
+// SQL returns the connection to the SQL database the driver is connected to, whichever it is.
+func (db *DB) SQL() *gorm.DB {
+	if db.SQLite != nil {
+		return db.SQLite
+	}
+	return db.Postgres
+}
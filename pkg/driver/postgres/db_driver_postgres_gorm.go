@@ -63,6 +63,79 @@ func SetupTables(db *driver.DB, object interface{}, init bool) error {
 			log.Error(msg)
 			return errors.New(msg)
 		}
+	} else if init {
+		// Add columns for fields added to the model since the table was created, leaving the existing data in place.
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || db.Postgres.Migrator().HasColumn(object, field.DBName) {
+				continue
+			}
+			err := db.Postgres.Migrator().AddColumn(object, field.Name)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to add column %s to table %s: %s", field.DBName, tableName, err.Error())
+				log.Error(msg)
+				return errors.New(msg)
+			}
+			log.Info(fmt.Sprintf("Added column %s to table %s", field.DBName, tableName))
+		}
+		// Add the foreign keys and indexes added to the model since the table was created. Fails if the existing data breaks them.
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			constraint := rel.ParseConstraint()
+			if constraint == nil || constraint.Schema != stmt.Schema || db.Postgres.Migrator().HasConstraint(object, constraint.Name) {
+				continue
+			}
+			err := db.Postgres.Migrator().CreateConstraint(object, constraint.Name)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to add constraint %s to table %s: %s", constraint.Name, tableName, err.Error())
+				log.Error(msg)
+				return errors.New(msg)
+			}
+			log.Info(fmt.Sprintf("Added constraint %s to table %s", constraint.Name, tableName))
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if db.Postgres.Migrator().HasIndex(object, index.Name) {
+				continue
+			}
+			err := db.Postgres.Migrator().CreateIndex(object, index.Name)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to add index %s to table %s: %s", index.Name, tableName, err.Error())
+				log.Error(msg)
+				return errors.New(msg)
+			}
+			log.Info(fmt.Sprintf("Added index %s to table %s", index.Name, tableName))
+		}
+	}
+	return nil
+}
+
+// SetupAppendOnlyTable installs triggers on the table for the given object that reject every update, delete and truncate,
+// so rows can only ever be inserted. Assumes the table already exists.
+func SetupAppendOnlyTable(db *driver.DB, object interface{}) error {
+	stmt := &gorm.Statement{DB: db.Postgres}
+	err := stmt.Parse(object)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse object %+v: %s", object, err.Error())
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	tableName := stmt.Schema.Table
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION fruitbar_reject_modification() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'table % is append-only', TG_TABLE_NAME;
+		END;
+		$$ LANGUAGE plpgsql`,
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %[1]s_append_only ON %[1]s`, tableName),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s_append_only BEFORE UPDATE OR DELETE ON %[1]s FOR EACH ROW EXECUTE PROCEDURE fruitbar_reject_modification()`, tableName),
+		fmt.Sprintf(`DROP TRIGGER IF EXISTS %[1]s_no_truncate ON %[1]s`, tableName),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s_no_truncate BEFORE TRUNCATE ON %[1]s FOR EACH STATEMENT EXECUTE PROCEDURE fruitbar_reject_modification()`, tableName),
+	}
+	for _, sql := range statements {
+		if err := db.Postgres.Exec(sql).Error; err != nil {
+			msg := fmt.Sprintf("Failed to make table %s append-only: %s", tableName, err.Error())
+			log.Error(msg)
+			return errors.New(msg)
+		}
 	}
+	log.Info("Table is append-only: " + tableName)
 	return nil
 }
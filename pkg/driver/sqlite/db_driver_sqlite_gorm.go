@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"github.com/tragicpixel/fruitbar/pkg/driver"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"moul.io/zapgorm2"
+)
+
+// Options of every connection: enforce foreign keys, let readers carry on while a write is in progress,
+// and wait for the other services sharing the file to finish writing instead of failing straight away.
+const options = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+
+// OpenConnection attempts to open a connection to an SQLite database file using gorm and returns a driver with a valid SQLite connection.
+// The file is created if it doesn't exist.
+func OpenConnection(connectionConfig *SQLiteConnectionConfig) (*driver.DB, error) {
+	zaplogger := zapgorm2.New(zap.L())
+	log.Info("Opening database file: " + connectionConfig.Path)
+	conn, err := gorm.Open(sqlite.Open("file:"+connectionConfig.Path+"?"+options), &gorm.Config{Logger: zaplogger})
+	if err != nil {
+		log.Error("Error opening database file: " + err.Error())
+		return nil, err
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		log.Error("Error opening database file: " + err.Error())
+		return nil, err
+	}
+	// SQLite only has one writer at a time anyway; going through a single connection queues the service's writes
+	// instead of having them fail to get the lock, and serializes its transactions like postgres' advisory locks would.
+	sqlDB.SetMaxOpenConns(1)
+	log.Info("Successfully opened the database file.")
+	db := driver.DB{SQLite: conn}
+	return &db, nil
+}
+
+// SetupTables checks that the SQLite database for the given database driver contains a table matching the schema gorm would create for that object.
+// Optionally, it can create the table if it is missing, and add the columns of fields added to the model since it was created.
+func SetupTables(db *driver.DB, object interface{}, init bool) error {
+	stmt := &gorm.Statement{DB: db.SQLite}
+	err := stmt.Parse(object)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse object %+v: %s", object, err.Error())
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	tableName := stmt.Schema.Table
+	migrator := db.SQLite.Migrator()
+	if !migrator.HasTable(object) {
+		log.Info("Table not found: " + tableName)
+		if !init {
+			msg := "Couldn't find table " + tableName
+			log.Error(msg)
+			return errors.New(msg)
+		}
+		err = migrator.CreateTable(object)
+		if err != nil {
+			msg := fmt.Sprintf("Failed to create table %s: %s", tableName, err.Error())
+			log.Error(msg)
+			return errors.New(msg)
+		}
+		log.Info("Created table: " + tableName)
+	} else if init {
+		// Add columns for fields added to the model since the table was created, leaving the existing data in place.
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName == "" || migrator.HasColumn(object, field.DBName) {
+				continue
+			}
+			err := migrator.AddColumn(object, field.Name)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to add column %s to table %s: %s", field.DBName, tableName, err.Error())
+				log.Error(msg)
+				return errors.New(msg)
+			}
+			log.Info(fmt.Sprintf("Added column %s to table %s", field.DBName, tableName))
+		}
+		// Add the foreign keys and indexes added to the model since the table was created. Fails if the existing data breaks them.
+		for _, rel := range stmt.Schema.Relationships.Relations {
+			constraint := rel.ParseConstraint()
+			if constraint == nil || constraint.Schema != stmt.Schema || migrator.HasConstraint(object, constraint.Name) {
+				continue
+			}
+			err := migrator.CreateConstraint(object, constraint.Name)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to add constraint %s to table %s: %s", constraint.Name, tableName, err.Error())
+				log.Error(msg)
+				return errors.New(msg)
+			}
+			log.Info(fmt.Sprintf("Added constraint %s to table %s", constraint.Name, tableName))
+		}
+		for _, index := range stmt.Schema.ParseIndexes() {
+			if migrator.HasIndex(object, index.Name) {
+				continue
+			}
+			err := migrator.CreateIndex(object, index.Name)
+			if err != nil {
+				msg := fmt.Sprintf("Failed to add index %s to table %s: %s", index.Name, tableName, err.Error())
+				log.Error(msg)
+				return errors.New(msg)
+			}
+			log.Info(fmt.Sprintf("Added index %s to table %s", index.Name, tableName))
+		}
+	}
+	return nil
+}
+
+// SetupAppendOnlyTable installs triggers on the table for the given object that reject every update and delete,
+// so rows can only ever be inserted. (SQLite has no truncate: deleting every row fires the delete trigger) Assumes the table already exists.
+func SetupAppendOnlyTable(db *driver.DB, object interface{}) error {
+	stmt := &gorm.Statement{DB: db.SQLite}
+	err := stmt.Parse(object)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse object %+v: %s", object, err.Error())
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	tableName := stmt.Schema.Table
+	statements := []string{
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_no_update BEFORE UPDATE ON %[1]s BEGIN SELECT RAISE(ABORT, 'table %[1]s is append-only'); END`, tableName),
+		fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_no_delete BEFORE DELETE ON %[1]s BEGIN SELECT RAISE(ABORT, 'table %[1]s is append-only'); END`, tableName),
+	}
+	for _, sql := range statements {
+		if err := db.SQLite.Exec(sql).Error; err != nil {
+			msg := fmt.Sprintf("Failed to make table %s append-only: %s", tableName, err.Error())
+			log.Error(msg)
+			return errors.New(msg)
+		}
+	}
+	log.Info("Table is append-only: " + tableName)
+	return nil
+}
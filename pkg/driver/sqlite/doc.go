@@ -0,0 +1,3 @@
+// Package sqlite provides an interface to connect to/set up an SQLite database file for use with the fruitbar application,
+// so a whole instance can run from a single file, without a database server. It uses a pure Go SQLite driver, so it doesn't need cgo.
+package sqlite
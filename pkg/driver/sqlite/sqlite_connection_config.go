@@ -0,0 +1,27 @@
+package sqlite
+
+import "os"
+
+// SQLiteConnectionConfig holds the properties necessary to configure a connection to an SQLite database file.
+type SQLiteConnectionConfig struct {
+	// Path of the database file, created if it doesn't exist.
+	Path string
+}
+
+const (
+	// Name of environment variable containing the path of the database file.
+	databasePathEnv = "FRUITBAR_DB_FILE"
+	// Path of the database file if the environment variable isn't set.
+	defaultDatabasePath = "fruitbar.db"
+)
+
+// NewSQLiteConnectionConfigFromEnv returns a new connection configuration based on the values of environment variables.
+func NewSQLiteConnectionConfigFromEnv() *SQLiteConnectionConfig {
+	path := os.Getenv(databasePathEnv)
+	if path == "" {
+		path = defaultDatabasePath
+	}
+	return &SQLiteConnectionConfig{
+		Path: path,
+	}
+}
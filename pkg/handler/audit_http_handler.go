@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/utils"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+)
+
+// Audit represents a handler for reading the audit log via HTTP.
+type Audit struct {
+	repo repository.Audit
+}
+
+// NewAuditHandler creates and initializes a new handler for reading the audit log via HTTP.
+func NewAuditHandler(repos *repository.Repositories) *Audit {
+	return &Audit{
+		repo: repos.Audit,
+	}
+}
+
+const (
+	auditActorIDParam        = "actorid"
+	auditActionParam         = "action"
+	auditEntityTypeParam     = "entitytype"
+	auditEntityIDParam       = "entityid"
+	auditRequestIDParam      = "requestid"
+	auditImpersonatorIDParam = "impersonatorid"
+	auditSinceParam          = "since"
+	auditUntilParam          = "until"
+)
+
+// GetAuditEntries sends a response to the supplied http response writer containing the requested page of audit entries,
+// filtered by the supplied http request's query parameters.
+func (h *Audit) GetAuditEntries(w http.ResponseWriter, r *http.Request) {
+	// The audit log is always read in the order it was appended, so there is nothing to filter or sort on beyond its own parameters.
+	seek, err := utils.GetPageSeekOptions(r, readAuditPageMaxRecordLimit, nil)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := getAuditFilter(r)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Reading %d audit entries (max %d) matching %+v...", seek.RecordLimit, readAuditPageMaxRecordLimit, *filter))
+	entries, err := h.repo.Fetch(utils.GetPeekSeekOptions(seek), filter)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error reading audit entries: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	start, end, more := utils.TrimPage(seek, len(entries))
+	entries = entries[start:end]
+	count, err := h.repo.Count(filter)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error counting audit entries: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	var first, last interface{}
+	startID, endID := uint(0), uint(0)
+	if len(entries) > 0 {
+		first, last = entries[0], entries[len(entries)-1]
+		startID = entries[0].ID
+		endID = entries[len(entries)-1].ID
+	}
+	page, err := utils.GetPage(seek, first, last, len(entries), more, count, nil)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error building cursors for audit page: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("audit=%d-%d/%d", startID, endID, count))
+	utils.SetPageLinkHeader(w, r, page)
+	log.Info(fmt.Sprintf("Read %d audit entries", len(entries)))
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: entries, Page: page})
+}
+
+// VerifyAuditLog walks the whole audit log checking its hash chain, and sends a response containing the number of entries verified.
+// If an entry has been changed or removed, a conflict is sent with the first entry that breaks the chain.
+func (h *Audit) VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	log.Info("Verifying the audit log...")
+	seek := &repository.PageSeekOptions{RecordLimit: readAuditPageMaxRecordLimit, Direction: repository.SeekDirectionNone}
+	prevHash, verified := "", 0
+	for {
+		entries, err := h.repo.Fetch(seek, nil)
+		if err != nil {
+			logMsg := fmt.Sprintf("Error reading audit entries for verification: %s", err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+		if len(entries) == 0 {
+			break
+		}
+		if err := models.VerifyAuditChain(prevHash, entries); err != nil {
+			log.Error("Audit log verification failed: " + err.Error())
+			json.WriteTypedErrorResponse(w, http.StatusConflict, auditLogBrokenErrType, "Audit log verification failed: "+err.Error())
+			return
+		}
+		verified += len(entries)
+		prevHash = entries[len(entries)-1].Hash
+		seek = &repository.PageSeekOptions{RecordLimit: readAuditPageMaxRecordLimit, Direction: repository.SeekDirectionAfter, StartId: entries[len(entries)-1].ID}
+	}
+	log.Info(fmt.Sprintf("Verified %d audit entries", verified))
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: verified})
+}
+
+// getAuditFilter returns the audit log filter set by the query parameters of the supplied http request.
+func getAuditFilter(r *http.Request) (*repository.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := repository.AuditFilter{
+		Action:     query.Get(auditActionParam),
+		EntityType: query.Get(auditEntityTypeParam),
+		RequestID:  query.Get(auditRequestIDParam),
+	}
+	var err error
+	if query.Has(auditActorIDParam) {
+		if filter.ActorID, err = httputils.GetQueryParamAsUint(r, auditActorIDParam); err != nil {
+			return nil, err
+		}
+	}
+	if query.Has(auditImpersonatorIDParam) {
+		if filter.ImpersonatorID, err = httputils.GetQueryParamAsUint(r, auditImpersonatorIDParam); err != nil {
+			return nil, err
+		}
+	}
+	if query.Has(auditEntityIDParam) {
+		if filter.EntityID, err = httputils.GetQueryParamAsUint(r, auditEntityIDParam); err != nil {
+			return nil, err
+		}
+	}
+	if query.Has(auditSinceParam) {
+		if filter.Since, err = time.Parse(time.RFC3339, query.Get(auditSinceParam)); err != nil {
+			return nil, fmt.Errorf("query parameter '%s' is not an RFC 3339 time: %s", auditSinceParam, query.Get(auditSinceParam))
+		}
+	}
+	if query.Has(auditUntilParam) {
+		if filter.Until, err = time.Parse(time.RFC3339, query.Get(auditUntilParam)); err != nil {
+			return nil, fmt.Errorf("query parameter '%s' is not an RFC 3339 time: %s", auditUntilParam, query.Get(auditUntilParam))
+		}
+	}
+	return &filter, nil
+}
+
+// appendAuditEntry records the supplied action, performed by the supplied client, in the supplied audit log,
+// along with the state of the entity before and after the action. (either may be nil)
+// Writes a response on the supplied http response writer if there is an error, which rolls back the transaction of the request making the change.
+func appendAuditEntry(w http.ResponseWriter, r *http.Request, repo repository.Audit, client *models.JwtClaim, action string, entityType string, id uint, before interface{}, after interface{}) bool {
+	entry := models.AuditEntry{
+		RequestID:  httputils.GetRequestID(r),
+		ActorID:    client.UserID,
+		ActorName:  client.UserName,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   id,
+	}
+	if client.IsImpersonation() {
+		entry.ImpersonatorID = client.Actor.UserID
+		entry.ImpersonatorName = client.Actor.UserName
+	}
+	err := entry.SetChange(before, after)
+	if err == nil {
+		err = repo.Append(&entry)
+	}
+	if err != nil {
+		logMsg := fmt.Sprintf("Error recording %s of %s (id: %d) in the audit log: %s", action, entityType, id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	return true
+}
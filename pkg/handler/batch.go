@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/jsonpatch"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+)
+
+// batchHandlers returns the http handler for each batch operation, performing its operations on the supplied repositories.
+type batchHandlers func(repos *repository.Repositories) map[string]http.HandlerFunc
+
+// HTTP method each batch operation is run with.
+var batchOperationMethods = map[string]string{
+	models.BatchOpCreate: http.MethodPost,
+	models.BatchOpUpdate: http.MethodPut,
+	models.BatchOpPatch:  http.MethodPatch,
+	models.BatchOpDelete: http.MethodDelete,
+}
+
+// Returned from an atomic batch's transaction to roll it back when one of its operations fails.
+var errBatchOperationFailed = errors.New("batch operation failed")
+
+// runBatch runs the batch of operations on records of the supplied type in the body of the supplied http request,
+// and sends a response containing the result of each operation, in order, to the supplied http response writer.
+// Each operation is run by the supplied handlers as a request of its own, with the same credentials, so it is checked and audited exactly like one.
+// A best-effort batch applies every operation it can, and always succeeds. An atomic batch runs in a single transaction,
+// which is rolled back when an operation fails; the batch then fails with that operation's status, and every other operation is marked as not applied.
+func runBatch(w http.ResponseWriter, r *http.Request, entityType string, repos *repository.Repositories, handlers batchHandlers) {
+	var batch models.Batch
+	response := *json.DecodeAndGetErrorResponse(w, r, &batch, json.MAX_BATCH_REQUEST_SIZE_IN_BYTES)
+	if response.Error != nil {
+		json.WriteErrorResponse(w, response.Error.Code, response.Error.Message)
+		return
+	}
+	if err := models.ValidateBatch(&batch); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Batch "+validationFailedErrMsgPrefix, err)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Running batch of %d %s operations (atomic: %t)...", len(batch.Operations), entityType, batch.Atomic))
+	results := make([]json.BatchResult, len(batch.Operations))
+	if !batch.Atomic {
+		ops := handlers(repos)
+		failed := 0
+		for i, op := range batch.Operations {
+			results[i] = runBatchOperation(r, ops[op.Op], op)
+			if results[i].Status >= http.StatusBadRequest {
+				failed++
+			}
+		}
+		log.Info(fmt.Sprintf("Ran batch of %d %s operations, %d failed", len(batch.Operations), entityType, failed))
+		json.WriteResponse(w, http.StatusOK, json.Response{Data: results})
+		return
+	}
+
+	failed := -1
+	err := repos.Transactions.Transaction(func(tx *repository.Repositories) error {
+		ops := handlers(tx)
+		for i, op := range batch.Operations {
+			results[i] = runBatchOperation(r, ops[op.Op], op)
+			if results[i].Status >= http.StatusBadRequest {
+				failed = i
+				return errBatchOperationFailed
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		log.Info(fmt.Sprintf("Applied batch of %d %s operations", len(batch.Operations), entityType))
+		json.WriteResponse(w, http.StatusOK, json.Response{Data: results})
+		return
+	}
+	if failed < 0 {
+		logMsg := fmt.Sprintf("Error committing batch of %s operations: %s", entityType, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+
+	status := results[failed].Status
+	reason := http.StatusText(status)
+	if results[failed].Error != nil {
+		reason = results[failed].Error.Message
+	}
+	for i := range results {
+		if i != failed {
+			results[i] = json.BatchResult{Status: http.StatusFailedDependency, Response: json.NewResponseWithError(http.StatusFailedDependency, batchOperationNotAppliedErrMsg)}
+		}
+	}
+	msg := fmt.Sprintf(batchFailedErrMsgPrefix+"Operation %d failed: %s", failed, reason)
+	log.Error(fmt.Sprintf("Rolled back batch of %d %s operations: operation %d failed: %s", len(batch.Operations), entityType, failed, reason))
+	json.WriteResponse(w, status, json.Response{Data: results, Error: &json.ErrorResponse{Code: status, Message: msg}})
+}
+
+// runBatchOperation runs the supplied batch operation with the supplied handler, as a request of its own made like the supplied batch request.
+// Returns the operation's result.
+func runBatchOperation(r *http.Request, handler http.HandlerFunc, op models.BatchOperation) json.BatchResult {
+	request, err := newBatchOperationRequest(r, op)
+	if err != nil {
+		return json.BatchResult{Status: http.StatusBadRequest, Response: json.NewResponseWithError(http.StatusBadRequest, "Operation "+validationFailedErrMsgPrefix+err.Error())}
+	}
+	recorder := responseRecorder{header: http.Header{}}
+	handler(&recorder, request)
+	return recorder.result()
+}
+
+// newBatchOperationRequest returns the http request that runs the supplied operation, made with the credentials and request id of the supplied batch request.
+func newBatchOperationRequest(r *http.Request, op models.BatchOperation) (*http.Request, error) {
+	body := []byte(op.Data)
+	contentType := "application/json"
+	switch op.Op {
+	case models.BatchOpUpdate:
+		// Updates read the id of the record to update from the record itself.
+		var record map[string]encjson.RawMessage
+		if err := encjson.Unmarshal(op.Data, &record); err != nil {
+			return nil, fmt.Errorf("data is not a record: %s", err.Error())
+		}
+		for field := range record {
+			if strings.EqualFold(field, "id") {
+				delete(record, field)
+			}
+		}
+		record["ID"] = encjson.RawMessage(strconv.FormatUint(uint64(op.ID), 10))
+		var err error
+		if body, err = encjson.Marshal(record); err != nil {
+			return nil, err
+		}
+	case models.BatchOpPatch:
+		contentType = jsonpatch.MergePatchMediaType
+		if bytes.HasPrefix(bytes.TrimSpace(op.Data), []byte("[")) {
+			contentType = jsonpatch.JSONPatchMediaType
+		}
+	case models.BatchOpDelete:
+		body = nil
+	}
+
+	request := r.Clone(r.Context())
+	request.Method = batchOperationMethods[op.Op]
+	query := url.Values{}
+	if op.ID != 0 {
+		query.Set(idParam, strconv.FormatUint(uint64(op.ID), 10))
+	}
+	request.URL.RawQuery = query.Encode()
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Del("If-None-Match")
+	request.Header.Del("If-Match")
+	if op.Version != 0 {
+		request.Header.Set("If-Match", httputils.ETag(op.Version))
+	}
+	return request, nil
+}
+
+// result returns the recorded response as the result of a batch operation.
+func (rec *responseRecorder) result() json.BatchResult {
+	result := json.BatchResult{Status: rec.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	if rec.body.Len() > 0 {
+		if err := encjson.Unmarshal(rec.body.Bytes(), &result.Response); err != nil {
+			log.Error("Failed to decode batch operation response: " + err.Error())
+		}
+	}
+	return result
+}
@@ -1,34 +1,67 @@
 // Package handler provides implementations for handlers that will handle incoming requests to perform operations on repositories of various data types in the fruitbar application.
+//
+// Every request changing records runs in a single transaction, and appends its audit entry in that transaction:
+// when the entry can't be appended, the request fails and its changes are rolled back, so no change is ever missing from the audit log.
+// Audited requests that change no records (exports, impersonation, and requests made with an impersonation token) fail before anything is sent instead.
 package handler
 
 const (
-	validationFailedErrMsgPrefix = "Validation failed: "
-	internalServerErrMsg         = "Internal server error. Please contact your system administrator."
-	unauthorizedErrMsg           = "Authorization failed."
-	unauthorizedErrMsgPrefix     = "Authorization failed: "
-	forbiddenErrMsgPrefix        = "Forbidden: Not enough privileges to "
+	validationFailedErrMsgPrefix     = "Validation failed: "
+	internalServerErrMsg             = "Internal server error. Please contact your system administrator."
+	unauthorizedErrMsg               = "Authorization failed."
+	unauthorizedErrMsgPrefix         = "Authorization failed: "
+	forbiddenErrMsgPrefix            = "Forbidden: Not enough privileges to "
+	patchedRecordInvalidErrMsgPrefix = "Patched record is invalid: "
+	preconditionRequiredErrMsg       = "An If-Match header with the record's ETag is required. Read the record first."
+	preconditionFailedErrMsg         = "The record has been changed since it was read. Read it again and retry."
+	batchFailedErrMsgPrefix          = "Batch rolled back, no operations were applied. "
+	batchOperationNotAppliedErrMsg   = "Not applied: another operation in the batch failed."
+	idempotencyKeyTooLongErrMsg      = "The Idempotency-Key header must not be longer than 255 characters."
+	idempotencyKeyReusedErrMsg       = "This Idempotency-Key was already used for a different request. Use a new key for a new request."
+	idempotencyKeyInFlightErrMsg     = "A request with this Idempotency-Key is still being handled. Retry it later."
 
 	forbiddenCreateOrderErrMsg = forbiddenErrMsgPrefix + "create this Order."
 	forbiddenReadOrderErrMsg   = forbiddenErrMsgPrefix + "read this Order."
 	forbiddenUpdateOrderErrMsg = forbiddenErrMsgPrefix + "update this Order."
 	forbiddenDeleteOrderErrMsg = forbiddenErrMsgPrefix + "delete this Order."
 	orderNotFoundMsg           = "The specified order could not be found."
+	itemNotFoundMsg            = "The specified item could not be found in this order."
+
+	forbiddenChangeOrderStatusErrMsg = forbiddenErrMsgPrefix + "change the status of this Order."
+	repriceNotPendingOrderErrMsg     = "Only pending orders can be repriced."
 
 	forbiddenCreateUserErrMsg = forbiddenErrMsgPrefix + "create Users with the 'employee' or 'admin' roles."
 	forbiddenReadUserErrMsg   = forbiddenErrMsgPrefix + "read this User."
 	forbiddenUpdateUserErrMsg = forbiddenErrMsgPrefix + "update this User."
 	forbiddenDeleteUserErrMsg = forbiddenErrMsgPrefix + "delete this User."
-	userNotFoundMsg           = "The specified user could not be found."
+	forbiddenEraseUserErrMsg  = forbiddenErrMsgPrefix + "erase this User."
+
+	forbiddenImpersonateUserErrMsg          = forbiddenErrMsgPrefix + "impersonate this User."
+	forbiddenImpersonationCredentialsErrMsg = forbiddenErrMsgPrefix + "change a password or role while impersonating a User."
+	userNotFoundMsg                         = "The specified user could not be found."
+	inactiveUserNotFoundMsg                 = "The specified deactivated user could not be found."
+	userDeactivatedErrMsg                   = "This user account has been deactivated."
+	purgeActiveUserErrMsg                   = "Only deactivated users can be purged. Deactivate the user first."
+
+	forbiddenReadInactiveUsersErrMsg = forbiddenErrMsgPrefix + "read deactivated Users."
 
 	forbiddenCreateProductErrMsg = forbiddenErrMsgPrefix + "create a Product."
 	forbiddenUpdateProductErrMsg = forbiddenErrMsgPrefix + "update a Product."
 	forbiddenDeleteProductErrMsg = forbiddenErrMsgPrefix + "delete a Product."
 	productNotFoundMsg           = "The specified product could not be found."
+	archivedProductNotFoundMsg   = "The specified archived product could not be found."
+	purgeActiveProductErrMsg     = "Only archived products can be purged. Archive the product first."
+	purgeProductInUseErrMsg      = "Products that have been ordered can't be purged, only archived."
+
+	forbiddenReadArchivedProductsErrMsg = forbiddenErrMsgPrefix + "read archived Products."
 
 	idParam     = "id"
+	itemIDParam = "itemId"
 	fieldsParam = "fields"
+	statusParam = "status"
 
 	readUsersPageMaxRecordLimit    = 1000
 	readOrdersPageMaxRecordLimit   = 1000
 	readProductsPageMaxRecordLimit = 1000
+	readAuditPageMaxRecordLimit    = 1000
 )
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// Types of the errors the handlers respond with, so clients can tell them apart without matching their messages.
+// Their names are part of the API: don't change them.
+var (
+	unauthorizedErrType           = json.ErrorType{Name: "unauthorized", Title: "Authorization failed"}
+	invalidCredentialsErrType     = json.ErrorType{Name: "invalid-credentials", Title: "Invalid user credentials"}
+	signInFailedErrType           = json.ErrorType{Name: "sign-in-failed", Title: "Single sign-on failed"}
+	userDeactivatedErrType        = json.ErrorType{Name: "user-deactivated", Title: "User account deactivated"}
+	forbiddenErrType              = json.ErrorType{Name: "forbidden", Title: "Not enough privileges"}
+	validationFailedErrType       = json.ErrorType{Name: "validation-failed", Title: "Validation failed"}
+	invalidPatchErrType           = json.ErrorType{Name: "invalid-patch", Title: "Malformed patch"}
+	patchConflictErrType          = json.ErrorType{Name: "patch-conflict", Title: "Patch can't be applied"}
+	patchedRecordInvalidErrType   = json.ErrorType{Name: "patched-record-invalid", Title: "Patched record is invalid"}
+	preconditionRequiredErrType   = json.ErrorType{Name: "precondition-required", Title: "If-Match header required"}
+	versionConflictErrType        = json.ErrorType{Name: "version-conflict", Title: "Record changed since it was read"}
+	idempotencyKeyInvalidErrType  = json.ErrorType{Name: "idempotency-key-invalid", Title: "Invalid Idempotency-Key"}
+	idempotencyKeyReusedErrType   = json.ErrorType{Name: "idempotency-key-reused", Title: "Idempotency-Key reused for a different request"}
+	idempotencyKeyInFlightErrType = json.ErrorType{Name: "idempotency-key-in-flight", Title: "Request with this Idempotency-Key still in progress"}
+	orderNotFoundErrType          = json.ErrorType{Name: "order-not-found", Title: "Order not found"}
+	orderNotPendingErrType        = json.ErrorType{Name: "order-not-pending", Title: "Order is not pending"}
+	itemNotFoundErrType           = json.ErrorType{Name: "item-not-found", Title: "Item not found"}
+	productNotFoundErrType        = json.ErrorType{Name: "product-not-found", Title: "Product not found"}
+	productActiveErrType          = json.ErrorType{Name: "product-active", Title: "Product is not archived"}
+	productInUseErrType           = json.ErrorType{Name: "product-in-use", Title: "Product has been ordered"}
+	userNotFoundErrType           = json.ErrorType{Name: "user-not-found", Title: "User not found"}
+	userActiveErrType             = json.ErrorType{Name: "user-active", Title: "User is active"}
+	userExistsErrType             = json.ErrorType{Name: "user-exists", Title: "User already exists"}
+	auditLogBrokenErrType         = json.ErrorType{Name: "audit-log-broken", Title: "Audit log verification failed"}
+)
+
+// writeValidationErrorResponse writes a response for the supplied error validating a record to the supplied http response writer,
+// with the supplied status, type and message prefix, listing each field of the record that is invalid.
+func writeValidationErrorResponse(w http.ResponseWriter, status int, errType json.ErrorType, msgPrefix string, err error) {
+	var params []json.InvalidParam
+	for _, fieldErr := range models.FieldErrors(err) {
+		if fieldErr.Field != "" {
+			params = append(params, json.InvalidParam{Name: fieldErr.Field, Reason: fieldErr.Error()})
+		}
+	}
+	json.WriteError(w, json.Error{Status: status, Type: errType, Message: msgPrefix + err.Error(), InvalidParams: params})
+}
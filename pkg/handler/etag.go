@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// setETag sets the ETag header of the response on the supplied http response writer to the tag of the supplied record version.
+func setETag(w http.ResponseWriter, version uint) {
+	w.Header().Set("ETag", httputils.ETag(version))
+}
+
+// notModified checks whether the If-None-Match header of the supplied http request matches the supplied version of the record it reads,
+// and if so sends a 304 Not Modified response. Either way, the ETag header of the response is set to the record's.
+func notModified(w http.ResponseWriter, r *http.Request, version uint) bool {
+	setETag(w, version)
+	if !httputils.MatchesETag(r.Header.Get("If-None-Match"), httputils.ETag(version), true) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// clientHasCurrentVersion checks that the If-Match header of the supplied http request matches the supplied current version of the record it changes,
+// so a client can't overwrite changes it hasn't seen. Writes a response on the supplied http response writer if there is an error.
+func clientHasCurrentVersion(w http.ResponseWriter, r *http.Request, version uint) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		json.WriteTypedErrorResponse(w, http.StatusPreconditionRequired, preconditionRequiredErrType, preconditionRequiredErrMsg)
+		return false
+	}
+	if !httputils.MatchesETag(header, httputils.ETag(version), false) {
+		json.WriteTypedErrorResponse(w, http.StatusPreconditionFailed, versionConflictErrType, preconditionFailedErrMsg)
+		return false
+	}
+	return true
+}
+
+// writeUpdateErrorResponse writes the response for the supplied error updating a record to the supplied http response writer:
+// a precondition failure if someone else updated the record first, otherwise an internal server error with the supplied log message.
+func writeUpdateErrorResponse(w http.ResponseWriter, err error, logMsg string) {
+	if errors.Is(err, repository.ErrVersionConflict) {
+		json.WriteTypedErrorResponse(w, http.StatusPreconditionFailed, versionConflictErrType, preconditionFailedErrMsg, logMsg)
+		return
+	}
+	json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	jwtrepo "github.com/tragicpixel/fruitbar/pkg/repository/jwt"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	jwtutils "github.com/tragicpixel/fruitbar/pkg/utils/jwt"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"gorm.io/gorm"
+)
+
+// DefaultIdempotencyKeyTTL is how long the response to a request made with an idempotency key is kept for replay, unless configured otherwise.
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+// How long a key is held for a request being handled. A key still held after that, e.g. by a request whose service crashed,
+// is taken over by the next request with it.
+const idempotencyKeyLease = time.Minute
+
+// Maximum length of an idempotency key supplied by a client.
+const maxIdempotencyKeyLength = 255
+
+// Idempotency represents a handler that makes unsafe requests safe to retry: the response to a request made with an Idempotency-Key header
+// is stored, and replayed for any retry of the request with the same key, instead of handling it again.
+type Idempotency struct {
+	repo    repository.Idempotency
+	jwtRepo repository.Jwt
+	ttl     time.Duration
+	lease   time.Duration
+}
+
+// NewIdempotencyHandler creates and initializes a new handler that keeps the responses to requests made with an idempotency key in the supplied repositories, for the supplied duration.
+// A duration of zero keeps them for DefaultIdempotencyKeyTTL.
+func NewIdempotencyHandler(repos *repository.Repositories, ttl time.Duration) *Idempotency {
+	if ttl <= 0 {
+		ttl = DefaultIdempotencyKeyTTL
+	}
+	return &Idempotency{
+		repo:    repos.Idempotency,
+		jwtRepo: jwtrepo.NewJWTRepository(),
+		ttl:     ttl,
+		lease:   idempotencyKeyLease,
+	}
+}
+
+// Idempotent makes the supplied http handler safe to retry. Requests without an Idempotency-Key header are passed on as they are.
+// The first request with a key is passed on, and its response stored for the client's user. A retry with the same key gets the stored response,
+// with the Idempotent-Replayed header set. A request reusing the key for a different method, URI or body gets a 422,
+// and a retry made while the first request is still being handled gets a 409. The key is only held for a short lease while the request is handled,
+// so a request that never finishes (e.g. because the service crashed) doesn't hold it until it expires.
+// Responses with a server error aren't stored, and handlers that panic store nothing, so the request can be retried once the error is fixed.
+// Must be called after the client has been authorized, as keys are scoped to the client's user.
+func (h *Idempotency) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(httputils.IdempotencyKeyHeader)
+		if key == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			json.WriteTypedErrorResponse(w, http.StatusBadRequest, idempotencyKeyInvalidErrType, idempotencyKeyTooLongErrMsg)
+			return
+		}
+		client, err := jwtutils.GetTokenClaims(r, h.jwtRepo)
+		if err != nil {
+			logMsg := unauthorizedErrMsgPrefix + err.Error()
+			json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, json.MAX_BATCH_REQUEST_SIZE_IN_BYTES))
+		if err != nil {
+			msg := "request body must not be larger than " + strconv.Itoa(json.MAX_BATCH_REQUEST_SIZE_IN_BYTES) + " bytes"
+			json.WriteErrorResponse(w, http.StatusRequestEntityTooLarge, msg)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := models.IdempotencyRecord{
+			Key:         key,
+			UserID:      client.UserID,
+			RequestHash: models.IdempotencyRequestHash(r.Method, r.URL.RequestURI(), body),
+			ExpiresAt:   time.Now().Add(h.lease),
+		}
+		existing, err := h.repo.Reserve(&rec)
+		if err != nil {
+			// The key was released by the request holding it between us trying to claim it and reading it back.
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				json.WriteTypedErrorResponse(w, http.StatusConflict, idempotencyKeyInFlightErrType, idempotencyKeyInFlightErrMsg)
+				return
+			}
+			logMsg := fmt.Sprintf("Error reserving idempotency key for user (id: %d): %s", client.UserID, err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+		if existing != nil {
+			h.replay(w, existing, &rec)
+			return
+		}
+
+		recorder := idempotencyRecorder{ResponseWriter: w}
+		handled := false
+		defer func() {
+			// A handler that panics (or otherwise never returns) leaves no response to store, so free the key for a retry.
+			if !handled {
+				h.release(&rec)
+			}
+		}()
+		next.ServeHTTP(&recorder, r)
+		handled = true
+		h.store(&rec, &recorder)
+	})
+}
+
+// replay sends the response stored in the supplied existing record to the supplied http response writer,
+// if the supplied request record is a retry of the request it holds. Otherwise sends an error.
+func (h *Idempotency) replay(w http.ResponseWriter, existing *models.IdempotencyRecord, rec *models.IdempotencyRecord) {
+	if existing.RequestHash != rec.RequestHash {
+		json.WriteTypedErrorResponse(w, http.StatusUnprocessableEntity, idempotencyKeyReusedErrType, idempotencyKeyReusedErrMsg)
+		return
+	}
+	if !existing.Completed {
+		w.Header().Set("Retry-After", "1")
+		json.WriteTypedErrorResponse(w, http.StatusConflict, idempotencyKeyInFlightErrType, idempotencyKeyInFlightErrMsg)
+		return
+	}
+	var header http.Header
+	if err := encjson.Unmarshal([]byte(existing.Header), &header); err != nil {
+		logMsg := fmt.Sprintf("Error decoding stored response headers (idempotency record id: %d): %s", existing.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	for name, values := range header {
+		// The retry keeps its own request id.
+		if name == httputils.RequestIDHeader {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set(httputils.IdempotentReplayedHeader, "true")
+	log.Info(fmt.Sprintf("Replaying stored response (idempotency record id: %d) for user (id: %d)", existing.ID, existing.UserID))
+	w.WriteHeader(existing.Status)
+	if _, err := w.Write(existing.Body); err != nil {
+		log.Error(fmt.Sprintf("failed to write: %s", err.Error()))
+	}
+}
+
+// store stores the response recorded by the supplied recorder in the supplied record, to be replayed until the key expires,
+// or releases its key if the response was a server error.
+func (h *Idempotency) store(rec *models.IdempotencyRecord, recorder *idempotencyRecorder) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	if recorder.status >= http.StatusInternalServerError {
+		h.release(rec)
+		return
+	}
+	header, err := encjson.Marshal(recorder.header)
+	if err != nil {
+		log.Error(fmt.Sprintf("Error encoding response headers (idempotency record id: %d): %s", rec.ID, err.Error()))
+		h.release(rec)
+		return
+	}
+	rec.Status = recorder.status
+	rec.Header = string(header)
+	rec.Body = recorder.body.Bytes()
+	rec.ExpiresAt = time.Now().Add(h.ttl)
+	if err := h.repo.Complete(rec); err != nil {
+		log.Error(fmt.Sprintf("Error storing response (idempotency record id: %d): %s", rec.ID, err.Error()))
+		h.release(rec)
+	}
+}
+
+// release removes the supplied record, so its key can be used again. The response has already been sent, so errors are only logged.
+func (h *Idempotency) release(rec *models.IdempotencyRecord) {
+	if err := h.repo.Release(rec.ID); err != nil {
+		log.Error(fmt.Sprintf("Error releasing idempotency key (idempotency record id: %d): %s", rec.ID, err.Error()))
+	}
+}
+
+// idempotencyRecorder is an http response writer that passes the response on to the client, and records it to be stored.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader sends and records the supplied status code, along with the headers sent with it.
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends and records the supplied part of the response body.
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the http response writer the recorder passes the response on to.
+func (rec *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
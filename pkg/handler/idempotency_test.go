@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+)
+
+// newIdempotentRequest returns a request made by an admin with the supplied idempotency key.
+func newIdempotentRequest(t *testing.T, key string) *http.Request {
+	r := newAdminRequest(t, http.MethodPost, "/v1/products", `{"name":"apple"}`)
+	r.Header.Set(httputils.IdempotencyKeyHeader, key)
+	return r
+}
+
+func TestIdempotent_leaseAndPanic(t *testing.T) {
+	h := NewIdempotencyHandler(memory.NewMemoryRepositories(memory.NewStore()), time.Hour)
+	calls := 0
+	blocked := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// A handler that panics leaves the key free for a retry.
+	panicking := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("expected the handler's panic to be passed on, got %v", p)
+			}
+		}()
+		panicking(httptest.NewRecorder(), newIdempotentRequest(t, "panic"))
+	}()
+	resp := httptest.NewRecorder()
+	blocked(resp, newIdempotentRequest(t, "panic"))
+	if resp.Code != http.StatusCreated || calls != 1 {
+		t.Errorf("expected a retry after a panic to be handled, got %d after %d calls", resp.Code, calls)
+	}
+
+	// A request still being handled holds its key, but only for its lease.
+	h.lease = 50 * time.Millisecond
+	var retry *httptest.ResponseRecorder
+	stuck := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		retry = httptest.NewRecorder()
+		blocked(retry, newIdempotentRequest(t, "stuck"))
+		if retry.Code != http.StatusConflict {
+			t.Errorf("expected a retry while the request is handled to fail with 409, got %d", retry.Code)
+		}
+		time.Sleep(2 * h.lease)
+		retry = httptest.NewRecorder()
+		blocked(retry, newIdempotentRequest(t, "stuck"))
+		w.WriteHeader(http.StatusCreated)
+	})
+	stuck(httptest.NewRecorder(), newIdempotentRequest(t, "stuck"))
+	if retry.Code != http.StatusCreated || calls != 2 {
+		t.Errorf("expected a retry after the lease to take the key over, got %d after %d calls", retry.Code, calls)
+	}
+
+	// Once handled, the response is replayed for the key's whole TTL, well past the lease.
+	time.Sleep(2 * h.lease)
+	resp = httptest.NewRecorder()
+	blocked(resp, newIdempotentRequest(t, "stuck"))
+	if resp.Code != http.StatusCreated || resp.Header().Get(httputils.IdempotentReplayedHeader) != "true" || calls != 2 {
+		t.Errorf("expected the response to be replayed after the lease, got %d (replayed: %q) after %d calls", resp.Code, resp.Header().Get(httputils.IdempotentReplayedHeader), calls)
+	}
+}
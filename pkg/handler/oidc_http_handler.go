@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	jwtrepo "github.com/tragicpixel/fruitbar/pkg/repository/jwt"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	jwtutils "github.com/tragicpixel/fruitbar/pkg/utils/jwt"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc"
+
+	"gorm.io/gorm"
+)
+
+// OIDC represents a handler for signing users in through an external OpenID Connect identity provider via HTTP.
+type OIDC struct {
+	provider     *oidc.Provider
+	states       *oidc.StateStore
+	repo         repository.User
+	identityRepo repository.ExternalIdentity
+	transactions repository.Transactor
+	jwtRepo      repository.Jwt
+}
+
+// NewOIDCHandler creates and initializes a new handler for signing users in through the supplied OpenID Connect provider via HTTP,
+// as the users in the supplied repositories.
+func NewOIDCHandler(repos *repository.Repositories, provider *oidc.Provider) *OIDC {
+	return &OIDC{
+		provider:     provider,
+		states:       oidc.NewStateStore(),
+		repo:         repos.Users,
+		identityRepo: repos.Identities,
+		transactions: repos.Transactions,
+		jwtRepo:      jwtrepo.NewJWTRepository(),
+	}
+}
+
+const (
+	oidcStateParam = "state"
+	oidcCodeParam  = "code"
+	oidcErrorParam = "error"
+
+	// Name of the cookie binding an authorization request to the user agent that started it, holding its state.
+	oidcStateCookie = "fruitbar_oidc_state"
+
+	oidcLoginFailedErrMsg = "Single sign-on failed. Please try again."
+)
+
+// Login starts a new authorization request and redirects the client to the identity provider to authenticate.
+// The state of the request is also set in a cookie, so only the user agent that started it can complete it.
+func (h *OIDC) Login(w http.ResponseWriter, r *http.Request) {
+	authRequest, err := oidc.NewAuthRequest()
+	if err != nil {
+		logMsg := "Failed to start OIDC authorization request: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	h.states.Save(authRequest)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    authRequest.State,
+		Path:     "/",
+		Expires:  authRequest.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax, so the cookie is sent along when the identity provider redirects the user agent back.
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Info("Redirecting client to OIDC provider " + h.provider.Issuer())
+	http.Redirect(w, r, h.provider.AuthCodeURL(authRequest), http.StatusFound)
+}
+
+// Callback completes an authorization request when the identity provider sends the client back,
+// links the authenticated subject to a user, and sends a response containing a fruitbar JSON Web Token.
+// The request is refused unless the client sends the state cookie set when it started the authorization request. (to prevent login CSRF)
+func (h *OIDC) Callback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	// The authorization request is over either way, so the cookie is no longer needed.
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/", MaxAge: -1, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+	if query.Has(oidcErrorParam) {
+		logMsg := "OIDC provider returned an error: " + query.Get(oidcErrorParam)
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, signInFailedErrType, oidcLoginFailedErrMsg, logMsg)
+		return
+	}
+	state := query.Get(oidcStateParam)
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		logMsg := "OIDC callback state doesn't match the state cookie of the client"
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, signInFailedErrType, oidcLoginFailedErrMsg, logMsg)
+		return
+	}
+	authRequest, ok := h.states.Take(state)
+	if !ok {
+		logMsg := "OIDC callback state is unknown or expired"
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, signInFailedErrType, oidcLoginFailedErrMsg, logMsg)
+		return
+	}
+	code := query.Get(oidcCodeParam)
+	if code == "" {
+		json.WriteErrorResponse(w, http.StatusBadRequest, "query parameter '"+oidcCodeParam+"' is not set")
+		return
+	}
+
+	tokens, err := h.provider.Exchange(code, authRequest.CodeVerifier)
+	if err != nil {
+		logMsg := "Failed to exchange OIDC authorization code: " + err.Error()
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, signInFailedErrType, oidcLoginFailedErrMsg, logMsg)
+		return
+	}
+	claims, err := h.provider.VerifyIDToken(tokens.IDToken, authRequest.Nonce)
+	if err != nil {
+		logMsg := "Failed to verify OIDC id token: " + err.Error()
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, signInFailedErrType, oidcLoginFailedErrMsg, logMsg)
+		return
+	}
+
+	user := h.getLinkedUser(w, claims)
+	if user == nil {
+		return
+	}
+
+	jwt := jwtutils.GetSecretAuthToken()
+	jwt.ExpirationHours = jwtutils.JWT_EXPIRATION_HOURS
+	signedToken, err := h.jwtRepo.GenerateToken(&jwt, user)
+	if err != nil {
+		logMsg := "Failed to generate token: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	log.Info(fmt.Sprintf("OIDC authentication successful for user '%s' (id: %d)", user.Name, user.ID))
+	json.WriteResponse(w, http.StatusOK, json.Response{Token: signedToken})
+}
+
+// getLinkedUser returns the user linked to the subject of the supplied claims, creating and linking a new user on first sign in.
+// The user's role is kept in sync with the role mapped from the claims.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *OIDC) getLinkedUser(w http.ResponseWriter, claims oidc.Claims) *models.User {
+	issuer, subject := h.provider.Issuer(), claims.Subject()
+	role := h.provider.Role(claims)
+
+	log.Info(fmt.Sprintf("Selecting user linked to OIDC subject %s...", subject))
+	identity, err := h.identityRepo.GetBySubject(issuer, subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logMsg := fmt.Sprintf("Failed to select OIDC identity for subject %s: %s", subject, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	if identity == nil {
+		return h.createLinkedUser(w, issuer, subject, claimedUserName(claims), role)
+	}
+
+	user, err := h.repo.GetByID(identity.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			logMsg := fmt.Sprintf("User (id: %d) linked to OIDC subject %s is deactivated", identity.UserID, subject)
+			json.WriteTypedErrorResponse(w, http.StatusForbidden, userDeactivatedErrType, userDeactivatedErrMsg, logMsg)
+			return nil
+		}
+		logMsg := fmt.Sprintf("Failed to select user (id: %d) linked to OIDC subject %s: %s", identity.UserID, subject, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	if user.Role != role {
+		log.Info(fmt.Sprintf("Updating role of user (id: %d) from '%s' to '%s' based on OIDC claims", user.ID, user.Role, role))
+		user.Role = role
+		_, err = h.repo.Update(user, []string{"role"})
+		if err != nil {
+			logMsg := fmt.Sprintf("Failed to update role of user (id: %d): %s", user.ID, err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return nil
+		}
+	}
+	return user
+}
+
+// createLinkedUser creates a new user with the supplied name and role, and links it to the supplied subject, in a single transaction,
+// so a user is never left behind without its link. Writes a response on the supplied http response writer if there is an error.
+func (h *OIDC) createLinkedUser(w http.ResponseWriter, issuer string, subject string, name string, role string) *models.User {
+	existing, err := h.repo.GetByUsername(name)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logMsg := fmt.Sprintf("Failed to check if user %s exists: %s", name, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	if existing != nil {
+		// Never link to an existing local account by name alone, that would let the identity provider take it over.
+		msg := fmt.Sprintf("Failed to create user %s: a user with that name already exists", name)
+		json.WriteTypedErrorResponse(w, http.StatusConflict, userExistsErrType, msg)
+		return nil
+	}
+
+	// Users signing in through the identity provider have no fruitbar password, so store a hash of a random one nobody knows.
+	password, err := generateUnusablePassword()
+	if err != nil {
+		logMsg := "Failed to generate password: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	user := models.User{Name: name, Role: role}
+	if err := h.repo.HashPassword(&user, password); err != nil {
+		logMsg := "Failed to hash password: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+
+	log.Info(fmt.Sprintf("Creating new user '%s' for OIDC subject %s...", name, subject))
+	err = h.transactions.Transaction(func(tx *repository.Repositories) error {
+		id, err := tx.Users.Create(&user)
+		if err != nil {
+			return fmt.Errorf("failed to create new user %s: %s", name, err.Error())
+		}
+		_, err = tx.Identities.Create(&models.ExternalIdentity{Issuer: issuer, Subject: subject, UserID: id})
+		if err != nil {
+			return fmt.Errorf("failed to link user (id: %d) to OIDC subject %s: %s", id, subject, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		logMsg := fmt.Sprintf("Failed to create user linked to OIDC subject %s: %s", subject, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	log.Info(fmt.Sprintf("Created new user '%s' (id: %d) linked to OIDC subject %s", name, user.ID, subject))
+	return &user
+}
+
+// claimedUserName returns the name to give a new user based on the supplied claims.
+func claimedUserName(claims oidc.Claims) string {
+	for _, claim := range []string{"preferred_username", "email"} {
+		if name := claims.String(claim); name != "" {
+			return name
+		}
+	}
+	return claims.Subject()
+}
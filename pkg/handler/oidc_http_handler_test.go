@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc/oidctest"
+)
+
+// newOIDCHandler returns a handler signing users in through the supplied mock provider, keeping its records in the returned repositories.
+func newOIDCHandler(t *testing.T, m *oidctest.Provider) (*OIDC, *repository.Repositories) {
+	provider, err := oidc.NewProvider(m.Config(), nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating the provider: %s", err.Error())
+	}
+	repos := memory.NewMemoryRepositories(memory.NewStore())
+	return NewOIDCHandler(repos, provider), repos
+}
+
+// signIn signs in through the supplied handler as the subject of the mock provider's claims, sending the callback the returned cookie
+// of the login when sendCookie is set, and returns the response of the callback.
+func signIn(t *testing.T, h *OIDC, sendCookie bool) *httptest.ResponseRecorder {
+	t.Helper()
+	login := httptest.NewRecorder()
+	h.Login(login, httptest.NewRequest(http.MethodGet, "/users/oidc/login", nil))
+	if login.Code != http.StatusFound {
+		t.Fatalf("expected login to redirect, got status %d", login.Code)
+	}
+	code, state := oidctest.Authorize(t, login.Header().Get("Location"))
+
+	r := httptest.NewRequest(http.MethodGet, "/users/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	if sendCookie {
+		for _, cookie := range login.Result().Cookies() {
+			r.AddCookie(cookie)
+		}
+	}
+	callback := httptest.NewRecorder()
+	h.Callback(callback, r)
+	return callback
+}
+
+func TestOIDCCallback(t *testing.T) {
+	m := oidctest.NewProvider(t)
+	h, repos := newOIDCHandler(t, m)
+
+	// First sign in creates a user named after the preferred username, with the mapped role, and links it to the subject.
+	if resp := signIn(t, h, true); resp.Code != http.StatusOK {
+		t.Fatalf("expected the first sign in to succeed, got status %d: %s", resp.Code, resp.Body.String())
+	}
+	user, err := repos.Users.GetByUsername("jdoe")
+	if err != nil {
+		t.Fatalf("expected user jdoe to be created, got error: %s", err.Error())
+	}
+	if user.Role != roles.Employee {
+		t.Errorf("expected the created user to be an employee, got role %s", user.Role)
+	}
+	identity, err := repos.Identities.GetBySubject(m.Server.URL, "employee-42")
+	if err != nil || identity.UserID != user.ID {
+		t.Fatalf("expected the subject to be linked to user %d, got %+v (error: %v)", user.ID, identity, err)
+	}
+
+	// Later sign ins find the linked user, and sync its role with the claims.
+	m.Claims["groups"] = []string{"fruitbar-admins"}
+	if resp := signIn(t, h, true); resp.Code != http.StatusOK {
+		t.Fatalf("expected the second sign in to succeed, got status %d: %s", resp.Code, resp.Body.String())
+	}
+	synced, err := repos.Users.GetByID(user.ID)
+	if err != nil {
+		t.Fatalf("unexpected error reading the user: %s", err.Error())
+	}
+	if synced.Role != roles.Admin {
+		t.Errorf("expected the role of the linked user to be synced to admin, got %s", synced.Role)
+	}
+
+	// A new subject whose preferred username is taken by another user is neither created nor linked.
+	m.Claims["sub"] = "employee-43"
+	if resp := signIn(t, h, true); resp.Code != http.StatusConflict {
+		t.Errorf("expected a sign in as a taken name to conflict, got status %d", resp.Code)
+	}
+	if _, err := repos.Identities.GetBySubject(m.Server.URL, "employee-43"); err == nil {
+		t.Errorf("expected the subject with a taken name not to be linked")
+	}
+}
+
+func TestOIDCCallback_stateCookie(t *testing.T) {
+	m := oidctest.NewProvider(t)
+	h, repos := newOIDCHandler(t, m)
+
+	// A callback the client didn't start, e.g. one an attacker tricked it into, is refused.
+	if resp := signIn(t, h, false); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a callback without the state cookie to fail, got status %d", resp.Code)
+	}
+
+	login := httptest.NewRecorder()
+	h.Login(login, httptest.NewRequest(http.MethodGet, "/users/oidc/login", nil))
+	cookies := login.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected login to set an HttpOnly state cookie, got %+v", cookies)
+	}
+	code, state := oidctest.Authorize(t, login.Header().Get("Location"))
+	if cookies[0].Value != state {
+		t.Errorf("expected the state cookie to hold the state of the authorization request")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/users/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "another-state"})
+	resp := httptest.NewRecorder()
+	h.Callback(resp, r)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected a callback with another state cookie to fail, got status %d", resp.Code)
+	}
+
+	if _, err := repos.Users.GetByUsername("jdoe"); err == nil {
+		t.Errorf("expected no user to be created by refused callbacks")
+	}
+}
@@ -4,14 +4,10 @@ import (
 	"errors"
 	"strings"
 
-	"github.com/tragicpixel/fruitbar/pkg/driver"
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/models/roles"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
-	itemsrepo "github.com/tragicpixel/fruitbar/pkg/repository/item"
 	jwtrepo "github.com/tragicpixel/fruitbar/pkg/repository/jwt"
-	orderrepo "github.com/tragicpixel/fruitbar/pkg/repository/order"
-	productsrepo "github.com/tragicpixel/fruitbar/pkg/repository/product"
 	"github.com/tragicpixel/fruitbar/pkg/utils"
 	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
 	"github.com/tragicpixel/fruitbar/pkg/utils/json"
@@ -25,25 +21,57 @@ import (
 
 // Order represents a handler for performing operations on orders via HTTP.
 type Order struct {
+	repos        *repository.Repositories
 	repo         repository.Order
 	productsRepo repository.Product
 	itemsRepo    repository.Item
+	usersRepo    repository.User
+	auditRepo    repository.Audit
 	jwtRepo      repository.Jwt
 }
 
-// NewOrderHandler creates and initializes a new handler for performing operations on orders via HTTP.
-func NewOrderHandler(db *driver.DB) *Order {
+// NewOrderHandler creates and initializes a new handler for performing operations on orders via HTTP, on the records in the supplied repositories.
+func NewOrderHandler(repos *repository.Repositories) *Order {
 	return &Order{
-		repo:         orderrepo.NewPostgresOrderRepo(db.Postgres),
-		productsRepo: productsrepo.NewPostgresProductRepo(db.Postgres),
-		itemsRepo:    itemsrepo.NewPostgresItemRepo(db.Postgres),
+		repos:        repos,
+		repo:         repos.Orders,
+		productsRepo: repos.Products,
+		itemsRepo:    repos.Items,
+		usersRepo:    repos.Users,
+		auditRepo:    repos.Audit,
 		jwtRepo:      jwtrepo.NewJWTRepository(),
 	}
 }
 
+// withRepos returns a copy of the handler that performs its operations on the supplied repositories, such as those of a transaction.
+func (h *Order) withRepos(repos *repository.Repositories) *Order {
+	return NewOrderHandler(repos)
+}
+
+// BatchOrders runs the batch of order operations in the supplied http request, and sends a response in JSON containing the result of each operation to the supplied http response writer.
+// Each operation is checked exactly as the single order request it stands for.
+func (h *Order) BatchOrders(w http.ResponseWriter, r *http.Request) {
+	runBatch(w, r, models.AuditEntityOrder, h.repos, func(repos *repository.Repositories) map[string]http.HandlerFunc {
+		t := h.withRepos(repos)
+		return map[string]http.HandlerFunc{
+			models.BatchOpCreate: t.CreateOrder,
+			models.BatchOpUpdate: t.UpdateOrder,
+			models.BatchOpPatch:  t.PatchOrder,
+			models.BatchOpDelete: t.DeleteOrder,
+		}
+	})
+}
+
 // CreateOrder creates a new order based on the supplied HTTP request and sends a response in JSON containing the newly created order to the supplied http response writer.
 // If there is a permission error, an HTTP error will be sent.
 func (h *Order) CreateOrder(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).createOrder
+	})
+}
+
+// createOrder creates a new order and its items from the supplied http request, and sends a response in JSON containing the new order.
+func (h *Order) createOrder(w http.ResponseWriter, r *http.Request) {
 	var order models.Order
 	response := *json.DecodeAndGetErrorResponse(w, r, &order, json.MAX_CREATE_REQUEST_SIZE_IN_BYTES)
 	if response.Error != nil {
@@ -57,43 +85,31 @@ func (h *Order) CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	err := models.ValidateNewOrder(&order)
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Order "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Order "+validationFailedErrMsgPrefix, err)
 		return
 	}
-	if err := h.itemsAreValid(order.Items); err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Items "+validationFailedErrMsgPrefix+err.Error())
+	if err := h.itemsAreValid(order.Items, nil); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Items "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
-	subtotal, err := h.calculateOrderSubtotal(&order)
-	if err != nil {
-		logMsg := "Failed to calculate new order subtotal: " + err.Error()
+	if err := h.priceOrder(&order, nil); err != nil {
+		logMsg := "Failed to price new order: " + err.Error()
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	order.Subtotal = subtotal
-	order.Tax = order.Subtotal * order.TaxRate
-	order.Total = order.Subtotal + order.Tax
+	order.Status = models.OrderStatusPending
 
 	log.Info("Inserting new order...")
-	createdID, itemIds, err := h.repo.Create(&order)
+	// The items are created along with the order, and given its ID.
+	createdID, _, err := h.repo.Create(&order)
 	if err != nil {
 		logMsg := fmt.Sprintf("Error inserting order %+v into database: %s", order, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	// Update all newly created items (gorm creates them) with the order's ID (didn't know the order ID until created)
-	// TODO: There is probably a way to create a key constraint in gorm so it does this automatically
-	for _, id := range itemIds {
-		update := models.Item{OrderID: createdID}
-		update.ID = id
-		log.Info(fmt.Sprintf("Updating item (id: %d) for order (id: %d)", id, order.ID))
-		_, err := h.itemsRepo.Update(&update, []string{"orderid", "id"})
-		if err != nil {
-			logMsg := fmt.Sprintf("Error updating item (id: %d) for order (id: %d): %s", id, order.ID, err.Error())
-			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-			return
-		}
+	if !h.recordAudit(w, r, models.AuditActionCreate, createdID, nil, &order) {
+		return
 	}
 	log.Info(fmt.Sprintf("Created new order (id: %d): %+v", createdID, order))
 	response = json.Response{Data: []*models.Order{&order}}
@@ -109,33 +125,211 @@ func (h *Order) GetOrders(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetOrderItems sends a response to the supplied http response writer containing the items of an order (id via http query parameter),
+// or a single one of them (item id via the itemId http query parameter), based on the supplied http request.
+func (h *Order) GetOrderItems(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var itemID uint
+	if r.URL.Query().Has(itemIDParam) {
+		if itemID, err = httputils.GetQueryParamAsUint(r, itemIDParam); err != nil {
+			json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	log.Info(fmt.Sprintf("Selecting order (id: %d) to read its items...", id))
+	order, err := h.getOrderWithItems(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, orderNotFoundErrType, orderNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting order (id: %d) to read its items: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !h.clientHasReadPermsForOrder(w, r, order) {
+		return
+	}
+
+	items := order.Items
+	if itemID != 0 {
+		items = nil
+		for _, item := range order.Items {
+			if item.ID == itemID {
+				items = []*models.Item{item}
+			}
+		}
+		if items == nil {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, itemNotFoundErrType, itemNotFoundMsg)
+			return
+		}
+	}
+	log.Info(fmt.Sprintf("Read %d items of order (id: %d)", len(items), id))
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: items})
+}
+
 // UpdateOrder updates an existing order based on the supplied http request and sends a response in JSON containing the updated order to the supplied http response writer.
+// The order and its items are updated in a single transaction.
 func (h *Order) UpdateOrder(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).updateOrder
+	})
+}
+
+// updateOrder updates an existing order based on the supplied http request and sends a response in JSON containing the updated order to the supplied http response writer.
+func (h *Order) updateOrder(w http.ResponseWriter, r *http.Request) {
 	var order models.Order
 	response := *json.DecodeAndGetErrorResponse(w, r, &order, json.MAX_CREATE_REQUEST_SIZE_IN_BYTES)
 	if response.Error != nil {
 		json.WriteErrorResponse(w, response.Error.Code, response.Error.Message)
 		return
 	}
+	if !recordIDMatchesQuery(w, r, &order.ID) {
+		return
+	}
 
 	if !h.clientHasUpdatePermsForOrder(w, r, order) {
 		return
 	}
 
-	if err := h.itemsAreValid(order.Items); err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Items "+validationFailedErrMsgPrefix+err.Error())
+	log.Info(fmt.Sprintf("Selecting order (id: %d) before update...", order.ID))
+	existing, err := h.getOrderWithItems(order.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, orderNotFoundErrType, orderNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting order (id: %d) before update: %s", order.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	// Check the existing order too, so customers can't take over someone else's order, or hand theirs over to someone else.
+	if !h.clientHasUpdatePermsForOrder(w, r, *existing) || !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+	if err := orderItemsAreUpdatable(&order); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Items "+validationFailedErrMsgPrefix, err)
+		return
+	}
+	if err := h.itemsAreValid(order.Items, existing.Items); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Items "+validationFailedErrMsgPrefix, err)
+		return
+	}
+	order.Version = existing.Version
+	if order.Status == "" {
+		order.Status = existing.Status
+	}
+	if !h.clientHasStatusPermsForOrder(w, r, &order, existing) {
 		return
 	}
 
 	if r.URL.Query().Has(fieldsParam) {
-		h.partiallyUpdateOrder(w, r, order)
+		h.partiallyUpdateOrder(w, r, order, existing)
 	} else {
-		h.fullyUpdateOrder(w, r, order)
+		h.fullyUpdateOrder(w, r, order, existing)
 	}
 }
 
+// Order fields a patch can't change, besides the ones every record has. The totals are recalculated from the items instead.
+var orderReadOnlyFields = []string{"subtotal", "tax", "total", "owner"}
+
+// Order columns written by a patch. All of them are written, so fields patched to their zero value (like cash=false) are stored too.
+var orderPatchColumns = []string{"owner_id", "tax_rate", "cash", "number", "cardholder_name", "expiration_date", "zipcode", "cvv", "subtotal", "tax", "total", "status"}
+
+// Item columns written when the items of an order are changed: the product of an item and its price go together.
+var orderItemColumns = []string{"product_id", "quantity", "unit_price", "product_name", "product_symbol", "total"}
+
+// PatchOrder applies the JSON Merge Patch or JSON Patch in the supplied http request to an existing order (id via http query parameter),
+// and sends a response in JSON containing the patched order to the supplied http response writer.
+// The patched order is validated and its totals recalculated before anything is stored. Items removed from the order's items are deleted.
+// The order and its items are patched in a single transaction.
+func (h *Order) PatchOrder(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).patchOrder
+	})
+}
+
+// patchOrder applies the patch in the supplied http request to an existing order (id via http query parameter),
+// and sends a response in JSON containing the patched order to the supplied http response writer.
+func (h *Order) patchOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting order (id: %d) before patch...", id))
+	existing, err := h.getOrderWithItems(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, orderNotFoundErrType, orderNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting order (id: %d) before patch: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !h.clientHasUpdatePermsForOrder(w, r, *existing) || !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+
+	var order models.Order
+	if _, ok := decodePatch(w, r, existing, &order, orderReadOnlyFields...); !ok {
+		return
+	}
+	// Check the patched order too, so customers can't hand their orders over to someone else, or change their status.
+	if !h.clientHasUpdatePermsForOrder(w, r, order) || !h.clientHasStatusPermsForOrder(w, r, &order, existing) {
+		return
+	}
+	if err := orderItemsArePatchable(&order, existing); err != nil {
+		writeValidationErrorResponse(w, http.StatusUnprocessableEntity, patchedRecordInvalidErrType, patchedRecordInvalidErrMsgPrefix, err)
+		return
+	}
+	if err := h.itemsAreValid(order.Items, existing.Items); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Items "+validationFailedErrMsgPrefix, err)
+		return
+	}
+	if err := h.priceOrder(&order, existing.Items); err != nil {
+		logMsg := fmt.Sprintf("Failed to price patched order (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if err := models.ValidateOrder(&order); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Order "+validationFailedErrMsgPrefix, err)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Patching order (id: %d) to %+v", id, order))
+	if _, err := h.repo.Update(&order, orderPatchColumns); err != nil {
+		writeUpdateErrorResponse(w, err, fmt.Sprintf("Error patching order (id: %d): %s", id, err.Error()))
+		return
+	}
+	if !h.syncOrderItems(w, &order, existing) {
+		return
+	}
+	if !h.recordUpdateAudit(w, r, existing) {
+		return
+	}
+	log.Info(fmt.Sprintf("Patched order (id: %d)", id))
+	setETag(w, order.Version)
+	response := json.Response{Data: []*models.Order{&order}}
+	json.WriteResponse(w, http.StatusOK, response)
+}
+
 // DeleteOrder deletes an existing order and all of its child items based on the supplied http request and sends a status code to the supplied http response writer.
 func (h *Order) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).deleteOrder
+	})
+}
+
+// deleteOrder deletes the order with the id in the supplied http request, along with its items.
+func (h *Order) deleteOrder(w http.ResponseWriter, r *http.Request) {
 	id, err := httputils.GetQueryParamAsUint(r, idParam)
 	if err != nil {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
@@ -147,7 +341,7 @@ func (h *Order) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			logMsg := fmt.Sprintf("failed to find order for proposed deletion with id: %d: %s", id, err.Error())
-			json.WriteErrorResponse(w, http.StatusNotFound, orderNotFoundMsg, logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, orderNotFoundErrType, orderNotFoundMsg, logMsg)
 			return
 		}
 		logMsg := "Error reading order for proposed deletion: " + err.Error()
@@ -155,10 +349,11 @@ func (h *Order) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.clientHasDeletePermsForOrder(w, r, order) {
+	if !h.clientHasDeletePermsForOrder(w, r, order) || !clientHasCurrentVersion(w, r, order.Version) {
 		return
 	}
 
+	// The items are only read for the audit log: they are deleted along with the order.
 	log.Info(fmt.Sprintf("Reading items for order (id: %d) for proposed deletion...", id))
 	existingItems, err := h.itemsRepo.GetByOrderID(id)
 	if err != nil {
@@ -166,27 +361,87 @@ func (h *Order) DeleteOrder(w http.ResponseWriter, r *http.Request) {
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	for _, item := range existingItems {
-		log.Info(fmt.Sprintf("Deleting existing item (id: %d) from order (id: %d)", item.ID, id))
-		err := h.itemsRepo.Delete(item.ID)
-		if err != nil {
-			logMsg := fmt.Sprintf("Error deleting existing item (id: %d): %s", item.ID, err.Error())
-			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-			return
-		}
-	}
-	log.Info(fmt.Sprintf("Deleted all items for order (id: %d)", id))
-	log.Info(fmt.Sprintf("Deleting order (id: %d)..., ", id))
+	log.Info(fmt.Sprintf("Deleting order (id: %d) and its %d items..., ", id, len(existingItems)))
 	err = h.repo.Delete(id)
 	if err != nil {
 		logMsg := fmt.Sprintf("Error deleting order (id %d): %s", id, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	order.Items = existingItems
+	if !h.recordAudit(w, r, models.AuditActionDelete, id, order, nil) {
+		return
+	}
 	log.Info(fmt.Sprintf("Deleted order (id: %d)", id))
 	json.WriteResponse(w, http.StatusOK, json.Response{})
 }
 
+// RepriceOrder charges each of the items of an existing pending order (id via http query parameter) the current price of its product,
+// recalculates the order's totals, and sends a response in JSON containing the repriced order to the supplied http response writer.
+func (h *Order) RepriceOrder(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).repriceOrder
+	})
+}
+
+// repriceOrder charges the items of the pending order with the id in the supplied http request the current price of their product.
+func (h *Order) repriceOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting order (id: %d) before reprice...", id))
+	existing, err := h.getOrderWithItems(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, orderNotFoundErrType, orderNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting order (id: %d) before reprice: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+	if existing.Status != models.OrderStatusPending {
+		json.WriteTypedErrorResponse(w, http.StatusConflict, orderNotPendingErrType, repriceNotPendingOrderErrMsg)
+		return
+	}
+
+	order := *existing
+	order.Items = make([]*models.Item, len(existing.Items))
+	for n, item := range existing.Items {
+		repriced := *item
+		order.Items[n] = &repriced
+	}
+	if err := h.priceOrder(&order, nil); err != nil {
+		logMsg := fmt.Sprintf("Failed to reprice order (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Repricing order (id: %d) from %.2f to %.2f...", id, existing.Total, order.Total))
+	if _, err := h.repo.Update(&order, []string{"subtotal", "tax", "total"}); err != nil {
+		writeUpdateErrorResponse(w, err, fmt.Sprintf("Error repricing order (id: %d): %s", id, err.Error()))
+		return
+	}
+	if err := h.itemsRepo.UpdateAll(order.Items, orderItemColumns); err != nil {
+		logMsg := fmt.Sprintf("Error repricing items of order (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !h.recordUpdateAudit(w, r, existing) {
+		return
+	}
+	log.Info(fmt.Sprintf("Repriced order (id: %d)", id))
+	setETag(w, order.Version)
+	response := json.Response{Data: []*models.Order{&order}}
+	json.WriteResponse(w, http.StatusOK, response)
+}
+
 // GetPageMaxRecordLimit always sends a response containing the maximum number of records that can be returned in one page.
 func (h *Order) GetPageMaxRecordLimit(w http.ResponseWriter, r *http.Request) {
 	json.WriteResponse(w, http.StatusOK, json.Response{Data: readOrdersPageMaxRecordLimit})
@@ -200,12 +455,17 @@ func (h *Order) getSingleOrder(w http.ResponseWriter, r *http.Request) {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	readOpts, err := utils.GetReadOptions(r, repository.OrderFields, orderRelations, orderDefaultInclude)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	log.Info(fmt.Sprintf("Selecting order with id %d...", id))
 	var order *models.Order
 	order, err = h.repo.GetByID(id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			json.WriteErrorResponse(w, http.StatusNotFound, orderNotFoundMsg)
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, orderNotFoundErrType, orderNotFoundMsg)
 			return
 		}
 		logMsg := fmt.Sprintf("Error selecting order (id: %d): %s", id, err.Error())
@@ -214,128 +474,245 @@ func (h *Order) getSingleOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Info(fmt.Sprintf("Successfully selected order with id = %d", id))
 
-	if !h.clientHasReadPermsForOrder(w, r, order) {
+	if !h.clientHasReadPermsForOrder(w, r, order) || notModified(w, r, order.Version) {
 		return
 	}
 
-	items, err := h.itemsRepo.GetByOrderID(id)
-	if err != nil {
-		logMsg := fmt.Sprintf("Error retrieving items for order (id: %d): %s", id, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+	orders := []*models.Order{order}
+	if !h.loadOrderRelations(w, orders, readOpts) {
 		return
 	}
-	order.Items = items
-
-	response := json.Response{Data: []*models.Order{order}}
-	json.WriteResponse(w, http.StatusOK, response)
+	writeRecords(w, orders, readOpts, repository.OrderFields, nil)
 }
 
 // getOrdersPage sends a response to the supplied http response writer containing the requested page of orders, based on the supplied http request.
 // If read access to a specific order is forbidden, it won't be included in the response and there will be no error message. (fail silently)
 func (h *Order) getOrdersPage(w http.ResponseWriter, r *http.Request) {
 	var seek *repository.PageSeekOptions
-	seek, err := utils.GetPageSeekOptions(r, readOrdersPageMaxRecordLimit)
+	seek, err := utils.GetPageSeekOptions(r, readOrdersPageMaxRecordLimit, repository.OrderFields)
 	if err != nil {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	log.Info(fmt.Sprintf("Selecting %d orders (max %d)...", seek.RecordLimit, readOrdersPageMaxRecordLimit))
+	readOpts, err := utils.GetReadOptions(r, repository.OrderFields, orderRelations, orderDefaultInclude)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// The owner is needed to check the client can read each order, and to embed it.
+	seek.Fields = readOpts.Select("ownerid")
+	if !h.filterOrdersReadableByClient(w, r, seek) {
+		return
+	}
+	log.Info(fmt.Sprintf("Selecting %d orders (max %d) matching %v...", seek.RecordLimit, readOrdersPageMaxRecordLimit, seek.Filters))
 	var orders []*models.Order
-	orders, err = h.repo.Fetch(seek)
+	orders, err = h.repo.Fetch(utils.GetPeekSeekOptions(seek))
 	if err != nil {
 		logMsg := fmt.Sprintf("Error selecting orders: %s", err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	start, end, more := utils.TrimPage(seek, len(orders))
+	orders = orders[start:end]
 
 	if orders = h.getOrdersReadableByClient(w, r, orders); orders == nil {
 		return
 	}
 
-	for _, order := range orders {
-		items, err := h.itemsRepo.GetByOrderID(order.ID)
+	if !h.loadOrderRelations(w, orders, readOpts) {
+		return
+	}
+
+	page := h.getOrdersPageInfo(w, r, seek, orders, more)
+	if page == nil {
+		return
+	}
+	log.Info(fmt.Sprintf("Read %d orders", len(orders)))
+	writeRecords(w, orders, readOpts, repository.OrderFields, page)
+}
+
+// loadOrderRelations embeds the related records included in the supplied read options in the supplied orders,
+// with one query per relation however many orders there are.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Order) loadOrderRelations(w http.ResponseWriter, orders []*models.Order, opts *utils.ReadOptions) bool {
+	if len(orders) == 0 {
+		return true
+	}
+	if opts.Includes("items") {
+		ids := make([]uint, len(orders))
+		for i, order := range orders {
+			ids[i] = order.ID
+		}
+		log.Info(fmt.Sprintf("Selecting items for %d orders...", len(orders)))
+		itemsByOrder, err := h.itemsRepo.GetByOrderIDs(ids)
 		if err != nil {
-			logMsg := fmt.Sprintf("Error retrieving items for order (id: %d): %s", order.ID, err.Error())
+			logMsg := fmt.Sprintf("Error selecting items for orders: %s", err.Error())
 			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-			return
+			return false
+		}
+		for _, order := range orders {
+			order.Items = itemsByOrder[order.ID]
+		}
+	}
+	if opts.Includes("items.product") {
+		var ids []uint
+		for _, order := range orders {
+			for _, item := range order.Items {
+				ids = append(ids, item.ProductID)
+			}
+		}
+		if ids = uniqueIDs(ids); len(ids) > 0 {
+			log.Info(fmt.Sprintf("Selecting %d products for order items...", len(ids)))
+			products, err := h.productsRepo.GetByIDs(ids)
+			if err != nil {
+				logMsg := fmt.Sprintf("Error selecting products for order items: %s", err.Error())
+				json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+				return false
+			}
+			byID := make(map[uint]*models.Product, len(products))
+			for _, product := range products {
+				byID[product.ID] = product
+			}
+			for _, order := range orders {
+				for _, item := range order.Items {
+					item.Product = byID[item.ProductID]
+				}
+			}
+		}
+	}
+	if opts.Includes("owner") {
+		ids := make([]uint, 0, len(orders))
+		for _, order := range orders {
+			if order.OwnerID != nil {
+				ids = append(ids, *order.OwnerID)
+			}
+		}
+		if ids = uniqueIDs(ids); len(ids) > 0 {
+			log.Info(fmt.Sprintf("Selecting %d owners of orders...", len(ids)))
+			owners, err := h.usersRepo.GetByIDs(ids)
+			if err != nil {
+				logMsg := fmt.Sprintf("Error selecting owners of orders: %s", err.Error())
+				json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+				return false
+			}
+			byID := make(map[uint]*models.User, len(owners))
+			for _, owner := range owners {
+				owner.Password = "" // Remove password hash for security reasons
+				byID[owner.ID] = owner
+			}
+			for _, order := range orders {
+				if order.OwnerID != nil {
+					order.Owner = byID[*order.OwnerID]
+				}
+			}
 		}
-		order.Items = items
 	}
+	return true
+}
 
-	rangeStr := h.getOrdersRangeStr(w, seek, orders)
-	w.Header().Set("Content-Range", rangeStr)
-	log.Info(fmt.Sprintf("Read %d orders", len(orders)))
-	response := json.Response{Data: orders}
-	json.WriteResponse(w, http.StatusOK, response)
+// Order columns written by a partial update of each of the fields of an order. The items are written through the item repository,
+// and the totals are always written, as they are recalculated from the items.
+var orderUpdateColumns = map[string][]string{
+	"ownerid":     {"owner_id"},
+	"taxrate":     {"tax_rate"},
+	"paymentinfo": {"cash", "number", "cardholder_name", "expiration_date", "zipcode", "cvv"},
+	"status":      {"status"},
 }
 
 // partiallyUpdateOrder updates only the specified fields (from the supplied http request) of the order and sends a response in JSON containing the newly updated order.
-// Assumes permission check has already been performed.
-func (h *Order) partiallyUpdateOrder(w http.ResponseWriter, r *http.Request, order models.Order) {
+// Assumes permission check has already been performed. The supplied items change the existing items of the same product, or are added to the order,
+// and the totals of the order are recalculated from all of its items. The order as it was before the update is recorded in the audit log.
+func (h *Order) partiallyUpdateOrder(w http.ResponseWriter, r *http.Request, order models.Order, existing *models.Order) {
 	fieldsStr := r.URL.Query().Get(fieldsParam)
 	fields := strings.Split(fieldsStr, ",")
 
 	err := models.ValidateOrderUpdate(&order, fields)
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Order "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Order "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
+	columns := []string{"subtotal", "tax", "total"}
+	// The totals are calculated with the tax rate the order has once updated, from the items it has once updated: the supplied ones, and the existing ones they don't change.
+	priced := models.Order{TaxRate: existing.TaxRate, Items: append([]*models.Item{}, order.Items...)}
+	for _, field := range fields {
+		columns = append(columns, orderUpdateColumns[field]...)
+		if field == "taxrate" {
+			priced.TaxRate = order.TaxRate
+		}
+	}
+	// Product IDs on items are unique (there is a maximum of 1 item with any given product ID), so items are matched by product.
+	existingByProduct := make(map[uint]*models.Item, len(existing.Items))
+	for _, existingItem := range existing.Items {
+		existingByProduct[existingItem.ProductID] = existingItem
+	}
+	var changedItems, newItems []*models.Item
+	for _, item := range order.Items {
+		if existingItem, ok := existingByProduct[item.ProductID]; ok {
+			item.ID = existingItem.ID
+			changedItems = append(changedItems, item)
+			delete(existingByProduct, item.ProductID)
+		} else {
+			newItems = append(newItems, item)
+		}
+	}
+	for _, existingItem := range existing.Items {
+		if _, unchanged := existingByProduct[existingItem.ProductID]; unchanged {
+			priced.Items = append(priced.Items, existingItem)
+		}
+	}
+	// Existing items keep the price they were ordered at; new ones are charged the current price of their product.
+	if err := h.priceOrder(&priced, existing.Items); err != nil {
+		logMsg := fmt.Sprintf("Failed to price the items of order (id: %d): %s", order.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	order.Subtotal, order.Tax, order.Total = priced.Subtotal, priced.Tax, priced.Total
+
 	log.Info(fmt.Sprintf("Updating order (id: %d) fields (%s) to %+v", order.ID, fieldsStr, order))
-	updated, err := h.repo.Update(&order, fields)
+	updated, err := h.repo.Update(&order, columns)
 	if err != nil {
 		logMsg := fmt.Sprintf("Error partially updating order (id: %d) fields (%s) to %+v: %s", order.ID, fieldsStr, order, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		writeUpdateErrorResponse(w, err, logMsg)
 		return
 	}
 	log.Info(fmt.Sprintf("Partially updated order (id: %d) fields (%s): %+v", order.ID, fieldsStr, updated))
 
-	existingItems, err := h.itemsRepo.GetByOrderID(order.ID)
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-		logMsg := fmt.Sprintf("Failed to select existing items: %s", err.Error())
+	log.Info(fmt.Sprintf("Updating %d items of order (id: %d)", len(changedItems), order.ID))
+	if err := h.itemsRepo.UpdateAll(changedItems, []string{"quantity", "total"}); err != nil {
+		logMsg := fmt.Sprintf("Error updating items of order (id: %d): %s", order.ID, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	for _, item := range order.Items {
-		match := false
-		for _, existingItem := range existingItems {
-			if item.ProductID == existingItem.ProductID {
-				match = true
-				item.ID = existingItem.ID
-				log.Info(fmt.Sprintf("Updating item (id: %d) to %+v", item.ID, item))
-				_, err := h.itemsRepo.Update(item, []string{})
-				if err != nil {
-					logMsg := fmt.Sprintf("Error updating item (id: %d): %s", item.ID, err.Error())
-					json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-					return
-				}
-				break // data assumption: product IDs on items are unique (there is a maximum of 1 item with any given product ID)
-			}
-		}
-		if !match {
-			log.Info(fmt.Sprintf("Inserting new item: %+v", item))
-			item.OrderID = order.ID
-			id, err := h.itemsRepo.Create(item)
-			if err != nil {
-				logMsg := fmt.Sprintf("Error inserting item: %s", err.Error())
-				json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-				return
-			}
-			item.ID = id
-		}
+	log.Info(fmt.Sprintf("Inserting %d new items for order (id: %d)", len(newItems), order.ID))
+	if _, err := h.itemsRepo.CreateAll(newItems); err != nil {
+		logMsg := fmt.Sprintf("Error inserting items: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
 	}
 	log.Info(fmt.Sprintf("Updated order's items (id: %d) due to partial update", order.ID))
+	if !h.recordUpdateAudit(w, r, existing) {
+		return
+	}
+	setETag(w, order.Version)
 	response := json.Response{Data: []*models.Order{&order}}
 	json.WriteResponse(w, http.StatusOK, response)
 }
 
 // fullyUpdateOrder updates all the fields of the order (based on the supplied http request) and sends a response in JSON containing the newly updated order.
-// Assumes permission check has already been performed.
+// Assumes permission check has already been performed. The totals of the order are calculated from its items.
 // Note: All items for the given order will be deleted, and the items included in the updated order will be created.
-func (h *Order) fullyUpdateOrder(w http.ResponseWriter, r *http.Request, order models.Order) {
+// The order as it was before the update is recorded in the audit log.
+func (h *Order) fullyUpdateOrder(w http.ResponseWriter, r *http.Request, order models.Order, existing *models.Order) {
+	// The items of products the order already had keep the price they were ordered at, so updating an order doesn't reprice it.
+	if err := h.priceOrder(&order, existing.Items); err != nil {
+		logMsg := fmt.Sprintf("Failed to price updated order (id: %d): %s", order.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
 	err := models.ValidateOrder(&order)
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Order "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Order "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -343,7 +720,7 @@ func (h *Order) fullyUpdateOrder(w http.ResponseWriter, r *http.Request, order m
 	updated, err := h.repo.Update(&order, []string{})
 	if err != nil {
 		logMsg := fmt.Sprintf("Error updating order (id: %d): %s", order.ID, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		writeUpdateErrorResponse(w, err, logMsg)
 		return
 	}
 	log.Info(fmt.Sprintf("Updated order (id: %d) to %+v", order.ID, updated))
@@ -355,33 +732,118 @@ func (h *Order) fullyUpdateOrder(w http.ResponseWriter, r *http.Request, order m
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	for _, item := range currentItems {
-		log.Info(fmt.Sprintf("Deleting existing item (id: %d) from order (id: %d)...", item.ID, order.ID))
-		err := h.itemsRepo.Delete(item.ID)
-		if err != nil {
-			logMsg := fmt.Sprintf("Error deleting existing item: %s", err.Error())
-			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-			return
-		}
+	log.Info(fmt.Sprintf("Deleting %d existing items from order (id: %d)...", len(currentItems), order.ID))
+	if err := h.itemsRepo.DeleteAll(itemIDs(currentItems)); err != nil {
+		logMsg := fmt.Sprintf("Error deleting existing items: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
 	}
-	for _, item := range order.Items {
-		log.Info(fmt.Sprintf("Inserting new item for order (id: %d)...", order.ID))
-		id, err := h.itemsRepo.Create(item)
-		if err != nil {
-			logMsg := fmt.Sprintf("couldn't create an item for a full order update: %s", err.Error())
-			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-			return
-		}
-		item.ID = id
+	log.Info(fmt.Sprintf("Inserting %d new items for order (id: %d)...", len(order.Items), order.ID))
+	if _, err := h.itemsRepo.CreateAll(order.Items); err != nil {
+		logMsg := fmt.Sprintf("couldn't create the items for a full order update: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !h.recordUpdateAudit(w, r, existing) {
+		return
 	}
 	log.Info(fmt.Sprintf("Fully updated order (id: %d)", order.ID))
+	setETag(w, order.Version)
 	response := json.Response{Data: []*models.Order{&order}}
 	json.WriteResponse(w, http.StatusOK, response)
 }
 
-// itemsAreValid validates whether the supplied items are valid.
-func (h *Order) itemsAreValid(items []*models.Item) error {
-	_, err := h.validateProductIDs(items)
+// orderItemsAreUpdatable checks that none of the items of the supplied updated order belong to another order,
+// and sets their order ID to the order's. Their ids are cleared, as the items of an update are matched to the existing ones by product, or created.
+func orderItemsAreUpdatable(order *models.Order) error {
+	for _, item := range order.Items {
+		if item == nil {
+			return errors.New("items can't be null")
+		}
+		if item.OrderID != 0 && item.OrderID != order.ID {
+			return fmt.Errorf("item of product (id: %d) belongs to another order (id: %d)", item.ProductID, item.OrderID)
+		}
+		item.ID = 0
+		item.OrderID = order.ID
+		item.Product = nil
+	}
+	return nil
+}
+
+// orderItemsArePatchable checks that the items of the supplied patched order are either new, or items of the supplied existing order,
+// and sets their order ID to the order's.
+func orderItemsArePatchable(order *models.Order, existing *models.Order) error {
+	existingIDs := make(map[uint]bool, len(existing.Items))
+	for _, item := range existing.Items {
+		existingIDs[item.ID] = true
+	}
+	seen := make(map[uint]bool, len(order.Items))
+	for _, item := range order.Items {
+		if item == nil {
+			return errors.New("items can't be null")
+		}
+		if item.ID != 0 {
+			if !existingIDs[item.ID] {
+				return fmt.Errorf("item (id: %d) is not an item of this order", item.ID)
+			}
+			if seen[item.ID] {
+				return fmt.Errorf("item (id: %d) appears more than once", item.ID)
+			}
+			seen[item.ID] = true
+		}
+		item.OrderID = order.ID
+		item.Product = nil
+	}
+	return nil
+}
+
+// syncOrderItems brings the stored items of the supplied patched order in line with its items: items no longer in the order are deleted,
+// changed items are updated and new items are created. The supplied existing order holds the items as they were before the patch.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Order) syncOrderItems(w http.ResponseWriter, order *models.Order, existing *models.Order) bool {
+	kept := make(map[uint]*models.Item, len(order.Items))
+	var newItems []*models.Item
+	for _, item := range order.Items {
+		if item.ID != 0 {
+			kept[item.ID] = item
+		} else {
+			newItems = append(newItems, item)
+		}
+	}
+	var removedIDs []uint
+	var changedItems []*models.Item
+	for _, item := range existing.Items {
+		patched, ok := kept[item.ID]
+		if !ok {
+			removedIDs = append(removedIDs, item.ID)
+		} else if patched.ProductID != item.ProductID || patched.Quantity != item.Quantity {
+			changedItems = append(changedItems, patched)
+		}
+	}
+	log.Info(fmt.Sprintf("Deleting items %v removed from order (id: %d)...", removedIDs, order.ID))
+	if err := h.itemsRepo.DeleteAll(removedIDs); err != nil {
+		logMsg := fmt.Sprintf("Error deleting items %v: %s", removedIDs, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	log.Info(fmt.Sprintf("Updating %d changed items of order (id: %d)...", len(changedItems), order.ID))
+	if err := h.itemsRepo.UpdateAll(changedItems, orderItemColumns); err != nil {
+		logMsg := fmt.Sprintf("Error updating items of order (id: %d): %s", order.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	log.Info(fmt.Sprintf("Inserting %d new items for order (id: %d)...", len(newItems), order.ID))
+	if _, err := h.itemsRepo.CreateAll(newItems); err != nil {
+		logMsg := fmt.Sprintf("Error inserting items for order (id: %d): %s", order.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	return true
+}
+
+// itemsAreValid validates whether the supplied items are valid, given the supplied existing items of the order. (nil for a new order)
+func (h *Order) itemsAreValid(items []*models.Item, existingItems []*models.Item) error {
+	_, err := h.validateProductIDs(items, existingItems)
 	if err != nil {
 		return err
 	}
@@ -392,24 +854,31 @@ func (h *Order) itemsAreValid(items []*models.Item) error {
 	return nil
 }
 
-// validateProductIDs checks that the product ID values in the supplied set of items, correspond to products that actually exist.
-func (h *Order) validateProductIDs(items []*models.Item) (bool, error) {
-	ids := make(map[uint]bool, len(items))
+// validateProductIDs checks that the product ID values in the supplied set of items, correspond to products that actually exist and aren't archived.
+// Products the supplied existing items of the order already have were checked when they were ordered, so they can stay in the order even once archived.
+func (h *Order) validateProductIDs(items []*models.Item, existingItems []*models.Item) (bool, error) {
+	ordered := make(map[uint]bool, len(existingItems))
+	for _, item := range existingItems {
+		ordered[item.ProductID] = true
+	}
+	ids := make([]uint, 0, len(items))
+	seen := make(map[uint]bool, len(items))
 	for _, item := range items {
-		// TODO: Rewrite this so that only one database call is made -> modify Exists() to take var args and send all the IDs at once
-		log.Info(fmt.Sprintf("Checking if a product with ID = %d exists", item.ProductID))
-		exists, err := h.productsRepo.Exists(item.ProductID)
-		if err != nil {
-			return false, errors.New("failed to validate product id: " + err.Error())
-		}
-		if !exists {
-			return false, fmt.Errorf("product ID %d does not exist in the repo", item.ProductID)
-		}
-		_, idAlreadyExists := ids[item.ProductID]
-		if idAlreadyExists {
+		if seen[item.ProductID] {
 			return false, fmt.Errorf("item list contains duplicate product ID: %d", item.ProductID)
 		}
-		ids[item.ProductID] = true
+		seen[item.ProductID] = true
+		if !ordered[item.ProductID] {
+			ids = append(ids, item.ProductID)
+		}
+	}
+	log.Info(fmt.Sprintf("Checking if products with IDs %v exist", ids))
+	missing, err := h.productsRepo.ExistsAll(ids)
+	if err != nil {
+		return false, errors.New("failed to validate product id: " + err.Error())
+	}
+	if len(missing) > 0 {
+		return false, fmt.Errorf("product ID %d does not exist in the repo or is archived", missing[0])
 	}
 	return true, nil
 }
@@ -424,18 +893,95 @@ func (h *Order) quantityIsValid(items []*models.Item) (bool, error) {
 	return true, nil
 }
 
-// calculateOrderSubtotal returns the calculated subtotal based on the supplied order.
-func (h *Order) calculateOrderSubtotal(order *models.Order) (float64, error) {
-	subtotal := float64(0)
+// priceOrder sets the unit price, product name and symbol of each of the items of the supplied order, and the totals of the items and of the order.
+// Items of a product the supplied existing items of the order (nil for a new order) already have keep the unit price they were ordered at;
+// the others are charged the current price of their product.
+func (h *Order) priceOrder(order *models.Order, existingItems []*models.Item) error {
+	existingByProduct := make(map[uint]*models.Item, len(existingItems))
+	for _, item := range existingItems {
+		existingByProduct[item.ProductID] = item
+	}
+	var ids []uint
 	for _, item := range order.Items {
-		log.Info(fmt.Sprintf("Selecting product (id: %d) to get price...", item.ProductID))
-		product, err := h.productsRepo.GetByID(item.ProductID)
+		if existingItem, ok := existingByProduct[item.ProductID]; ok {
+			item.CopySnapshot(existingItem)
+		} else {
+			ids = append(ids, item.ProductID)
+		}
+	}
+	if len(ids) > 0 {
+		log.Info(fmt.Sprintf("Selecting products (ids: %v) to get prices...", ids))
+		products, err := h.productsRepo.GetByIDs(ids)
 		if err != nil {
-			return -1, err
+			return err
+		}
+		byID := make(map[uint]*models.Product, len(products))
+		for _, p := range products {
+			byID[p.ID] = p
+		}
+		for _, item := range order.Items {
+			if _, ok := existingByProduct[item.ProductID]; ok {
+				continue
+			}
+			p, ok := byID[item.ProductID]
+			if !ok {
+				return fmt.Errorf("product (id: %d): %w", item.ProductID, gorm.ErrRecordNotFound)
+			}
+			item.SnapshotProduct(p)
 		}
-		subtotal += float64(item.Quantity) * product.Price
 	}
-	return subtotal, nil
+	subtotal := float64(0)
+	for _, item := range order.Items {
+		subtotal += item.Total
+	}
+	order.Subtotal = subtotal
+	order.Tax = order.Subtotal * order.TaxRate
+	order.Total = order.Subtotal + order.Tax
+	return nil
+}
+
+// itemIDs returns the ids of the supplied items.
+func itemIDs(items []*models.Item) []uint {
+	ids := make([]uint, len(items))
+	for n, item := range items {
+		ids[n] = item.ID
+	}
+	return ids
+}
+
+// getOrderWithItems returns the order with the supplied id, along with all of its items.
+func (h *Order) getOrderWithItems(id uint) (*models.Order, error) {
+	order, err := h.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	order.Items, err = h.itemsRepo.GetByOrderID(id)
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// recordAudit records the supplied action on the order with the supplied id, performed by the client, in the audit log
+// along with the state of the order before and after it. Writes a response on the supplied http response writer if there is an error.
+func (h *Order) recordAudit(w http.ResponseWriter, r *http.Request, action string, id uint, before *models.Order, after *models.Order) bool {
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return false
+	}
+	return appendAuditEntry(w, r, h.auditRepo, client, action, models.AuditEntityOrder, id, before, after)
+}
+
+// recordUpdateAudit reads back the supplied order after an update and records the update in the audit log.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Order) recordUpdateAudit(w http.ResponseWriter, r *http.Request, before *models.Order) bool {
+	after, err := h.getOrderWithItems(before.ID)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting updated order (id: %d) for the audit log: %s", before.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	return h.recordAudit(w, r, models.AuditActionUpdate, before.ID, before, after)
 }
 
 // getClientAuthInfo returns the authorization information about the client based on the supplied http request.
@@ -444,13 +990,13 @@ func (h *Order) getClientAuthInfo(w http.ResponseWriter, r *http.Request) *model
 	client, err := jwtutils.GetTokenClaims(r, h.jwtRepo)
 	if err != nil {
 		logMsg := unauthorizedErrMsgPrefix + err.Error()
-		json.WriteErrorResponse(w, http.StatusBadRequest, unauthorizedErrMsg, logMsg)
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 		return nil
 	}
 	err = roles.IsValid(client.UserRole)
 	if err != nil {
 		logMsg := unauthorizedErrMsgPrefix + err.Error()
-		json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 		return nil
 	}
 	return client
@@ -464,8 +1010,8 @@ func (h *Order) clientHasCreatePermsForOrder(w http.ResponseWriter, r *http.Requ
 		return false
 	}
 	// Customers can only create orders owned by themselves
-	if client.UserRole == roles.Customer && order.OwnerID != client.UserID {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenCreateOrderErrMsg)
+	if client.UserRole == roles.Customer && !order.OwnedBy(client.UserID) {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenCreateOrderErrMsg)
 		return false
 	}
 	return true
@@ -479,8 +1025,8 @@ func (h *Order) clientHasReadPermsForOrder(w http.ResponseWriter, r *http.Reques
 		return false
 	}
 	// Customers can only read orders with their own IDs
-	if client.UserRole == roles.Customer && order.OwnerID != client.UserID {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenReadOrderErrMsg)
+	if client.UserRole == roles.Customer && !order.OwnedBy(client.UserID) {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenReadOrderErrMsg)
 		return false
 	}
 	return true
@@ -492,21 +1038,38 @@ func (h *Order) getOrdersReadableByClient(w http.ResponseWriter, r *http.Request
 	if client == nil {
 		return nil
 	}
-	var pruned []*models.Order
+	// Never nil, so an empty page isn't mistaken for an error.
+	pruned := make([]*models.Order, 0, len(orders))
 	switch client.UserRole {
 	case roles.Customer:
 		// Customers can only read orders owned by their user ID
 		for _, order := range orders {
-			if order.OwnerID == client.UserID {
+			if order.OwnedBy(client.UserID) {
 				pruned = append(pruned, order)
 			}
 		}
 	default:
-		pruned = orders
+		pruned = append(pruned, orders...)
 	}
 	return pruned
 }
 
+// filterOrdersReadableByClient restricts the supplied seek options to the orders the client can read, based on the supplied http request,
+// so pages and counts only include orders the client is allowed to see.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Order) filterOrdersReadableByClient(w http.ResponseWriter, r *http.Request, seek *repository.PageSeekOptions) bool {
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return false
+	}
+	if client.UserRole == roles.Customer {
+		// Customers can only read orders owned by their user ID
+		ownerFilter := repository.Filter{Field: "ownerid", Operator: repository.FilterOpEqual, Value: client.UserID}
+		seek.Filters = append(seek.Filters, ownerFilter)
+	}
+	return true
+}
+
 // clientHasUpdatePermsForOrder checks whether the client has permissions to update the supplied order, based on the supplied http request.
 // Writes a response on the supplied http response writer if there is an error.
 func (h *Order) clientHasUpdatePermsForOrder(w http.ResponseWriter, r *http.Request, order models.Order) bool {
@@ -515,8 +1078,23 @@ func (h *Order) clientHasUpdatePermsForOrder(w http.ResponseWriter, r *http.Requ
 		return false
 	}
 	// Customers can only update their own orders
-	if client.UserRole == roles.Customer && order.OwnerID != client.UserID {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenUpdateOrderErrMsg)
+	if client.UserRole == roles.Customer && !order.OwnedBy(client.UserID) {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenUpdateOrderErrMsg)
+		return false
+	}
+	return true
+}
+
+// clientHasStatusPermsForOrder checks whether the client has permissions to give the supplied updated order its status, based on the supplied http request
+// and the supplied existing order. Only employees and admins can change the status of an order.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Order) clientHasStatusPermsForOrder(w http.ResponseWriter, r *http.Request, order *models.Order, existing *models.Order) bool {
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return false
+	}
+	if client.UserRole == roles.Customer && order.Status != existing.Status {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenChangeOrderStatusErrMsg)
 		return false
 	}
 	return true
@@ -530,27 +1108,38 @@ func (h *Order) clientHasDeletePermsForOrder(w http.ResponseWriter, r *http.Requ
 		return false
 	}
 	// Customers can only delete their own orders
-	if client.UserRole == roles.Customer && order.OwnerID != client.UserID {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenDeleteOrderErrMsg)
+	if client.UserRole == roles.Customer && !order.OwnedBy(client.UserID) {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenDeleteOrderErrMsg)
 		return false
 	}
 	return true
 }
 
-// getUsersRangeStr returns a string representation of the range of the supplied orders.
-func (h *Order) getOrdersRangeStr(w http.ResponseWriter, seek *repository.PageSeekOptions, orders []*models.Order) string {
+// getOrdersPageInfo returns the position of the supplied page of orders, fetched with the supplied seek options, out of all the orders matching the seek options' filters.
+// Sets the Content-Range and Link headers of the response to match. more is whether there are orders beyond the page in the direction of the seek.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Order) getOrdersPageInfo(w http.ResponseWriter, r *http.Request, seek *repository.PageSeekOptions, orders []*models.Order, more bool) *json.Page {
 	log.Info("Counting orders...")
-	count, err := h.repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone})
+	count, err := h.repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Filters: seek.Filters})
 	if err != nil {
 		logMsg := fmt.Sprintf("Error counting orders: %s", err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-		return ""
+		return nil
 	}
+	var first, last interface{}
 	startID, endID := uint(0), uint(0)
 	if len(orders) > 0 {
+		first, last = orders[0], orders[len(orders)-1]
 		startID = orders[0].ID
 		endID = orders[len(orders)-1].ID
 	}
-	rangeStr := fmt.Sprintf("orders=%d-%d/%d", startID, endID, count)
-	return rangeStr
+	page, err := utils.GetPage(seek, first, last, len(orders), more, count, repository.OrderFields)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error building cursors for orders page: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("orders=%d-%d/%d", startID, endID, count))
+	utils.SetPageLinkHeader(w, r, page)
+	return page
 }
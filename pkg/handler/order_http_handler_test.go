@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+)
+
+func TestUpdateOrder_otherCustomersOrder(t *testing.T) {
+	repos := memory.NewMemoryRepositories(memory.NewStore())
+	h := NewOrderHandler(repos)
+	alice := &models.User{Name: "alice", Password: "s3cret!password", Role: roles.Customer}
+	bob := &models.User{Name: "bob", Password: "s3cret!password", Role: roles.Customer}
+	for _, user := range []*models.User{alice, bob} {
+		if _, err := repos.Users.Create(user); err != nil {
+			t.Fatalf("unexpected error creating a user: %s", err.Error())
+		}
+	}
+	apple := &models.Product{Name: "apple", Symbol: "🍎", Price: 1.5, NumInStock: 10}
+	if _, err := repos.Products.Create(apple); err != nil {
+		t.Fatalf("unexpected error creating a product: %s", err.Error())
+	}
+	create := httptest.NewRecorder()
+	h.CreateOrder(create, newUserRequest(t, bob, http.MethodPost, "/v1/orders", fmt.Sprintf(`{"ownerId":%d,"items":[{"productId":%d,"quantity":1}],"paymentInfo":{"cash":true}}`, bob.ID, apple.ID)))
+	if create.Code != http.StatusCreated {
+		t.Fatalf("expected bob's order to be created, got %d: %s", create.Code, create.Body.String())
+	}
+	order, err := repos.Orders.GetByID(1)
+	if err != nil {
+		t.Fatalf("unexpected error reading the order: %s", err.Error())
+	}
+
+	// A customer can't take another customer's order over by claiming it in the update.
+	body := fmt.Sprintf(`{"ID":%d,"ownerId":%d,"items":[{"productId":%d,"quantity":9}],"paymentInfo":{"cash":true}}`, order.ID, alice.ID, apple.ID)
+	r := newUserRequest(t, alice, http.MethodPut, fmt.Sprintf("/v1/orders?id=%d", order.ID), body)
+	r.Header.Set("If-Match", httputils.ETag(order.Version))
+	resp := httptest.NewRecorder()
+	h.UpdateOrder(resp, r)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected updating another customer's order to fail with 403, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if order, err = repos.Orders.GetByID(order.ID); err != nil || !order.OwnedBy(bob.ID) {
+		t.Errorf("expected the order to stay bob's, got %+v (%v)", order, err)
+	}
+
+	// Nor hand their own order over to another customer.
+	body = fmt.Sprintf(`{"ID":%d,"ownerId":%d,"items":[{"productId":%d,"quantity":1}],"paymentInfo":{"cash":true}}`, order.ID, alice.ID, apple.ID)
+	r = newUserRequest(t, bob, http.MethodPut, fmt.Sprintf("/v1/orders?id=%d", order.ID), body)
+	r.Header.Set("If-Match", httputils.ETag(order.Version))
+	resp = httptest.NewRecorder()
+	h.UpdateOrder(resp, r)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected handing an order over to another customer to fail with 403, got %d: %s", resp.Code, resp.Body.String())
+	}
+}
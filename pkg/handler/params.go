@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// recordIDMatchesQuery checks that the supplied id of a record sent in the body of the supplied http request agrees with its id query parameter, if it has one.
+// (as a record updated at its own path does, e.g. PUT /v1/orders/{id}) A record sent without an id takes the id of the query parameter.
+// Writes a response on the supplied http response writer if there is an error.
+func recordIDMatchesQuery(w http.ResponseWriter, r *http.Request, id *uint) bool {
+	if !r.URL.Query().Has(idParam) {
+		return true
+	}
+	queryID, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if *id != 0 && *id != queryID {
+		json.WriteErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("the record's ID (%d) doesn't match the ID it is updated at (%d)", *id, queryID))
+		return false
+	}
+	*id = queryID
+	return true
+}
+
+// getStatusScope returns the repository scope for the status filter in the supplied http request. Defaults to active records only.
+// Only admins can list inactive records; others get a forbidden response with the supplied message. The client is read with the supplied func.
+// Writes a response on the supplied http response writer if there is an error.
+func getStatusScope(w http.ResponseWriter, r *http.Request, getClient func(http.ResponseWriter, *http.Request) *models.JwtClaim, forbiddenMsg string) (string, error) {
+	if !r.URL.Query().Has(statusParam) {
+		return repository.ScopeActive, nil
+	}
+	scope := r.URL.Query().Get(statusParam)
+	switch scope {
+	case repository.ScopeActive:
+		return scope, nil
+	case repository.ScopeInactive, repository.ScopeAll:
+		client := getClient(w, r)
+		if client == nil {
+			return "", errors.New("failed to get client auth info")
+		}
+		if client.UserRole != roles.Admin {
+			json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenMsg)
+			return "", errors.New("client can't read inactive records")
+		}
+		return scope, nil
+	default:
+		msg := fmt.Sprintf("query parameter '%s' is invalid, expected one of: %s, %s, %s got %s", statusParam, repository.ScopeActive, repository.ScopeInactive, repository.ScopeAll, scope)
+		json.WriteErrorResponse(w, http.StatusBadRequest, msg)
+		return "", errors.New(msg)
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/gddo/httputil/header"
+	"github.com/tragicpixel/fruitbar/pkg/utils"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/jsonpatch"
+)
+
+// Fields every patchable record has, which are set by the application and can't be changed with a patch.
+var readOnlyModelFields = []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "version"}
+
+// decodePatch applies the patch in the body of the supplied http request to the supplied existing record, and decodes the patched record into the supplied destination.
+// The patch is read as a JSON Merge Patch or a JSON Patch, depending on the request's Content-Type.
+// Returns the JSON names of the top-level fields the patch changed. Patches that change one of the supplied read-only fields, or a field every record has, are rejected.
+// Writes a response on the supplied http response writer if there is an error.
+func decodePatch(w http.ResponseWriter, r *http.Request, existing interface{}, destination interface{}, readOnly ...string) ([]string, bool) {
+	mediaType, _ := header.ParseValueAndParams(r.Header, "Content-Type")
+	var apply func(doc []byte, patch []byte) ([]byte, error)
+	switch mediaType {
+	case jsonpatch.MergePatchMediaType:
+		apply = jsonpatch.MergePatch
+	case jsonpatch.JSONPatchMediaType:
+		apply = jsonpatch.Apply
+	default:
+		msg := fmt.Sprintf("Content-Type header must be %s or %s", jsonpatch.MergePatchMediaType, jsonpatch.JSONPatchMediaType)
+		json.WriteErrorResponse(w, http.StatusUnsupportedMediaType, msg)
+		return nil, false
+	}
+
+	patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, json.MAX_CREATE_REQUEST_SIZE_IN_BYTES))
+	if err != nil {
+		msg := "request body must not be larger than " + strconv.Itoa(json.MAX_CREATE_REQUEST_SIZE_IN_BYTES) + " bytes"
+		json.WriteErrorResponse(w, http.StatusRequestEntityTooLarge, msg)
+		return nil, false
+	}
+	doc, err := encjson.Marshal(existing)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, "Failed to encode record to patch: "+err.Error())
+		return nil, false
+	}
+	patched, err := apply(doc, patch)
+	switch {
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, invalidPatchErrType, err.Error())
+		return nil, false
+	case errors.Is(err, jsonpatch.ErrPatchConflict):
+		json.WriteTypedErrorResponse(w, http.StatusConflict, patchConflictErrType, err.Error())
+		return nil, false
+	case err != nil:
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, "Failed to apply patch: "+err.Error())
+		return nil, false
+	}
+
+	decoder := encjson.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(destination); err != nil {
+		writeValidationErrorResponse(w, http.StatusUnprocessableEntity, patchedRecordInvalidErrType, patchedRecordInvalidErrMsgPrefix, err)
+		return nil, false
+	}
+	// Compare the record as it would be stored, so values that are only written differently (1 and 1.0) don't count as changes.
+	normalized, err := encjson.Marshal(destination)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, "Failed to encode patched record: "+err.Error())
+		return nil, false
+	}
+	changed, err := jsonpatch.ChangedFields(doc, normalized)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, "Failed to compare patched record: "+err.Error())
+		return nil, false
+	}
+	for _, field := range append(readOnly, readOnlyModelFields...) {
+		if utils.IsStringInSlice(field, changed) {
+			reason := "field can't be changed"
+			json.WriteError(w, json.Error{
+				Status:        http.StatusUnprocessableEntity,
+				Type:          patchedRecordInvalidErrType,
+				Message:       fmt.Sprintf(patchedRecordInvalidErrMsgPrefix+"field '%s' can't be changed", field),
+				InvalidParams: []json.InvalidParam{{Name: field, Reason: reason}},
+			})
+			return nil, false
+		}
+	}
+	return changed, true
+}
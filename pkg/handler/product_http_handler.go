@@ -4,13 +4,10 @@ import (
 	"errors"
 	"strings"
 
-	"github.com/tragicpixel/fruitbar/pkg/driver"
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/models/roles"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
-	itemsrepo "github.com/tragicpixel/fruitbar/pkg/repository/item"
 	jwtrepo "github.com/tragicpixel/fruitbar/pkg/repository/jwt"
-	productrepo "github.com/tragicpixel/fruitbar/pkg/repository/product"
 	"github.com/tragicpixel/fruitbar/pkg/utils"
 	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
 	"github.com/tragicpixel/fruitbar/pkg/utils/json"
@@ -24,23 +21,53 @@ import (
 
 // Product represents a handler for performing operations on products via HTTP.
 type Product struct {
+	repos     *repository.Repositories
 	repo      repository.Product
 	itemsRepo repository.Item
+	auditRepo repository.Audit
 	jwtRepo   repository.Jwt
 }
 
-// NewProductHandler creates and initializes a new handler for performing operations on products via HTTP.
-func NewProductHandler(db *driver.DB) *Product {
+// NewProductHandler creates and initializes a new handler for performing operations on products via HTTP, on the records in the supplied repositories.
+func NewProductHandler(repos *repository.Repositories) *Product {
 	return &Product{
-		repo:      productrepo.NewPostgresProductRepo(db.Postgres),
-		itemsRepo: itemsrepo.NewPostgresItemRepo(db.Postgres),
+		repos:     repos,
+		repo:      repos.Products,
+		itemsRepo: repos.Items,
+		auditRepo: repos.Audit,
 		jwtRepo:   jwtrepo.NewJWTRepository(),
 	}
 }
 
+// withRepos returns a copy of the handler that performs its operations on the supplied repositories, such as those of a transaction.
+func (h *Product) withRepos(repos *repository.Repositories) *Product {
+	return NewProductHandler(repos)
+}
+
+// BatchProducts runs the batch of product operations in the supplied http request, and sends a response in JSON containing the result of each operation to the supplied http response writer.
+// Each operation is checked exactly as the single product request it stands for.
+func (h *Product) BatchProducts(w http.ResponseWriter, r *http.Request) {
+	runBatch(w, r, models.AuditEntityProduct, h.repos, func(repos *repository.Repositories) map[string]http.HandlerFunc {
+		t := h.withRepos(repos)
+		return map[string]http.HandlerFunc{
+			models.BatchOpCreate: t.CreateProduct,
+			models.BatchOpUpdate: t.UpdateProduct,
+			models.BatchOpPatch:  t.PatchProduct,
+			models.BatchOpDelete: t.DeleteProduct,
+		}
+	})
+}
+
 // CreateProduct creates a new product based on the supplied HTTP request and sends a response in JSON containing the newly created product to the supplied http response writer.
 // If there is a permission error, an HTTP error will be sent.
 func (h *Product) CreateProduct(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).createProduct
+	})
+}
+
+// createProduct creates a new product from the supplied http request, and sends a response in JSON containing the new product.
+func (h *Product) createProduct(w http.ResponseWriter, r *http.Request) {
 	if !h.clientHasCreatePerms(w, r) {
 		return
 	}
@@ -53,7 +80,7 @@ func (h *Product) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 	err := product.IsValid()
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Product "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Product "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -64,6 +91,9 @@ func (h *Product) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	if !h.recordAudit(w, r, models.AuditActionCreate, createdId, nil, &product) {
+		return
+	}
 	log.Info(fmt.Sprintf("Created new product (id: %d): %+v", createdId, product))
 	response = json.Response{Data: []*models.Product{&product}}
 	json.WriteResponse(w, http.StatusCreated, response)
@@ -80,6 +110,13 @@ func (h *Product) GetProducts(w http.ResponseWriter, r *http.Request) {
 
 // UpdateOrder updates an existing product based on the supplied http request and sends a response in JSON containing the updated product to the supplied http response writer.
 func (h *Product) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).updateProduct
+	})
+}
+
+// updateProduct updates the product in the supplied http request, in full or only the fields listed in it.
+func (h *Product) updateProduct(w http.ResponseWriter, r *http.Request) {
 	if !h.clientHasUpdatePerms(w, r) {
 		return
 	}
@@ -90,16 +127,114 @@ func (h *Product) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		json.WriteErrorResponse(w, response.Error.Code, response.Error.Message)
 		return
 	}
+	if !recordIDMatchesQuery(w, r, &product.ID) {
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting Product (id: %d) before update...", product.ID))
+	existing, err := h.repo.GetByID(product.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, productNotFoundErrType, productNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting Product (id: %d) before update: %s", product.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+	product.Version = existing.Version
 
 	if r.URL.Query().Has(fieldsParam) {
-		h.partiallyUpdateProduct(w, r, product)
+		h.partiallyUpdateProduct(w, r, product, existing)
 	} else {
-		h.fullyUpdateProduct(w, r, product)
+		h.fullyUpdateProduct(w, r, product, existing)
 	}
 }
 
-// DeleteOrder deletes an existing product and any items with the its product ID, based on the supplied http request, and sends a status code to the supplied http response writer.
+// PatchProduct applies the JSON Merge Patch or JSON Patch in the supplied http request to an existing product (id via http query parameter),
+// and sends a response in JSON containing the patched product to the supplied http response writer.
+// The patched product is validated before anything is stored.
+func (h *Product) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).patchProduct
+	})
+}
+
+// patchProduct applies the patch in the supplied http request to the product with the id in it.
+func (h *Product) patchProduct(w http.ResponseWriter, r *http.Request) {
+	if !h.clientHasUpdatePerms(w, r) {
+		return
+	}
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting Product (id: %d) before patch...", id))
+	existing, err := h.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, productNotFoundErrType, productNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting Product (id: %d) before patch: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+
+	var product models.Product
+	changed, ok := decodePatch(w, r, existing, &product)
+	if !ok {
+		return
+	}
+	if err := product.IsValid(); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Product "+validationFailedErrMsgPrefix, err)
+		return
+	}
+	if len(changed) == 0 {
+		log.Info(fmt.Sprintf("Patch left Product (id: %d) unchanged", id))
+		setETag(w, existing.Version)
+		json.WriteResponse(w, http.StatusOK, json.Response{Data: []*models.Product{existing}})
+		return
+	}
+	// Write the changed fields by column, so fields patched to their zero value are stored too.
+	columns := make([]string, len(changed))
+	for i, field := range changed {
+		columns[i] = repository.ProductFields[strings.ToLower(field)].Column
+	}
+
+	log.Info(fmt.Sprintf("Patching Product (id: %d) fields (%s) to %+v", id, strings.Join(changed, ","), product))
+	updated, err := h.repo.Update(&product, columns)
+	if err != nil {
+		writeUpdateErrorResponse(w, err, fmt.Sprintf("Error patching Product (id: %d): %s", id, err.Error()))
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionUpdate, id, existing, updated) {
+		return
+	}
+	log.Info(fmt.Sprintf("Patched Product (id: %d): %+v", id, updated))
+	setETag(w, product.Version)
+	response := json.Response{Data: []*models.Product{&product}}
+	json.WriteResponse(w, http.StatusOK, response)
+}
+
+// DeleteProduct archives an existing product based on the supplied http request, and sends a status code to the supplied http response writer.
+// An archived product is hidden from the catalog and can't be ordered, but the orders it is in still refer to it.
 func (h *Product) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).deleteProduct
+	})
+}
+
+// deleteProduct archives the product with the id in the supplied http request.
+func (h *Product) deleteProduct(w http.ResponseWriter, r *http.Request) {
 	if !h.clientHasDeletePerms(w, r) {
 		return
 	}
@@ -110,43 +245,130 @@ func (h *Product) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	exists, err := h.repo.Exists(id)
-	if !exists {
-		json.WriteErrorResponse(w, http.StatusNotFound, productNotFoundMsg)
+	existing, err := h.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, productNotFoundErrType, productNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting product before delete (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	if !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+
+	log.Info(fmt.Sprintf("Archiving product (id: %d)...", id))
+	err = h.repo.Delete(id)
 	if err != nil {
-		logMsg := fmt.Sprintf("Error checking existence of product before delete (id: %d): %s", id, err.Error())
+		logMsg := fmt.Sprintf("Error archiving product (id: %d): %s", id, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	if !h.recordAudit(w, r, models.AuditActionDelete, id, existing, nil) {
+		return
+	}
+	log.Info(fmt.Sprintf("Successfully archived product with id = %d.", id))
+	json.WriteResponse(w, http.StatusOK, json.Response{})
+}
+
+// RestoreProduct puts an archived product back in the catalog based on the supplied http request,
+// and sends a response in JSON containing the restored product to the supplied http response writer.
+func (h *Product) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).restoreProduct
+	})
+}
 
-	log.Info(fmt.Sprintf("Selecting items with product id %d for potential delete...", id))
-	existingItems, err := h.itemsRepo.GetByProductID(id)
+// restoreProduct brings back the archived product with the id in the supplied http request.
+func (h *Product) restoreProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
 	if err != nil {
-		logMsg := fmt.Sprintf("Error reading existing items for product (id: %d): %s", id, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	for _, item := range existingItems {
-		log.Info(fmt.Sprintf("Deleting existing item (id: %d, order id: %d) with product (id: %d)", item.ID, item.OrderID, id))
-		err := h.itemsRepo.Delete(item.ID)
-		if err != nil {
-			logMsg := fmt.Sprintf("Error deleting existing item (id: %d): %s", item.ID, err.Error())
-			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+
+	log.Info(fmt.Sprintf("Restoring Product (id: %d)...", id))
+	err = h.repo.Restore(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, productNotFoundErrType, archivedProductNotFoundMsg)
 			return
 		}
+		logMsg := fmt.Sprintf("Error restoring Product (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	product, err := h.repo.GetByID(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting restored Product (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
 	}
-	log.Info(fmt.Sprintf("Deleted all items for product (id: %d)", id))
+	if !h.recordAudit(w, r, models.AuditActionRestore, id, nil, product) {
+		return
+	}
+	log.Info(fmt.Sprintf("Successfully restored Product with id = %d.", id))
+	setETag(w, product.Version)
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: []*models.Product{product}})
+}
 
-	log.Info(fmt.Sprintf("Deleting product (id: %d)...", id))
-	err = h.repo.Delete(id)
+// PurgeProduct permanently removes an archived product based on the supplied http request, and sends a status code to the supplied http response writer.
+// Products that are in any order can't be purged, so the orders keep referring to them.
+func (h *Product) PurgeProduct(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).purgeProduct
+	})
+}
+
+// purgeProduct permanently removes the archived product with the id in the supplied http request.
+func (h *Product) purgeProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
 	if err != nil {
-		logMsg := fmt.Sprintf("Error deleting product (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Checking that Product (id: %d) is archived before purge...", id))
+	active, err := h.repo.Exists(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error checking existence of product before purge (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if active {
+		json.WriteTypedErrorResponse(w, http.StatusConflict, productActiveErrType, purgeActiveProductErrMsg)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Checking that Product (id: %d) isn't in any order before purge...", id))
+	items, err := h.itemsRepo.GetByProductID(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting items of Product (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if len(items) > 0 {
+		json.WriteTypedErrorResponse(w, http.StatusConflict, productInUseErrType, purgeProductInUseErrMsg)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Purging Product (id: %d)...", id))
+	err = h.repo.Purge(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, productNotFoundErrType, archivedProductNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error purging Product (id: %d): %s", id, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	log.Info(fmt.Sprintf("Successfully deleted product with id = %d.", id))
+	if !h.recordAudit(w, r, models.AuditActionPurge, id, nil, nil) {
+		return
+	}
+	log.Info(fmt.Sprintf("Successfully purged Product with id = %d.", id))
 	json.WriteResponse(w, http.StatusOK, json.Response{})
 }
 
@@ -162,12 +384,17 @@ func (h *Product) getSingleProduct(w http.ResponseWriter, r *http.Request) {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	readOpts, err := utils.GetReadOptions(r, repository.ProductFields, nil, nil)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	log.Info(fmt.Sprintf("Reading product (id: %d)...", id))
 	var product *models.Product
 	product, err = h.repo.GetByID(id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			json.WriteErrorResponse(w, http.StatusNotFound, productNotFoundMsg)
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, productNotFoundErrType, productNotFoundMsg)
 			return
 		}
 		logMsg := fmt.Sprintf("Error reading product (id: %d): %s", id, err.Error())
@@ -175,43 +402,59 @@ func (h *Product) getSingleProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	log.Info(fmt.Sprintf("Read product (id: %d)", id))
-	response := json.Response{Data: []*models.Product{product}}
-	json.WriteResponse(w, http.StatusOK, response)
+	if notModified(w, r, product.Version) {
+		return
+	}
+	writeRecords(w, []*models.Product{product}, readOpts, repository.ProductFields, nil)
 }
 
 // getProductsPage sends a response to the supplied http response writer containing the requested page of products, based on the supplied http request.
 func (h *Product) getProductsPage(w http.ResponseWriter, r *http.Request) {
-	seek, err := utils.GetPageSeekOptions(r, readProductsPageMaxRecordLimit)
+	seek, err := utils.GetPageSeekOptions(r, readProductsPageMaxRecordLimit, repository.ProductFields)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	readOpts, err := utils.GetReadOptions(r, repository.ProductFields, nil, nil)
 	if err != nil {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	seek.Fields = readOpts.Select()
+	seek.Scope, err = getStatusScope(w, r, h.getClientAuthInfo, forbiddenReadArchivedProductsErrMsg)
+	if err != nil {
+		return
+	}
 
-	log.Info(fmt.Sprintf("Reading %d products (max %d)...", seek.RecordLimit, readProductsPageMaxRecordLimit))
+	log.Info(fmt.Sprintf("Reading %d %s products (max %d) matching %v...", seek.RecordLimit, seek.Scope, readProductsPageMaxRecordLimit, seek.Filters))
 	var products []*models.Product
-	products, err = h.repo.Fetch(seek)
+	products, err = h.repo.Fetch(utils.GetPeekSeekOptions(seek))
 	if err != nil {
 		logMsg := fmt.Sprintf("Error reading products: %s", err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	start, end, more := utils.TrimPage(seek, len(products))
+	products = products[start:end]
 
-	rangeStr := h.getProductsRangeStr(w, products)
-	w.Header().Set("Content-Range", rangeStr)
+	page := h.getProductsPageInfo(w, r, seek, products, more)
+	if page == nil {
+		return
+	}
 	log.Info(fmt.Sprintf("Read %d products", len(products)))
-	response := json.Response{Data: products}
-	json.WriteResponse(w, http.StatusOK, response)
+	writeRecords(w, products, readOpts, repository.ProductFields, page)
 }
 
 // partiallyUpdateProduct updates only the specified fields (via http query parameter) of the supplied user
 // and sends a response to the supplied http response writer containing the updated user in JSON.
-func (h *Product) partiallyUpdateProduct(w http.ResponseWriter, r *http.Request, product models.Product) {
+// The product as it was before the update is recorded in the audit log.
+func (h *Product) partiallyUpdateProduct(w http.ResponseWriter, r *http.Request, product models.Product, existing *models.Product) {
 	fieldsStr := r.URL.Query().Get(fieldsParam)
 	fields := strings.Split(fieldsStr, ",")
 
 	err := product.PartialUpdateIsValid(fields)
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Product "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Product "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -219,20 +462,25 @@ func (h *Product) partiallyUpdateProduct(w http.ResponseWriter, r *http.Request,
 	updated, err := h.repo.Update(&product, fields)
 	if err != nil {
 		logMsg := fmt.Sprintf("Error partially updating Product (id: %d)  fields (%s) : %s", product.ID, fieldsStr, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		writeUpdateErrorResponse(w, err, logMsg)
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionUpdate, product.ID, existing, updated) {
 		return
 	}
 	log.Info(fmt.Sprintf("Partially updated Product (id: %d) fields (%s): %+v", product.ID, fieldsStr, updated))
+	setETag(w, product.Version)
 	response := json.Response{Data: []*models.Product{&product}}
 	json.WriteResponse(w, http.StatusOK, response)
 }
 
 // fullyUpdateProduct updates all of the fields of the supplied product
 // and sends a response to the supplied http response writer containing the updated product in JSON.
-func (h *Product) fullyUpdateProduct(w http.ResponseWriter, r *http.Request, product models.Product) {
+// The product as it was before the update is recorded in the audit log.
+func (h *Product) fullyUpdateProduct(w http.ResponseWriter, r *http.Request, product models.Product, existing *models.Product) {
 	err := product.IsValid()
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Product "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "Product "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -240,10 +488,14 @@ func (h *Product) fullyUpdateProduct(w http.ResponseWriter, r *http.Request, pro
 	updated, err := h.repo.Update(&product, []string{})
 	if err != nil {
 		logMsg := fmt.Sprintf("Error fully updating Product with id = %d: %+v: %s", product.ID, product, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		writeUpdateErrorResponse(w, err, logMsg)
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionUpdate, product.ID, existing, updated) {
 		return
 	}
 	log.Info(fmt.Sprintf("Fully updated Product (id: %d): %+v", product.ID, updated))
+	setETag(w, product.Version)
 	response := json.Response{Data: []*models.Product{&product}}
 	json.WriteResponse(w, http.StatusOK, response)
 }
@@ -254,18 +506,28 @@ func (h *Product) getClientAuthInfo(w http.ResponseWriter, r *http.Request) *mod
 	client, err := jwtutils.GetTokenClaims(r, h.jwtRepo)
 	if err != nil {
 		logMsg := unauthorizedErrMsgPrefix + err.Error()
-		json.WriteErrorResponse(w, http.StatusBadRequest, unauthorizedErrMsg, logMsg)
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 		return nil
 	}
 	err = roles.IsValid(client.UserRole)
 	if err != nil {
 		logMsg := unauthorizedErrMsgPrefix + err.Error()
-		json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 		return nil
 	}
 	return client
 }
 
+// recordAudit records the supplied action on the product with the supplied id, performed by the client, in the audit log
+// along with the state of the product before and after it. Writes a response on the supplied http response writer if there is an error.
+func (h *Product) recordAudit(w http.ResponseWriter, r *http.Request, action string, id uint, before *models.Product, after *models.Product) bool {
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return false
+	}
+	return appendAuditEntry(w, r, h.auditRepo, client, action, models.AuditEntityProduct, id, before, after)
+}
+
 // clientHasCreatePerms checks whether the client has permissions to create a product, based on the supplied http request.
 // Writes a response on the supplied http response writer if there is an error.
 func (h *Product) clientHasCreatePerms(w http.ResponseWriter, r *http.Request) bool {
@@ -274,7 +536,7 @@ func (h *Product) clientHasCreatePerms(w http.ResponseWriter, r *http.Request) b
 		return false
 	}
 	if client.UserRole != roles.Admin {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenCreateProductErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenCreateProductErrMsg)
 		return false
 	}
 	return true
@@ -289,7 +551,7 @@ func (h *Product) clientHasUpdatePerms(w http.ResponseWriter, r *http.Request) b
 	}
 	// Only an admin can update a product
 	if client.UserRole != roles.Admin {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenUpdateProductErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenUpdateProductErrMsg)
 		return false
 	}
 	return true
@@ -304,28 +566,38 @@ func (h *Product) clientHasDeletePerms(w http.ResponseWriter, r *http.Request) b
 	}
 	// Only an admin can delete a product
 	if client.UserRole != roles.Admin {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenDeleteProductErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenDeleteProductErrMsg)
 		return false
 	}
 	return true
 }
 
-// getProductsRangeStr returns a string representation of the range of the supplied products.
-func (h *Product) getProductsRangeStr(w http.ResponseWriter, products []*models.Product) string {
+// getProductsPageInfo returns the position of the supplied page of products, fetched with the supplied seek options, out of all the products in the seek options' scope matching its filters.
+// Sets the Content-Range and Link headers of the response to match. more is whether there are products beyond the page in the direction of the seek.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *Product) getProductsPageInfo(w http.ResponseWriter, r *http.Request, seek *repository.PageSeekOptions, products []*models.Product, more bool) *json.Page {
 	log.Info("Counting products...")
 	// TODO: Cache this count value and update every X seconds, so we don't need to perform a full count on every page read.
-	// TODO: I want a full count here, but I think this is just returning the number of total records based on this seek, not the total # of orders.
-	count, err := h.repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone})
+	count, err := h.repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Filters: seek.Filters, Scope: seek.Scope})
 	if err != nil {
 		logMsg := fmt.Sprintf("Error counting products: %s", err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-		return ""
+		return nil
 	}
+	var first, last interface{}
 	startID, endID := uint(0), uint(0)
 	if len(products) > 0 {
+		first, last = products[0], products[len(products)-1]
 		startID = products[0].ID
 		endID = products[len(products)-1].ID
 	}
-	rangeStr := fmt.Sprintf("products=%d-%d/%d", startID, endID, count)
-	return rangeStr
+	page, err := utils.GetPage(seek, first, last, len(products), more, count, repository.ProductFields)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error building cursors for products page: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("products=%d-%d/%d", startID, endID, count))
+	utils.SetPageLinkHeader(w, r, page)
+	return page
 }
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/utils"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// Related records that can be embedded in orders with the include query parameter.
+var orderRelations = []string{"items", "items.product", "owner"}
+
+// Orders have always been sent with their items, so keep doing so for clients that don't ask for anything else.
+var orderDefaultInclude = []string{"items"}
+
+// Related records that can be embedded in users with the include query parameter.
+var userRelations = []string{"orders", "orders.items"}
+
+// writeRecords sends a response to the supplied http response writer containing the supplied records, with only the fields selected in the supplied read options,
+// and the supplied position of the records in a listing. (nil if they aren't a page of a listing)
+func writeRecords(w http.ResponseWriter, records interface{}, opts *utils.ReadOptions, fields map[string]repository.Field, page *json.Page) {
+	data, err := utils.GetSparseData(records, opts, fields)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, "Error selecting fields of records: "+err.Error())
+		return
+	}
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: data, Page: page})
+}
+
+// uniqueIDs returns the supplied ids without duplicates or zeros, in the order they first appear.
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
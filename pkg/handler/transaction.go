@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// Returned from a request's transaction to roll it back when the request fails.
+var errRequestFailed = errors.New("request failed")
+
+// runInTransaction runs the supplied request with the http handler returned for the repositories of a single transaction,
+// and sends the handler's response to the supplied http response writer once the transaction ends.
+// The transaction is committed when the handler succeeds, and rolled back when it fails (with a status of 400 or above),
+// so the request makes either all of its changes or none of them.
+func runInTransaction(w http.ResponseWriter, r *http.Request, repos *repository.Repositories, handler func(tx *repository.Repositories) http.HandlerFunc) {
+	recorder := responseRecorder{header: http.Header{}}
+	err := repos.Transactions.Transaction(func(tx *repository.Repositories) error {
+		handler(tx)(&recorder, r)
+		if recorder.status >= http.StatusBadRequest {
+			return errRequestFailed
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRequestFailed) {
+		logMsg := "Error committing transaction: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	recorder.replay(w)
+}
+
+// responseRecorder is an http response writer that records a response, to be used as the result of a batch operation,
+// or sent once the transaction it was written in ends.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// Header returns the headers of the recorded response.
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader records the supplied status code, if one hasn't been written already.
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+// Write records the supplied part of the response body.
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	return rec.body.Write(b)
+}
+
+// replay sends the recorded response to the supplied http response writer.
+func (rec *responseRecorder) replay(w http.ResponseWriter) {
+	for name, values := range rec.header {
+		w.Header()[name] = values
+	}
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+	}
+	w.Write(rec.body.Bytes())
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	jwtrepo "github.com/tragicpixel/fruitbar/pkg/repository/jwt"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	jwtutils "github.com/tragicpixel/fruitbar/pkg/utils/jwt"
+)
+
+// failingAudit is an audit log refusing every entry.
+type failingAudit struct {
+	repository.Audit
+}
+
+func (failingAudit) Append(*models.AuditEntry) error {
+	return errors.New("audit log unavailable")
+}
+
+// failingAuditTransactor runs transactions whose audit log refuses every entry.
+type failingAuditTransactor struct {
+	repository.Transactor
+}
+
+func (t failingAuditTransactor) Transaction(fn func(tx *repository.Repositories) error) error {
+	return t.Transactor.Transaction(func(tx *repository.Repositories) error {
+		tx.Audit = failingAudit{tx.Audit}
+		return fn(tx)
+	})
+}
+
+// newAdminRequest returns a request with the supplied method, target and body, made by an admin.
+func newAdminRequest(t *testing.T, method string, target string, body string) *http.Request {
+	return newUserRequest(t, &models.User{Name: "admin", Role: roles.Admin}, method, target, body)
+}
+
+// newUserRequest returns a request with the supplied method, target and body, made by the supplied user.
+func newUserRequest(t *testing.T, user *models.User, method string, target string, body string) *http.Request {
+	jwt := jwtutils.GetSecretAuthToken()
+	jwt.ExpirationHours = 1
+	token, err := jwtrepo.NewJWTRepository().GenerateToken(&jwt, user)
+	if err != nil {
+		t.Fatalf("unexpected error generating a token: %s", err.Error())
+	}
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestRunInTransaction_auditFailure(t *testing.T) {
+	store := memory.NewStore()
+	repos := memory.NewMemoryRepositories(store)
+	repos.Transactions = failingAuditTransactor{store}
+	h := NewProductHandler(repos)
+
+	// A change that can't be audited is rolled back.
+	resp := httptest.NewRecorder()
+	h.CreateProduct(resp, newAdminRequest(t, http.MethodPost, "/v1/products", `{"name":"apple","symbol":"🍎","price":1.5,"numInStock":10}`))
+	if resp.Code != http.StatusInternalServerError {
+		t.Errorf("expected creating a product that can't be audited to fail with 500, got %d", resp.Code)
+	}
+	if exists, err := repos.Products.Exists(1); err != nil || exists {
+		t.Errorf("expected the product not to be created, got %v (%v)", exists, err)
+	}
+
+	// Changes that are audited are committed, and their response is sent as it was written.
+	repos.Transactions = store
+	h = NewProductHandler(repos)
+	resp = httptest.NewRecorder()
+	h.CreateProduct(resp, newAdminRequest(t, http.MethodPost, "/v1/products", `{"name":"apple","symbol":"🍎","price":1.5,"numInStock":10}`))
+	if resp.Code != http.StatusCreated || !strings.Contains(resp.Body.String(), "apple") {
+		t.Errorf("expected the product to be created, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if exists, err := repos.Products.Exists(1); err != nil || !exists {
+		t.Errorf("expected the product to be created, got %v (%v)", exists, err)
+	}
+}
@@ -1,12 +1,10 @@
 package handler
 
 import (
-	"github.com/tragicpixel/fruitbar/pkg/driver"
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/models/roles"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
 	jwtrepo "github.com/tragicpixel/fruitbar/pkg/repository/jwt"
-	userrepo "github.com/tragicpixel/fruitbar/pkg/repository/user"
 	"github.com/tragicpixel/fruitbar/pkg/utils"
 	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
 	"github.com/tragicpixel/fruitbar/pkg/utils/json"
@@ -22,21 +20,82 @@ import (
 
 // User represents a handler for performing operations on users via HTTP.
 type User struct {
-	repo    repository.User
-	jwtRepo repository.Jwt
+	repos          *repository.Repositories
+	repo           repository.User
+	ordersRepo     repository.Order
+	itemsRepo      repository.Item
+	identityRepo   repository.ExternalIdentity
+	auditRepo      repository.Audit
+	jwtRepo        repository.Jwt
+	orderRetention OrderRetentionPolicy
 }
 
-// NewUserHandler creates and initializes a new handler for performing operations on users via HTTP.
-func NewUserHandler(db *driver.DB) *User {
+// OrderRetentionPolicy determines what happens to a user's orders when the user is purged.
+type OrderRetentionPolicy string
+
+const (
+	// RetainOrders keeps the user's orders, detaching them from the purged user. (owner id is set to null)
+	RetainOrders OrderRetentionPolicy = "retain"
+	// DeleteOrders deletes the user's orders and all of their items along with the user.
+	DeleteOrders OrderRetentionPolicy = "delete"
+)
+
+// NewUserHandler creates and initializes a new handler for performing operations on users via HTTP, on the records in the supplied repositories.
+func NewUserHandler(repos *repository.Repositories) *User {
 	return &User{
-		repo:    userrepo.NewPostgresUserRepo(db.Postgres), // this is where it is decided which implementation(/database type) of the User Repo we will use
-		jwtRepo: jwtrepo.NewJWTRepository(),
+		repos:          repos,
+		repo:           repos.Users,
+		ordersRepo:     repos.Orders,
+		itemsRepo:      repos.Items,
+		identityRepo:   repos.Identities,
+		auditRepo:      repos.Audit,
+		jwtRepo:        jwtrepo.NewJWTRepository(),
+		orderRetention: RetainOrders,
+	}
+}
+
+// SetOrderRetentionPolicy sets what happens to a user's orders when the user is purged. Defaults to RetainOrders.
+func (h *User) SetOrderRetentionPolicy(p OrderRetentionPolicy) error {
+	switch p {
+	case RetainOrders, DeleteOrders:
+		h.orderRetention = p
+		return nil
+	default:
+		return fmt.Errorf("order retention policy is invalid, expected one of: %s, %s got %s", RetainOrders, DeleteOrders, p)
 	}
 }
 
+// withRepos returns a copy of the handler that performs its operations on the supplied repositories, such as those of a transaction.
+func (h *User) withRepos(repos *repository.Repositories) *User {
+	t := NewUserHandler(repos)
+	t.orderRetention = h.orderRetention
+	return t
+}
+
+// BatchUsers runs the batch of user operations in the supplied http request, and sends a response in JSON containing the result of each operation to the supplied http response writer.
+// Each operation is checked exactly as the single user request it stands for.
+func (h *User) BatchUsers(w http.ResponseWriter, r *http.Request) {
+	runBatch(w, r, models.AuditEntityUser, h.repos, func(repos *repository.Repositories) map[string]http.HandlerFunc {
+		t := h.withRepos(repos)
+		return map[string]http.HandlerFunc{
+			models.BatchOpCreate: t.CreateUser,
+			models.BatchOpUpdate: t.UpdateUser,
+			models.BatchOpPatch:  t.PatchUser,
+			models.BatchOpDelete: t.DeleteUser,
+		}
+	})
+}
+
 // CreateUser creates a new user based on the supplied HTTP request and sends a response in JSON containing the newly created user to the supplied http response writer.
 // If there is a permission error, an HTTP error will be sent.
 func (h *User) CreateUser(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).createUser
+	})
+}
+
+// createUser creates a new user from the supplied http request, and sends a response in JSON containing the new user.
+func (h *User) createUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	response := *json.DecodeAndGetErrorResponse(w, r, &user, json.MAX_CREATE_REQUEST_SIZE_IN_BYTES)
 	if response.Error != nil {
@@ -45,7 +104,7 @@ func (h *User) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	err := user.IsValid()
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "User "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "User "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -62,7 +121,7 @@ func (h *User) CreateUser(w http.ResponseWriter, r *http.Request) {
 	}
 	if existingUser != nil {
 		msg := fmt.Sprintf("Failed to create user %s: a user with that name already exists", user.Name)
-		json.WriteErrorResponse(w, http.StatusBadRequest, msg)
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, userExistsErrType, msg)
 		return
 	}
 
@@ -80,6 +139,9 @@ func (h *User) CreateUser(w http.ResponseWriter, r *http.Request) {
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	if !h.recordAudit(w, r, models.AuditActionCreate, models.AuditEntityUser, id, nil, &user) {
+		return
+	}
 	log.Info(fmt.Sprintf("Created new user '%s' (id: %d)", user.Name, id))
 	response = json.Response{Data: []*models.User{&user}}
 	json.WriteResponse(w, http.StatusCreated, response)
@@ -96,26 +158,149 @@ func (h *User) GetUsers(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser updates an existing user based on the supplied http request and sends a response in JSON containing the updated user to the supplied http response writer.
 func (h *User) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).updateUser
+	})
+}
+
+// updateUser updates the user in the supplied http request, in full or only the fields listed in it.
+func (h *User) updateUser(w http.ResponseWriter, r *http.Request) {
 	var user models.User
 	response := *json.DecodeAndGetErrorResponse(w, r, &user, json.MAX_CREATE_REQUEST_SIZE_IN_BYTES)
 	if response.Error != nil {
 		json.WriteErrorResponse(w, response.Error.Code, response.Error.Message)
 		return
 	}
+	if !recordIDMatchesQuery(w, r, &user.ID) {
+		return
+	}
 
 	if !h.clientHasUpdatePermsForUser(w, r, user) {
 		return
 	}
+	var fields []string
+	if r.URL.Query().Has(fieldsParam) {
+		fields = strings.Split(r.URL.Query().Get(fieldsParam), ",")
+	}
+	if !h.clientCanChangeCredentials(w, r, fields) {
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting User (id: %d) before update...", user.ID))
+	existing, err := h.repo.GetByID(user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, userNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting User (id: %d) before update: %s", user.ID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+	user.Version = existing.Version
 
 	if r.URL.Query().Has(fieldsParam) {
-		h.partiallyUpdateUser(w, r, user)
+		h.partiallyUpdateUser(w, r, user, existing)
 	} else {
-		h.fullyUpdateUser(w, r, user)
+		h.fullyUpdateUser(w, r, user, existing)
 	}
 }
 
-// DeleteProduct deletes an existing user from the repo based on the supplied http request, and returns a status message in JSON to the user.
+// User fields a patch can't change, besides the ones every record has.
+var userReadOnlyFields = []string{"orders"}
+
+// PatchUser applies the JSON Merge Patch or JSON Patch in the supplied http request to an existing user (id via http query parameter),
+// and sends a response in JSON containing the patched user to the supplied http response writer.
+// The user's password is patched as if it were empty: a patch that sets it sets a new password. The patched user is validated before anything is stored.
+func (h *User) PatchUser(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).patchUser
+	})
+}
+
+// patchUser applies the patch in the supplied http request to the user with the id in it.
+func (h *User) patchUser(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting User (id: %d) before patch...", id))
+	existing, err := h.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, userNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting User (id: %d) before patch: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !h.clientHasUpdatePermsForUser(w, r, *existing) || !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+
+	// Never hand the password hash to the patch.
+	doc := *existing
+	doc.Password = ""
+	var user models.User
+	changed, ok := decodePatch(w, r, &doc, &user, userReadOnlyFields...)
+	if !ok {
+		return
+	}
+	if len(changed) == 0 {
+		log.Info(fmt.Sprintf("Patch left User (id: %d) unchanged", id))
+		setETag(w, existing.Version)
+		json.WriteResponse(w, http.StatusOK, json.Response{Data: []*models.User{&doc}})
+		return
+	}
+	// Check the patched user too, so the patch can't give the user a role the client can't manage.
+	if !h.clientHasUpdatePermsForUser(w, r, user) || !h.clientCanChangeCredentials(w, r, changed) {
+		return
+	}
+	if err := user.ValidatePartialUserUpdate(changed); err != nil {
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "User "+validationFailedErrMsgPrefix, err)
+		return
+	}
+	if utils.IsStringInSlice("password", changed) {
+		log.Info(fmt.Sprintf("Password changed for user %s: Hashing password...", user.Name))
+		if err := h.repo.HashPassword(&user, user.Password); err != nil {
+			logMsg := fmt.Sprintf("Failed to hash password: %s", err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+	}
+
+	log.Info(fmt.Sprintf("Patching User (id: %d) fields (%s)", id, strings.Join(changed, ",")))
+	updated, err := h.repo.Update(&user, changed)
+	if err != nil {
+		writeUpdateErrorResponse(w, err, fmt.Sprintf("Error patching User (id: %d): %s", id, err.Error()))
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionUpdate, models.AuditEntityUser, id, existing, updated) {
+		return
+	}
+	log.Info(fmt.Sprintf("Patched User (id: %d)", id))
+	setETag(w, user.Version)
+	user.Password = ""
+	response := json.Response{Data: []*models.User{&user}}
+	json.WriteResponse(w, http.StatusOK, response)
+}
+
+// DeleteUser deactivates an existing user based on the supplied http request, and returns a status message in JSON to the user.
+// A deactivated user can't log in and their tokens are rejected, but the user and their orders are kept until the user is purged.
 func (h *User) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).deleteUser
+	})
+}
+
+// deleteUser deactivates the user with the id in the supplied http request.
+func (h *User) deleteUser(w http.ResponseWriter, r *http.Request) {
 	id, err := httputils.GetQueryParamAsUint(r, idParam)
 	if err != nil {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
@@ -126,25 +311,133 @@ func (h *User) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Info(fmt.Sprintf("Deleting User (id: %d)...", id))
-	exists, err := h.repo.Exists(id)
-	if !exists {
-		msg := fmt.Sprintf("User with id = %d could not be found", id)
-		json.WriteErrorResponse(w, http.StatusNotFound, msg)
+	log.Info(fmt.Sprintf("Deactivating User (id: %d)...", id))
+	existing, err := h.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			msg := fmt.Sprintf("User with id = %d could not be found", id)
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, msg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting user before deactivation (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	if !clientHasCurrentVersion(w, r, existing.Version) {
+		return
+	}
+	err = h.repo.Delete(id)
 	if err != nil {
-		logMsg := fmt.Sprintf("Error checking existence of user before delete (id: %d): %s", id, err.Error())
+		logMsg := fmt.Sprintf("Error deactivating User (id: %d): %s", id, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	err = h.repo.Delete(id)
+	if !h.recordAudit(w, r, models.AuditActionDelete, models.AuditEntityUser, id, existing, nil) {
+		return
+	}
+	log.Info(fmt.Sprintf("Successfully deactivated User with id = %d.", id))
+	json.WriteResponse(w, http.StatusOK, json.Response{})
+}
+
+// RestoreUser reactivates a deactivated user based on the supplied http request, and sends a response in JSON containing the restored user.
+func (h *User) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).restoreUser
+	})
+}
+
+// restoreUser reactivates the deactivated user with the id in the supplied http request.
+func (h *User) restoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Restoring User (id: %d)...", id))
+	err = h.repo.Restore(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, inactiveUserNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error restoring User (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	user, err := h.repo.GetByID(id)
 	if err != nil {
-		logMsg := fmt.Sprintf("Error deleting User (id: %d): %s", id, err.Error())
+		logMsg := fmt.Sprintf("Error selecting restored User (id: %d): %s", id, err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
-	log.Info(fmt.Sprintf("Successfully deleted User with id = %d.", id))
+	if !h.recordAudit(w, r, models.AuditActionRestore, models.AuditEntityUser, id, nil, user) {
+		return
+	}
+	user.Password = "" // Remove password hash for security reasons
+	log.Info(fmt.Sprintf("Successfully restored User with id = %d.", id))
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: []*models.User{user}})
+}
+
+// PurgeUser permanently removes a deactivated user based on the supplied http request, handling the user's orders according to the order retention policy.
+// Sends a status message in JSON to the user. The orders are handled and the user is purged in a single transaction,
+// so a failed purge leaves the user and all of their orders as they were.
+func (h *User) PurgeUser(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).purgeUser
+	})
+}
+
+// purgeUser permanently removes a deactivated user based on the supplied http request, and sends a status message in JSON to the user.
+func (h *User) purgeUser(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.Info(fmt.Sprintf("Checking that User (id: %d) is deactivated before purge...", id))
+	active, err := h.repo.Exists(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error checking existence of user before purge (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if active {
+		json.WriteTypedErrorResponse(w, http.StatusConflict, userActiveErrType, purgeActiveUserErrMsg)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting orders owned by User (id: %d) for purge (retention policy: %s)...", id, h.orderRetention))
+	orders, err := h.ordersRepo.GetByOwnerID(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting orders owned by User (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	for _, order := range orders {
+		if err := h.applyOrderRetention(order); err != nil {
+			logMsg := fmt.Sprintf("Error applying order retention policy to order (id: %d): %s", order.ID, err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+	}
+
+	log.Info(fmt.Sprintf("Purging User (id: %d)...", id))
+	err = h.repo.Purge(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, inactiveUserNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error purging User (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionPurge, models.AuditEntityUser, id, nil, nil) {
+		return
+	}
+	log.Info(fmt.Sprintf("Successfully purged User with id = %d and %d of their orders (retention policy: %s).", id, len(orders), h.orderRetention))
 	json.WriteResponse(w, http.StatusOK, json.Response{})
 }
 
@@ -178,7 +471,7 @@ func (h *User) Login(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			logMsg := fmt.Sprintf("failed to find user with username: %s: %s", user.Name, err.Error())
-			json.WriteErrorResponse(w, http.StatusBadRequest, "Invalid user credentials.", logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusBadRequest, invalidCredentialsErrType, "Invalid user credentials.", logMsg)
 			return
 		}
 		log.Error(fmt.Sprintf("failed to select user '%s' for login: %s", user.Name, err.Error()))
@@ -189,7 +482,7 @@ func (h *User) Login(w http.ResponseWriter, r *http.Request) {
 	err = h.repo.CheckPassword(storedUser, user.Password)
 	if err != nil {
 		log.Error(fmt.Sprintf("Failed to authenticate user %s: password check failed: %s", user.Name, err.Error()))
-		json.WriteErrorResponse(w, http.StatusBadRequest, "Invalid user credentials.")
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, invalidCredentialsErrType, "Invalid user credentials.")
 		return
 	}
 
@@ -221,23 +514,39 @@ func (h *User) IsAuthorized(next http.HandlerFunc) http.HandlerFunc {
 		auth := r.Header.Get("Authorization")
 		if auth == "" {
 			logMsg := unauthorizedErrMsgPrefix + "No authorization header provided"
-			json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 			return
 		}
 
 		authToken, err := jwtutils.GetTokenFromAuthHeader(auth)
 		if err != nil {
 			logMsg := unauthorizedErrMsgPrefix + err.Error()
-			json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 			return
 		}
 
 		authReal := jwtutils.GetSecretAuthToken()
 
-		_, err = h.jwtRepo.ValidateToken(&authReal, authToken)
+		claims, err := h.jwtRepo.ValidateToken(&authReal, authToken)
 		if err != nil {
 			logMsg := unauthorizedErrMsgPrefix + "SecretKey and/or Issuer wrong"
-			json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
+			return
+		}
+
+		// Tokens stay valid until they expire, so make sure the user hasn't been deactivated since the token was issued.
+		active, err := h.repo.Exists(claims.UserID)
+		if err != nil {
+			logMsg := fmt.Sprintf("Error checking if user (id: %d) is active: %s", claims.UserID, err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+		if !active {
+			logMsg := fmt.Sprintf(unauthorizedErrMsgPrefix+"User (id: %d) is deactivated", claims.UserID)
+			json.WriteTypedErrorResponse(w, http.StatusUnauthorized, userDeactivatedErrType, userDeactivatedErrMsg, logMsg)
+			return
+		}
+		if claims.IsImpersonation() && !h.checkImpersonation(w, r, claims) {
 			return
 		}
 		log.Info("Authorization successful.")
@@ -257,7 +566,7 @@ func (h *User) HasRole(next http.HandlerFunc, role string) http.HandlerFunc {
 		requestor, err := jwtutils.GetTokenClaims(r, h.jwtRepo)
 		if err != nil {
 			logMsg := unauthorizedErrMsgPrefix + err.Error()
-			json.WriteErrorResponse(w, http.StatusBadRequest, unauthorizedErrMsg, logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusBadRequest, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 			return
 		}
 
@@ -270,7 +579,7 @@ func (h *User) HasRole(next http.HandlerFunc, role string) http.HandlerFunc {
 
 		if !hasRole {
 			logMsg := fmt.Sprintf(unauthorizedErrMsgPrefix+"Client's role does not meet the access level requirements: expecting '%s' got '%s'", role, requestor.UserRole)
-			json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+			json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -286,6 +595,12 @@ func (h *User) getSingleUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	readOpts, err := utils.GetReadOptions(r, repository.UserFields, userRelations, nil)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if !h.clientHasReadUserPermsForID(w, r, id) {
 		return
 	}
@@ -295,7 +610,7 @@ func (h *User) getSingleUser(w http.ResponseWriter, r *http.Request) {
 	user, err = h.repo.GetByID(id)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			json.WriteErrorResponse(w, http.StatusNotFound, userNotFoundMsg)
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, userNotFoundMsg)
 			return
 		}
 		logMsg := fmt.Sprintf("Error selecting user (id: %d): %s", id, err.Error())
@@ -305,58 +620,124 @@ func (h *User) getSingleUser(w http.ResponseWriter, r *http.Request) {
 	log.Info(fmt.Sprintf("Read user (id: %d)", id))
 	user.Password = "" // Remove password hash for security reasons
 
-	if !h.clientHasReadPermsForUser(w, r, user) {
+	if !h.clientHasReadPermsForUser(w, r, user) || notModified(w, r, user.Version) {
 		return
 	}
 
-	response := json.Response{Data: []*models.User{user}}
-	json.WriteResponse(w, http.StatusOK, response)
+	users := []*models.User{user}
+	if !h.loadUserRelations(w, users, readOpts) {
+		return
+	}
+	writeRecords(w, users, readOpts, repository.UserFields, nil)
 }
 
 // getUsersPage retrieves a single user from the user repository based on the supplied seek options via http query parameter.
 // Sends a response in json to the supplied http ResponseWriter.
 func (h *User) getUsersPage(w http.ResponseWriter, r *http.Request) {
 	var seek *repository.PageSeekOptions
-	seek, err := utils.GetPageSeekOptions(r, readUsersPageMaxRecordLimit)
+	seek, err := utils.GetPageSeekOptions(r, readUsersPageMaxRecordLimit, repository.UserFields)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	readOpts, err := utils.GetReadOptions(r, repository.UserFields, userRelations, nil)
 	if err != nil {
 		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	// The role is needed to check the client can read each user.
+	seek.Fields = readOpts.Select("role")
+	seek.Scope, err = getStatusScope(w, r, h.getClientAuthInfo, forbiddenReadInactiveUsersErrMsg)
+	if err != nil {
+		return
+	}
 
-	log.Info(fmt.Sprintf("Reading %d users (max %d)...", seek.RecordLimit, readUsersPageMaxRecordLimit))
+	log.Info(fmt.Sprintf("Reading %d %s users (max %d) matching %v...", seek.RecordLimit, seek.Scope, readUsersPageMaxRecordLimit, seek.Filters))
 	var users []*models.User
-	users, err = h.repo.Fetch(seek)
+	users, err = h.repo.Fetch(utils.GetPeekSeekOptions(seek))
 	if err != nil {
 		logMsg := fmt.Sprintf("Error reading users: %s", err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
 		return
 	}
+	start, end, more := utils.TrimPage(seek, len(users))
+	users = users[start:end]
 	for _, user := range users {
 		user.Password = "" // Remove password hash for security reasons
 	}
 
+	// Work out the cursors before pruning, so the next page starts after the last user fetched rather than the last one the client can read.
+	page := h.getUsersPageInfo(w, r, seek, users, more)
+	if page == nil {
+		return
+	}
 	users = h.getUsersReadableByClient(w, r, users)
 	if users == nil {
 		return
 	}
-
-	rangeStr := h.getUsersRangeStr(w, seek, users)
-	w.Header().Set("Content-Range", rangeStr)
+	page.Count = len(users)
+	if !h.loadUserRelations(w, users, readOpts) {
+		return
+	}
 
 	log.Info(fmt.Sprintf("Read %d users", len(users)))
-	response := json.Response{Data: users}
-	json.WriteResponse(w, http.StatusOK, response)
+	writeRecords(w, users, readOpts, repository.UserFields, page)
+}
+
+// loadUserRelations embeds the related records included in the supplied read options in the supplied users,
+// with one query per relation however many users there are.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *User) loadUserRelations(w http.ResponseWriter, users []*models.User, opts *utils.ReadOptions) bool {
+	if len(users) == 0 || !opts.Includes("orders") {
+		return true
+	}
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	log.Info(fmt.Sprintf("Selecting orders for %d users...", len(users)))
+	ordersByOwner, err := h.ordersRepo.GetByOwnerIDs(ids)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting orders for users: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	var orderIDs []uint
+	for _, user := range users {
+		user.Orders = ordersByOwner[user.ID]
+		for _, order := range user.Orders {
+			orderIDs = append(orderIDs, order.ID)
+		}
+	}
+
+	if !opts.Includes("orders.items") || len(orderIDs) == 0 {
+		return true
+	}
+	log.Info(fmt.Sprintf("Selecting items for %d orders...", len(orderIDs)))
+	itemsByOrder, err := h.itemsRepo.GetByOrderIDs(orderIDs)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting items for orders: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	for _, user := range users {
+		for _, order := range user.Orders {
+			order.Items = itemsByOrder[order.ID]
+		}
+	}
+	return true
 }
 
 // partiallyUpdateUser updates only the specified fields (via http query parameter) of the supplied user
 // and sends a response to the supplied http response writer containing the updated user in JSON.
-func (h *User) partiallyUpdateUser(w http.ResponseWriter, r *http.Request, user models.User) {
+// The user as it was before the update is recorded in the audit log.
+func (h *User) partiallyUpdateUser(w http.ResponseWriter, r *http.Request, user models.User, existing *models.User) {
 	fieldsStr := r.URL.Query().Get(fieldsParam)
 	fields := strings.Split(fieldsStr, ",")
 
 	err := user.ValidatePartialUserUpdate(fields)
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "User "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "User "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -374,20 +755,25 @@ func (h *User) partiallyUpdateUser(w http.ResponseWriter, r *http.Request, user
 	updated, err := h.repo.Update(&user, fields)
 	if err != nil {
 		logMsg := fmt.Sprintf("Error partially updating User (id: %d)  fields (%s) : %s", user.ID, fieldsStr, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		writeUpdateErrorResponse(w, err, logMsg)
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionUpdate, models.AuditEntityUser, user.ID, existing, updated) {
 		return
 	}
 	log.Info(fmt.Sprintf("Partially updated User (id: %d) fields (%s): %+v", user.ID, fieldsStr, updated))
+	setETag(w, user.Version)
 	response := json.Response{Data: []*models.User{&user}}
 	json.WriteResponse(w, http.StatusOK, response)
 }
 
 // fullyUpdateUser updates all of the fields of the supplied user
 // and sends a response to the supplied http response writer containing the updated user in JSON.
-func (h *User) fullyUpdateUser(w http.ResponseWriter, r *http.Request, user models.User) {
+// The user as it was before the update is recorded in the audit log.
+func (h *User) fullyUpdateUser(w http.ResponseWriter, r *http.Request, user models.User, existing *models.User) {
 	err := user.IsValid()
 	if err != nil {
-		json.WriteErrorResponse(w, http.StatusBadRequest, "User "+validationFailedErrMsgPrefix+err.Error())
+		writeValidationErrorResponse(w, http.StatusBadRequest, validationFailedErrType, "User "+validationFailedErrMsgPrefix, err)
 		return
 	}
 
@@ -402,10 +788,14 @@ func (h *User) fullyUpdateUser(w http.ResponseWriter, r *http.Request, user mode
 	updated, err := h.repo.Update(&user, []string{})
 	if err != nil {
 		logMsg := fmt.Sprintf("Error fully updating User with id = %d: %+v: %s", user.ID, user, err.Error())
-		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		writeUpdateErrorResponse(w, err, logMsg)
+		return
+	}
+	if !h.recordAudit(w, r, models.AuditActionUpdate, models.AuditEntityUser, user.ID, existing, updated) {
 		return
 	}
 	log.Info(fmt.Sprintf("Fully updated User (id: %d): %+v", user.ID, updated))
+	setETag(w, user.Version)
 	response := json.Response{Data: []*models.User{&user}}
 	json.WriteResponse(w, http.StatusOK, response)
 }
@@ -416,18 +806,28 @@ func (h *User) getClientAuthInfo(w http.ResponseWriter, r *http.Request) *models
 	client, err := jwtutils.GetTokenClaims(r, h.jwtRepo)
 	if err != nil {
 		logMsg := unauthorizedErrMsgPrefix + err.Error()
-		json.WriteErrorResponse(w, http.StatusBadRequest, unauthorizedErrMsg, logMsg)
+		json.WriteTypedErrorResponse(w, http.StatusBadRequest, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 		return nil
 	}
 	err = roles.IsValid(client.UserRole)
 	if err != nil {
 		logMsg := unauthorizedErrMsgPrefix + err.Error()
-		json.WriteErrorResponse(w, http.StatusUnauthorized, unauthorizedErrMsg, logMsg)
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
 		return nil
 	}
 	return client
 }
 
+// recordAudit records the supplied action, performed by the client, in the audit log along with the state of the user before and after it.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *User) recordAudit(w http.ResponseWriter, r *http.Request, action string, entityType string, id uint, before interface{}, after interface{}) bool {
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return false
+	}
+	return appendAuditEntry(w, r, h.auditRepo, client, action, entityType, id, before, after)
+}
+
 // clientHasCreatePermsForUser checks whether the client has permissions to create the supplied user, based on the supplied http request.
 // Writes a response on the supplied http response writer if there is an error.
 func (h *User) clientHasCreateUserPermsForUser(w http.ResponseWriter, r *http.Request, user models.User) bool {
@@ -437,7 +837,7 @@ func (h *User) clientHasCreateUserPermsForUser(w http.ResponseWriter, r *http.Re
 	}
 	// Only admins can create employee or admin users
 	if user.Role != roles.Customer && client.UserRole != roles.Admin {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenCreateUserErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenCreateUserErrMsg)
 		return false
 	}
 	return true
@@ -453,13 +853,13 @@ func (h *User) clientHasReadPerms(w http.ResponseWriter, r *http.Request, id uin
 	if user == nil {
 		// Customers can only read their own user account
 		if client.UserRole == roles.Customer && client.UserID != id {
-			json.WriteErrorResponse(w, http.StatusForbidden, forbiddenReadUserErrMsg)
+			json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenReadUserErrMsg)
 			return false
 		}
 	} else {
 		// Employees can read any customer user account and their own user account
 		if client.UserRole == roles.Employee && (user.Role != roles.Customer && client.UserID != id) {
-			json.WriteErrorResponse(w, http.StatusForbidden, forbiddenReadUserErrMsg)
+			json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenReadUserErrMsg)
 			return false
 		}
 	}
@@ -484,11 +884,12 @@ func (h *User) getUsersReadableByClient(w http.ResponseWriter, r *http.Request,
 	if client == nil {
 		return nil
 	}
-	var pruned []*models.User
+	// Never nil, so an empty page isn't mistaken for an error.
+	pruned := make([]*models.User, 0, len(users))
 	switch client.UserRole {
 	case roles.Admin:
 		// Admin can read all users
-		pruned = users
+		pruned = append(pruned, users...)
 	case roles.Employee:
 		// Employees can only read: other customer users, and their user
 		for _, user := range users {
@@ -516,12 +917,12 @@ func (h *User) clientHasUpdatePermsForUser(w http.ResponseWriter, r *http.Reques
 	}
 	// Customers can only update their own user account
 	if client.UserRole == roles.Customer && user.ID != client.UserID {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenUpdateUserErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenUpdateUserErrMsg)
 		return false
 	}
 	// Employees can only update customer accounts and their own user account
 	if client.UserRole == roles.Employee && (user.ID != client.UserID && user.Role != roles.Customer) {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenUpdateUserErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenUpdateUserErrMsg)
 		return false
 	}
 	return true
@@ -536,12 +937,12 @@ func (h *User) clientHasDeletePermsForID(w http.ResponseWriter, r *http.Request,
 	}
 	// Prevent users from deleting themselves.
 	if client.UserID == id {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenDeleteUserErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenDeleteUserErrMsg)
 		return false
 	}
 	// Customers can only update their own user account
 	if client.UserRole == roles.Customer && id != client.UserID {
-		json.WriteErrorResponse(w, http.StatusForbidden, forbiddenDeleteUserErrMsg)
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenDeleteUserErrMsg)
 		return false
 	}
 	// Employees can only update customer accounts and their own user account
@@ -551,7 +952,7 @@ func (h *User) clientHasDeletePermsForID(w http.ResponseWriter, r *http.Request,
 		if err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
 				msg := "Could not delete user: " + userNotFoundMsg
-				json.WriteErrorResponse(w, http.StatusNotFound, msg)
+				json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, msg)
 				return false
 			}
 			logMsg := fmt.Sprintf("Error reading user (id: %d): %s", id, err.Error())
@@ -559,27 +960,48 @@ func (h *User) clientHasDeletePermsForID(w http.ResponseWriter, r *http.Request,
 			return false
 		}
 		if user.Role != roles.Customer {
-			json.WriteErrorResponse(w, http.StatusForbidden, forbiddenDeleteUserErrMsg)
+			json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenDeleteUserErrMsg)
 			return false
 		}
 	}
 	return true
 }
 
-// getUsersRangeStr returns a string representation of the range of the supplied products.
-func (h *User) getUsersRangeStr(w http.ResponseWriter, seek *repository.PageSeekOptions, users []*models.User) string {
+// getUsersPageInfo returns the position of the supplied page of users, fetched with the supplied seek options, out of all the users matching the seek options' scope and filters.
+// Sets the Content-Range and Link headers of the response to match. more is whether there are users beyond the page in the direction of the seek.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *User) getUsersPageInfo(w http.ResponseWriter, r *http.Request, seek *repository.PageSeekOptions, users []*models.User, more bool) *json.Page {
 	log.Info("Counting users for users page read...")
-	count, err := h.repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone})
+	count, err := h.repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Scope: seek.Scope, Filters: seek.Filters})
 	if err != nil {
 		logMsg := fmt.Sprintf("Error counting users: %s", err.Error())
 		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
-		return ""
+		return nil
 	}
+	var first, last interface{}
 	startID, endID := uint(0), uint(0)
 	if len(users) > 0 {
+		first, last = users[0], users[len(users)-1]
 		startID = users[0].ID
 		endID = users[len(users)-1].ID
 	}
-	rangeStr := fmt.Sprintf("users=%d-%d/%d", startID, endID, count)
-	return rangeStr
+	page, err := utils.GetPage(seek, first, last, len(users), more, count, repository.UserFields)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error building cursors for users page: %s", err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return nil
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("users=%d-%d/%d", startID, endID, count))
+	utils.SetPageLinkHeader(w, r, page)
+	return page
+}
+
+// applyOrderRetention deletes the supplied order of a user being purged, along with its items, if the order retention policy is to delete them.
+// Otherwise the order is left alone, and the database detaches it from the user when the user is purged.
+func (h *User) applyOrderRetention(order *models.Order) error {
+	if h.orderRetention != DeleteOrders {
+		return nil
+	}
+	log.Info(fmt.Sprintf("Deleting order (id: %d) of purged user", order.ID))
+	return h.ordersRepo.Delete(order.ID)
 }
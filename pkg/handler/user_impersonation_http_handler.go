@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	jwtutils "github.com/tragicpixel/fruitbar/pkg/utils/jwt"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+
+	"gorm.io/gorm"
+)
+
+// How long an impersonation token stays valid. Kept short, as support sessions are short and the token acts with someone else's identity.
+const impersonationTokenTTL = 15 * time.Minute
+
+// Impersonate sends a response containing a short-lived JSON Web Token for the user with the supplied id (via http query parameter),
+// which identifies the admin making the request as the real actor. Admins can't be impersonated, and impersonation tokens can't be chained.
+func (h *User) Impersonate(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return
+	}
+	if client.IsImpersonation() || client.UserID == id {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenImpersonateUserErrMsg)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting user (id: %d) to impersonate...", id))
+	user, err := h.repo.GetByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, userNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting user (id: %d) to impersonate: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	if user.Role == roles.Admin {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenImpersonateUserErrMsg)
+		return
+	}
+
+	if !h.recordAudit(w, r, models.AuditActionImpersonate, models.AuditEntityUser, id, nil, nil) {
+		return
+	}
+	jwt := jwtutils.GetSecretAuthToken()
+	actor := models.JwtActor{UserID: client.UserID, UserName: client.UserName}
+	signedToken, err := h.jwtRepo.GenerateImpersonationToken(&jwt, user, &actor, impersonationTokenTTL)
+	if err != nil {
+		logMsg := "Failed to generate impersonation token: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	log.Info(fmt.Sprintf("Admin '%s' (id: %d) is impersonating user '%s' (id: %d) for %s", client.UserName, client.UserID, user.Name, user.ID, impersonationTokenTTL))
+	json.WriteResponse(w, http.StatusOK, json.Response{Token: signedToken})
+}
+
+// checkImpersonation makes sure the admin behind the supplied impersonation token is still an active admin,
+// then logs and audits the request under both identities.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *User) checkImpersonation(w http.ResponseWriter, r *http.Request, claims *models.JwtClaim) bool {
+	actor, err := h.repo.GetByID(claims.Actor.UserID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logMsg := fmt.Sprintf("Error selecting impersonating admin (id: %d): %s", claims.Actor.UserID, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return false
+	}
+	if actor == nil || actor.Role != roles.Admin {
+		logMsg := fmt.Sprintf(unauthorizedErrMsgPrefix+"Impersonating user (id: %d) is no longer an active admin", claims.Actor.UserID)
+		json.WriteTypedErrorResponse(w, http.StatusUnauthorized, unauthorizedErrType, unauthorizedErrMsg, logMsg)
+		return false
+	}
+
+	log.Info(fmt.Sprintf("Request %s %s by user '%s' (id: %d) impersonated by admin '%s' (id: %d)", r.Method, r.URL.RequestURI(), claims.UserName, claims.UserID, claims.Actor.UserName, claims.Actor.UserID))
+	request := map[string]string{"method": r.Method, "uri": r.URL.RequestURI()}
+	return appendAuditEntry(w, r, h.auditRepo, claims, models.AuditActionRequest, models.AuditEntityRequest, 0, nil, request)
+}
+
+// clientCanChangeCredentials checks that the client isn't impersonating a user, if the supplied update would change the user's password or role.
+// Writes a response on the supplied http response writer if there is an error.
+func (h *User) clientCanChangeCredentials(w http.ResponseWriter, r *http.Request, fields []string) bool {
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return false
+	}
+	if !client.IsImpersonation() {
+		return true
+	}
+	// A full update (no fields) always sets the password and role.
+	if len(fields) == 0 {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenImpersonationCredentialsErrMsg)
+		return false
+	}
+	for _, field := range fields {
+		// gorm also accepts struct field names and "*", so compare loosely.
+		if strings.EqualFold(field, "password") || strings.EqualFold(field, "role") || field == "*" {
+			json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenImpersonationCredentialsErrMsg)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+
+	"gorm.io/gorm"
+)
+
+// Database columns holding an order's credit card details, which are scrubbed when a user's data is erased.
+var cardInfoColumns = []string{"number", "cardholder_name", "expiration_date", "zipcode", "cvv"}
+
+// ExportUserData sends a response containing all of the personal data held about the user with the supplied id (via http query parameter):
+// their account, their orders and items, and the payment metadata of their orders. Deactivated users can be exported too.
+// Every export is recorded in the audit log.
+func (h *User) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !h.clientHasReadUserPermsForID(w, r, id) {
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting user (id: %d) for data export...", id))
+	user, err := h.repo.GetByIDUnscoped(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, userNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting user (id: %d) for data export: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	user.Password = "" // Remove password hash for security reasons
+
+	if !h.clientHasReadPermsForUser(w, r, user) {
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting orders of user (id: %d) for data export...", id))
+	orders, err := h.ordersRepo.GetByOwnerID(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting orders of user (id: %d) for data export: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	orderIDs := make([]uint, 0, len(orders))
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.ID)
+	}
+	items, err := h.itemsRepo.GetByOrderIDs(orderIDs)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error retrieving items for orders of user (id: %d) for data export: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	export := models.UserDataExport{ExportedAt: time.Now(), User: user, Orders: orders, Payments: []models.PaymentMetadata{}}
+	for _, order := range orders {
+		order.Items = items[order.ID]
+		export.Payments = append(export.Payments, models.NewPaymentMetadata(order))
+		order.PaymentInfo.CardInfo = models.CreditCardInfo{} // Never send full card details back out
+	}
+
+	// Record the export before sending anything, so there is never an export missing from the audit log.
+	if !h.recordAudit(w, r, models.AuditActionExport, models.AuditEntityUser, id, nil, nil) {
+		return
+	}
+	log.Info(fmt.Sprintf("Exported data of user (id: %d): %d orders", id, len(orders)))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"fruitbar-user-%d.json\"", id))
+	json.WriteResponse(w, http.StatusOK, json.Response{Data: export})
+}
+
+// EraseUserData anonymizes the user with the supplied id (via http query parameter) and scrubs the card details from their orders.
+// Order totals are kept for accounting. The user is deactivated afterwards, and deactivated users can be erased too. Every erasure is recorded in the audit log.
+// The erasure runs in a single transaction, so a failed erasure never leaves the user's data partly erased.
+func (h *User) EraseUserData(w http.ResponseWriter, r *http.Request) {
+	runInTransaction(w, r, h.repos, func(tx *repository.Repositories) http.HandlerFunc {
+		return h.withRepos(tx).eraseUserData
+	})
+}
+
+// eraseUserData anonymizes the user with the supplied id (via http query parameter) and scrubs the card details from their orders,
+// and sends a status message in JSON to the client.
+func (h *User) eraseUserData(w http.ResponseWriter, r *http.Request) {
+	id, err := httputils.GetQueryParamAsUint(r, idParam)
+	if err != nil {
+		json.WriteErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	client := h.getClientAuthInfo(w, r)
+	if client == nil {
+		return
+	}
+	if client.UserID == id {
+		json.WriteTypedErrorResponse(w, http.StatusForbidden, forbiddenErrType, forbiddenEraseUserErrMsg)
+		return
+	}
+
+	log.Info(fmt.Sprintf("Selecting user (id: %d) for erasure...", id))
+	user, err := h.repo.GetByIDUnscoped(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			json.WriteTypedErrorResponse(w, http.StatusNotFound, userNotFoundErrType, userNotFoundMsg)
+			return
+		}
+		logMsg := fmt.Sprintf("Error selecting user (id: %d) for erasure: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+
+	orders, err := h.ordersRepo.GetByOwnerID(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error selecting orders of user (id: %d) for erasure: %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	for _, order := range orders {
+		log.Info(fmt.Sprintf("Scrubbing card details from order (id: %d)...", order.ID))
+		order.PaymentInfo.CardInfo = models.CreditCardInfo{}
+		_, err := h.ordersRepo.Update(order, cardInfoColumns)
+		if err != nil {
+			logMsg := fmt.Sprintf("Error scrubbing card details from order (id: %d): %s", order.ID, err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+	}
+
+	log.Info(fmt.Sprintf("Removing external identity links of user (id: %d)...", id))
+	err = h.identityRepo.DeleteByUserID(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error removing external identity links of user (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+
+	if user.DeletedAt.Valid {
+		// Only active users can be updated, so a deactivated user is reactivated for the erasure, and deactivated again along with the rest.
+		err = h.repo.Restore(id)
+		if err != nil {
+			logMsg := fmt.Sprintf("Error reactivating user (id: %d) for erasure: %s", id, err.Error())
+			json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+			return
+		}
+		user.DeletedAt = gorm.DeletedAt{}
+	}
+	log.Info(fmt.Sprintf("Anonymizing user (id: %d)...", id))
+	password, err := generateUnusablePassword()
+	if err != nil {
+		logMsg := "Failed to generate password: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	user.Name = fmt.Sprintf("erased-user-%d", id)
+	err = h.repo.HashPassword(user, password)
+	if err != nil {
+		logMsg := "Failed to hash password: " + err.Error()
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	_, err = h.repo.Update(user, []string{"name", "password"})
+	if err != nil {
+		logMsg := fmt.Sprintf("Error anonymizing user (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+	err = h.repo.Delete(id)
+	if err != nil {
+		logMsg := fmt.Sprintf("Error deactivating erased user (id: %d): %s", id, err.Error())
+		json.WriteErrorResponse(w, http.StatusInternalServerError, internalServerErrMsg, logMsg)
+		return
+	}
+
+	// The user's data isn't recorded in the entry, as that would keep a copy of what was just erased.
+	if !h.recordAudit(w, r, models.AuditActionErase, models.AuditEntityUser, id, nil, nil) {
+		return
+	}
+	log.Info(fmt.Sprintf("Erased data of user (id: %d): scrubbed %d orders", id, len(orders)))
+	json.WriteResponse(w, http.StatusOK, json.Response{})
+}
+
+// generateUnusablePassword returns a random password nobody knows, for accounts that must not be logged in to with a password.
+func generateUnusablePassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,269 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// swagger:model auditEntry
+// AuditEntry records a privileged action performed on the fruitbar application's data.
+// Entries are chained together: each one includes the hash of the entry before it, so changing or removing an entry breaks the chain.
+type AuditEntry struct {
+	ID uint `json:"id" gorm:"primarykey"`
+	// Time the action was performed.
+	CreatedAt time.Time `json:"createdat"`
+	// ID of the http request the action was performed in.
+	RequestID string `json:"requestid" gorm:"index"`
+	// ID of the user who performed the action.
+	ActorID uint `json:"actorid" gorm:"index"`
+	// Name of the user who performed the action.
+	ActorName string `json:"actorname"`
+	// ID of the admin who performed the action while impersonating the actor. (zero if the actor performed it themselves)
+	ImpersonatorID uint `json:"impersonatorid,omitempty" gorm:"index"`
+	// Name of the admin who performed the action while impersonating the actor.
+	ImpersonatorName string `json:"impersonatorname,omitempty"`
+	// Action that was performed.
+	Action string `json:"action"`
+	// Type of the entity the action was performed on.
+	EntityType string `json:"entitytype" gorm:"index:idx_audit_entry_entity"`
+	// ID of the entity the action was performed on.
+	EntityID uint `json:"entityid" gorm:"index:idx_audit_entry_entity"`
+	// The entity before the action was performed. (empty if it didn't exist)
+	Before AuditData `json:"before,omitempty" gorm:"type:json"`
+	// The entity after the action was performed. (empty if it no longer exists)
+	After AuditData `json:"after,omitempty" gorm:"type:json"`
+	// The fields that differ between before and after, by field name.
+	Changes AuditData `json:"changes,omitempty" gorm:"type:json"`
+	// Hash of the previous entry in the audit log. (empty for the first entry)
+	PrevHash string `json:"prevhash"`
+	// Hash of this entry, including the hash of the previous entry.
+	Hash string `json:"hash" gorm:"uniqueIndex"`
+}
+
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionRestore = "restore"
+	AuditActionPurge   = "purge"
+	AuditActionExport  = "export"
+	AuditActionErase   = "erase"
+	// An admin started impersonating the user.
+	AuditActionImpersonate = "impersonate"
+	// A request was made with an impersonation token.
+	AuditActionRequest = "request"
+
+	AuditEntityUser    = "user"
+	AuditEntityOrder   = "order"
+	AuditEntityProduct = "product"
+	AuditEntityRequest = "request"
+)
+
+// Placeholder recorded instead of the value of a sensitive field.
+const auditRedacted = "[redacted]"
+
+// Fields whose values are never recorded in the audit log, wherever they appear in an entity: passwords, and every card detail.
+var auditRedactedFields = map[string]bool{
+	"password":       true,
+	"number":         true,
+	"cardholdername": true,
+	"expirationdate": true,
+	"zipcode":        true,
+	"cvv":            true,
+}
+
+// Fields left out when working out what changed, because they change on every update.
+var auditIgnoredFields = map[string]bool{
+	"UpdatedAt": true,
+}
+
+// AuditData holds a JSON document recorded in an audit entry. It is stored as-is so the entry's hash can be recomputed.
+type AuditData []byte
+
+func (d AuditData) MarshalJSON() ([]byte, error) {
+	if len(d) == 0 {
+		return []byte("null"), nil
+	}
+	return d, nil
+}
+
+func (d *AuditData) UnmarshalJSON(b []byte) error {
+	*d = append((*d)[0:0], b...)
+	return nil
+}
+
+func (d AuditData) Value() (driver.Value, error) {
+	if len(d) == 0 {
+		return nil, nil
+	}
+	return string(d), nil
+}
+
+func (d *AuditData) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*d = nil
+	case []byte:
+		*d = append(AuditData{}, v...)
+	case string:
+		*d = AuditData(v)
+	default:
+		return fmt.Errorf("unsupported type for audit data: %T", value)
+	}
+	return nil
+}
+
+// AuditChange holds the value of a single field before and after an action.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// SetChange records the supplied entity states before and after the action, and the fields that differ between them.
+// Either state can be nil, for an entity that was created or removed. Sensitive fields are redacted.
+func (e *AuditEntry) SetChange(before interface{}, after interface{}) error {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return err
+	}
+
+	// Work out what changed before redacting, so a changed password still shows up as a change.
+	var changes map[string]AuditChange
+	if beforeFields != nil && afterFields != nil {
+		changes = make(map[string]AuditChange)
+		for name := range beforeFields {
+			if !auditIgnoredFields[name] && !reflect.DeepEqual(beforeFields[name], afterFields[name]) {
+				changes[name] = AuditChange{Before: redact(name, beforeFields[name]), After: redact(name, afterFields[name])}
+			}
+		}
+		for name := range afterFields {
+			if _, ok := beforeFields[name]; !ok && !auditIgnoredFields[name] {
+				changes[name] = AuditChange{Before: nil, After: redact(name, afterFields[name])}
+			}
+		}
+	}
+
+	if e.Before, err = marshalAuditData(redactAll(beforeFields)); err != nil {
+		return err
+	}
+	if e.After, err = marshalAuditData(redactAll(afterFields)); err != nil {
+		return err
+	}
+	if changes == nil {
+		e.Changes = nil
+		return nil
+	}
+	e.Changes, err = json.Marshal(changes)
+	return err
+}
+
+// ComputeHash returns the hash of the entry's contents, including the hash of the previous entry. The entry's ID is not included.
+func (e *AuditEntry) ComputeHash() string {
+	h := sha256.New()
+	fields := []string{
+		e.PrevHash,
+		strconv.FormatInt(e.CreatedAt.UTC().UnixNano(), 10),
+		e.RequestID,
+		strconv.FormatUint(uint64(e.ActorID), 10),
+		e.ActorName,
+		e.Action,
+		e.EntityType,
+		strconv.FormatUint(uint64(e.EntityID), 10),
+		string(e.Before),
+		string(e.After),
+		string(e.Changes),
+	}
+	// Only hash the impersonator when there is one, so entries appended before impersonation existed keep their hashes.
+	if e.ImpersonatorID != 0 {
+		fields = append(fields, strconv.FormatUint(uint64(e.ImpersonatorID), 10), e.ImpersonatorName)
+	}
+	for _, field := range fields {
+		// Prefix every field with its length so values can't be shifted between fields without changing the hash.
+		fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifyAuditChain checks that the supplied entries, in the order they were appended, form an unbroken hash chain
+// starting from the entry with the supplied hash. (empty for the start of the audit log)
+func VerifyAuditChain(prevHash string, entries []*AuditEntry) error {
+	for _, e := range entries {
+		if e.PrevHash != prevHash {
+			return fmt.Errorf("audit entry (id: %d) does not follow the previous entry: expected previous hash %q got %q", e.ID, prevHash, e.PrevHash)
+		}
+		if hash := e.ComputeHash(); hash != e.Hash {
+			return fmt.Errorf("audit entry (id: %d) has been modified: expected hash %q got %q", e.ID, hash, e.Hash)
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
+
+// auditFields returns the supplied entity as a map of its JSON fields. Returns nil for a nil entity.
+func auditFields(entity interface{}) (map[string]interface{}, error) {
+	if entity == nil || (reflect.ValueOf(entity).Kind() == reflect.Ptr && reflect.ValueOf(entity).IsNil()) {
+		return nil, nil
+	}
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return nil, errors.New("failed to encode entity for the audit log: " + err.Error())
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, errors.New("entity for the audit log is not a JSON object: " + err.Error())
+	}
+	return fields, nil
+}
+
+// redact returns the value to record for the field with the supplied name, replacing sensitive values and recursing into nested objects.
+func redact(name string, value interface{}) interface{} {
+	if auditRedactedFields[name] {
+		if value == nil || value == "" {
+			return value
+		}
+		return auditRedacted
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redactAll(v)
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, elem := range v {
+			redacted[i] = redact("", elem)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+// redactAll returns a copy of the supplied fields with sensitive values replaced.
+func redactAll(fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		redacted[name] = redact(name, value)
+	}
+	return redacted
+}
+
+// marshalAuditData encodes the supplied fields for an audit entry. Returns empty data for nil fields.
+func marshalAuditData(fields map[string]interface{}) (AuditData, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Operations a batch can run on a record.
+const (
+	BatchOpCreate = "create"
+	BatchOpUpdate = "update"
+	BatchOpPatch  = "patch"
+	BatchOpDelete = "delete"
+)
+
+// Maximum number of operations in a single batch.
+const BatchMaxOperations = 500
+
+// swagger:model batch
+// Batch holds a list of operations to run on records of one type in a single request.
+type Batch struct {
+	// Whether the operations are all-or-nothing: if any one fails, none of them are applied. Otherwise each operation is applied on its own.
+	Atomic bool `json:"atomic"`
+	// Operations to run, in order.
+	Operations []BatchOperation `json:"operations"`
+}
+
+// swagger:model batchOperation
+// BatchOperation holds a single operation in a batch.
+type BatchOperation struct {
+	// Operation to run: create, update, patch or delete.
+	Op string `json:"op"`
+	// ID of the record to update, patch or delete.
+	ID uint `json:"id"`
+	// Version of the record the client last read, to update, patch or delete. (see the ETag header)
+	Version uint `json:"version"`
+	// Record to create or update, or the patch to apply: a JSON Merge Patch object, or a JSON Patch array.
+	Data json.RawMessage `json:"data"`
+}
+
+// ValidateBatch validates the supplied batch.
+// Returns an error describing the first invalid operation, or nil if the batch is valid.
+func ValidateBatch(b *Batch) error {
+	if len(b.Operations) == 0 {
+		return errors.New("batch must contain at least one operation")
+	}
+	if len(b.Operations) > BatchMaxOperations {
+		return fmt.Errorf("batch must not contain more than %d operations", BatchMaxOperations)
+	}
+	for i, op := range b.Operations {
+		if err := validateBatchOperation(&op); err != nil {
+			return fmt.Errorf("operation %d: %s", i, err.Error())
+		}
+	}
+	return nil
+}
+
+// validateBatchOperation validates the supplied batch operation.
+// Returns an error if the operation is invalid, nil otherwise.
+func validateBatchOperation(op *BatchOperation) error {
+	data := bytes.TrimSpace(op.Data)
+	switch op.Op {
+	case BatchOpCreate:
+		if op.ID != 0 {
+			return errors.New("id must not be set when creating a record")
+		}
+	case BatchOpUpdate, BatchOpPatch, BatchOpDelete:
+		if op.ID == 0 {
+			return fmt.Errorf("id is required to %s a record", op.Op)
+		}
+	default:
+		return fmt.Errorf("unknown op '%s', must be one of %s, %s, %s or %s", op.Op, BatchOpCreate, BatchOpUpdate, BatchOpPatch, BatchOpDelete)
+	}
+	switch op.Op {
+	case BatchOpDelete:
+		if len(data) != 0 && !bytes.Equal(data, []byte("null")) {
+			return errors.New("data must not be set when deleting a record")
+		}
+	case BatchOpPatch:
+		if len(data) == 0 || (data[0] != '{' && data[0] != '[') {
+			return errors.New("data must be a JSON Merge Patch object or a JSON Patch array")
+		}
+	default:
+		if len(data) == 0 || data[0] != '{' {
+			return fmt.Errorf("data must be the record to %s", op.Op)
+		}
+	}
+	return nil
+}
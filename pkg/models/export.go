@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// UserDataExport holds all of the personal data the fruitbar application holds about a single user.
+type UserDataExport struct {
+	// Time the export was created.
+	ExportedAt time.Time `json:"exportedat"`
+	// The user's account. (password hash removed)
+	User *User `json:"user"`
+	// All of the user's orders and their items. (card details removed, see payments)
+	Orders []*Order `json:"orders"`
+	// Payment metadata for each of the user's orders.
+	Payments []PaymentMetadata `json:"payments"`
+}
+
+// PaymentMetadata holds the non-secret details of how an order was paid for.
+type PaymentMetadata struct {
+	// ID of the order that was paid for.
+	OrderID uint `json:"orderid"`
+	// Whether the order was paid in cash.
+	Cash bool `json:"cash"`
+	// Name on the card.
+	CardholderName string `json:"cardholdername"`
+	// Last four digits of the card number.
+	CardNumberLast4 string `json:"cardnumberlast4"`
+	// Expiration date of the card. (mm/yy format)
+	ExpirationDate string `json:"expirationdate"`
+	// Zipcode on the card.
+	Zipcode string `json:"zipcode"`
+}
+
+// NewPaymentMetadata returns the payment metadata for the supplied order.
+func NewPaymentMetadata(o *Order) PaymentMetadata {
+	digits := make([]rune, 0, len(o.PaymentInfo.CardInfo.Number))
+	for _, r := range o.PaymentInfo.CardInfo.Number {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	last4 := ""
+	if len(digits) >= 4 {
+		last4 = string(digits[len(digits)-4:])
+	}
+	return PaymentMetadata{
+		OrderID:         o.ID,
+		Cash:            o.PaymentInfo.Cash,
+		CardholderName:  o.PaymentInfo.CardInfo.CardholderName,
+		CardNumberLast4: last4,
+		ExpirationDate:  o.PaymentInfo.CardInfo.ExpirationDate,
+		Zipcode:         o.PaymentInfo.CardInfo.Zipcode,
+	}
+}
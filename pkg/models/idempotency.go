@@ -0,0 +1,39 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// IdempotencyRecord holds a request made with an Idempotency-Key header, and once it has been handled, the response to it,
+// so a retry of the request gets the same response instead of being handled again.
+type IdempotencyRecord struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	// Key supplied by the client. Keys are scoped to the user who sent them.
+	Key string `gorm:"column:idempotency_key;not null;uniqueIndex:idx_idempotency_key_user"`
+	// ID of the user who made the request.
+	UserID uint `gorm:"not null;uniqueIndex:idx_idempotency_key_user"`
+	// Fingerprint of the request's method, URI and body, to tell a retry from a different request reusing the key.
+	RequestHash string `gorm:"not null"`
+	// Whether the response has been stored. Until then, the request is still being handled.
+	Completed bool `gorm:"not null;default:false"`
+	// HTTP status code of the response.
+	Status int
+	// Headers of the response, encoded as JSON.
+	Header string
+	// Body of the response.
+	Body []byte
+	// Time after which the key can be used for a new request: the end of the short lease of a request being handled,
+	// and once it has been handled, the end of the time its response is replayed for.
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// IdempotencyRequestHash returns the fingerprint of a request with the supplied method, URI and body.
+func IdempotencyRequestHash(method string, uri string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + uri + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
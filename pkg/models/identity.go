@@ -0,0 +1,14 @@
+package models
+
+import "gorm.io/gorm"
+
+// ExternalIdentity links a subject at an external identity provider to a fruitbar user account.
+type ExternalIdentity struct {
+	gorm.Model
+	// Issuer URL of the identity provider that authenticated the subject.
+	Issuer string `json:"issuer" gorm:"uniqueIndex:idx_external_identity_subject"`
+	// Subject identifier assigned to the user by the identity provider.
+	Subject string `json:"subject" gorm:"uniqueIndex:idx_external_identity_subject"`
+	// ID of the fruitbar user the subject is linked to.
+	UserID uint `json:"userid"`
+}
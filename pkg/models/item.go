@@ -9,9 +9,39 @@ import (
 // swagger:model item
 type Item struct {
 	gorm.Model
-	OrderID   uint `json:"orderid"`
-	ProductID uint `json:"productid"`
+	// An order has at most one item of each product.
+	OrderID   uint `json:"orderid" gorm:"not null;uniqueIndex:idx_items_order_product"`
+	ProductID uint `json:"productid" gorm:"not null;uniqueIndex:idx_items_order_product"`
 	Quantity  int  `json:"quantity"`
+	// Unit price, name and symbol of the product as it was when the item was ordered. The item is charged at this price, even if the product's price has changed since.
+	UnitPrice     float64 `json:"unitprice"`
+	ProductName   string  `json:"productname"`
+	ProductSymbol string  `json:"productsymbol"`
+	// Total of the item. (its quantity times its unit price)
+	Total float64 `json:"total"`
+	// The product ordered, which can't be deleted while it has items. (only set when requested with include=items.product)
+	Product *Product `json:"product,omitempty" gorm:"constraint:OnDelete:RESTRICT"`
+}
+
+// SnapshotProduct sets the unit price, product name and symbol of the item to those of the supplied product, as it is now, and recalculates the item's total.
+func (i *Item) SnapshotProduct(p *Product) {
+	i.UnitPrice = p.Price
+	i.ProductName = p.Name
+	i.ProductSymbol = p.Symbol
+	i.CalculateTotal()
+}
+
+// CopySnapshot sets the unit price, product name and symbol of the item to those of the supplied item, and recalculates the item's total.
+func (i *Item) CopySnapshot(from *Item) {
+	i.UnitPrice = from.UnitPrice
+	i.ProductName = from.ProductName
+	i.ProductSymbol = from.ProductSymbol
+	i.CalculateTotal()
+}
+
+// CalculateTotal sets the total of the item to its quantity times its unit price.
+func (i *Item) CalculateTotal() {
+	i.Total = float64(i.Quantity) * i.UnitPrice
 }
 
 func (i *Item) ValidateOrderID() error {
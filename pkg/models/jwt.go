@@ -15,4 +15,17 @@ type JwtClaim struct {
 	UserID   uint
 	UserName string
 	UserRole string
+	// The admin really making requests with this token, when it was issued to impersonate the user. (nil otherwise)
+	Actor *JwtActor `json:"act,omitempty"`
+}
+
+// JwtActor identifies the admin acting as another user through an impersonation token. (the RFC 8693 actor claim)
+type JwtActor struct {
+	UserID   uint
+	UserName string
+}
+
+// IsImpersonation determines whether the claim was issued to an admin impersonating the user.
+func (c *JwtClaim) IsImpersonation() bool {
+	return c.Actor != nil
 }
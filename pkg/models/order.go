@@ -2,8 +2,8 @@ package models
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
-	"strings"
 
 	"gorm.io/gorm"
 )
@@ -30,14 +30,22 @@ type PaymentInfo struct {
 	CardInfo CreditCardInfo `json:"cardinfo" gorm:"embedded"`
 }
 
+// Statuses of an order.
+const (
+	// The order hasn't been fulfilled yet. Only pending orders can be repriced.
+	OrderStatusPending = "pending"
+	// The order has been fulfilled.
+	OrderStatusFulfilled = "fulfilled"
+)
+
 // swagger:model order
 // Order holds all the information in an order of fruit.
 type Order struct {
 	gorm.Model
-	// User ID of the user who owns the order.
-	OwnerID uint `json:"ownerid"`
-	// All of the items present in the order.
-	Items []*Item `json:"items"`
+	// User ID of the user who owns the order. (null once the user is purged, if their orders are retained)
+	OwnerID *uint `json:"ownerid"`
+	// All of the items present in the order. (deleted along with the order)
+	Items []*Item `json:"items" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
 	// Payment information for this order.
 	PaymentInfo PaymentInfo `json:"paymentinfo" gorm:"embedded"`
 	// Tax rate for this order.
@@ -48,6 +56,17 @@ type Order struct {
 	Tax float64 `json:"tax"`
 	// Total cost of the order.
 	Total float64 `json:"total"`
+	// Status of the order. (pending or fulfilled; new orders are pending)
+	Status string `json:"status" gorm:"not null;default:pending"`
+	// Version of the order, bumped on every update. (sent as the ETag of the order)
+	Version uint `json:"version" gorm:"not null;default:1"`
+	// The user who owns the order. (only set when requested with include=owner)
+	Owner *User `json:"owner,omitempty"`
+}
+
+// OwnedBy determines whether the order is owned by the user with the supplied id.
+func (o *Order) OwnedBy(id uint) bool {
+	return o.OwnerID != nil && *o.OwnerID == id
 }
 
 // ValidateCreditCardExpirationDate determines whether a credit card's expiration date is valid. (4 digit mm/yy string)
@@ -89,17 +108,11 @@ func ValidateZipcode(zipcode string) error {
 
 // ValidateCreditCardInfo determines whether all of the supplied credit card information is valid.
 func ValidateCreditCardInfo(cardInfo CreditCardInfo) error {
-	expDateError := ValidateCreditCardExpirationDate(cardInfo.ExpirationDate)
-	numberError := ValidateCreditCardNumber(cardInfo.Number)
-	cvvError := ValidateCreditCardCVV(cardInfo.Cvv)
-	zipcodeError := ValidateZipcode(cardInfo.Zipcode)
-	if expDateError == nil && numberError == nil && cvvError == nil && zipcodeError == nil {
-		return nil
-	} else {
-		errorMsgs := []string{expDateError.Error(), numberError.Error(), cvvError.Error(), zipcodeError.Error()}
-		msg := strings.Join(errorMsgs, ", ")
-		return errors.New(msg)
-	}
+	expDateError := newFieldError("expirationdate", ValidateCreditCardExpirationDate(cardInfo.ExpirationDate))
+	numberError := newFieldError("number", ValidateCreditCardNumber(cardInfo.Number))
+	cvvError := newFieldError("cvv", ValidateCreditCardCVV(cardInfo.Cvv))
+	zipcodeError := newFieldError("zipcode", ValidateZipcode(cardInfo.Zipcode))
+	return joinErrors(expDateError, numberError, cvvError, zipcodeError)
 }
 
 // ValidateOrderPaymentInfo determines whether all of the supplied payment information for an order is valid.
@@ -107,7 +120,7 @@ func ValidateOrderPaymentInfo(info PaymentInfo) error {
 	if info.Cash {
 		return nil
 	} else {
-		return ValidateCreditCardInfo(info.CardInfo)
+		return nestFieldErrors("cardinfo", ValidateCreditCardInfo(info.CardInfo))
 	}
 }
 
@@ -119,45 +132,45 @@ func ValidateOrderId(order *Order) error {
 	return nil
 }
 
+// ValidateOrderStatus determines whether the supplied order status is valid. (pending or fulfilled)
+func ValidateOrderStatus(status string) error {
+	if status != OrderStatusPending && status != OrderStatusFulfilled {
+		return fmt.Errorf("status must be %s or %s, got %q", OrderStatusPending, OrderStatusFulfilled, status)
+	}
+	return nil
+}
+
 // ValidateOrder validates whether the supplied order is valid. (totals, payment info, and id need to be valid)
 func ValidateOrder(order *Order) error {
-	paymentInfoError := ValidateOrderPaymentInfo(order.PaymentInfo)
-	idError := ValidateOrderId(order)
-	totalIsValid := order.validateTotal()
-	if paymentInfoError == nil && idError == nil && totalIsValid {
-		return nil
-	} else {
-		errorMsgs := []string{paymentInfoError.Error(), idError.Error()}
-		msg := strings.Join(errorMsgs, ", ")
-		return errors.New(msg)
+	paymentInfoError := nestFieldErrors("paymentinfo", ValidateOrderPaymentInfo(order.PaymentInfo))
+	idError := newFieldError("ID", ValidateOrderId(order))
+	statusError := newFieldError("status", ValidateOrderStatus(order.Status))
+	var totalError error
+	if !order.validateTotal() {
+		totalError = newFieldError("total", errors.New("total must be the subtotal plus tax"))
 	}
+	return joinErrors(paymentInfoError, idError, statusError, totalError)
 }
 
 // ValidateNewOrder validates whether the supplied new fruit order (freshly created) is valid. (payment info needs to be valid)
 func ValidateNewOrder(order *Order) error {
 	// Need also to validate that subtotal, tax, and total are empty??
-	subtotalIsValid, taxIsValid, totalIsValid := true, true, true
 	var subtotalError, taxError, totalError error
 	if order.Subtotal != 0.0 {
-		subtotalIsValid = false
-		subtotalError = errors.New("subtotal must be empty")
+		subtotalError = newFieldError("subtotal", errors.New("subtotal must be empty"))
 	}
 	if order.Tax != 0.0 {
-		taxIsValid = false
-		taxError = errors.New("tax must be empty")
+		taxError = newFieldError("tax", errors.New("tax must be empty"))
 	}
 	if order.Total != 0.0 {
-		totalIsValid = false
-		totalError = errors.New("total must be empty")
+		totalError = newFieldError("total", errors.New("total must be empty"))
 	}
-	paymentInfoError := ValidateOrderPaymentInfo(order.PaymentInfo)
-	if subtotalIsValid && taxIsValid && totalIsValid && paymentInfoError == nil {
-		return nil
-	} else {
-		errorMsgs := []string{subtotalError.Error(), taxError.Error(), totalError.Error(), paymentInfoError.Error()}
-		msg := strings.Join(errorMsgs, ", ")
-		return errors.New(msg)
+	var statusError error
+	if order.Status != "" && order.Status != OrderStatusPending {
+		statusError = newFieldError("status", errors.New("new orders must be "+OrderStatusPending))
 	}
+	paymentInfoError := nestFieldErrors("paymentinfo", ValidateOrderPaymentInfo(order.PaymentInfo))
+	return joinErrors(subtotalError, taxError, totalError, statusError, paymentInfoError)
 }
 
 func ValidateOrderUpdate(order *Order, selectedFields []string) error {
@@ -166,9 +179,11 @@ func ValidateOrderUpdate(order *Order, selectedFields []string) error {
 	for _, field := range selectedFields {
 		switch field {
 		case "ownerid":
-			err = ValidateOrderId(order)
+			err = newFieldError(field, ValidateOrderId(order))
 		case "paymentinfo":
-			err = ValidateOrderPaymentInfo(order.PaymentInfo)
+			err = nestFieldErrors(field, ValidateOrderPaymentInfo(order.PaymentInfo))
+		case "status":
+			err = newFieldError(field, ValidateOrderStatus(order.Status))
 		case "items":
 		case "taxrate":
 		}
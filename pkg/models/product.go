@@ -20,25 +20,27 @@ type Product struct {
 	Price float64 `json:"price"`
 	// Number of the product currently in stock.
 	NumInStock int `json:"numInStock"`
+	// Version of the product, bumped on every update. (sent as the ETag of the product)
+	Version uint `json:"version" gorm:"not null;default:1"`
 }
 
 func (p *Product) IsValid() error {
 	errMsgPrefix := "failed to validate product: "
 	err := p.nameIsValid()
 	if err != nil {
-		return errors.New(errMsgPrefix + err.Error())
+		return newFieldError("name", errors.New(errMsgPrefix+err.Error()))
 	}
 	err = p.symbolIsValid()
 	if err != nil {
-		return errors.New(errMsgPrefix + err.Error())
+		return newFieldError("symbol", errors.New(errMsgPrefix+err.Error()))
 	}
 	err = p.priceIsValid()
 	if err != nil {
-		return errors.New(errMsgPrefix + err.Error())
+		return newFieldError("price", errors.New(errMsgPrefix+err.Error()))
 	}
 	err = p.numInStockIsValid()
 	if err != nil {
-		return errors.New(errMsgPrefix + err.Error())
+		return newFieldError("numInStock", errors.New(errMsgPrefix+err.Error()))
 	}
 	return nil
 }
@@ -49,15 +51,15 @@ func (p *Product) PartialUpdateIsValid(selectedFields []string) error {
 	for _, field := range selectedFields {
 		switch field {
 		case "name":
-			err = p.nameIsValid()
+			err = newFieldError(field, p.nameIsValid())
 		case "symbol":
-			err = p.symbolIsValid()
+			err = newFieldError(field, p.symbolIsValid())
 		case "price":
-			err = p.priceIsValid()
+			err = newFieldError(field, p.priceIsValid())
 		case "numInStock":
-			err = p.numInStockIsValid()
+			err = newFieldError(field, p.numInStockIsValid())
 		default:
-			err = fmt.Errorf("field name is invalid: %s", field)
+			err = newFieldError(field, fmt.Errorf("field name is invalid: %s", field))
 		}
 		if err != nil {
 			return err
@@ -17,6 +17,11 @@ type User struct {
 	Name     string `json:"name"`
 	Password string `json:"password"`
 	Role     string `json:"role"`
+	// Version of the user, bumped on every update. (sent as the ETag of the user)
+	Version uint `json:"version" gorm:"not null;default:1"`
+	// Orders owned by the user. (only set when requested with include=orders)
+	// Orders outlive their owner, losing it when the user is purged.
+	Orders []*Order `json:"orders,omitempty" gorm:"foreignKey:OwnerID;constraint:OnDelete:SET NULL"`
 }
 
 // PasswordFmtReqMsg returns an array of strings containing all the formatting requirements for setting a password, where each item is a requirement.
@@ -34,15 +39,15 @@ func PasswordFmtReqMsg() []string {
 func (u *User) IsValid() error {
 	err := u.validateName()
 	if err != nil {
-		return err
+		return newFieldError("name", err)
 	}
 	err = u.validatePassword()
 	if err != nil {
-		return err
+		return newFieldError("password", err)
 	}
 	err = roles.IsValid(u.Role)
 	if err != nil {
-		return err
+		return newFieldError("role", err)
 	}
 	return nil
 }
@@ -54,11 +59,11 @@ func (u *User) ValidatePartialUserUpdate(selectedFields []string) error {
 	for _, field := range selectedFields {
 		switch field {
 		case "name":
-			err = u.validateName()
+			err = newFieldError(field, u.validateName())
 		case "password":
-			err = u.validatePassword()
+			err = newFieldError(field, u.validatePassword())
 		case "role":
-			err = roles.IsValid(u.Role)
+			err = newFieldError(field, roles.IsValid(u.Role))
 		}
 		if err != nil {
 			return err
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldError holds the reason a single field of a record is invalid.
+type FieldError struct {
+	// JSON name of the field, preceded by the names of the objects it is nested in. (e.g. paymentinfo.cardinfo.cvv)
+	Field string
+	Err   error
+}
+
+// Error returns the reason the field is invalid.
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the reason the field is invalid.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError holds every reason a record is invalid.
+type ValidationError struct {
+	// Reasons the record is invalid. Reasons that aren't about a single field have no field name.
+	Errors []*FieldError
+}
+
+// Error returns a list of the reasons the record is invalid.
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// FieldErrors returns the reasons for each field the supplied validation error reports as invalid, or nil if it doesn't name any fields.
+func FieldErrors(err error) []*FieldError {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Errors
+	}
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		return []*FieldError{fieldErr}
+	}
+	return nil
+}
+
+// newFieldError returns the supplied error as the reason the supplied field is invalid, or nil if the error is nil.
+func newFieldError(field string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &FieldError{Field: field, Err: err}
+}
+
+// nestFieldErrors returns the supplied validation error of an object with the fields it names nested in the object with the supplied name, or nil if the error is nil.
+func nestFieldErrors(object string, err error) error {
+	if err == nil {
+		return nil
+	}
+	fieldErrs := FieldErrors(err)
+	if fieldErrs == nil {
+		return newFieldError(object, err)
+	}
+	nested := make([]*FieldError, len(fieldErrs))
+	for i, fieldErr := range fieldErrs {
+		field := object
+		if fieldErr.Field != "" {
+			field += "." + fieldErr.Field
+		}
+		nested[i] = &FieldError{Field: field, Err: fieldErr.Err}
+	}
+	return &ValidationError{Errors: nested}
+}
+
+// joinErrors returns a validation error listing all the supplied errors that aren't nil, or nil if they all are.
+func joinErrors(errs ...error) error {
+	var joined []*FieldError
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if fieldErrs := FieldErrors(err); fieldErrs != nil {
+			joined = append(joined, fieldErrs...)
+		} else {
+			joined = append(joined, &FieldError{Err: err})
+		}
+	}
+	if len(joined) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: joined}
+}
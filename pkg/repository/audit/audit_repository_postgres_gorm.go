@@ -0,0 +1,115 @@
+// Package audit provides implementations of an audit log repository.
+package audit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// Key of the postgres advisory lock held while appending, so concurrent appends can't chain to the same entry.
+const appendLockKey = 0x66727569746261 // "fruitba"
+
+// PostgresAuditRepo represents an implementation of an audit log repository using postgres.
+type PostgresAuditRepo struct {
+	DB *gorm.DB
+}
+
+// NewPostgresAuditRepo creates a new postgres audit log repository.
+func NewPostgresAuditRepo(db *gorm.DB) repository.Audit {
+	return &PostgresAuditRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgresAuditRepo) Append(e *models.AuditEntry) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		// SQLite has no advisory locks, but only ever has one transaction writing at a time.
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", appendLockKey).Error; err != nil {
+				return err
+			}
+		}
+		var last models.AuditEntry
+		result := tx.Order("id desc").Limit(1).Find(&last)
+		if result.Error != nil {
+			return result.Error
+		}
+		e.ID = 0
+		e.PrevHash = ""
+		if result.RowsAffected > 0 {
+			e.PrevHash = last.Hash
+		}
+		// Postgres stores timestamps to the microsecond, so truncate now so the hash still matches once read back.
+		e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		e.Hash = e.ComputeHash()
+		return tx.Create(e).Error
+	})
+}
+
+func (r *PostgresAuditRepo) Count(filter *repository.AuditFilter) (count int64, err error) {
+	result := r.filtered(filter).Model(&models.AuditEntry{}).Count(&count)
+	if result.Error != nil {
+		return -1, result.Error
+	}
+	return count, nil
+}
+
+func (r *PostgresAuditRepo) Fetch(seek *repository.PageSeekOptions, filter *repository.AuditFilter) (entries []*models.AuditEntry, err error) {
+	db := r.filtered(filter).Limit(seek.RecordLimit)
+	var result *gorm.DB
+	switch seek.Direction {
+	case repository.SeekDirectionBefore:
+		// Take the entries closest to the start id, then put them back in the order they were appended.
+		result = db.Where("id < ?", seek.StartId).Order("id desc").Find(&entries)
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	case repository.SeekDirectionAfter:
+		result = db.Where("id > ?", seek.StartId).Order("id asc").Find(&entries)
+	case repository.SeekDirectionNone:
+		result = db.Order("id asc").Find(&entries)
+	default:
+		return nil, errors.New("invalid seek direction")
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return entries, nil
+}
+
+// filtered returns a database session that only matches entries matching the supplied filter.
+func (r *PostgresAuditRepo) filtered(filter *repository.AuditFilter) *gorm.DB {
+	db := r.DB
+	if filter == nil {
+		return db
+	}
+	if filter.ActorID != 0 {
+		db = db.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.ImpersonatorID != 0 {
+		db = db.Where("impersonator_id = ?", filter.ImpersonatorID)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		db = db.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		db = db.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.RequestID != "" {
+		db = db.Where("request_id = ?", filter.RequestID)
+	}
+	if !filter.Since.IsZero() {
+		db = db.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		db = db.Where("created_at < ?", filter.Until)
+	}
+	return db
+}
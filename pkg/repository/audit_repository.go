@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// Audit provides an interface for recording privileged actions in an append-only audit log.
+// Entries can't be changed or removed once appended.
+type Audit interface {
+	// Append adds a new entry to the end of the audit log, chaining it to the last entry.
+	Append(e *models.AuditEntry) error
+	// Count returns the count of all the entries matching the supplied filter.
+	Count(filter *AuditFilter) (int64, error)
+	// Fetch returns the entries matching the supplied seek options and filter, in the order they were appended.
+	Fetch(seek *PageSeekOptions, filter *AuditFilter) ([]*models.AuditEntry, error)
+}
+
+// AuditFilter holds the criteria entries must match to be returned from an audit log. Zero values match every entry.
+type AuditFilter struct {
+	ActorID uint
+	// Only entries for actions performed by this admin while impersonating another user.
+	ImpersonatorID uint
+	Action         string
+	EntityType     string
+	EntityID       uint
+	RequestID      string
+	// Only entries created at or after this time.
+	Since time.Time
+	// Only entries created before this time.
+	Until time.Time
+}
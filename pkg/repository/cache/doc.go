@@ -0,0 +1,38 @@
+// Package cache provides repositories which keep the records most recently read from another repository in memory for a short time,
+// so frequent reads of the same records don't all reach the database.
+//
+// Records written through a cached repository are removed from its cache, but records written by another process, such as another service,
+// are only read again once they expire, so a cache should only hold records that are read much more often than they are written.
+package cache
+
+import "time"
+
+// Config configures a cache of records.
+type Config struct {
+	// Maximum number of records held, the least recently used are evicted to make room for new ones. (defaults to 1000)
+	Size int
+	// How long a record is held before it is read from the repository again. (defaults to 10 seconds)
+	TTL time.Duration
+}
+
+// Defaults of the configuration of a cache.
+const (
+	defaultSize = 1000
+	defaultTTL  = 10 * time.Second
+)
+
+// Stats holds the statistics of a cache since it was created.
+type Stats struct {
+	// Number of records read from the cache.
+	Hits uint64 `json:"hits"`
+	// Number of records read from the repository because the cache didn't hold them.
+	Misses uint64 `json:"misses"`
+	// Number of records evicted to make room for new ones.
+	Evictions uint64 `json:"evictions"`
+	// Number of records removed because they had expired.
+	Expirations uint64 `json:"expirations"`
+	// Number of records removed because they were written.
+	Invalidations uint64 `json:"invalidations"`
+	// Number of records currently held.
+	Size int `json:"size"`
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru holds up to a fixed number of records by id, each for a limited time, evicting the least recently used record to make room for a new one.
+// It is safe for concurrent use.
+type lru struct {
+	mu   sync.Mutex
+	size int
+	ttl  time.Duration
+	// Returns the current time. (replaced in tests)
+	now func() time.Time
+	// Element of each record in order, by id.
+	entries map[uint]*list.Element
+	// Records from the most recently used to the least recently used.
+	order *list.List
+	// Bumped whenever records are invalidated, so records read before the invalidation aren't added after it. (see add)
+	generation uint64
+	stats      Stats
+}
+
+// lruEntry is a record held in an lru.
+type lruEntry struct {
+	id      uint
+	value   interface{}
+	expires time.Time
+}
+
+// newLRU creates a new, empty lru holding up to the supplied number of records, each for the supplied duration.
+func newLRU(size int, ttl time.Duration) *lru {
+	return &lru{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[uint]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the record with the supplied id, if it is held and hasn't expired, and counts the hit or miss.
+func (c *lru) get(id uint) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[id]
+	if ok && c.now().After(element.Value.(*lruEntry).expires) {
+		c.removeElement(element)
+		c.stats.Expirations++
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+// currentGeneration returns the generation to pass to add for a record about to be read from the repository.
+func (c *lru) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// add holds the supplied record with the supplied id, unless records were invalidated since the supplied generation,
+// in which case the record may have been read before it was written, and is left out.
+func (c *lru) add(id uint, value interface{}, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	entry := &lruEntry{id: id, value: value, expires: c.now().Add(c.ttl)}
+	if element, ok := c.entries[id]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// invalidate removes the records with the supplied ids, and keeps records read before the call from being added.
+func (c *lru) invalidate(ids ...uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, id := range ids {
+		if element, ok := c.entries[id]; ok {
+			c.removeElement(element)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// removeElement removes the record of the supplied element. The caller must hold the lock.
+func (c *lru) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).id)
+}
+
+// getStats returns the current statistics of the lru.
+func (c *lru) getStats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// CachedProductRepo represents an implementation of a Product repository reading the products of another product repository through a cache.
+// Products are read by id through the cache, concurrent reads of a product missing from it are collapsed into a single read of the repository,
+// and products updated, deleted (archived), restored or purged through it are removed from it. Listings and counts are always read from the repository.
+// Checks for products existing read the products, so they can be cached too.
+type CachedProductRepo struct {
+	Repo    repository.Product
+	cache   *lru
+	flights singleflight.Group
+}
+
+// NewCachedProductRepo creates a new product repository reading the products of the supplied repository through a cache configured by the supplied configuration.
+func NewCachedProductRepo(repo repository.Product, config Config) *CachedProductRepo {
+	if config.Size <= 0 {
+		config.Size = defaultSize
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+	return &CachedProductRepo{
+		Repo:  repo,
+		cache: newLRU(config.Size, config.TTL),
+	}
+}
+
+// Stats returns the statistics of the repository's cache.
+func (r *CachedProductRepo) Stats() Stats {
+	return r.cache.getStats()
+}
+
+// Invalidate removes the products with the supplied ids from the repository's cache, so they are read from the repository again.
+func (r *CachedProductRepo) Invalidate(ids ...uint) {
+	r.cache.invalidate(ids...)
+}
+
+func (r *CachedProductRepo) Count(seek *repository.PageSeekOptions) (int64, error) {
+	return r.Repo.Count(seek)
+}
+
+func (r *CachedProductRepo) Fetch(seek *repository.PageSeekOptions) ([]*models.Product, error) {
+	return r.Repo.Fetch(seek)
+}
+
+func (r *CachedProductRepo) Exists(id uint) (bool, error) {
+	_, err := r.GetByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *CachedProductRepo) ExistsAll(ids []uint) ([]uint, error) {
+	// Reading the products caches them, so the orders checking their products exist find them in the cache when they read their prices.
+	products, err := r.GetByIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	exists := make(map[uint]bool, len(products))
+	for _, p := range products {
+		// Unlike the other reads, reading products by ids includes the archived ones, which don't count as existing.
+		exists[p.ID] = !p.DeletedAt.Valid
+	}
+	var missing []uint
+	for _, id := range ids {
+		if !exists[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+func (r *CachedProductRepo) GetByID(id uint) (*models.Product, error) {
+	if p, ok := r.cache.get(id); ok {
+		// Archived products are cached when they are read by ids, but aren't read by id.
+		if p.(*models.Product).DeletedAt.Valid {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return copyProduct(p.(*models.Product)), nil
+	}
+	p, err, _ := r.flights.Do(strconv.FormatUint(uint64(id), 10), func() (interface{}, error) {
+		generation := r.cache.currentGeneration()
+		p, err := r.Repo.GetByID(id)
+		if err != nil {
+			return nil, err
+		}
+		r.cache.add(id, copyProduct(p), generation)
+		return p, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Every caller sharing the read gets a copy of its own.
+	return copyProduct(p.(*models.Product)), nil
+}
+
+func (r *CachedProductRepo) GetByIDs(ids []uint) ([]*models.Product, error) {
+	var products []*models.Product
+	var missing []uint
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if p, ok := r.cache.get(id); ok {
+			products = append(products, copyProduct(p.(*models.Product)))
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		generation := r.cache.currentGeneration()
+		read, err := r.Repo.GetByIDs(missing)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range read {
+			r.cache.add(p.ID, copyProduct(p), generation)
+		}
+		products = append(products, read...)
+	}
+	sort.Slice(products, func(i, j int) bool { return products[i].ID < products[j].ID })
+	return products, nil
+}
+
+func (r *CachedProductRepo) Create(p *models.Product) (uint, error) {
+	// Missing products aren't cached, so a new product needs nothing removed from the cache.
+	return r.Repo.Create(p)
+}
+
+func (r *CachedProductRepo) Update(p *models.Product, fields []string) (*models.Product, error) {
+	defer r.cache.invalidate(p.ID)
+	return r.Repo.Update(p, fields)
+}
+
+func (r *CachedProductRepo) Delete(id uint) error {
+	defer r.cache.invalidate(id)
+	return r.Repo.Delete(id)
+}
+
+func (r *CachedProductRepo) Restore(id uint) error {
+	defer r.cache.invalidate(id)
+	return r.Repo.Restore(id)
+}
+
+func (r *CachedProductRepo) Purge(id uint) error {
+	defer r.cache.invalidate(id)
+	return r.Repo.Purge(id)
+}
+
+// copyProduct returns a copy of the supplied product, so the cached product can't be changed by its readers.
+func copyProduct(p *models.Product) *models.Product {
+	c := *p
+	return &c
+}
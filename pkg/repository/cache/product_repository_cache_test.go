@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	"github.com/tragicpixel/fruitbar/pkg/repository/repositorytest"
+	"gorm.io/gorm"
+)
+
+// countingProductRepo counts the products read by id from the product repository it wraps, and can hold those reads until released.
+type countingProductRepo struct {
+	repository.Product
+	reads int32
+	// Reads wait for it to be closed, if set.
+	release chan struct{}
+}
+
+func (r *countingProductRepo) GetByID(id uint) (*models.Product, error) {
+	atomic.AddInt32(&r.reads, 1)
+	if r.release != nil {
+		<-r.release
+	}
+	return r.Product.GetByID(id)
+}
+
+// newCachedRepositories returns in-memory repositories with a store of their own, reading products through a cache configured by the supplied configuration,
+// along with the cached product repository and the repository it reads from.
+func newCachedRepositories(t *testing.T, config Config) (*repository.Repositories, *CachedProductRepo, *countingProductRepo) {
+	repos := memory.NewMemoryRepositories(memory.NewStore())
+	counting := &countingProductRepo{Product: repos.Products}
+	products := NewCachedProductRepo(counting, config)
+	return NewCachedRepositories(repos, products), products, counting
+}
+
+// createProduct creates a product with the supplied price in the supplied repository and returns its id.
+func createProduct(t *testing.T, repo repository.Product, price float64) uint {
+	t.Helper()
+	id, err := repo.Create(&models.Product{Name: "Apple", Symbol: "A", Price: price, NumInStock: 10})
+	if err != nil {
+		t.Fatalf("failed to create product: %s", err.Error())
+	}
+	return id
+}
+
+// expectPrice checks that reading the product with the supplied id from the supplied repository returns the supplied price.
+func expectPrice(t *testing.T, repo repository.Product, id uint, want float64) {
+	t.Helper()
+	p, err := repo.GetByID(id)
+	if err != nil {
+		t.Fatalf("unexpected error reading the product: %s", err.Error())
+	}
+	if p.Price != want {
+		t.Errorf("expected the product to cost %v, got %v", want, p.Price)
+	}
+}
+
+func TestProductRepository(t *testing.T) {
+	repositorytest.TestProductRepository(t, func(t *testing.T) *repository.Repositories {
+		repos, _, _ := newCachedRepositories(t, Config{})
+		return repos
+	})
+}
+
+func TestCachedProductRepo(t *testing.T) {
+	t.Run("hits", func(t *testing.T) {
+		repos, products, counting := newCachedRepositories(t, Config{})
+		id := createProduct(t, repos.Products, 1)
+		for i := 0; i < 3; i++ {
+			expectPrice(t, repos.Products, id, 1)
+		}
+		if exists, err := repos.Products.Exists(id); err != nil || !exists {
+			t.Errorf("expected the product to exist, got %v (%v)", exists, err)
+		}
+		if counting.reads != 1 {
+			t.Errorf("expected the product to be read from the repository once, got %d", counting.reads)
+		}
+		if stats := products.Stats(); stats.Hits != 3 || stats.Misses != 1 || stats.Size != 1 {
+			t.Errorf("expected 3 hits and 1 miss of 1 product, got %+v", stats)
+		}
+	})
+
+	t.Run("copies", func(t *testing.T) {
+		repos, _, _ := newCachedRepositories(t, Config{})
+		id := createProduct(t, repos.Products, 1)
+		p, _ := repos.Products.GetByID(id)
+		p.Price = 2
+		expectPrice(t, repos.Products, id, 1)
+	})
+
+	t.Run("missing products aren't cached", func(t *testing.T) {
+		repos, products, _ := newCachedRepositories(t, Config{})
+		if exists, err := repos.Products.Exists(1); err != nil || exists {
+			t.Errorf("expected a missing product not to exist, got %v (%v)", exists, err)
+		}
+		id := createProduct(t, repos.Products, 1)
+		expectPrice(t, repos.Products, id, 1)
+		if stats := products.Stats(); stats.Size != 1 {
+			t.Errorf("expected only the created product to be cached, got %+v", stats)
+		}
+	})
+
+	t.Run("get by ids", func(t *testing.T) {
+		repos, products, _ := newCachedRepositories(t, Config{})
+		first := createProduct(t, repos.Products, 1)
+		second := createProduct(t, repos.Products, 2)
+		expectPrice(t, repos.Products, second, 2)
+		got, err := repos.Products.GetByIDs([]uint{second, first, first, second + 100})
+		if err != nil {
+			t.Fatalf("unexpected error reading the products: %s", err.Error())
+		}
+		if len(got) != 2 || got[0].ID != first || got[1].ID != second {
+			t.Errorf("expected both products in id order, got %+v", got)
+		}
+		if stats := products.Stats(); stats.Hits != 1 || stats.Misses != 3 || stats.Size != 2 {
+			t.Errorf("expected the second product to be read from the cache and the others from the repository, got %+v", stats)
+		}
+	})
+
+	t.Run("writes invalidate", func(t *testing.T) {
+		repos, products, _ := newCachedRepositories(t, Config{})
+		id := createProduct(t, repos.Products, 1)
+		expectPrice(t, repos.Products, id, 1)
+		if _, err := repos.Products.Update(&models.Product{Model: gorm.Model{ID: id}, Price: 2, Version: 1}, nil); err != nil {
+			t.Fatalf("unexpected error updating the product: %s", err.Error())
+		}
+		expectPrice(t, repos.Products, id, 2)
+		if err := repos.Products.Delete(id); err != nil {
+			t.Fatalf("unexpected error deleting the product: %s", err.Error())
+		}
+		if exists, err := repos.Products.Exists(id); err != nil || exists {
+			t.Errorf("expected the deleted product not to exist, got %v (%v)", exists, err)
+		}
+		if stats := products.Stats(); stats.Invalidations != 2 {
+			t.Errorf("expected 2 invalidations, got %+v", stats)
+		}
+	})
+
+	t.Run("transactions invalidate", func(t *testing.T) {
+		repos, _, _ := newCachedRepositories(t, Config{})
+		id := createProduct(t, repos.Products, 1)
+		expectPrice(t, repos.Products, id, 1)
+		err := repos.Transactions.Transaction(func(tx *repository.Repositories) error {
+			if _, err := tx.Products.Update(&models.Product{Model: gorm.Model{ID: id}, Price: 2, Version: 1}, nil); err != nil {
+				return err
+			}
+			// The transaction sees its own writes.
+			expectPrice(t, tx.Products, id, 2)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unexpected error running the transaction: %s", err.Error())
+		}
+		expectPrice(t, repos.Products, id, 2)
+
+		err = repos.Transactions.Transaction(func(tx *repository.Repositories) error {
+			if _, err := tx.Products.Update(&models.Product{Model: gorm.Model{ID: id}, Price: 3, Version: 2}, nil); err != nil {
+				return err
+			}
+			return errors.New("rolled back")
+		})
+		if err == nil {
+			t.Fatalf("expected the transaction to fail")
+		}
+		expectPrice(t, repos.Products, id, 2)
+
+		// Archived products are cached by GetByIDs, so restoring one in a transaction must remove it from the cache too.
+		if err := repos.Products.Delete(id); err != nil {
+			t.Fatalf("unexpected error deleting the product: %s", err.Error())
+		}
+		if got, err := repos.Products.GetByIDs([]uint{id}); err != nil || len(got) != 1 {
+			t.Fatalf("expected the archived product to be read by id, got %+v (%v)", got, err)
+		}
+		err = repos.Transactions.Transaction(func(tx *repository.Repositories) error {
+			return tx.Products.Restore(id)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error restoring the product: %s", err.Error())
+		}
+		expectPrice(t, repos.Products, id, 2)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		repos, products, counting := newCachedRepositories(t, Config{TTL: time.Minute})
+		now := time.Now()
+		products.cache.now = func() time.Time { return now }
+		id := createProduct(t, repos.Products, 1)
+		expectPrice(t, repos.Products, id, 1)
+		now = now.Add(time.Minute + time.Second)
+		expectPrice(t, repos.Products, id, 1)
+		if stats := products.Stats(); counting.reads != 2 || stats.Expirations != 1 {
+			t.Errorf("expected the expired product to be read again, got %d reads, %+v", counting.reads, stats)
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		repos, products, counting := newCachedRepositories(t, Config{Size: 2})
+		ids := []uint{createProduct(t, repos.Products, 1), createProduct(t, repos.Products, 2), createProduct(t, repos.Products, 3)}
+		expectPrice(t, repos.Products, ids[0], 1)
+		expectPrice(t, repos.Products, ids[1], 2)
+		// Reading the first product again makes the second the least recently used.
+		expectPrice(t, repos.Products, ids[0], 1)
+		expectPrice(t, repos.Products, ids[2], 3)
+		expectPrice(t, repos.Products, ids[0], 1)
+		if stats := products.Stats(); counting.reads != 3 || stats.Evictions != 1 || stats.Size != 2 {
+			t.Errorf("expected the least recently used product to be evicted, got %d reads, %+v", counting.reads, stats)
+		}
+		expectPrice(t, repos.Products, ids[1], 2)
+		if counting.reads != 4 {
+			t.Errorf("expected the evicted product to be read again, got %d reads", counting.reads)
+		}
+	})
+
+	t.Run("concurrent misses", func(t *testing.T) {
+		repos, _, counting := newCachedRepositories(t, Config{})
+		id := createProduct(t, repos.Products, 1)
+		counting.release = make(chan struct{})
+		var started, done sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			started.Add(1)
+			done.Add(1)
+			go func() {
+				defer done.Done()
+				started.Done()
+				if _, err := repos.Products.GetByID(id); err != nil {
+					t.Errorf("unexpected error reading the product: %s", err.Error())
+				}
+			}()
+		}
+		started.Wait()
+		// Give the readers time to join the first read.
+		time.Sleep(50 * time.Millisecond)
+		close(counting.release)
+		done.Wait()
+		if counting.reads != 1 {
+			t.Errorf("expected the concurrent misses to be collapsed into one read, got %d reads", counting.reads)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// NewCachedRepositories returns a copy of the supplied repositories reading products through the supplied cached product repository,
+// which must read the products of the supplied repositories. Products written in a transaction are removed from the cache once it is over.
+func NewCachedRepositories(repos *repository.Repositories, products *CachedProductRepo) *repository.Repositories {
+	cached := *repos
+	cached.Products = products
+	cached.Transactions = &cachedTransactor{Transactor: repos.Transactions, products: products}
+	return &cached
+}
+
+// cachedTransactor represents an implementation of a Transactor running transactions on the repositories of another transactor,
+// which removes the products written in a transaction from the cache of a cached product repository once it is over.
+type cachedTransactor struct {
+	repository.Transactor
+	products *CachedProductRepo
+}
+
+func (t *cachedTransactor) Transaction(fn func(tx *repository.Repositories) error) error {
+	var written []uint
+	// Products written in the transaction are only removed once it is committed (or rolled back), so the products
+	// read from the repository in the meantime, which may be those from before the transaction, aren't cached.
+	defer func() { t.products.Invalidate(written...) }()
+	return t.Transactor.Transaction(func(tx *repository.Repositories) error {
+		return fn(recordWrittenProducts(tx, &written))
+	})
+}
+
+// recordWrittenProducts returns a copy of the supplied repositories of a transaction, adding the ids of the products written through them to the supplied list.
+// Products are read from the transaction, rather than from the cache, so the transaction sees its own writes.
+func recordWrittenProducts(tx *repository.Repositories, written *[]uint) *repository.Repositories {
+	recorded := *tx
+	recorded.Products = &txProductRepo{Product: tx.Products, written: written}
+	if tx.Transactions != nil {
+		recorded.Transactions = &txTransactor{Transactor: tx.Transactions, written: written}
+	}
+	return &recorded
+}
+
+// txProductRepo represents an implementation of a Product repository writing products in a transaction, and recording the ids of the products it writes.
+type txProductRepo struct {
+	repository.Product
+	written *[]uint
+}
+
+func (r *txProductRepo) Update(p *models.Product, fields []string) (*models.Product, error) {
+	*r.written = append(*r.written, p.ID)
+	return r.Product.Update(p, fields)
+}
+
+func (r *txProductRepo) Delete(id uint) error {
+	*r.written = append(*r.written, id)
+	return r.Product.Delete(id)
+}
+
+func (r *txProductRepo) Restore(id uint) error {
+	*r.written = append(*r.written, id)
+	return r.Product.Restore(id)
+}
+
+func (r *txProductRepo) Purge(id uint) error {
+	*r.written = append(*r.written, id)
+	return r.Product.Purge(id)
+}
+
+// txTransactor represents an implementation of a Transactor running transactions nested in another transaction,
+// which records the products written in them as written in the outer transaction.
+type txTransactor struct {
+	repository.Transactor
+	written *[]uint
+}
+
+func (t *txTransactor) Transaction(fn func(tx *repository.Repositories) error) error {
+	return t.Transactor.Transaction(func(tx *repository.Repositories) error {
+		return fn(recordWrittenProducts(tx, t.written))
+	})
+}
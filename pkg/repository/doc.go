@@ -1,2 +1,8 @@
 // Package repository provides interfaces for the implementation of a repository for various data types used by the fruitbar application.
 package repository
+
+import "errors"
+
+// ErrVersionConflict is returned when updating a record whose version no longer matches the version supplied with it,
+// because someone else updated the record after it was read.
+var ErrVersionConflict = errors.New("record version conflict")
@@ -0,0 +1,61 @@
+// Package idempotency provides implementations of an idempotency key repository.
+package idempotency
+
+import (
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresIdempotencyRepo represents an implementation of an idempotency key repository using postgres.
+type PostgresIdempotencyRepo struct {
+	DB *gorm.DB
+}
+
+// NewPostgresIdempotencyRepo creates a new postgres idempotency key repository.
+func NewPostgresIdempotencyRepo(db *gorm.DB) repository.Idempotency {
+	return &PostgresIdempotencyRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgresIdempotencyRepo) Reserve(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	result := r.DB.Where("expires_at <= ?", time.Now()).Delete(&models.IdempotencyRecord{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	// The unique index on key and user makes claiming a key atomic: of two requests racing for it, only one inserts a row.
+	result = r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(rec)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+	var existing models.IdempotencyRecord
+	result = r.DB.Where("idempotency_key = ? AND user_id = ?", rec.Key, rec.UserID).First(&existing)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &existing, nil
+}
+
+func (r *PostgresIdempotencyRepo) Complete(rec *models.IdempotencyRecord) error {
+	rec.Completed = true
+	result := r.DB.Model(rec).Select("completed", "status", "header", "body", "expires_at").Updates(rec)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *PostgresIdempotencyRepo) Release(id uint) error {
+	result := r.DB.Delete(&models.IdempotencyRecord{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
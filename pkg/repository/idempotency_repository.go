@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// Idempotency provides an interface for storing the responses to requests made with an idempotency key, so they can be replayed on retry.
+type Idempotency interface {
+	// Reserve claims the key of the supplied record for its user, and stores the record as a request being handled, until it expires.
+	// Expired records are removed first, whether handled or not, so their keys can be used again.
+	// If the key has already been claimed by the user, nothing is stored and the existing record is returned instead. Returns nil if the key was claimed.
+	Reserve(rec *models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	// Complete stores the response and expiry time in the supplied record, and marks its request as handled.
+	// Does nothing if the record has been removed, e.g. because it expired before its request was handled.
+	Complete(rec *models.IdempotencyRecord) error
+	// Release removes the record with the supplied id, so its key can be used again.
+	Release(id uint) error
+}
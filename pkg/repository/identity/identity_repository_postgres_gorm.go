@@ -0,0 +1,45 @@
+// Package identity provides implementations of an external identity repository.
+package identity
+
+import (
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// PostgresIdentityRepo represents an implementation of an external identity repository using postgres.
+type PostgresIdentityRepo struct {
+	DB *gorm.DB
+}
+
+// NewPostgresIdentityRepo creates a new postgres external identity repository.
+func NewPostgresIdentityRepo(db *gorm.DB) repository.ExternalIdentity {
+	return &PostgresIdentityRepo{
+		DB: db,
+	}
+}
+
+func (r *PostgresIdentityRepo) GetBySubject(issuer string, subject string) (*models.ExternalIdentity, error) {
+	var identity models.ExternalIdentity
+	result := r.DB.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &identity, nil
+}
+
+func (r *PostgresIdentityRepo) Create(i *models.ExternalIdentity) (uint, error) {
+	result := r.DB.Create(i)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return i.ID, nil
+}
+
+func (r *PostgresIdentityRepo) DeleteByUserID(id uint) error {
+	result := r.DB.Unscoped().Where("user_id = ?", id).Delete(&models.ExternalIdentity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"github.com/tragicpixel/fruitbar/pkg/models"
+)
+
+// ExternalIdentity provides an interface for performing operations on a repository of links between external identity provider subjects and users.
+type ExternalIdentity interface {
+	// GetBySubject returns the link for the supplied issuer and subject, if it exists.
+	GetBySubject(issuer string, subject string) (*models.ExternalIdentity, error)
+	// Create creates a new link and returns its ID.
+	Create(i *models.ExternalIdentity) (uint, error)
+	// DeleteByUserID removes all of the links to the user with the supplied id.
+	DeleteByUserID(id uint) error
+}
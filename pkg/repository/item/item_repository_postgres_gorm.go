@@ -2,10 +2,12 @@ package item
 
 import (
 	"errors"
+	"reflect"
 
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PostgresProductRepo represents an implementation of a Product repository using postgres.
@@ -42,11 +44,15 @@ func (r *PostgresItemRepo) Fetch(seek *repository.PageSeekOptions) (items []*mod
 	var result *gorm.DB
 	switch seek.Direction {
 	case repository.SeekDirectionBefore:
-		result = r.DB.Limit(seek.RecordLimit).Where("ID < ?", seek.StartId).Find(&items)
+		// Take the items closest to the start id, then put them back in id order.
+		result = r.DB.Limit(seek.RecordLimit).Where("ID < ?", seek.StartId).Order("id desc").Find(&items)
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
 	case repository.SeekDirectionAfter:
-		result = r.DB.Limit(seek.RecordLimit).Where("ID > ?", seek.StartId).Find(&items)
+		result = r.DB.Limit(seek.RecordLimit).Where("ID > ?", seek.StartId).Order("id asc").Find(&items)
 	case repository.SeekDirectionNone:
-		result = r.DB.Limit(seek.RecordLimit).Find(&items)
+		result = r.DB.Limit(seek.RecordLimit).Order("id asc").Find(&items)
 	default:
 		return nil, errors.New("invalid seek direction")
 	}
@@ -84,6 +90,19 @@ func (r *PostgresItemRepo) GetByOrderID(id uint) ([]*models.Item, error) {
 	}
 }
 
+func (r *PostgresItemRepo) GetByOrderIDs(ids []uint) (map[uint][]*models.Item, error) {
+	var items []*models.Item
+	result := r.DB.Where("order_id IN ?", ids).Order("id asc").Find(&items)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	byOrder := make(map[uint][]*models.Item, len(ids))
+	for _, item := range items {
+		byOrder[item.OrderID] = append(byOrder[item.OrderID], item)
+	}
+	return byOrder, nil
+}
+
 func (r *PostgresItemRepo) GetByProductID(id uint) ([]*models.Item, error) {
 	var items []*models.Item
 	result := r.DB.Where(&models.Item{ProductID: id}).Find(&items)
@@ -95,34 +114,86 @@ func (r *PostgresItemRepo) GetByProductID(id uint) ([]*models.Item, error) {
 }
 
 func (r *PostgresItemRepo) Create(i *models.Item) (uint, error) {
-	result := r.DB.Create(&i)
+	result := r.DB.Omit(clause.Associations).Create(&i)
 	if result.Error != nil {
 		return 0, result.Error
 	}
 	return i.ID, nil
 }
 
+func (r *PostgresItemRepo) CreateAll(items []*models.Item) ([]uint, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	result := r.DB.Omit(clause.Associations).Create(&items)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	ids := make([]uint, len(items))
+	for n, i := range items {
+		ids[n] = i.ID
+	}
+	return ids, nil
+}
+
 func (r *PostgresItemRepo) Update(i *models.Item, fields []string) (*models.Item, error) {
 	_, err := r.GetByID(i.ID)
 	if err != nil {
 		return nil, err
 	}
 	if len(fields) > 0 { // Partial update
-		result := r.DB.Model(i).Select(fields).Updates(i)
+		result := r.DB.Model(i).Select(fields).Omit(clause.Associations).Updates(i)
 		if result.Error != nil {
-			return nil, err
+			return nil, result.Error
 		}
 	} else { // Full update
-		result := r.DB.Model(i).Updates(i)
+		result := r.DB.Model(i).Omit(clause.Associations).Updates(i)
 		if result.Error != nil {
-			return nil, err
+			return nil, result.Error
 		}
 	}
-	updated, err := r.GetByID(i.ID) // TODO: fix -- this doesnt return the updated item
-	if err != nil {
-		return nil, err
+	return r.GetByID(i.ID)
+}
+
+func (r *PostgresItemRepo) UpdateAll(items []*models.Item, fields []string) error {
+	if len(items) == 0 || len(fields) == 0 {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: r.DB}
+	if err := stmt.Parse(&models.Item{}); err != nil {
+		return err
 	}
-	return updated, nil
+	ids := make([]uint, len(items))
+	for n, i := range items {
+		ids[n] = i.ID
+	}
+	// Set each field to the value of the item of each row: SET quantity = CASE id WHEN 1 THEN 2 WHEN 3 THEN 1 END, ...
+	updates := make(map[string]interface{}, len(fields))
+	for _, name := range fields {
+		// Like a gorm update, unknown fields are ignored.
+		field := stmt.Schema.LookUpField(name)
+		if field == nil || field.DBName == "" || field.PrimaryKey || !field.Updatable {
+			continue
+		}
+		value := "?"
+		if r.DB.Dialector.Name() == "postgres" {
+			// Postgres can't tell the type of the values from the CASE expression alone.
+			value = "CAST(? AS " + r.DB.Dialector.DataTypeOf(field) + ")"
+		}
+		sql := "CASE id"
+		args := make([]interface{}, 0, 2*len(items))
+		for _, i := range items {
+			v, _ := field.ValueOf(reflect.ValueOf(i))
+			sql += " WHEN ? THEN " + value
+			args = append(args, i.ID, v)
+		}
+		updates[field.DBName] = gorm.Expr(sql+" END", args...)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	result := r.DB.Model(&models.Item{}).Where("id IN ?", ids).Updates(updates)
+	return result.Error
 }
 
 func (r *PostgresItemRepo) Delete(id uint) error {
@@ -134,3 +205,11 @@ func (r *PostgresItemRepo) Delete(id uint) error {
 	}
 	return result.Error
 }
+
+func (r *PostgresItemRepo) DeleteAll(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := r.DB.Unscoped().Where("id IN ?", ids).Delete(&models.Item{}) // hard delete, like Delete
+	return result.Error
+}
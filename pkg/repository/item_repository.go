@@ -16,12 +16,21 @@ type Item interface {
 	GetByID(id uint) (*models.Item, error)
 	// GetByOrderID returns an array of all the items in the repository with the supplied order id.
 	GetByOrderID(id uint) ([]*models.Item, error)
+	// GetByOrderIDs returns all the items in the repository belonging to any of the supplied order ids, by order id, in a single query.
+	GetByOrderIDs(ids []uint) (map[uint][]*models.Item, error)
 	// GetByOrderID returns an array of all the items in the repository with the supplied product id.
 	GetByProductID(id uint) ([]*models.Item, error)
 	// Create creates a new record and returns its ID.
 	Create(i *models.Item) (uint, error)
+	// CreateAll creates all of the supplied new records in a single query, and returns their IDs in the same order.
+	CreateAll(items []*models.Item) ([]uint, error)
 	// Update updates an existing product in the repository and returns the updated record.
 	Update(i *models.Item, fields []string) (*models.Item, error)
+	// UpdateAll updates the supplied fields of all of the supplied existing records to their values, in a single query.
+	// Records that don't exist are skipped.
+	UpdateAll(items []*models.Item, fields []string) error
 	// Delete removes the record with the supplied id from the repository.
 	Delete(id uint) error
+	// DeleteAll removes the records with any of the supplied ids from the repository, in a single query.
+	DeleteAll(ids []uint) error
 }
@@ -62,6 +62,22 @@ func (r *JWTRepository) ValidateToken(j *models.JwtWrapper, signedToken string)
 	return
 }
 
+func (r *JWTRepository) GenerateImpersonationToken(j *models.JwtWrapper, u *models.User, actor *models.JwtActor, expiresIn time.Duration) (signedToken string, err error) {
+	claims := &models.JwtClaim{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Local().Add(expiresIn).Unix(),
+			Issuer:    j.Issuer,
+		},
+		UserID:   u.ID,
+		UserName: u.Name,
+		UserRole: u.Role,
+		Actor:    actor,
+	}
+	log.Info(fmt.Sprintf("Generated impersonation token with user id %d and role %s for actor id %d", claims.UserID, claims.UserRole, actor.UserID))
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(j.SecretKey))
+}
+
 // same for username?
 func (r *JWTRepository) GetRole(j *models.JwtWrapper, signedToken string) (role string, err error) {
 	token, err := jwt.ParseWithClaims(
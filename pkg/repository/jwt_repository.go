@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/tragicpixel/fruitbar/pkg/models"
 )
 
@@ -8,6 +10,8 @@ import (
 type Jwt interface {
 	// GenerateToken returns a signed token based on the supplied JWT wrapper.
 	GenerateToken(j *models.JwtWrapper, u *models.User) (signedToken string, err error)
+	// GenerateImpersonationToken returns a signed token for the supplied user, carrying the supplied actor, that expires after the supplied duration.
+	GenerateImpersonationToken(j *models.JwtWrapper, u *models.User, actor *models.JwtActor, expiresIn time.Duration) (signedToken string, err error)
 	// ValidateToken returns a JWT claim based on the supplied JWT wrapper and signed token.
 	ValidateToken(j *models.JwtWrapper, signedToken string) (claims *models.JwtClaim, err error)
 	// GetRole returns the
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"errors"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// MemoryAuditRepo represents an implementation of an audit log repository keeping the log in memory.
+type MemoryAuditRepo struct {
+	Store *Store
+}
+
+// NewMemoryAuditRepo creates a new in-memory audit log repository, keeping the log in the supplied store.
+func NewMemoryAuditRepo(s *Store) repository.Audit {
+	return &MemoryAuditRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryAuditRepo) Append(e *models.AuditEntry) error {
+	return r.Store.write(func(t *tables) error {
+		e.ID = uint(len(t.audit)) + 1
+		e.PrevHash = ""
+		if len(t.audit) > 0 {
+			e.PrevHash = t.audit[len(t.audit)-1].Hash
+		}
+		e.CreatedAt = now().UTC()
+		e.Hash = e.ComputeHash()
+		stored := *e
+		t.audit = append(t.audit, &stored)
+		return nil
+	})
+}
+
+func (r *MemoryAuditRepo) Count(filter *repository.AuditFilter) (count int64, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, e := range t.audit {
+			if auditMatches(e, filter) {
+				count++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+func (r *MemoryAuditRepo) Fetch(seek *repository.PageSeekOptions, filter *repository.AuditFilter) (entries []*models.AuditEntry, err error) {
+	var include func(id uint) bool
+	switch seek.Direction {
+	case repository.SeekDirectionBefore:
+		include = func(id uint) bool { return id < seek.StartId }
+	case repository.SeekDirectionAfter:
+		include = func(id uint) bool { return id > seek.StartId }
+	case repository.SeekDirectionNone:
+		include = func(id uint) bool { return true }
+	default:
+		return nil, errors.New("invalid seek direction")
+	}
+	err = r.Store.read(func(t *tables) error {
+		for _, e := range t.audit {
+			if include(e.ID) && auditMatches(e, filter) {
+				c := *e
+				entries = append(entries, &c)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if seek.RecordLimit > 0 && len(entries) > seek.RecordLimit {
+		if seek.Direction == repository.SeekDirectionBefore {
+			// Take the entries closest to the start id.
+			entries = entries[len(entries)-seek.RecordLimit:]
+		} else {
+			entries = entries[:seek.RecordLimit]
+		}
+	}
+	return entries, nil
+}
+
+// auditMatches determines whether the supplied entry matches the supplied filter.
+func auditMatches(e *models.AuditEntry, filter *repository.AuditFilter) bool {
+	if filter == nil {
+		return true
+	}
+	return (filter.ActorID == 0 || e.ActorID == filter.ActorID) &&
+		(filter.ImpersonatorID == 0 || e.ImpersonatorID == filter.ImpersonatorID) &&
+		(filter.Action == "" || e.Action == filter.Action) &&
+		(filter.EntityType == "" || e.EntityType == filter.EntityType) &&
+		(filter.EntityID == 0 || e.EntityID == filter.EntityID) &&
+		(filter.RequestID == "" || e.RequestID == filter.RequestID) &&
+		(filter.Since.IsZero() || !e.CreatedAt.Before(filter.Since)) &&
+		(filter.Until.IsZero() || e.CreatedAt.Before(filter.Until))
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// MemoryIdempotencyRepo represents an implementation of an idempotency key repository keeping the records in memory.
+type MemoryIdempotencyRepo struct {
+	Store *Store
+}
+
+// NewMemoryIdempotencyRepo creates a new in-memory idempotency key repository, keeping the records in the supplied store.
+func NewMemoryIdempotencyRepo(s *Store) repository.Idempotency {
+	return &MemoryIdempotencyRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryIdempotencyRepo) Reserve(rec *models.IdempotencyRecord) (existing *models.IdempotencyRecord, err error) {
+	err = r.Store.write(func(t *tables) error {
+		current := time.Now()
+		for id, stored := range t.idempotency {
+			if !stored.ExpiresAt.After(current) {
+				delete(t.idempotency, id)
+			}
+		}
+		// Keys are claimed under the store's lock, so of two requests racing for a key, only one claims it.
+		for _, stored := range t.idempotency {
+			if stored.Key == rec.Key && stored.UserID == rec.UserID {
+				c := *stored
+				existing = &c
+				return nil
+			}
+		}
+		rec.ID = t.nextID(idempotencyTable, rec.ID)
+		if rec.CreatedAt.IsZero() {
+			rec.CreatedAt = now()
+		}
+		c := *rec
+		t.idempotency[rec.ID] = &c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (r *MemoryIdempotencyRepo) Complete(rec *models.IdempotencyRecord) error {
+	rec.Completed = true
+	return r.Store.write(func(t *tables) error {
+		stored, ok := t.idempotency[rec.ID]
+		if !ok {
+			return nil
+		}
+		c := *stored
+		c.Completed, c.Status, c.Header, c.Body, c.ExpiresAt = rec.Completed, rec.Status, rec.Header, rec.Body, rec.ExpiresAt
+		t.idempotency[rec.ID] = &c
+		return nil
+	})
+}
+
+func (r *MemoryIdempotencyRepo) Release(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		delete(t.idempotency, id)
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// MemoryIdentityRepo represents an implementation of an external identity repository keeping the links in memory.
+type MemoryIdentityRepo struct {
+	Store *Store
+}
+
+// NewMemoryIdentityRepo creates a new in-memory external identity repository, keeping the links in the supplied store.
+func NewMemoryIdentityRepo(s *Store) repository.ExternalIdentity {
+	return &MemoryIdentityRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryIdentityRepo) GetBySubject(issuer string, subject string) (identity *models.ExternalIdentity, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, stored := range t.identities {
+			if stored.Issuer == issuer && stored.Subject == subject {
+				c := *stored
+				identity = &c
+				return nil
+			}
+		}
+		return gorm.ErrRecordNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+func (r *MemoryIdentityRepo) Create(i *models.ExternalIdentity) (uint, error) {
+	err := r.Store.write(func(t *tables) error {
+		for _, stored := range t.identities {
+			if stored.Issuer == i.Issuer && stored.Subject == i.Subject {
+				return fmt.Errorf("the subject %s of %s is already linked to a user", i.Subject, i.Issuer)
+			}
+		}
+		i.ID = t.nextID(identitiesTable, i.ID)
+		setCreated(&i.Model)
+		c := *i
+		t.identities[i.ID] = &c
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return i.ID, nil
+}
+
+func (r *MemoryIdentityRepo) DeleteByUserID(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		for identityID, stored := range t.identities {
+			if stored.UserID == id {
+				delete(t.identities, identityID)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,300 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// MemoryItemRepo represents an implementation of an Item repository keeping the items in memory.
+type MemoryItemRepo struct {
+	Store *Store
+}
+
+// NewMemoryItemRepo creates a new in-memory item repository, keeping the items in the supplied store.
+func NewMemoryItemRepo(s *Store) repository.Item {
+	return &MemoryItemRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryItemRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
+	items, err := r.seek(seek)
+	if err != nil {
+		return -1, err
+	}
+	return int64(len(items)), nil
+}
+
+func (r *MemoryItemRepo) Fetch(seek *repository.PageSeekOptions) (items []*models.Item, err error) {
+	if items, err = r.seek(seek); err != nil {
+		return nil, err
+	}
+	if seek.RecordLimit > 0 && len(items) > seek.RecordLimit {
+		if seek.Direction == repository.SeekDirectionBefore {
+			// Take the items closest to the start id.
+			items = items[len(items)-seek.RecordLimit:]
+		} else {
+			items = items[:seek.RecordLimit]
+		}
+	}
+	return items, nil
+}
+
+// seek returns the items after (or before) the start id of the supplied seek, in id order. Items are only seeked through by id.
+func (r *MemoryItemRepo) seek(seek *repository.PageSeekOptions) (items []*models.Item, err error) {
+	var include func(id uint) bool
+	switch seek.Direction {
+	case repository.SeekDirectionBefore:
+		include = func(id uint) bool { return id < seek.StartId }
+	case repository.SeekDirectionAfter:
+		include = func(id uint) bool { return id > seek.StartId }
+	case repository.SeekDirectionNone:
+		include = func(id uint) bool { return true }
+	default:
+		return nil, errors.New("invalid seek direction")
+	}
+	err = r.Store.read(func(t *tables) error {
+		for _, item := range sortedItems(t) {
+			if include(item.ID) {
+				items = append(items, copyItem(item))
+			}
+		}
+		return nil
+	})
+	return items, err
+}
+
+func (r *MemoryItemRepo) Exists(id uint) (exists bool, err error) {
+	err = r.Store.read(func(t *tables) error {
+		_, exists = t.items[id]
+		return nil
+	})
+	return exists, err
+}
+
+func (r *MemoryItemRepo) GetByID(id uint) (item *models.Item, err error) {
+	err = r.Store.read(func(t *tables) error {
+		stored, ok := t.items[id]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		item = copyItem(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (r *MemoryItemRepo) GetByOrderID(id uint) ([]*models.Item, error) {
+	byOrder, err := r.GetByOrderIDs([]uint{id})
+	if err != nil {
+		return nil, err
+	}
+	return byOrder[id], nil
+}
+
+func (r *MemoryItemRepo) GetByOrderIDs(ids []uint) (map[uint][]*models.Item, error) {
+	byOrder := make(map[uint][]*models.Item, len(ids))
+	err := r.Store.read(func(t *tables) error {
+		orders := idSet(ids)
+		for _, item := range sortedItems(t) {
+			if orders[item.OrderID] {
+				byOrder[item.OrderID] = append(byOrder[item.OrderID], copyItem(item))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return byOrder, nil
+}
+
+func (r *MemoryItemRepo) GetByProductID(id uint) (items []*models.Item, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, item := range sortedItems(t) {
+			if item.ProductID == id {
+				items = append(items, copyItem(item))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *MemoryItemRepo) Create(i *models.Item) (uint, error) {
+	err := r.Store.write(func(t *tables) error {
+		if _, ok := t.items[i.ID]; ok {
+			return fmt.Errorf("an item with id %d already exists", i.ID)
+		}
+		if err := checkItem(t, i, nil); err != nil {
+			return err
+		}
+		i.ID = t.nextID(itemsTable, i.ID)
+		setCreated(&i.Model)
+		t.items[i.ID] = copyItem(i)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return i.ID, nil
+}
+
+func (r *MemoryItemRepo) CreateAll(items []*models.Item) ([]uint, error) {
+	var ids []uint
+	err := r.Store.write(func(t *tables) error {
+		// Like a single insert, create all of the items or none of them.
+		for n, i := range items {
+			if _, ok := t.items[i.ID]; ok && i.ID != 0 {
+				return fmt.Errorf("an item with id %d already exists", i.ID)
+			}
+			if err := checkItem(t, i, items[:n]); err != nil {
+				return err
+			}
+		}
+		for _, i := range items {
+			i.ID = t.nextID(itemsTable, i.ID)
+			setCreated(&i.Model)
+			t.items[i.ID] = copyItem(i)
+			ids = append(ids, i.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *MemoryItemRepo) Update(i *models.Item, fields []string) (*models.Item, error) {
+	err := r.Store.write(func(t *tables) error {
+		stored, ok := t.items[i.ID]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		updated := copyItem(stored)
+		if err := updateFields(updated, i, fields); err != nil {
+			return err
+		}
+		if err := checkItem(t, updated, nil); err != nil {
+			return err
+		}
+		t.items[i.ID] = updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.GetByID(i.ID)
+}
+
+func (r *MemoryItemRepo) UpdateAll(items []*models.Item, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return r.Store.write(func(t *tables) error {
+		// Like a single update, update all of the items or none of them.
+		updated := make([]*models.Item, 0, len(items))
+		for _, i := range items {
+			stored, ok := t.items[i.ID]
+			if !ok {
+				continue
+			}
+			u := copyItem(stored)
+			if err := updateFields(u, i, fields); err != nil {
+				return err
+			}
+			// Like postgres, each item is checked as it is updated, against the items updated before it and those not updated yet.
+			if err := checkItem(t, u, updated); err != nil {
+				return err
+			}
+			updated = append(updated, u)
+		}
+		for _, u := range updated {
+			t.items[u.ID] = u
+		}
+		return nil
+	})
+}
+
+func (r *MemoryItemRepo) Delete(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		delete(t.items, id)
+		return nil
+	})
+}
+
+func (r *MemoryItemRepo) DeleteAll(ids []uint) error {
+	return r.Store.write(func(t *tables) error {
+		for _, id := range ids {
+			delete(t.items, id)
+		}
+		return nil
+	})
+}
+
+// checkItem returns an error if the supplied item refers to a missing order or product, or to a product its order already has another item of,
+// stored in the supplied tables or among the supplied items about to be written along with it, as the constraints of a database would.
+func checkItem(t *tables, i *models.Item, written []*models.Item) error {
+	if _, ok := t.orders[i.OrderID]; !ok {
+		return fmt.Errorf("item refers to a missing order (id: %d)", i.OrderID)
+	}
+	if _, ok := t.products[i.ProductID]; !ok {
+		return fmt.Errorf("item refers to a missing product (id: %d)", i.ProductID)
+	}
+	duplicate := func(other *models.Item) bool {
+		return other.OrderID == i.OrderID && other.ProductID == i.ProductID
+	}
+	rewritten := make(map[uint]bool, len(written))
+	for _, other := range written {
+		if other != i && duplicate(other) {
+			return fmt.Errorf("order (id: %d) already has an item of product (id: %d)", i.OrderID, i.ProductID)
+		}
+		rewritten[other.ID] = true
+	}
+	for _, other := range t.items {
+		if other.ID != i.ID && !rewritten[other.ID] && duplicate(other) {
+			return fmt.Errorf("order (id: %d) already has an item of product (id: %d)", i.OrderID, i.ProductID)
+		}
+	}
+	return nil
+}
+
+// deleteItems deletes the items in the supplied tables matching the supplied function, as a database deletes the items of a deleted order or product.
+func deleteItems(t *tables, match func(i *models.Item) bool) {
+	for id, i := range t.items {
+		if match(i) {
+			delete(t.items, id)
+		}
+	}
+}
+
+// sortedItems returns the items in the supplied tables, in id order.
+func sortedItems(t *tables) []*models.Item {
+	ids := make([]uint, 0, len(t.items))
+	for id := range t.items {
+		ids = append(ids, id)
+	}
+	items := make([]*models.Item, 0, len(ids))
+	for _, id := range sortIDs(ids) {
+		items = append(items, t.items[id])
+	}
+	return items
+}
+
+// copyItem returns a copy of the supplied item, without its product, which is never stored with it.
+func copyItem(i *models.Item) *models.Item {
+	c := *i
+	c.Product = nil
+	return &c
+}
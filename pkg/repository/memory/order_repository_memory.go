@@ -0,0 +1,201 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// MemoryOrderRepo represents an implementation of an Order repository keeping the orders in memory.
+type MemoryOrderRepo struct {
+	Store *Store
+}
+
+// NewMemoryOrderRepo creates a new in-memory fruit order repository, keeping the orders in the supplied store.
+func NewMemoryOrderRepo(s *Store) repository.Order {
+	return &MemoryOrderRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryOrderRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
+	err = r.Store.read(func(t *tables) error {
+		matched, err := seekRecords(orderRecords(t), seek, repository.OrderFields, false)
+		count = int64(len(matched))
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+func (r *MemoryOrderRepo) Fetch(seek *repository.PageSeekOptions) (orders []*models.Order, err error) {
+	err = r.Store.read(func(t *tables) error {
+		matched, err := seekRecords(orderRecords(t), seek, repository.OrderFields, true)
+		for _, o := range matched {
+			orders = append(orders, copyOrder(o.(*models.Order)))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (r *MemoryOrderRepo) Exists(id uint) (exists bool, err error) {
+	err = r.Store.read(func(t *tables) error {
+		_, exists = t.orders[id]
+		return nil
+	})
+	return exists, err
+}
+
+func (r *MemoryOrderRepo) GetByID(id uint) (o *models.Order, err error) {
+	err = r.Store.read(func(t *tables) error {
+		stored, ok := t.orders[id]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		o = copyOrder(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func (r *MemoryOrderRepo) GetByOwnerID(id uint) (orders []*models.Order, err error) {
+	byOwner, err := r.GetByOwnerIDs([]uint{id})
+	if err != nil {
+		return nil, err
+	}
+	return byOwner[id], nil
+}
+
+func (r *MemoryOrderRepo) GetByOwnerIDs(ids []uint) (map[uint][]*models.Order, error) {
+	byOwner := make(map[uint][]*models.Order, len(ids))
+	err := r.Store.read(func(t *tables) error {
+		owners := idSet(ids)
+		for _, o := range sortedOrders(t) {
+			if o.OwnerID != nil && owners[*o.OwnerID] {
+				byOwner[*o.OwnerID] = append(byOwner[*o.OwnerID], copyOrder(o))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return byOwner, nil
+}
+
+func (r *MemoryOrderRepo) Create(o *models.Order) (orderId uint, itemIds []uint, err error) {
+	err = r.Store.write(func(t *tables) error {
+		if _, ok := t.orders[o.ID]; ok {
+			return fmt.Errorf("an order with id %d already exists", o.ID)
+		}
+		// Like gorm, create the items along with the order, checking them as the database would once the order exists.
+		// The owner isn't checked: a service keeping its records in memory doesn't have the users of the other services.
+		for _, item := range o.Items {
+			if _, ok := t.items[item.ID]; ok && item.ID != 0 {
+				return fmt.Errorf("an item with id %d already exists", item.ID)
+			}
+		}
+		id := t.nextID(ordersTable, o.ID)
+		t.orders[id] = o
+		for n, item := range o.Items {
+			item.OrderID = id
+			if err := checkItem(t, item, o.Items[:n]); err != nil {
+				delete(t.orders, id)
+				return err
+			}
+		}
+		o.ID = id
+		o.Version = 1
+		setCreated(&o.Model)
+		t.orders[o.ID] = copyOrder(o)
+		for _, item := range o.Items {
+			item.ID = t.nextID(itemsTable, item.ID)
+			setCreated(&item.Model)
+			t.items[item.ID] = copyItem(item)
+			itemIds = append(itemIds, item.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, []uint{}, err
+	}
+	return o.ID, itemIds, nil
+}
+
+func (r *MemoryOrderRepo) Update(o *models.Order, fields []string) (*models.Order, error) {
+	// Compare and swap: only update the order if it still has the version it was read with, bumping the version as part of the update.
+	err := r.Store.write(func(t *tables) error {
+		stored, ok := t.orders[o.ID]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		if stored.Version != o.Version {
+			return repository.ErrVersionConflict
+		}
+		updated := copyOrder(stored)
+		if err := updateFields(updated, o, fields); err != nil {
+			return err
+		}
+		updated.Version = o.Version + 1
+		t.orders[o.ID] = updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.Version++
+	return r.GetByID(o.ID)
+}
+
+func (r *MemoryOrderRepo) Delete(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		delete(t.orders, id)
+		deleteItems(t, func(i *models.Item) bool { return i.OrderID == id })
+		return nil
+	})
+}
+
+// orderRecords returns the orders in the supplied tables, as records to seek through.
+func orderRecords(t *tables) []interface{} {
+	records := make([]interface{}, 0, len(t.orders))
+	for _, o := range t.orders {
+		records = append(records, o)
+	}
+	return records
+}
+
+// sortedOrders returns the orders in the supplied tables, in id order.
+func sortedOrders(t *tables) []*models.Order {
+	ids := make([]uint, 0, len(t.orders))
+	for id := range t.orders {
+		ids = append(ids, id)
+	}
+	orders := make([]*models.Order, 0, len(ids))
+	for _, id := range sortIDs(ids) {
+		orders = append(orders, t.orders[id])
+	}
+	return orders
+}
+
+// copyOrder returns a copy of the supplied order, without its items or owner, which are never stored with it.
+func copyOrder(o *models.Order) *models.Order {
+	c := *o
+	if o.OwnerID != nil {
+		ownerID := *o.OwnerID
+		c.OwnerID = &ownerID
+	}
+	c.Items = nil
+	c.Owner = nil
+	return &c
+}
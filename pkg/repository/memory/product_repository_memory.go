@@ -0,0 +1,227 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// MemoryProductRepo represents an implementation of a Product repository keeping the products in memory.
+type MemoryProductRepo struct {
+	Store *Store
+}
+
+// NewMemoryProductRepo creates a new in-memory product repository, keeping the products in the supplied store.
+func NewMemoryProductRepo(s *Store) repository.Product {
+	return &MemoryProductRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryProductRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
+	err = r.Store.read(func(t *tables) error {
+		records, err := productRecords(t, seek.Scope)
+		if err != nil {
+			return err
+		}
+		matched, err := seekRecords(records, seek, repository.ProductFields, false)
+		count = int64(len(matched))
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+func (r *MemoryProductRepo) Fetch(seek *repository.PageSeekOptions) (products []*models.Product, err error) {
+	err = r.Store.read(func(t *tables) error {
+		records, err := productRecords(t, seek.Scope)
+		if err != nil {
+			return err
+		}
+		matched, err := seekRecords(records, seek, repository.ProductFields, true)
+		for _, p := range matched {
+			products = append(products, copyProduct(p.(*models.Product)))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (r *MemoryProductRepo) Exists(id uint) (exists bool, err error) {
+	err = r.Store.read(func(t *tables) error {
+		p, ok := t.products[id]
+		exists = ok && !archived(p)
+		return nil
+	})
+	return exists, err
+}
+
+func (r *MemoryProductRepo) ExistsAll(ids []uint) (missing []uint, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, id := range ids {
+			if p, ok := t.products[id]; !ok || archived(p) {
+				missing = append(missing, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return missing, nil
+}
+
+func (r *MemoryProductRepo) GetByID(id uint) (p *models.Product, err error) {
+	err = r.Store.read(func(t *tables) error {
+		stored, ok := t.products[id]
+		if !ok || archived(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		p = copyProduct(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *MemoryProductRepo) GetByIDs(ids []uint) (products []*models.Product, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, id := range sortIDs(append([]uint{}, ids...)) {
+			if p, ok := t.products[id]; ok && (len(products) == 0 || products[len(products)-1].ID != id) {
+				products = append(products, copyProduct(p))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (r *MemoryProductRepo) Create(p *models.Product) (uint, error) {
+	err := r.Store.write(func(t *tables) error {
+		if _, ok := t.products[p.ID]; ok {
+			return fmt.Errorf("a product with id %d already exists", p.ID)
+		}
+		p.ID = t.nextID(productsTable, p.ID)
+		p.Version = 1
+		setCreated(&p.Model)
+		t.products[p.ID] = copyProduct(p)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return p.ID, nil
+}
+
+func (r *MemoryProductRepo) Update(p *models.Product, fields []string) (*models.Product, error) {
+	// Compare and swap: only update the product if it still has the version it was read with, bumping the version as part of the update.
+	err := r.Store.write(func(t *tables) error {
+		stored, ok := t.products[p.ID]
+		if !ok || archived(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		if stored.Version != p.Version {
+			return repository.ErrVersionConflict
+		}
+		updated := copyProduct(stored)
+		if err := updateFields(updated, p, fields); err != nil {
+			return err
+		}
+		updated.Version = p.Version + 1
+		t.products[p.ID] = updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.Version++
+	return r.GetByID(p.ID)
+}
+
+func (r *MemoryProductRepo) Delete(id uint) error {
+	// Soft delete: items of past orders keep pointing at the product, so it must stay until it is explicitly purged.
+	return r.Store.write(func(t *tables) error {
+		if stored, ok := t.products[id]; ok && !archived(stored) {
+			p := copyProduct(stored)
+			p.DeletedAt = gorm.DeletedAt{Time: now(), Valid: true}
+			t.products[id] = p
+		}
+		return nil
+	})
+}
+
+func (r *MemoryProductRepo) Restore(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		stored, ok := t.products[id]
+		if !ok || !archived(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		p := copyProduct(stored)
+		p.DeletedAt = gorm.DeletedAt{}
+		t.products[id] = p
+		return nil
+	})
+}
+
+func (r *MemoryProductRepo) Purge(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		stored, ok := t.products[id]
+		if !ok || !archived(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		// Like a database, refuse to remove a product items still refer to.
+		for _, i := range t.items {
+			if i.ProductID == id {
+				return fmt.Errorf("product (id: %d) is still referred to by item (id: %d)", id, i.ID)
+			}
+		}
+		delete(t.products, id)
+		return nil
+	})
+}
+
+// productRecords returns the products in the supplied scope of the supplied tables, as records to seek through. (inactive products are the archived ones)
+func productRecords(t *tables, scope string) ([]interface{}, error) {
+	var include func(p *models.Product) bool
+	switch scope {
+	case "", repository.ScopeActive:
+		include = func(p *models.Product) bool { return !archived(p) }
+	case repository.ScopeInactive:
+		include = archived
+	case repository.ScopeAll:
+		include = func(p *models.Product) bool { return true }
+	default:
+		return nil, errors.New("invalid scope")
+	}
+	records := make([]interface{}, 0, len(t.products))
+	for _, p := range t.products {
+		if include(p) {
+			records = append(records, p)
+		}
+	}
+	return records, nil
+}
+
+// archived determines whether the supplied product has been deleted. (archived)
+func archived(p *models.Product) bool {
+	return p.DeletedAt.Valid
+}
+
+// copyProduct returns a copy of the supplied product.
+func copyProduct(p *models.Product) *models.Product {
+	c := *p
+	return &c
+}
@@ -0,0 +1,352 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm/schema"
+)
+
+// Parsed schemas of the models, by type. Models are parsed exactly as gorm parses them, so fields map to the same columns in either storage.
+var schemas sync.Map
+
+// schemaOf returns the schema of the supplied model.
+func schemaOf(model interface{}) (*schema.Schema, error) {
+	return schema.Parse(model, &schemas, schema.NamingStrategy{})
+}
+
+// columnValue returns the value of the field stored in the supplied column of the supplied record.
+func columnValue(s *schema.Schema, record reflect.Value, column string) (interface{}, error) {
+	field := s.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("unknown column '%s'", column)
+	}
+	return field.ReflectValueOf(record).Interface(), nil
+}
+
+// seekRecords returns the records matching the filters of the supplied seek options, that come after (or before) the start of the seek,
+// in the order set by its sort keys and then by id. The supplied records must be pointers to models of the same type.
+// When fetching, only a page of records is returned, closest to the start of the seek, with only the selected fields read.
+func seekRecords(records []interface{}, seek *repository.PageSeekOptions, fields map[string]repository.Field, fetch bool) ([]interface{}, error) {
+	if len(records) == 0 {
+		if seek.Direction != repository.SeekDirectionNone && seek.Direction != repository.SeekDirectionAfter && seek.Direction != repository.SeekDirectionBefore {
+			return nil, errors.New("invalid seek direction")
+		}
+		return records, nil
+	}
+	s, err := schemaOf(records[0])
+	if err != nil {
+		return nil, err
+	}
+	columns, descending, err := sortColumns(seek.Sort, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	type keyed struct {
+		record interface{}
+		keys   []interface{}
+	}
+	var matched []keyed
+	for _, record := range records {
+		v := reflect.ValueOf(record).Elem()
+		ok, err := matchesFilters(s, v, seek.Filters, fields)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		keys := make([]interface{}, len(columns))
+		for i, column := range columns {
+			if keys[i], err = columnValue(s, v, column); err != nil {
+				return nil, err
+			}
+		}
+		matched = append(matched, keyed{record: record, keys: keys})
+	}
+
+	if seek.Direction != repository.SeekDirectionNone {
+		if seek.Direction != repository.SeekDirectionAfter && seek.Direction != repository.SeekDirectionBefore {
+			return nil, errors.New("invalid seek direction")
+		}
+		if len(seek.StartKeys) != len(seek.Sort) {
+			return nil, fmt.Errorf("expected %d sort key values to seek from, got %d", len(seek.Sort), len(seek.StartKeys))
+		}
+		start := append(append([]interface{}{}, seek.StartKeys...), seek.StartId)
+		var after []keyed
+		for _, m := range matched {
+			c, err := compareKeys(m.keys, start, descending)
+			if err != nil {
+				return nil, err
+			}
+			if (seek.Direction == repository.SeekDirectionAfter && c > 0) || (seek.Direction == repository.SeekDirectionBefore && c < 0) {
+				after = append(after, m)
+			}
+		}
+		matched = after
+	}
+
+	var sortErr error
+	sort.SliceStable(matched, func(i, j int) bool {
+		c, err := compareKeys(matched[i].keys, matched[j].keys, descending)
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return c < 0
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	if fetch && seek.RecordLimit > 0 && len(matched) > seek.RecordLimit {
+		if seek.Direction == repository.SeekDirectionBefore {
+			// Take the records closest to the start of the seek.
+			matched = matched[len(matched)-seek.RecordLimit:]
+		} else {
+			matched = matched[:seek.RecordLimit]
+		}
+	}
+	result := make([]interface{}, len(matched))
+	for i, m := range matched {
+		result[i] = m.record
+		if fetch {
+			if result[i], err = selectFields(s, m.record, seek, fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// matchesFilters determines whether the supplied record matches all of the supplied filters.
+func matchesFilters(s *schema.Schema, record reflect.Value, filters []repository.Filter, fields map[string]repository.Field) (bool, error) {
+	for _, f := range filters {
+		field, ok := fields[f.Field]
+		if !ok {
+			return false, fmt.Errorf("cannot filter by unknown field '%s'", f.Field)
+		}
+		value, err := columnValue(s, record, field.Column)
+		if err != nil {
+			return false, err
+		}
+		if normalize(value) == nil {
+			// Like a null in a database, a missing value matches no filter.
+			return false, nil
+		}
+		if f.Operator == repository.FilterOpContains {
+			text, ok := value.(string)
+			contained, isText := f.Value.(string)
+			if !ok || !isText {
+				return false, fmt.Errorf("cannot filter by '%s' containing a non-text value", f.Field)
+			}
+			if !strings.Contains(strings.ToLower(text), strings.ToLower(contained)) {
+				return false, nil
+			}
+			continue
+		}
+		c, err := compare(value, f.Value)
+		if err != nil {
+			return false, err
+		}
+		var match bool
+		switch f.Operator {
+		case repository.FilterOpEqual:
+			match = c == 0
+		case repository.FilterOpNotEqual:
+			match = c != 0
+		case repository.FilterOpGreater:
+			match = c > 0
+		case repository.FilterOpGreaterOrEqual:
+			match = c >= 0
+		case repository.FilterOpLess:
+			match = c < 0
+		case repository.FilterOpLessOrEqual:
+			match = c <= 0
+		default:
+			return false, fmt.Errorf("invalid filter operator '%s'", f.Operator)
+		}
+		if !match {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// selectFields returns a copy of the supplied record holding only the fields selected by the supplied seek, along with the id and sort keys.
+// Returns the record itself if the seek selects no fields.
+func selectFields(s *schema.Schema, record interface{}, seek *repository.PageSeekOptions, fields map[string]repository.Field) (interface{}, error) {
+	if len(seek.Fields) == 0 {
+		return record, nil
+	}
+	columns := []string{"id"}
+	names := append([]string{}, seek.Fields...)
+	for _, sort := range seek.Sort {
+		names = append(names, sort.Field)
+	}
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot select unknown field '%s'", name)
+		}
+		columns = append(columns, field.Column)
+	}
+	src := reflect.ValueOf(record).Elem()
+	dst := reflect.New(src.Type())
+	for _, column := range columns {
+		field := s.LookUpField(column)
+		if field == nil {
+			return nil, fmt.Errorf("unknown column '%s'", column)
+		}
+		field.ReflectValueOf(dst.Elem()).Set(field.ReflectValueOf(src))
+	}
+	return dst.Interface(), nil
+}
+
+// sortColumns returns the columns of the supplied sort keys followed by the id column, and whether each is sorted descending.
+func sortColumns(sorts []repository.Sort, fields map[string]repository.Field) ([]string, []bool, error) {
+	columns := make([]string, 0, len(sorts)+1)
+	descending := make([]bool, 0, len(sorts)+1)
+	for _, s := range sorts {
+		field, ok := fields[s.Field]
+		if !ok {
+			return nil, nil, fmt.Errorf("cannot sort by unknown field '%s'", s.Field)
+		}
+		columns = append(columns, field.Column)
+		descending = append(descending, s.Descending)
+	}
+	return append(columns, "id"), append(descending, false), nil
+}
+
+// compareKeys compares the supplied sort key values in order of precedence, each in the supplied direction.
+// Returns a negative number if a comes first, a positive number if b comes first, and zero if they are the same.
+func compareKeys(a []interface{}, b []interface{}, descending []bool) (int, error) {
+	for i := range a {
+		c, err := compare(a[i], b[i])
+		if err != nil {
+			return 0, err
+		}
+		if descending[i] {
+			c = -c
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return 0, nil
+}
+
+// compare compares the supplied values of a field: numbers, text, booleans or times, any of which may be missing. (nil)
+// Returns a negative number if a is less than b, a positive number if it is greater, and zero if they are equal.
+// Missing values are greater than any other, as postgres sorts nulls.
+func compare(a interface{}, b interface{}) (int, error) {
+	a, b = normalize(a), normalize(b)
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return 1, nil
+	case b == nil:
+		return -1, nil
+	}
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case y:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1, nil
+			case x.After(y):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+// normalize converts every kind of number to a float64, so numbers of different types can be compared.
+// Pointers are replaced by the values they point to, or nil.
+func normalize(v interface{}) interface{} {
+	if _, ok := v.(time.Time); ok {
+		return v
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		return normalize(rv.Elem().Interface())
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	default:
+		return v
+	}
+}
+
+// updateFields copies the supplied fields of the supplied record onto the supplied stored record, as a gorm update would:
+// fields are named by column or by Go field name, and unknown names are ignored. With no fields, every field set to a non-zero value is copied.
+// The update time is always set.
+func updateFields(stored interface{}, record interface{}, fields []string) error {
+	s, err := schemaOf(record)
+	if err != nil {
+		return err
+	}
+	dst := reflect.ValueOf(stored).Elem()
+	src := reflect.ValueOf(record).Elem()
+	if len(fields) > 0 {
+		for _, name := range fields {
+			if field := s.LookUpField(name); field != nil && !field.PrimaryKey && field.Updatable {
+				field.ReflectValueOf(dst).Set(field.ReflectValueOf(src))
+			}
+		}
+	} else {
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.PrimaryKey || !field.Updatable {
+				continue
+			}
+			if _, zero := field.ValueOf(src); !zero {
+				field.ReflectValueOf(dst).Set(field.ReflectValueOf(src))
+			}
+		}
+	}
+	if field := s.LookUpField("updated_at"); field != nil {
+		field.ReflectValueOf(dst).Set(reflect.ValueOf(now()))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/repositorytest"
+)
+
+// newRepositories returns in-memory repositories with a store of their own.
+func newRepositories(t *testing.T) *repository.Repositories {
+	return NewMemoryRepositories(NewStore())
+}
+
+func TestProductRepository(t *testing.T) {
+	repositorytest.TestProductRepository(t, newRepositories)
+}
+
+func TestOrderRepository(t *testing.T) {
+	repositorytest.TestOrderRepository(t, newRepositories)
+}
+
+func TestItemRepository(t *testing.T) {
+	repositorytest.TestItemRepository(t, newRepositories)
+}
+
+func TestUserRepository(t *testing.T) {
+	repositorytest.TestUserRepository(t, newRepositories)
+}
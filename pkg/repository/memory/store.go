@@ -0,0 +1,178 @@
+// Package memory provides implementations of the repositories of every type of record that keep them in memory, for tests and local development.
+// Records are lost when the process exits.
+package memory
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// Store holds the records of every in-memory repository created from it. It is safe for concurrent use.
+type Store struct {
+	mu     sync.RWMutex
+	tables *tables
+}
+
+// tables holds the records of each type, by id. Stored records are never changed in place, only replaced, so tables can be copied cheaply.
+type tables struct {
+	orders      map[uint]*models.Order
+	items       map[uint]*models.Item
+	products    map[uint]*models.Product
+	users       map[uint]*models.User
+	identities  map[uint]*models.ExternalIdentity
+	idempotency map[uint]*models.IdempotencyRecord
+	// Audit log entries, in the order they were appended. The id of each entry is its position plus one.
+	audit []*models.AuditEntry
+	// Last id given to a record of each table, so ids are never reused, like those of a postgres sequence.
+	lastIDs map[string]uint
+}
+
+// Names of the tables, for the ids given to their records.
+const (
+	ordersTable      = "orders"
+	itemsTable       = "items"
+	productsTable    = "products"
+	usersTable       = "users"
+	identitiesTable  = "identities"
+	idempotencyTable = "idempotency"
+)
+
+// NewStore creates a new, empty store.
+func NewStore() *Store {
+	return &Store{
+		tables: &tables{
+			orders:      make(map[uint]*models.Order),
+			items:       make(map[uint]*models.Item),
+			products:    make(map[uint]*models.Product),
+			users:       make(map[uint]*models.User),
+			identities:  make(map[uint]*models.ExternalIdentity),
+			idempotency: make(map[uint]*models.IdempotencyRecord),
+			lastIDs:     make(map[string]uint),
+		},
+	}
+}
+
+// NewMemoryRepositories creates the in-memory repositories of every type of record, all keeping their records in the supplied store.
+func NewMemoryRepositories(s *Store) *repository.Repositories {
+	return &repository.Repositories{
+		Orders:       NewMemoryOrderRepo(s),
+		Items:        NewMemoryItemRepo(s),
+		Products:     NewMemoryProductRepo(s),
+		Users:        NewMemoryUserRepo(s),
+		Identities:   NewMemoryIdentityRepo(s),
+		Audit:        NewMemoryAuditRepo(s),
+		Idempotency:  NewMemoryIdempotencyRepo(s),
+		Transactions: s,
+	}
+}
+
+// Transaction runs the supplied function with repositories working on a copy of the store's records, which replaces them if the function returns nil.
+// Transactions are serializable: every other operation on the store waits for the transaction to end,
+// so the function must only use the repositories it is supplied, never those of the store itself.
+func (s *Store) Transaction(fn func(tx *repository.Repositories) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &Store{tables: s.tables.copy()}
+	if err := fn(NewMemoryRepositories(tx)); err != nil {
+		return err
+	}
+	s.tables = tx.tables
+	return nil
+}
+
+// read runs the supplied function on the store's records, along with any other reads.
+func (s *Store) read(fn func(t *tables) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.tables)
+}
+
+// write runs the supplied function on the store's records, alone.
+func (s *Store) write(fn func(t *tables) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.tables)
+}
+
+// copy returns a copy of the tables, sharing the records.
+func (t *tables) copy() *tables {
+	c := &tables{
+		orders:      make(map[uint]*models.Order, len(t.orders)),
+		items:       make(map[uint]*models.Item, len(t.items)),
+		products:    make(map[uint]*models.Product, len(t.products)),
+		users:       make(map[uint]*models.User, len(t.users)),
+		identities:  make(map[uint]*models.ExternalIdentity, len(t.identities)),
+		idempotency: make(map[uint]*models.IdempotencyRecord, len(t.idempotency)),
+		audit:       append([]*models.AuditEntry{}, t.audit...),
+		lastIDs:     make(map[string]uint, len(t.lastIDs)),
+	}
+	for id, o := range t.orders {
+		c.orders[id] = o
+	}
+	for id, i := range t.items {
+		c.items[id] = i
+	}
+	for id, p := range t.products {
+		c.products[id] = p
+	}
+	for id, u := range t.users {
+		c.users[id] = u
+	}
+	for id, i := range t.identities {
+		c.identities[id] = i
+	}
+	for id, rec := range t.idempotency {
+		c.idempotency[id] = rec
+	}
+	for table, id := range t.lastIDs {
+		c.lastIDs[table] = id
+	}
+	return c
+}
+
+// nextID returns the id of a new record of the supplied table, or the supplied id if it is set.
+func (t *tables) nextID(table string, id uint) uint {
+	if id == 0 {
+		id = t.lastIDs[table] + 1
+	}
+	if id > t.lastIDs[table] {
+		t.lastIDs[table] = id
+	}
+	return id
+}
+
+// setCreated sets the creation and update times of a new record, unless they are already set.
+func setCreated(m *gorm.Model) {
+	t := now()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = t
+	}
+	if m.UpdatedAt.IsZero() {
+		m.UpdatedAt = t
+	}
+}
+
+// sortIDs sorts the supplied ids in place, and returns them.
+func sortIDs(ids []uint) []uint {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// idSet returns the supplied ids as a set.
+func idSet(ids []uint) map[uint]bool {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
+
+// now returns the current time, as precise as postgres stores it, so records read back from either storage compare the same.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
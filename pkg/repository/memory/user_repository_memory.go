@@ -0,0 +1,276 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Cost of hashing passwords. In-memory users never outlive the process, so they are hashed as cheaply as bcrypt allows, to keep tests fast.
+const passwordHashCost = bcrypt.MinCost
+
+// MemoryUserRepo represents an implementation of a user account repository keeping the users in memory.
+type MemoryUserRepo struct {
+	Store *Store
+}
+
+// NewMemoryUserRepo creates a new in-memory user account repository, keeping the users in the supplied store.
+func NewMemoryUserRepo(s *Store) repository.User {
+	return &MemoryUserRepo{
+		Store: s,
+	}
+}
+
+func (r *MemoryUserRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
+	err = r.Store.read(func(t *tables) error {
+		records, err := userRecords(t, seek.Scope)
+		if err != nil {
+			return err
+		}
+		matched, err := seekRecords(records, seek, repository.UserFields, false)
+		count = int64(len(matched))
+		return err
+	})
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+func (r *MemoryUserRepo) Fetch(seek *repository.PageSeekOptions) (users []*models.User, err error) {
+	err = r.Store.read(func(t *tables) error {
+		records, err := userRecords(t, seek.Scope)
+		if err != nil {
+			return err
+		}
+		matched, err := seekRecords(records, seek, repository.UserFields, true)
+		for _, u := range matched {
+			users = append(users, copyUser(u.(*models.User)))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepo) Exists(id uint) (exists bool, err error) {
+	err = r.Store.read(func(t *tables) error {
+		u, ok := t.users[id]
+		exists = ok && !deleted(u)
+		return nil
+	})
+	return exists, err
+}
+
+func (r *MemoryUserRepo) GetByID(id uint) (u *models.User, err error) {
+	err = r.Store.read(func(t *tables) error {
+		stored, ok := t.users[id]
+		if !ok || deleted(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		u = copyUser(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *MemoryUserRepo) GetByIDUnscoped(id uint) (u *models.User, err error) {
+	err = r.Store.read(func(t *tables) error {
+		stored, ok := t.users[id]
+		if !ok {
+			return gorm.ErrRecordNotFound
+		}
+		u = copyUser(stored)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *MemoryUserRepo) GetByIDs(ids []uint) (users []*models.User, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, id := range sortIDs(append([]uint{}, ids...)) {
+			if u, ok := t.users[id]; ok && !deleted(u) && (len(users) == 0 || users[len(users)-1].ID != id) {
+				users = append(users, copyUser(u))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepo) GetByUsername(uname string) (u *models.User, err error) {
+	err = r.Store.read(func(t *tables) error {
+		for _, stored := range sortedUsers(t) {
+			if stored.Name == uname && !deleted(stored) {
+				u = copyUser(stored)
+				return nil
+			}
+		}
+		return gorm.ErrRecordNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *MemoryUserRepo) Create(u *models.User) (uint, error) {
+	err := r.Store.write(func(t *tables) error {
+		if _, ok := t.users[u.ID]; ok {
+			return fmt.Errorf("a user with id %d already exists", u.ID)
+		}
+		u.ID = t.nextID(usersTable, u.ID)
+		u.Version = 1
+		setCreated(&u.Model)
+		t.users[u.ID] = copyUser(u)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return u.ID, nil
+}
+
+func (r *MemoryUserRepo) Update(u *models.User, fields []string) (*models.User, error) {
+	// Compare and swap: only update the user if it still has the version it was read with, bumping the version as part of the update.
+	err := r.Store.write(func(t *tables) error {
+		stored, ok := t.users[u.ID]
+		if !ok || deleted(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		if stored.Version != u.Version {
+			return repository.ErrVersionConflict
+		}
+		updated := copyUser(stored)
+		if err := updateFields(updated, u, fields); err != nil {
+			return err
+		}
+		updated.Version = u.Version + 1
+		t.users[u.ID] = updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	u.Version++
+	return r.GetByID(u.ID)
+}
+
+func (r *MemoryUserRepo) Delete(id uint) error {
+	// Soft delete: orders keep pointing at the user, so it must stay until it is explicitly purged.
+	return r.Store.write(func(t *tables) error {
+		if stored, ok := t.users[id]; ok && !deleted(stored) {
+			u := copyUser(stored)
+			u.DeletedAt = gorm.DeletedAt{Time: now(), Valid: true}
+			t.users[id] = u
+		}
+		return nil
+	})
+}
+
+func (r *MemoryUserRepo) Restore(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		stored, ok := t.users[id]
+		if !ok || !deleted(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		u := copyUser(stored)
+		u.DeletedAt = gorm.DeletedAt{}
+		t.users[id] = u
+		return nil
+	})
+}
+
+func (r *MemoryUserRepo) Purge(id uint) error {
+	return r.Store.write(func(t *tables) error {
+		stored, ok := t.users[id]
+		if !ok || !deleted(stored) {
+			return gorm.ErrRecordNotFound
+		}
+		delete(t.users, id)
+		// Like a database, detach the user's orders from them.
+		for orderID, o := range t.orders {
+			if o.OwnerID != nil && *o.OwnerID == id {
+				detached := copyOrder(o)
+				detached.OwnerID = nil
+				t.orders[orderID] = detached
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryUserRepo) HashPassword(u *models.User, pass string) error {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(pass), passwordHashCost)
+	if err != nil {
+		return err
+	}
+	u.Password = string(bytes)
+	return nil
+}
+
+func (r *MemoryUserRepo) CheckPassword(u *models.User, rawPass string) error {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(rawPass))
+}
+
+// userRecords returns the users in the supplied scope of the supplied tables, as records to seek through.
+func userRecords(t *tables, scope string) ([]interface{}, error) {
+	var include func(u *models.User) bool
+	switch scope {
+	case "", repository.ScopeActive:
+		include = func(u *models.User) bool { return !deleted(u) }
+	case repository.ScopeInactive:
+		include = deleted
+	case repository.ScopeAll:
+		include = func(u *models.User) bool { return true }
+	default:
+		return nil, errors.New("invalid scope")
+	}
+	records := make([]interface{}, 0, len(t.users))
+	for _, u := range t.users {
+		if include(u) {
+			records = append(records, u)
+		}
+	}
+	return records, nil
+}
+
+// sortedUsers returns the users in the supplied tables, deactivated or not, in id order.
+func sortedUsers(t *tables) []*models.User {
+	ids := make([]uint, 0, len(t.users))
+	for id := range t.users {
+		ids = append(ids, id)
+	}
+	users := make([]*models.User, 0, len(ids))
+	for _, id := range sortIDs(ids) {
+		users = append(users, t.users[id])
+	}
+	return users
+}
+
+// deleted determines whether the supplied user has been deleted. (deactivated)
+func deleted(u *models.User) bool {
+	return u.DeletedAt.Valid
+}
+
+// copyUser returns a copy of the supplied user, without its orders, which are never stored with it.
+func copyUser(u *models.User) *models.User {
+	c := *u
+	c.Orders = nil
+	return &c
+}
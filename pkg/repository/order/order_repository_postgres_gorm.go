@@ -2,11 +2,11 @@
 package order
 
 import (
-	"errors"
-
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/query"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PostgresOrderRepo represents an implementation of an Order repository using postgres.
@@ -22,17 +22,14 @@ func NewPostgresOrderRepo(db *gorm.DB) repository.Order {
 }
 
 func (r *PostgresOrderRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
-	var result *gorm.DB
-	switch seek.Direction {
-	case repository.SeekDirectionBefore:
-		result = r.DB.Model(&models.Order{}).Where("ID < ?", seek.StartId).Count(&count)
-	case repository.SeekDirectionAfter:
-		result = r.DB.Model(&models.Order{}).Where("ID > ?", seek.StartId).Count(&count)
-	case repository.SeekDirectionNone:
-		result = r.DB.Model(&models.Order{}).Count(&count)
-	default:
-		return -1, errors.New("invalid seek direction")
+	db, err := query.Filter(r.DB, seek.Filters, repository.OrderFields)
+	if err != nil {
+		return -1, err
+	}
+	if db, err = query.Seek(db, seek, repository.OrderFields); err != nil {
+		return -1, err
 	}
+	result := db.Model(&models.Order{}).Count(&count)
 	if result.Error != nil {
 		return -1, result.Error
 	}
@@ -40,20 +37,29 @@ func (r *PostgresOrderRepo) Count(seek *repository.PageSeekOptions) (count int64
 }
 
 func (r *PostgresOrderRepo) Fetch(seek *repository.PageSeekOptions) (orders []*models.Order, err error) {
-	var result *gorm.DB
-	if seek.Direction == repository.SeekDirectionBefore {
-		result = r.DB.Limit(int(seek.RecordLimit)).Where("ID < ?", seek.StartId).Find(&orders)
-	} else if seek.Direction == repository.SeekDirectionAfter {
-		result = r.DB.Limit(int(seek.RecordLimit)).Where("ID > ?", seek.StartId).Find(&orders)
-	} else if seek.Direction == repository.SeekDirectionNone {
-		result = r.DB.Limit(int(seek.RecordLimit)).Find(&orders)
-	} else {
-		return nil, errors.New("invalid seek direction")
+	db, err := query.Filter(r.DB, seek.Filters, repository.OrderFields)
+	if err != nil {
+		return nil, err
 	}
-
+	if db, err = query.Seek(db, seek, repository.OrderFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Order(db, seek, repository.OrderFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Select(db, seek, repository.OrderFields); err != nil {
+		return nil, err
+	}
+	result := db.Limit(seek.RecordLimit).Find(&orders)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	if seek.Direction == repository.SeekDirectionBefore {
+		// Put the orders closest to the start of the seek back in sort order.
+		for i, j := 0, len(orders)-1; i < j; i, j = i+1, j-1 {
+			orders[i], orders[j] = orders[j], orders[i]
+		}
+	}
 	return orders, nil
 }
 
@@ -74,8 +80,32 @@ func (r *PostgresOrderRepo) GetByID(id uint) (*models.Order, error) {
 	return &o, nil
 }
 
+func (r *PostgresOrderRepo) GetByOwnerID(id uint) ([]*models.Order, error) {
+	var orders []*models.Order
+	result := r.DB.Where("owner_id = ?", id).Find(&orders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return orders, nil
+}
+
+func (r *PostgresOrderRepo) GetByOwnerIDs(ids []uint) (map[uint][]*models.Order, error) {
+	var orders []*models.Order
+	result := r.DB.Where("owner_id IN ?", ids).Order("id asc").Find(&orders)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	byOwner := make(map[uint][]*models.Order, len(ids))
+	for _, o := range orders {
+		byOwner[*o.OwnerID] = append(byOwner[*o.OwnerID], o)
+	}
+	return byOwner, nil
+}
+
 func (r *PostgresOrderRepo) Create(o *models.Order) (orderId uint, itemIds []uint, err error) {
-	result := r.DB.Create(&o)
+	o.Version = 1
+	// The items are created along with the order, and given its id. Its owner and the items' products are only referred to.
+	result := r.DB.Omit("Owner", "Items.Product").Create(&o)
 	if result.Error != nil {
 		return 0, []uint{}, result.Error
 	}
@@ -85,27 +115,29 @@ func (r *PostgresOrderRepo) Create(o *models.Order) (orderId uint, itemIds []uin
 	return o.ID, itemIds, nil
 }
 
-func (r *PostgresOrderRepo) Update(o *models.Order, fields []string) (update *models.Order, err error) {
-	_, err = r.GetByID(o.ID)
-	if err != nil {
-		return nil, err
-	}
+func (r *PostgresOrderRepo) Update(o *models.Order, fields []string) (*models.Order, error) {
+	// Compare and swap: only update the order if it still has the version it was read with, bumping the version as part of the update.
+	expected := o.Version
+	o.Version = expected + 1
+	// The items of the order are written through the item repository.
+	db := r.DB.Model(o).Omit(clause.Associations).Where("version = ?", expected)
 	if len(fields) > 0 { // Partial update
-		result := r.DB.Model(o).Select(fields).Updates(o)
-		if result.Error != nil {
-			return nil, err
-		}
-	} else { // Full update
-		result := r.DB.Model(o).Updates(o)
-		if result.Error != nil {
+		db = db.Select(append(append([]string{}, fields...), "version"))
+	}
+	result := db.Updates(o)
+	if result.Error != nil {
+		o.Version = expected
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		o.Version = expected
+		// Either the order is gone, or someone else updated it first.
+		if _, err := r.GetByID(o.ID); err != nil {
 			return nil, err
 		}
+		return nil, repository.ErrVersionConflict
 	}
-	update, err = r.GetByID(o.ID)
-	if err != nil {
-		return nil, err
-	}
-	return update, nil
+	return r.GetByID(o.ID)
 }
 
 func (r *PostgresOrderRepo) Delete(id uint) error {
@@ -14,9 +14,14 @@ type Order interface {
 	Exists(id uint) (bool, error)
 	// GetByID returns the order with the supplied id, if it exists.
 	GetByID(id uint) (*models.Order, error)
+	// GetByOwnerID returns all of the orders owned by the user with the supplied id.
+	GetByOwnerID(id uint) ([]*models.Order, error)
+	// GetByOwnerIDs returns all of the orders owned by any of the users with the supplied ids, by owner id, in a single query.
+	GetByOwnerIDs(ids []uint) (map[uint][]*models.Order, error)
 	// Create creates a new order and returns the ID of the newly created product.
 	Create(u *models.Order) (orderId uint, itemIds []uint, err error)
 	// Update updates an existing order in the repository. Returns the updated order.
+	// The update only happens if the stored order still has the supplied order's version, otherwise ErrVersionConflict is returned. The version is bumped by the update.
 	Update(u *models.Order, fields []string) (*models.Order, error)
 	// Delete removes an order with the supplied id from the repository.
 	Delete(id uint) error
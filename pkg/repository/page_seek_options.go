@@ -6,8 +6,19 @@ type PageSeekOptions struct {
 	RecordLimit int `json:"limit"`
 	// The ID to begin the seek operation from.
 	StartId uint `json:"startid"`
+	// The sort key values of the record to begin the seek operation from, one for each of Sort.
+	StartKeys []interface{} `json:"startkeys"`
 	// The direction to move away from the starting Id.
 	Direction string `json:"direction"`
+	// Which records to include based on whether they have been deleted (deactivated). Empty means active records only.
+	Scope string `json:"scope"`
+	// Conditions records must all match to be counted or returned.
+	Filters []Filter `json:"filters"`
+	// Fields to read from the returned records; the others are left empty. Empty reads every field.
+	// The ID and the sort keys are always read, so the records can be paged through.
+	Fields []string `json:"fields"`
+	// Keys to order the returned records by, in order of precedence. Records are always ordered by ID last.
+	Sort []Sort `json:"sort"`
 }
 
 const (
@@ -15,3 +26,9 @@ const (
 	SeekDirectionBefore = "before"
 	SeekDirectionNone   = "none"
 )
+
+const (
+	ScopeActive   = "active"
+	ScopeInactive = "inactive"
+	ScopeAll      = "all"
+)
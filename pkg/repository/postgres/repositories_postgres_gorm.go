@@ -0,0 +1,46 @@
+// Package postgres provides the repositories of every type of record kept in postgres.
+package postgres
+
+import (
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/audit"
+	"github.com/tragicpixel/fruitbar/pkg/repository/idempotency"
+	"github.com/tragicpixel/fruitbar/pkg/repository/identity"
+	"github.com/tragicpixel/fruitbar/pkg/repository/item"
+	"github.com/tragicpixel/fruitbar/pkg/repository/order"
+	"github.com/tragicpixel/fruitbar/pkg/repository/product"
+	"github.com/tragicpixel/fruitbar/pkg/repository/user"
+	"gorm.io/gorm"
+)
+
+// NewPostgresRepositories creates the postgres repositories of every type of record, all using the supplied database session.
+func NewPostgresRepositories(db *gorm.DB) *repository.Repositories {
+	return &repository.Repositories{
+		Orders:       order.NewPostgresOrderRepo(db),
+		Items:        item.NewPostgresItemRepo(db),
+		Products:     product.NewPostgresProductRepo(db),
+		Users:        user.NewPostgresUserRepo(db),
+		Identities:   identity.NewPostgresIdentityRepo(db),
+		Audit:        audit.NewPostgresAuditRepo(db),
+		Idempotency:  idempotency.NewPostgresIdempotencyRepo(db),
+		Transactions: NewPostgresTransactor(db),
+	}
+}
+
+// PostgresTransactor represents an implementation of a transactor using postgres transactions.
+type PostgresTransactor struct {
+	DB *gorm.DB
+}
+
+// NewPostgresTransactor creates a new postgres transactor.
+func NewPostgresTransactor(db *gorm.DB) repository.Transactor {
+	return &PostgresTransactor{
+		DB: db,
+	}
+}
+
+func (t *PostgresTransactor) Transaction(fn func(tx *repository.Repositories) error) error {
+	return t.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(NewPostgresRepositories(tx))
+	})
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+
+	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/repositorytest"
+)
+
+// Name of environment variable containing the name of the database to run the tests in. Every test drops and re-creates the tables,
+// so it must be a database of its own. It is connected to with the other FRUITBAR_DB_* environment variables, and the tests are skipped if it isn't set.
+const testDatabaseEnv = "FRUITBAR_TEST_DB_DATABASE"
+
+// newTestRepositories returns a function creating postgres repositories for a single test, on empty tables of the test database.
+func newTestRepositories(t *testing.T) repositorytest.NewRepositories {
+	database := os.Getenv(testDatabaseEnv)
+	if database == "" {
+		t.Skipf("set %s to run the postgres repository tests", testDatabaseEnv)
+	}
+	os.Setenv("FRUITBAR_DB_DATABASE", database)
+	config, err := pgdriver.NewPostgresConnectionConfigFromEnv()
+	if err != nil {
+		t.Fatalf("failed to configure the test database connection: %s", err.Error())
+	}
+	db, err := pgdriver.OpenConnection(config)
+	if err != nil {
+		t.Fatalf("failed to connect to the test database: %s", err.Error())
+	}
+	return func(t *testing.T) *repository.Repositories {
+		for _, model := range []interface{}{&models.User{}, &models.Product{}, &models.Order{}, &models.Item{}} {
+			if err := db.Postgres.Migrator().DropTable(model); err != nil {
+				t.Fatalf("failed to drop the table of %T: %s", model, err.Error())
+			}
+			if err := pgdriver.SetupTables(db, model, true); err != nil {
+				t.Fatalf("failed to create the table of %T: %s", model, err.Error())
+			}
+		}
+		return NewPostgresRepositories(db.Postgres)
+	}
+}
+
+func TestOrderRepository(t *testing.T) {
+	repositorytest.TestOrderRepository(t, newTestRepositories(t))
+}
+
+func TestItemRepository(t *testing.T) {
+	repositorytest.TestItemRepository(t, newTestRepositories(t))
+}
+
+func TestProductRepository(t *testing.T) {
+	repositorytest.TestProductRepository(t, newTestRepositories(t))
+}
+
+func TestUserRepository(t *testing.T) {
+	repositorytest.TestUserRepository(t, newTestRepositories(t))
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/query"
 	"gorm.io/gorm"
 )
 
@@ -22,17 +23,17 @@ func NewPostgresProductRepo(db *gorm.DB) repository.Product {
 }
 
 func (r *PostgresProductRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
-	var result *gorm.DB
-	switch seek.Direction {
-	case repository.SeekDirectionBefore:
-		result = r.DB.Model(&models.Product{}).Where("ID < ?", seek.StartId).Count(&count)
-	case repository.SeekDirectionAfter:
-		result = r.DB.Model(&models.Product{}).Where("ID > ?", seek.StartId).Count(&count)
-	case repository.SeekDirectionNone:
-		result = r.DB.Model(&models.Product{}).Count(&count)
-	default:
-		return -1, errors.New("invalid seek direction")
+	db, err := r.scoped(seek.Scope)
+	if err != nil {
+		return -1, err
+	}
+	if db, err = query.Filter(db, seek.Filters, repository.ProductFields); err != nil {
+		return -1, err
+	}
+	if db, err = query.Seek(db, seek, repository.ProductFields); err != nil {
+		return -1, err
 	}
+	result := db.Model(&models.Product{}).Count(&count)
 	if result.Error != nil {
 		return -1, result.Error
 	}
@@ -40,20 +41,32 @@ func (r *PostgresProductRepo) Count(seek *repository.PageSeekOptions) (count int
 }
 
 func (r *PostgresProductRepo) Fetch(seek *repository.PageSeekOptions) (products []*models.Product, err error) {
-	var result *gorm.DB
-	switch seek.Direction {
-	case repository.SeekDirectionBefore:
-		result = r.DB.Limit(seek.RecordLimit).Where("ID < ?", seek.StartId).Find(&products)
-	case repository.SeekDirectionAfter:
-		result = r.DB.Limit(seek.RecordLimit).Where("ID > ?", seek.StartId).Find(&products)
-	case repository.SeekDirectionNone:
-		result = r.DB.Limit(seek.RecordLimit).Find(&products)
-	default:
-		return nil, errors.New("invalid seek direction")
+	db, err := r.scoped(seek.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if db, err = query.Filter(db, seek.Filters, repository.ProductFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Seek(db, seek, repository.ProductFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Order(db, seek, repository.ProductFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Select(db, seek, repository.ProductFields); err != nil {
+		return nil, err
 	}
+	result := db.Limit(seek.RecordLimit).Find(&products)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	if seek.Direction == repository.SeekDirectionBefore {
+		// Put the products closest to the start of the seek back in sort order.
+		for i, j := 0, len(products)-1; i < j; i, j = i+1, j-1 {
+			products[i], products[j] = products[j], products[i]
+		}
+	}
 	return products, nil
 }
 
@@ -66,6 +79,28 @@ func (r *PostgresProductRepo) Exists(id uint) (bool, error) {
 	return exists, nil
 }
 
+func (r *PostgresProductRepo) ExistsAll(ids []uint) ([]uint, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var found []uint
+	result := r.DB.Model(&models.Product{}).Where("id IN ?", ids).Pluck("id", &found)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	exists := make(map[uint]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	var missing []uint
+	for _, id := range ids {
+		if !exists[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
 func (r *PostgresProductRepo) GetByID(id uint) (*models.Product, error) {
 	var product models.Product
 	result := r.DB.First(&product, id)
@@ -75,7 +110,17 @@ func (r *PostgresProductRepo) GetByID(id uint) (*models.Product, error) {
 	return &product, nil
 }
 
+func (r *PostgresProductRepo) GetByIDs(ids []uint) ([]*models.Product, error) {
+	var products []*models.Product
+	result := r.DB.Unscoped().Where("id IN ?", ids).Find(&products)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return products, nil
+}
+
 func (r *PostgresProductRepo) Create(p *models.Product) (uint, error) {
+	p.Version = 1
 	result := r.DB.Create(&p)
 	if result.Error != nil {
 		return 0, result.Error
@@ -84,34 +129,70 @@ func (r *PostgresProductRepo) Create(p *models.Product) (uint, error) {
 }
 
 func (r *PostgresProductRepo) Update(p *models.Product, fields []string) (*models.Product, error) {
-	_, err := r.GetByID(p.ID)
-	if err != nil {
-		return nil, err
-	}
+	// Compare and swap: only update the product if it still has the version it was read with, bumping the version as part of the update.
+	expected := p.Version
+	p.Version = expected + 1
+	db := r.DB.Model(p).Where("version = ?", expected)
 	if len(fields) > 0 { // Partial update
-		result := r.DB.Model(p).Select(fields).Updates(p)
-		if result.Error != nil {
-			return nil, err
-		}
-	} else { // Full update
-		result := r.DB.Model(p).Updates(p)
-		if result.Error != nil {
+		db = db.Select(append(append([]string{}, fields...), "version"))
+	}
+	result := db.Updates(p)
+	if result.Error != nil {
+		p.Version = expected
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		p.Version = expected
+		// Either the product is gone, or someone else updated it first.
+		if _, err := r.GetByID(p.ID); err != nil {
 			return nil, err
 		}
+		return nil, repository.ErrVersionConflict
 	}
-	updatedProduct, err := r.GetByID(p.ID) // rethink returning the updated product ... this doesn't return the fully updated product
-	if err != nil {
-		return nil, err
-	}
-	return updatedProduct, nil
+	return r.GetByID(p.ID)
 }
 
 func (r *PostgresProductRepo) Delete(id uint) error {
-	// swap between these two based on some flag, set the flag in the deployment, so you can have different options for dev/test/prod builds
-	//result := r.DB.Delete(&models.Product{}, id) // soft delete
-	result := r.DB.Unscoped().Delete(&models.Product{}, id) // hard delete
+	// Soft delete: items of past orders keep pointing at the product, so the row must stay until it is explicitly purged.
+	result := r.DB.Delete(&models.Product{}, id)
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
+
+func (r *PostgresProductRepo) Restore(id uint) error {
+	result := r.DB.Unscoped().Model(&models.Product{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *PostgresProductRepo) Purge(id uint) error {
+	result := r.DB.Unscoped().Where("deleted_at IS NOT NULL").Delete(&models.Product{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// scoped returns a query limited to the products in the supplied scope. (inactive products are the archived ones)
+func (r *PostgresProductRepo) scoped(scope string) (*gorm.DB, error) {
+	switch scope {
+	case "", repository.ScopeActive:
+		return r.DB, nil
+	case repository.ScopeInactive:
+		return r.DB.Unscoped().Where("deleted_at IS NOT NULL"), nil
+	case repository.ScopeAll:
+		return r.DB.Unscoped(), nil
+	default:
+		return nil, errors.New("invalid scope")
+	}
+}
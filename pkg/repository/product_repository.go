@@ -6,18 +6,29 @@ import (
 
 // Product provides an interface for performing operations on a repository of products.
 type Product interface {
-	// Count returns the count of all the records matching the supplied seek options.
+	// Count returns the count of all the records matching the supplied seek options. Only active products are counted, unless the seek options' scope says otherwise.
 	Count(seek *PageSeekOptions) (count int64, err error)
-	// Fetch returns the products in the repository matching the supplied seek options.
+	// Fetch returns the products in the repository matching the supplied seek options. Only active products are returned, unless the seek options' scope says otherwise.
 	Fetch(pageSeekOptions *PageSeekOptions) ([]*models.Product, error)
-	// Exists determines if a product with the supplied id exists.
+	// Exists determines if an active product with the supplied id exists.
 	Exists(id uint) (bool, error)
-	// GetByID returns the product with the supplied id, if it exists.
+	// ExistsAll determines if active products with all of the supplied ids exist, in a single query.
+	// Returns the ids that don't exist, in the order they were supplied, which is empty if they all do.
+	ExistsAll(ids []uint) (missing []uint, err error)
+	// GetByID returns the active product with the supplied id, if it exists.
 	GetByID(id uint) (*models.Product, error)
+	// GetByIDs returns the products with any of the supplied ids, in a single query, archived or not, so the items of past orders can still find their product.
+	// Ids that don't exist are skipped.
+	GetByIDs(ids []uint) ([]*models.Product, error)
 	// Create creates a new product and returns the ID of the newly created product.
 	Create(p *models.Product) (uint, error)
 	// Update updates an existing product in the repository and returns the updated product.
+	// The update only happens if the stored product still has the supplied product's version, otherwise ErrVersionConflict is returned. The version is bumped by the update.
 	Update(p *models.Product, fields []string) (*models.Product, error)
-	// Delete removes a product with the supplied id from the repository.
+	// Delete archives the active product with the supplied id. Archived products are excluded from every other operation except GetByIDs, Restore, Purge and the inactive scope.
 	Delete(id uint) error
+	// Restore brings back the archived product with the supplied id.
+	Restore(id uint) error
+	// Purge permanently removes the archived product with the supplied id from the repository. Fails if any item refers to it.
+	Purge(id uint) error
 }
@@ -0,0 +1,94 @@
+package repository
+
+// FilterOperator is a comparison that a record's field must satisfy to match a filter.
+type FilterOperator string
+
+const (
+	FilterOpEqual          FilterOperator = "="
+	FilterOpNotEqual       FilterOperator = "!="
+	FilterOpGreater        FilterOperator = ">"
+	FilterOpGreaterOrEqual FilterOperator = ">="
+	FilterOpLess           FilterOperator = "<"
+	FilterOpLessOrEqual    FilterOperator = "<="
+	// Case insensitive substring match. (text fields only)
+	FilterOpContains FilterOperator = "~"
+)
+
+// Filter holds a single condition that records must match to be returned from a repository.
+// Filters are only built by parsing them against a whitelist of fields, so Field is always a known field name.
+type Filter struct {
+	// Name of the field to compare, as it appears in the whitelist.
+	Field string `json:"field"`
+	// Comparison to perform.
+	Operator FilterOperator `json:"op"`
+	// Value to compare against, already converted to the field's type.
+	Value interface{} `json:"value"`
+}
+
+// Sort holds a single key that records are ordered by when returned from a repository.
+type Sort struct {
+	// Name of the field to sort by, as it appears in the whitelist.
+	Field string `json:"field"`
+	// Whether to sort from the highest value to the lowest.
+	Descending bool `json:"desc"`
+}
+
+// FieldType is the type of a field that can be filtered or sorted on, which determines how values are parsed and compared.
+type FieldType int
+
+const (
+	FieldTypeUint FieldType = iota
+	FieldTypeFloat
+	FieldTypeString
+	FieldTypeBool
+	FieldTypeTime
+)
+
+// Field describes a field that can be filtered, sorted on or selected.
+type Field struct {
+	// Name of the database column holding the field.
+	Column string
+	Type   FieldType
+	// Path of the field in the record's JSON, with nested objects separated by dots. (empty if it is the field's name)
+	JSON string
+	// Whether the column can hold NULL, which sorts after every other value, as postgres sorts nulls.
+	Nullable bool
+}
+
+// Fields of an order that can be filtered, sorted on or selected, by the name used in query parameters.
+var OrderFields = map[string]Field{
+	"id":        {Column: "id", Type: FieldTypeUint},
+	"createdat": {Column: "created_at", Type: FieldTypeTime},
+	"updatedat": {Column: "updated_at", Type: FieldTypeTime},
+	"ownerid":   {Column: "owner_id", Type: FieldTypeUint, Nullable: true},
+	"cash":      {Column: "cash", Type: FieldTypeBool, JSON: "paymentinfo.cash"},
+	"taxrate":   {Column: "tax_rate", Type: FieldTypeFloat},
+	"subtotal":  {Column: "subtotal", Type: FieldTypeFloat},
+	"tax":       {Column: "tax", Type: FieldTypeFloat},
+	"total":     {Column: "total", Type: FieldTypeFloat},
+	"status":    {Column: "status", Type: FieldTypeString},
+	"version":   {Column: "version", Type: FieldTypeUint},
+}
+
+// Fields of a product that can be filtered, sorted on or selected, by the name used in query parameters.
+var ProductFields = map[string]Field{
+	"id":         {Column: "id", Type: FieldTypeUint},
+	"createdat":  {Column: "created_at", Type: FieldTypeTime},
+	"updatedat":  {Column: "updated_at", Type: FieldTypeTime},
+	"name":       {Column: "name", Type: FieldTypeString},
+	"symbol":     {Column: "symbol", Type: FieldTypeString},
+	"price":      {Column: "price", Type: FieldTypeFloat},
+	"numinstock": {Column: "num_in_stock", Type: FieldTypeUint},
+	"version":    {Column: "version", Type: FieldTypeUint},
+}
+
+// Fields of a user that can be filtered, sorted on or selected, by the name used in query parameters.
+// The password is deliberately left out, so it can't be probed one comparison at a time.
+var UserFields = map[string]Field{
+	"id":        {Column: "id", Type: FieldTypeUint},
+	"createdat": {Column: "created_at", Type: FieldTypeTime},
+	"updatedat": {Column: "updated_at", Type: FieldTypeTime},
+	"name":      {Column: "name", Type: FieldTypeString},
+	"role":      {Column: "role", Type: FieldTypeString},
+	"version":   {Column: "version", Type: FieldTypeUint},
+}
@@ -0,0 +1,171 @@
+// Package query translates repository filters and sorts into database queries.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Escapes the wildcards of a LIKE pattern, so a contains filter only ever matches its value literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Filter returns the supplied database session restricted to records matching all of the supplied filters.
+// Column names only ever come from the supplied fields, and values are always passed as query parameters.
+func Filter(db *gorm.DB, filters []repository.Filter, fields map[string]repository.Field) (*gorm.DB, error) {
+	for _, f := range filters {
+		field, ok := fields[f.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot filter by unknown field '%s'", f.Field)
+		}
+		column := clause.Column{Name: field.Column}
+		switch f.Operator {
+		case repository.FilterOpEqual, repository.FilterOpNotEqual,
+			repository.FilterOpGreater, repository.FilterOpGreaterOrEqual,
+			repository.FilterOpLess, repository.FilterOpLessOrEqual:
+			db = db.Where(clause.Expr{SQL: "? " + string(f.Operator) + " ?", Vars: []interface{}{column, f.Value}})
+		case repository.FilterOpContains:
+			value, ok := f.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot filter by '%s' containing a non-text value", f.Field)
+			}
+			pattern := "%" + likeEscaper.Replace(value) + "%"
+			if db.Dialector.Name() == "postgres" {
+				db = db.Where(clause.Expr{SQL: "? ILIKE ?", Vars: []interface{}{column, pattern}})
+			} else {
+				// SQLite has no ILIKE, but its LIKE ignores case already. Unlike postgres, it has no escape character unless given one.
+				db = db.Where(clause.Expr{SQL: `? LIKE ? ESCAPE '\'`, Vars: []interface{}{column, pattern}})
+			}
+		default:
+			return nil, fmt.Errorf("invalid filter operator '%s'", f.Operator)
+		}
+	}
+	return db, nil
+}
+
+// Seek returns the supplied database session restricted to the records that come after (or before) the start of the supplied seek,
+// in the order set by the seek's sort. Records are compared on every sort key, then on ID, so no record is skipped or repeated between pages
+// even when many share the same sort key values. Nulls come after every other value, as they are ordered by Order.
+func Seek(db *gorm.DB, seek *repository.PageSeekOptions, fields map[string]repository.Field) (*gorm.DB, error) {
+	if seek.Direction == repository.SeekDirectionNone {
+		return db, nil
+	}
+	if seek.Direction != repository.SeekDirectionAfter && seek.Direction != repository.SeekDirectionBefore {
+		return nil, errors.New("invalid seek direction")
+	}
+	if len(seek.StartKeys) != len(seek.Sort) {
+		return nil, fmt.Errorf("expected %d sort key values to seek from, got %d", len(seek.Sort), len(seek.StartKeys))
+	}
+	keys, err := sortKeys(seek.Sort, fields)
+	if err != nil {
+		return nil, err
+	}
+	values := append(append([]interface{}{}, seek.StartKeys...), seek.StartId)
+
+	// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ... OR (k1 = v1 AND ... AND id > vid), with the comparisons flipped for descending keys and for seeking before.
+	var terms []string
+	var vars []interface{}
+	for i, key := range keys {
+		var conditions []string
+		var conditionVars []interface{}
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				conditions = append(conditions, "? IS NULL")
+				conditionVars = append(conditionVars, keys[j].column)
+			} else {
+				conditions = append(conditions, "? = ?")
+				conditionVars = append(conditionVars, keys[j].column, values[j])
+			}
+		}
+		greater := key.descending == (seek.Direction == repository.SeekDirectionBefore)
+		switch {
+		case values[i] == nil && greater:
+			// Nothing comes after a null.
+			continue
+		case values[i] == nil:
+			conditions = append(conditions, "? IS NOT NULL")
+			conditionVars = append(conditionVars, key.column)
+		case greater && key.nullable:
+			conditions = append(conditions, "(? > ? OR ? IS NULL)")
+			conditionVars = append(conditionVars, key.column, values[i], key.column)
+		case greater:
+			conditions = append(conditions, "? > ?")
+			conditionVars = append(conditionVars, key.column, values[i])
+		default:
+			conditions = append(conditions, "? < ?")
+			conditionVars = append(conditionVars, key.column, values[i])
+		}
+		terms = append(terms, "("+strings.Join(conditions, " AND ")+")")
+		vars = append(vars, conditionVars...)
+	}
+	return db.Where(clause.Expr{SQL: "(" + strings.Join(terms, " OR ") + ")", Vars: vars}), nil
+}
+
+// Order returns the supplied database session ordered by the supplied seek's sort keys, then by ID so the order is always the same.
+// Nulls come after every other value, as postgres orders them, whatever the database. When seeking before, the order is reversed
+// so the records closest to the start of the seek come first; reverse them once fetched.
+func Order(db *gorm.DB, seek *repository.PageSeekOptions, fields map[string]repository.Field) (*gorm.DB, error) {
+	keys, err := sortKeys(seek.Sort, fields)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		desc := key.descending != (seek.Direction == repository.SeekDirectionBefore)
+		if key.nullable {
+			// Booleans sort false first, so the nulls go last when sorted ascending. (and first when descending, as postgres sorts them)
+			isNull := clause.Column{Name: db.Statement.Quote(key.column) + " IS NULL", Raw: true}
+			db = db.Order(clause.OrderByColumn{Column: isNull, Desc: desc})
+		}
+		db = db.Order(clause.OrderByColumn{Column: key.column, Desc: desc})
+	}
+	return db, nil
+}
+
+// Select returns the supplied database session reading only the columns of the supplied seek's fields, along with the ID and sort key columns
+// needed to page through the records. Reads every column if the seek has no fields.
+func Select(db *gorm.DB, seek *repository.PageSeekOptions, fields map[string]repository.Field) (*gorm.DB, error) {
+	if len(seek.Fields) == 0 {
+		return db, nil
+	}
+	columns := []string{"id"}
+	seen := map[string]bool{"id": true}
+	names := append([]string{}, seek.Fields...)
+	for _, s := range seek.Sort {
+		names = append(names, s.Field)
+	}
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot select unknown field '%s'", name)
+		}
+		if !seen[field.Column] {
+			seen[field.Column] = true
+			columns = append(columns, field.Column)
+		}
+	}
+	return db.Select(columns), nil
+}
+
+// sortKey is a column that records are ordered by.
+type sortKey struct {
+	column     clause.Column
+	descending bool
+	nullable   bool
+}
+
+// sortKeys returns the columns of the supplied sort keys followed by the ID column.
+func sortKeys(sorts []repository.Sort, fields map[string]repository.Field) ([]sortKey, error) {
+	keys := make([]sortKey, 0, len(sorts)+1)
+	for _, s := range sorts {
+		field, ok := fields[s.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by unknown field '%s'", s.Field)
+		}
+		keys = append(keys, sortKey{column: clause.Column{Name: field.Column}, descending: s.Descending, nullable: field.Nullable})
+	}
+	return append(keys, sortKey{column: clause.Column{Name: "id"}}), nil
+}
@@ -0,0 +1,21 @@
+package repository
+
+// Repositories holds a repository of every type of record, all kept in the same storage.
+type Repositories struct {
+	Orders      Order
+	Items       Item
+	Products    Product
+	Users       User
+	Identities  ExternalIdentity
+	Audit       Audit
+	Idempotency Idempotency
+	// Runs operations on the repositories atomically.
+	Transactions Transactor
+}
+
+// Transactor provides an interface for running operations on a set of repositories atomically.
+type Transactor interface {
+	// Transaction runs the supplied function with repositories whose changes are all kept if it returns nil,
+	// and all discarded if it returns an error, which is then returned.
+	Transaction(fn func(tx *Repositories) error) error
+}
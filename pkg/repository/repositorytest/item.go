@@ -0,0 +1,332 @@
+package repositorytest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// createItems creates two orders and two products in the supplied repositories, and four items in them, alternating between the orders
+// and the products. Returns the items in the order they were created, then the ids of the orders and of the products.
+func createItems(t *testing.T, repos *repository.Repositories) (items []*models.Item, orders []uint, products []uint) {
+	t.Helper()
+	alice, bob := createOwners(t, repos)
+	orders = orderIDs(createOrders(t, repos.Orders, testOrders(alice, bob)[:2]))
+	for _, p := range createProducts(t, repos.Products, testProducts()[:2]) {
+		products = append(products, p.ID)
+	}
+	for i := 0; i < 4; i++ {
+		item := &models.Item{OrderID: orders[i%2], ProductID: products[i/2], Quantity: i + 1}
+		if _, err := repos.Items.Create(item); err != nil {
+			t.Fatalf("failed to create item: %s", err.Error())
+		}
+		items = append(items, item)
+	}
+	return items, orders, products
+}
+
+// createMoreProducts creates the supplied number of products in the supplied repository, besides those createItems creates, and returns their ids.
+// An order has at most one item of each product, so adding items to the orders of createItems needs more products.
+func createMoreProducts(t *testing.T, repo repository.Product, n int) []uint {
+	t.Helper()
+	ids := make([]uint, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := repo.Create(&models.Product{Name: fmt.Sprintf("fig %d", i), Symbol: "🍈", Price: 2, NumInStock: 1})
+		if err != nil {
+			t.Fatalf("failed to create product: %s", err.Error())
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// itemIDs returns the ids of the supplied items.
+func itemIDs(items []*models.Item) []uint {
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+// TestItemRepository tests that the order item repositories returned by the supplied function behave like every other order item repository.
+func TestItemRepository(t *testing.T, newRepos NewRepositories) {
+	t.Run("create", func(t *testing.T) {
+		repos := newRepos(t)
+		items, orders, products := createItems(t, repos)
+		if items[0].ID == 0 || items[0].CreatedAt.IsZero() {
+			t.Errorf("expected the created item to get an id and its creation time, got %+v", items[0])
+		}
+		got, err := repos.Items.GetByID(items[0].ID)
+		if err != nil {
+			t.Fatalf("unexpected error reading the created item: %s", err.Error())
+		}
+		if got.ID != items[0].ID || got.OrderID != orders[0] || got.ProductID != products[0] || got.Quantity != 1 || got.Product != nil {
+			t.Errorf("expected to read the item as created, without its product, %+v, got %+v", items[0], got)
+		}
+		expectExists(t, repos.Items.Exists, items[0].ID, true)
+	})
+
+	t.Run("create concurrently", func(t *testing.T) {
+		repos := newRepos(t)
+		_, orders, _ := createItems(t, repos)
+		products := createMoreProducts(t, repos.Products, concurrentWriters)
+		ids := make([]uint, concurrentWriters)
+		errs := runConcurrently(func(n int) (err error) {
+			ids[n], err = repos.Items.Create(&models.Item{OrderID: orders[0], ProductID: products[n], Quantity: n + 1})
+			return err
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error creating items concurrently: %s", err.Error())
+			}
+		}
+		expectDistinctIDs(t, ids)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepos(t).Items
+		item, err := repo.GetByID(1)
+		expectNotFound(t, "reading a missing item", err)
+		if item != nil {
+			t.Errorf("expected no item, got %+v", item)
+		}
+		expectExists(t, repo.Exists, 1, false)
+		updated, err := repo.Update(&models.Item{Model: gormModel(1), Quantity: 2}, nil)
+		expectNotFound(t, "updating a missing item", err)
+		if updated != nil {
+			t.Errorf("expected no item, got %+v", updated)
+		}
+		if err := repo.Delete(1); err != nil {
+			t.Errorf("expected deleting a missing item to do nothing, got %s", err.Error())
+		}
+		if items, err := repo.GetByOrderID(1); err != nil || len(items) != 0 {
+			t.Errorf("expected no items for a missing order, got %d (%v)", len(items), err)
+		}
+	})
+
+	t.Run("get by order and product", func(t *testing.T) {
+		repos := newRepos(t)
+		items, orders, products := createItems(t, repos)
+		for _, c := range []struct {
+			name string
+			get  func() ([]*models.Item, error)
+			want []int
+		}{
+			{"order", func() ([]*models.Item, error) { return repos.Items.GetByOrderID(orders[1]) }, []int{1, 3}},
+			{"product", func() ([]*models.Item, error) { return repos.Items.GetByProductID(products[0]) }, []int{0, 1}},
+			{"missing product", func() ([]*models.Item, error) { return repos.Items.GetByProductID(products[1] + 100) }, nil},
+		} {
+			got, err := c.get()
+			if err != nil {
+				t.Fatalf("unexpected error reading items by %s: %s", c.name, err.Error())
+			}
+			ids := map[uint]bool{}
+			for _, item := range got {
+				ids[item.ID] = true
+			}
+			if len(got) != len(c.want) {
+				t.Errorf("expected %d items by %s, got %v", len(c.want), c.name, itemIDs(got))
+			}
+			for _, i := range c.want {
+				if !ids[items[i].ID] {
+					t.Errorf("expected item %d by %s, got %v", items[i].ID, c.name, itemIDs(got))
+				}
+			}
+		}
+		byOrder, err := repos.Items.GetByOrderIDs([]uint{orders[0], orders[1], orders[1] + 100})
+		if err != nil {
+			t.Fatalf("unexpected error reading items by orders: %s", err.Error())
+		}
+		if ids := itemIDs(byOrder[orders[0]]); len(ids) != 2 || ids[0] != items[0].ID || ids[1] != items[2].ID {
+			t.Errorf("expected the first order's items in id order, got %v", ids)
+		}
+		if ids := itemIDs(byOrder[orders[1]]); len(ids) != 2 || ids[0] != items[1].ID || ids[1] != items[3].ID {
+			t.Errorf("expected the second order's items in id order, got %v", ids)
+		}
+		if len(byOrder[orders[1]+100]) != 0 {
+			t.Errorf("expected no items for a missing order, got %v", itemIDs(byOrder[orders[1]+100]))
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		repos := newRepos(t)
+		items, _, _ := createItems(t, repos)
+		// Items are only ever paged through by id.
+		runSeekCases(t, []seekCase{
+			{name: "everything in id order", want: []int{0, 1, 2, 3}, count: -1},
+			{name: "first page", seek: repository.PageSeekOptions{RecordLimit: 2}, want: []int{0, 1}, count: 4},
+			{name: "after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, RecordLimit: 2}, from: 0, want: []int{1, 2}, count: 3},
+			{name: "after the last", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter}, from: 3, count: -1},
+			{name: "before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, RecordLimit: 1}, from: 2, want: []int{1}, count: 2},
+			{name: "before the first", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore}, from: 0, count: -1},
+			{name: "invalid direction", seek: repository.PageSeekOptions{Direction: "sideways"}, wantErr: true},
+		}, itemIDs(items), repos.Items.Count, func(seek *repository.PageSeekOptions) ([]uint, error) {
+			items, err := repos.Items.Fetch(seek)
+			if err != nil {
+				return nil, err
+			}
+			return itemIDs(items), nil
+		})
+	})
+
+	t.Run("update", func(t *testing.T) {
+		for _, c := range []struct {
+			name   string
+			update models.Item
+			fields []string
+			// Quantity the item should be left with. (the first item of the first order starts with 1)
+			want int
+		}{
+			{name: "every non-zero field", update: models.Item{Quantity: 5}, want: 5},
+			{name: "zero fields left alone", update: models.Item{Quantity: 0}, want: 1},
+			{name: "selected fields, even when zero", update: models.Item{Quantity: 0}, fields: []string{"quantity"}, want: 0},
+		} {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				repos := newRepos(t)
+				items, orders, products := createItems(t, repos)
+				update := c.update
+				update.ID = items[0].ID
+				updated, err := repos.Items.Update(&update, c.fields)
+				if err != nil {
+					t.Fatalf("unexpected error updating the item: %s", err.Error())
+				}
+				if updated.ID != items[0].ID || updated.OrderID != orders[0] || updated.ProductID != products[0] || updated.Quantity != c.want {
+					t.Errorf("expected the updated item to have a quantity of %d and be otherwise unchanged, got %+v", c.want, updated)
+				}
+				if stored, err := repos.Items.GetByID(items[0].ID); err != nil || stored.Quantity != updated.Quantity {
+					t.Errorf("expected the updated item to be returned as stored, %+v, got %+v (%v)", stored, updated, err)
+				}
+			})
+		}
+	})
+
+	t.Run("create all", func(t *testing.T) {
+		repos := newRepos(t)
+		_, orders, _ := createItems(t, repos)
+		products := createMoreProducts(t, repos.Products, 2)
+		created := []*models.Item{{OrderID: orders[1], ProductID: products[0], Quantity: 7}, {OrderID: orders[1], ProductID: products[1], Quantity: 8}}
+		ids, err := repos.Items.CreateAll(created)
+		if err != nil {
+			t.Fatalf("unexpected error creating items: %s", err.Error())
+		}
+		if len(ids) != 2 || ids[0] == 0 || ids[0] != created[0].ID || ids[1] != created[1].ID || ids[0] == ids[1] {
+			t.Errorf("expected the ids of the created items to be returned in order and set on them, got %v and %v", ids, itemIDs(created))
+		}
+		for _, item := range created {
+			got, err := repos.Items.GetByID(item.ID)
+			if err != nil || got.OrderID != orders[1] || got.ProductID != item.ProductID || got.Quantity != item.Quantity || got.CreatedAt.IsZero() {
+				t.Errorf("expected to read the item as created, %+v, got %+v (%v)", item, got, err)
+			}
+		}
+		if ids, err := repos.Items.CreateAll(nil); err != nil || len(ids) != 0 {
+			t.Errorf("expected creating no items to do nothing, got %v (%v)", ids, err)
+		}
+	})
+
+	t.Run("update all", func(t *testing.T) {
+		repos := newRepos(t)
+		items, orders, products := createItems(t, repos)
+		other := createMoreProducts(t, repos.Products, 1)[0]
+		// Change the product of the first order's first item, set the quantities and prices of its items, and skip a missing item.
+		updates := []*models.Item{
+			{Model: gormModel(items[0].ID), OrderID: orders[1], ProductID: other, Quantity: 0},
+			{Model: gormModel(items[2].ID), OrderID: orders[1], ProductID: products[1], Quantity: 9, UnitPrice: 1.25, ProductName: "kiwi"},
+			{Model: gormModel(items[3].ID + 100), ProductID: products[0], Quantity: 9},
+		}
+		if err := repos.Items.UpdateAll(updates, []string{"product_id", "quantity", "unit_price", "product_name"}); err != nil {
+			t.Fatalf("unexpected error updating items: %s", err.Error())
+		}
+		for i, want := range []models.Item{
+			{OrderID: orders[0], ProductID: other, Quantity: 0},
+			{OrderID: orders[1], ProductID: products[0], Quantity: 2},
+			{OrderID: orders[0], ProductID: products[1], Quantity: 9},
+			{OrderID: orders[1], ProductID: products[1], Quantity: 4},
+		} {
+			got, err := repos.Items.GetByID(items[i].ID)
+			if err != nil || got.OrderID != want.OrderID || got.ProductID != want.ProductID || got.Quantity != want.Quantity {
+				t.Errorf("expected item %d to have only its selected fields updated, to %+v, got %+v (%v)", i, want, got, err)
+			}
+		}
+		if got, err := repos.Items.GetByID(items[2].ID); err != nil || got.UnitPrice != 1.25 || got.ProductName != "kiwi" {
+			t.Errorf("expected item 2 to have its price updated, got %+v (%v)", got, err)
+		}
+		expectExists(t, repos.Items.Exists, items[3].ID+100, false)
+		if err := repos.Items.UpdateAll(nil, []string{"quantity"}); err != nil {
+			t.Errorf("expected updating no items to do nothing, got %s", err.Error())
+		}
+	})
+
+	t.Run("constraints", func(t *testing.T) {
+		repos := newRepos(t)
+		items, orders, products := createItems(t, repos)
+		for _, c := range []struct {
+			name  string
+			write func() error
+		}{
+			{"creating an item of a missing order", func() error {
+				_, err := repos.Items.Create(&models.Item{OrderID: orders[1] + 100, ProductID: products[0], Quantity: 1})
+				return err
+			}},
+			{"creating an item of a missing product", func() error {
+				_, err := repos.Items.Create(&models.Item{OrderID: orders[0], ProductID: products[1] + 100, Quantity: 1})
+				return err
+			}},
+			{"creating a second item of a product in an order", func() error {
+				_, err := repos.Items.Create(&models.Item{OrderID: orders[0], ProductID: products[0], Quantity: 1})
+				return err
+			}},
+			{"creating two items of a product in an order at once", func() error {
+				other := createMoreProducts(t, repos.Products, 1)[0]
+				_, err := repos.Items.CreateAll([]*models.Item{{OrderID: orders[0], ProductID: other, Quantity: 1}, {OrderID: orders[0], ProductID: other, Quantity: 2}})
+				return err
+			}},
+			{"moving an item to the product of another item of its order", func() error {
+				return repos.Items.UpdateAll([]*models.Item{{Model: gormModel(items[0].ID), ProductID: products[1]}}, []string{"product_id"})
+			}},
+		} {
+			if err := c.write(); err == nil {
+				t.Errorf("expected %s to fail", c.name)
+			}
+		}
+		if n, err := repos.Items.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone}); err != nil || n != int64(len(items)) {
+			t.Errorf("expected the failed writes to leave the %d items alone, got %d (%v)", len(items), n, err)
+		}
+		if got, err := repos.Items.GetByID(items[0].ID); err != nil || got.ProductID != products[0] {
+			t.Errorf("expected the item to keep its product, got %+v (%v)", got, err)
+		}
+	})
+
+	t.Run("delete all", func(t *testing.T) {
+		repos := newRepos(t)
+		items, _, _ := createItems(t, repos)
+		if err := repos.Items.DeleteAll([]uint{items[0].ID, items[2].ID, items[3].ID + 100}); err != nil {
+			t.Fatalf("unexpected error deleting items: %s", err.Error())
+		}
+		for i, want := range []bool{false, true, false, true} {
+			expectExists(t, repos.Items.Exists, items[i].ID, want)
+		}
+		if err := repos.Items.DeleteAll(nil); err != nil {
+			t.Errorf("expected deleting no items to do nothing, got %s", err.Error())
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repos := newRepos(t)
+		items, orders, _ := createItems(t, repos)
+		if err := repos.Items.Delete(items[1].ID); err != nil {
+			t.Fatalf("unexpected error deleting an item: %s", err.Error())
+		}
+		_, err := repos.Items.GetByID(items[1].ID)
+		expectNotFound(t, "reading a deleted item", err)
+		expectExists(t, repos.Items.Exists, items[1].ID, false)
+		if got, err := repos.Items.GetByOrderID(orders[1]); err != nil || len(got) != 1 || got[0].ID != items[3].ID {
+			t.Errorf("expected only the order's remaining item, got %v (%v)", itemIDs(got), err)
+		}
+		_, err = repos.Items.Update(&models.Item{Model: gormModel(items[1].ID), Quantity: 2}, nil)
+		expectNotFound(t, "updating a deleted item", err)
+	})
+}
@@ -0,0 +1,352 @@
+package repositorytest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// testOrders are the orders the order tests are run against, created in this order, owned by the supplied users. (or no one, for zero)
+// Two have the same total, so ties are broken by id.
+func testOrders(alice, bob uint) []*models.Order {
+	return []*models.Order{
+		{OwnerID: ownerID(alice), PaymentInfo: models.PaymentInfo{Cash: true}, TaxRate: 0.1, Subtotal: 10, Tax: 1, Total: 11},
+		{OwnerID: ownerID(bob), TaxRate: 0.1, Subtotal: 5, Tax: 0.5, Total: 5.5},
+		{OwnerID: ownerID(alice), TaxRate: 0.1, Subtotal: 20, Tax: 2, Total: 22},
+		{OwnerID: ownerID(bob), PaymentInfo: models.PaymentInfo{Cash: true}, TaxRate: 0.1, Subtotal: 5, Tax: 0.5, Total: 5.5},
+	}
+}
+
+// ownerID returns the owner id of an order owned by the user with the supplied id, or of an order owned by no one for zero.
+func ownerID(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// createOwners creates two users to own orders in the supplied repositories, and returns their ids.
+func createOwners(t *testing.T, repos *repository.Repositories) (alice uint, bob uint) {
+	t.Helper()
+	users := createUsers(t, repos.Users, testUsers()[1:3])
+	return users[0].ID, users[1].ID
+}
+
+// createOrders creates the supplied orders in the supplied repository, in order.
+func createOrders(t *testing.T, repo repository.Order, orders []*models.Order) []*models.Order {
+	t.Helper()
+	for _, o := range orders {
+		if _, _, err := repo.Create(o); err != nil {
+			t.Fatalf("failed to create order: %s", err.Error())
+		}
+	}
+	return orders
+}
+
+// orderIDs returns the ids of the supplied orders.
+func orderIDs(orders []*models.Order) []uint {
+	ids := make([]uint, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+	return ids
+}
+
+// TestOrderRepository tests that the order repositories returned by the supplied function behave like every other order repository.
+func TestOrderRepository(t *testing.T, newRepos NewRepositories) {
+	t.Run("create", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, _ := createOwners(t, repos)
+		products := createProducts(t, repos.Products, testProducts()[:2])
+		o := testOrders(alice, 0)[0]
+		o.Items = []*models.Item{{ProductID: products[0].ID, Quantity: 2}, {ProductID: products[1].ID, Quantity: 1}}
+		id, itemIDs, err := repos.Orders.Create(o)
+		if err != nil {
+			t.Fatalf("unexpected error creating an order: %s", err.Error())
+		}
+		if id == 0 || id != o.ID || o.Version != 1 || o.CreatedAt.IsZero() {
+			t.Errorf("expected the created order to get an id, version 1 and its creation time, got id %d and %+v", id, o)
+		}
+		if len(itemIDs) != 2 || itemIDs[0] != o.Items[0].ID || itemIDs[1] != o.Items[1].ID {
+			t.Errorf("expected the ids of the items created with the order to be returned and set on them, got %v", itemIDs)
+		}
+		for _, item := range o.Items {
+			if item.OrderID != id {
+				t.Errorf("expected the items created with the order to belong to it, got %+v", item)
+			}
+		}
+		got, err := repos.Orders.GetByID(id)
+		if err != nil {
+			t.Fatalf("unexpected error reading the created order: %s", err.Error())
+		}
+		if got.ID != id || !got.OwnedBy(alice) || got.PaymentInfo != o.PaymentInfo || got.TaxRate != o.TaxRate || got.Subtotal != o.Subtotal || got.Tax != o.Tax || got.Total != o.Total || got.Version != 1 {
+			t.Errorf("expected to read the order as created, %+v, got %+v", o, got)
+		}
+		if len(got.Items) != 0 || got.Owner != nil {
+			t.Errorf("expected the order to be read without its items or owner, got %+v", got)
+		}
+		items, err := repos.Items.GetByOrderID(id)
+		if err != nil {
+			t.Fatalf("unexpected error reading the items of the created order: %s", err.Error())
+		}
+		if len(items) != 2 {
+			t.Errorf("expected the order's 2 items to be created with it, got %d", len(items))
+		}
+		expectExists(t, repos.Orders.Exists, id, true)
+	})
+
+	t.Run("create concurrently", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, _ := createOwners(t, repos)
+		product := createProducts(t, repos.Products, testProducts()[:1])[0]
+		ids := make([]uint, concurrentWriters)
+		var itemIDs []uint
+		itemIDsByWriter := make([][]uint, concurrentWriters)
+		errs := runConcurrently(func(n int) (err error) {
+			o := &models.Order{OwnerID: ownerID(alice), Items: []*models.Item{{ProductID: product.ID, Quantity: n + 1}}}
+			ids[n], itemIDsByWriter[n], err = repos.Orders.Create(o)
+			return err
+		})
+		for n, err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error creating orders concurrently: %s", err.Error())
+			}
+			itemIDs = append(itemIDs, itemIDsByWriter[n]...)
+		}
+		expectDistinctIDs(t, ids)
+		expectDistinctIDs(t, itemIDs)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepos(t).Orders
+		o, err := repo.GetByID(1)
+		expectNotFound(t, "reading a missing order", err)
+		if o != nil {
+			t.Errorf("expected no order, got %+v", o)
+		}
+		expectExists(t, repo.Exists, 1, false)
+		updated, err := repo.Update(&models.Order{Model: gormModel(1), Total: 1, Version: 1}, nil)
+		expectNotFound(t, "updating a missing order", err)
+		if updated != nil {
+			t.Errorf("expected no order, got %+v", updated)
+		}
+		if err := repo.Delete(1); err != nil {
+			t.Errorf("expected deleting a missing order to do nothing, got %s", err.Error())
+		}
+	})
+
+	t.Run("get by owner", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, bob := createOwners(t, repos)
+		orders := createOrders(t, repos.Orders, testOrders(alice, bob))
+		got, err := repos.Orders.GetByOwnerID(alice)
+		if err != nil {
+			t.Fatalf("unexpected error reading orders by owner: %s", err.Error())
+		}
+		if len(got) != 2 || !got[0].OwnedBy(alice) || !got[1].OwnedBy(alice) {
+			t.Errorf("expected alice's 2 orders, got %v", orderIDs(got))
+		}
+		byOwner, err := repos.Orders.GetByOwnerIDs([]uint{bob, alice, bob + 100})
+		if err != nil {
+			t.Fatalf("unexpected error reading orders by owners: %s", err.Error())
+		}
+		if ids := orderIDs(byOwner[alice]); len(ids) != 2 || ids[0] != orders[0].ID || ids[1] != orders[2].ID {
+			t.Errorf("expected alice's orders in id order, got %v", ids)
+		}
+		if ids := orderIDs(byOwner[bob]); len(ids) != 2 || ids[0] != orders[1].ID || ids[1] != orders[3].ID {
+			t.Errorf("expected bob's orders in id order, got %v", ids)
+		}
+		if len(byOwner[bob+100]) != 0 {
+			t.Errorf("expected no orders for an owner without any, got %v", orderIDs(byOwner[bob+100]))
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, bob := createOwners(t, repos)
+		orders := createOrders(t, repos.Orders, testOrders(alice, bob))
+		byTotal := []repository.Sort{{Field: "total", Descending: true}}
+		runSeekCases(t, []seekCase{
+			{name: "everything in id order", want: []int{0, 1, 2, 3}, count: -1},
+			{name: "first page", seek: repository.PageSeekOptions{RecordLimit: 3}, want: []int{0, 1, 2}, count: 4},
+			{name: "before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, RecordLimit: 2}, from: 3, want: []int{1, 2}, count: 3},
+			{name: "sorted", seek: repository.PageSeekOptions{Sort: byTotal}, want: []int{2, 0, 1, 3}, count: -1},
+			{name: "sorted after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, Sort: byTotal, StartKeys: []interface{}{float64(11)}, RecordLimit: 1}, from: 0, want: []int{1}, count: 2},
+			{name: "tie sorted before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, Sort: []repository.Sort{{Field: "total"}}, StartKeys: []interface{}{5.5}}, from: 3, want: []int{1}, count: -1},
+			{name: "filtered by owner", seek: repository.PageSeekOptions{Filters: []repository.Filter{{Field: "ownerid", Operator: repository.FilterOpEqual, Value: uint64(alice)}}}, want: []int{0, 2}, count: -1},
+			{name: "filtered by payment", seek: repository.PageSeekOptions{Filters: []repository.Filter{{Field: "cash", Operator: repository.FilterOpEqual, Value: true}}}, want: []int{0, 3}, count: -1},
+			{name: "invalid direction", seek: repository.PageSeekOptions{Direction: "sideways"}, wantErr: true},
+		}, orderIDs(orders), repos.Orders.Count, func(seek *repository.PageSeekOptions) ([]uint, error) {
+			orders, err := repos.Orders.Fetch(seek)
+			if err != nil {
+				return nil, err
+			}
+			return orderIDs(orders), nil
+		})
+	})
+
+	t.Run("pagination by owner", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, bob := createOwners(t, repos)
+		// Orders of purged owners are owned by no one, and come after every other order, as postgres sorts nulls.
+		orders := createOrders(t, repos.Orders, append(testOrders(alice, 0), testOrders(bob, alice)[:1]...))
+		ids := orderIDs(orders)
+		for _, c := range []struct {
+			name      string
+			sort      repository.Sort
+			direction string
+			want      []int
+		}{
+			{name: "ascending", sort: repository.Sort{Field: "ownerid"}, direction: repository.SeekDirectionAfter, want: []int{0, 2, 4, 1, 3}},
+			{name: "descending", sort: repository.Sort{Field: "ownerid", Descending: true}, direction: repository.SeekDirectionAfter, want: []int{1, 3, 4, 0, 2}},
+			{name: "ascending before", sort: repository.Sort{Field: "ownerid"}, direction: repository.SeekDirectionBefore, want: []int{0, 2, 4, 1, 3}},
+			{name: "descending before", sort: repository.Sort{Field: "ownerid", Descending: true}, direction: repository.SeekDirectionBefore, want: []int{1, 3, 4, 0, 2}},
+		} {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				// Page through the orders one at a time, from the first one (or the last, seeking before), seeking from the owner of each page's order.
+				seek := repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Sort: []repository.Sort{c.sort}, RecordLimit: 1}
+				got, err := repos.Orders.Fetch(&seek)
+				if err != nil {
+					t.Fatalf("unexpected error fetching the first page: %s", err.Error())
+				}
+				if c.direction == repository.SeekDirectionBefore {
+					got = []*models.Order{orders[c.want[len(c.want)-1]]}
+				}
+				var paged []uint
+				for len(got) == 1 && len(paged) <= len(ids) {
+					paged = append(paged, got[0].ID)
+					var owner interface{}
+					if got[0].OwnerID != nil {
+						owner = uint64(*got[0].OwnerID)
+					}
+					seek.Direction, seek.StartId, seek.StartKeys = c.direction, got[0].ID, []interface{}{owner}
+					if got, err = repos.Orders.Fetch(&seek); err != nil {
+						t.Fatalf("unexpected error fetching the page next to order %d: %s", paged[len(paged)-1], err.Error())
+					}
+				}
+				want := make([]uint, 0, len(c.want))
+				for _, i := range c.want {
+					want = append(want, ids[i])
+				}
+				if c.direction == repository.SeekDirectionBefore {
+					for i, j := 0, len(paged)-1; i < j; i, j = i+1, j-1 {
+						paged[i], paged[j] = paged[j], paged[i]
+					}
+				}
+				if !reflect.DeepEqual(paged, want) {
+					t.Errorf("expected to page through orders %v, got %v", want, paged)
+				}
+			})
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		for _, c := range []struct {
+			name   string
+			update models.Order
+			fields []string
+			want   models.Order
+		}{
+			{
+				name:   "every non-zero field",
+				update: models.Order{Subtotal: 12, Total: 13.2},
+				want:   models.Order{PaymentInfo: models.PaymentInfo{Cash: true}, TaxRate: 0.1, Subtotal: 12, Tax: 1, Total: 13.2},
+			},
+			{
+				name:   "selected fields, even when zero",
+				update: models.Order{Subtotal: 12, Tax: 0, Total: 12},
+				fields: []string{"tax", "total"},
+				want:   models.Order{PaymentInfo: models.PaymentInfo{Cash: true}, TaxRate: 0.1, Subtotal: 10, Tax: 0, Total: 12},
+			},
+		} {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				repos := newRepos(t)
+				alice, _ := createOwners(t, repos)
+				created := createOrders(t, repos.Orders, testOrders(alice, 0)[:1])[0]
+				update := c.update
+				update.ID, update.Version = created.ID, created.Version
+				updated, err := repos.Orders.Update(&update, c.fields)
+				if err != nil {
+					t.Fatalf("unexpected error updating the order: %s", err.Error())
+				}
+				if update.Version != 2 {
+					t.Errorf("expected the version of the supplied order to be bumped to 2, got %d", update.Version)
+				}
+				if updated.ID != created.ID || !updated.OwnedBy(alice) || updated.PaymentInfo != c.want.PaymentInfo || updated.TaxRate != c.want.TaxRate || updated.Subtotal != c.want.Subtotal || updated.Tax != c.want.Tax || updated.Total != c.want.Total || updated.Version != 2 {
+					t.Errorf("expected the updated order to be %+v at version 2, got %+v", c.want, updated)
+				}
+			})
+		}
+	})
+
+	t.Run("update stale version", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, _ := createOwners(t, repos)
+		created := createOrders(t, repos.Orders, testOrders(alice, 0)[:1])[0]
+		if _, err := repos.Orders.Update(&models.Order{Model: gormModel(created.ID), Total: 12, Version: 1}, []string{"total"}); err != nil {
+			t.Fatalf("unexpected error updating the order: %s", err.Error())
+		}
+		stale := models.Order{Model: gormModel(created.ID), Total: 13, Version: 1}
+		updated, err := repos.Orders.Update(&stale, []string{"total"})
+		if !errors.Is(err, repository.ErrVersionConflict) {
+			t.Errorf("expected updating a stale version to fail with %v, got %v", repository.ErrVersionConflict, err)
+		}
+		if updated != nil || stale.Version != 1 {
+			t.Errorf("expected no order and the supplied version to be left alone, got %+v and version %d", updated, stale.Version)
+		}
+		if stored, err := repos.Orders.GetByID(created.ID); err != nil || stored.Total != 12 || stored.Version != 2 {
+			t.Errorf("expected the stored order to be left alone, got %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("update concurrently", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, _ := createOwners(t, repos)
+		created := createOrders(t, repos.Orders, testOrders(alice, 0)[:1])[0]
+		errs := runConcurrently(func(n int) error {
+			_, err := repos.Orders.Update(&models.Order{Model: gormModel(created.ID), Total: float64(100 + n), Version: created.Version}, []string{"total"})
+			return err
+		})
+		winner := expectOneWinner(t, errs)
+		if stored, err := repos.Orders.GetByID(created.ID); err != nil || stored.Total != float64(100+winner) || stored.Version != 2 {
+			t.Errorf("expected the winning update to be stored at version 2, got %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, bob := createOwners(t, repos)
+		orders := createOrders(t, repos.Orders, testOrders(alice, bob))
+		if err := repos.Orders.Delete(orders[1].ID); err != nil {
+			t.Fatalf("unexpected error deleting an order: %s", err.Error())
+		}
+		_, err := repos.Orders.GetByID(orders[1].ID)
+		expectNotFound(t, "reading a deleted order", err)
+		expectExists(t, repos.Orders.Exists, orders[1].ID, false)
+		if got, err := repos.Orders.GetByOwnerID(bob); err != nil || len(got) != 1 || got[0].ID != orders[3].ID {
+			t.Errorf("expected only bob's remaining order, got %v (%v)", orderIDs(got), err)
+		}
+		if n, err := repos.Orders.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone}); err != nil || n != 3 {
+			t.Errorf("expected 3 orders left, got %d (%v)", n, err)
+		}
+		_, err = repos.Orders.Update(&models.Order{Model: gormModel(orders[1].ID), Total: 1, Version: 1}, nil)
+		expectNotFound(t, "updating a deleted order", err)
+	})
+
+	t.Run("delete with items", func(t *testing.T) {
+		repos := newRepos(t)
+		items, orders, _ := createItems(t, repos)
+		if err := repos.Orders.Delete(orders[0]); err != nil {
+			t.Fatalf("unexpected error deleting an order: %s", err.Error())
+		}
+		// The items of the order are deleted along with it.
+		for i, want := range []bool{false, true, false, true} {
+			expectExists(t, repos.Items.Exists, items[i].ID, want)
+		}
+	})
+}
@@ -0,0 +1,374 @@
+package repositorytest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// testProducts are the products the product tests are run against, created in this order.
+// Two share a price, so ties are broken by id.
+func testProducts() []*models.Product {
+	return []*models.Product{
+		{Name: "cherry", Symbol: "🍒", Price: 3, NumInStock: 10},
+		{Name: "apple", Symbol: "🍎", Price: 1, NumInStock: 0},
+		{Name: "banana", Symbol: "🍌", Price: 1, NumInStock: 5},
+		{Name: "date", Symbol: "🌴", Price: 4.5, NumInStock: 2},
+		{Name: "elderberry", Symbol: "🫐", Price: 2, NumInStock: 7},
+	}
+}
+
+// createProducts creates the supplied products in the supplied repository, in order.
+func createProducts(t *testing.T, repo repository.Product, products []*models.Product) []*models.Product {
+	t.Helper()
+	for _, p := range products {
+		if _, err := repo.Create(p); err != nil {
+			t.Fatalf("failed to create product %s: %s", p.Name, err.Error())
+		}
+	}
+	return products
+}
+
+// TestProductRepository tests that the product repositories returned by the supplied function behave like every other product repository.
+func TestProductRepository(t *testing.T, newRepos NewRepositories) {
+	t.Run("create", func(t *testing.T) {
+		repo := newRepos(t).Products
+		p := &models.Product{Name: "apple", Symbol: "🍎", Price: 1.25, NumInStock: 3}
+		id, err := repo.Create(p)
+		if err != nil {
+			t.Fatalf("unexpected error creating a product: %s", err.Error())
+		}
+		if id == 0 || id != p.ID {
+			t.Errorf("expected the id of the created product to be returned and set on it, got %d and %d", id, p.ID)
+		}
+		if p.Version != 1 || p.CreatedAt.IsZero() {
+			t.Errorf("expected the created product to be at version 1 with its creation time set, got %+v", p)
+		}
+		got, err := repo.GetByID(id)
+		if err != nil {
+			t.Fatalf("unexpected error reading the created product: %s", err.Error())
+		}
+		if got.ID != id || got.Name != p.Name || got.Symbol != p.Symbol || got.Price != p.Price || got.NumInStock != p.NumInStock || got.Version != 1 || !got.CreatedAt.Equal(p.CreatedAt) {
+			t.Errorf("expected to read the product as created, %+v, got %+v", p, got)
+		}
+		expectExists(t, repo.Exists, id, true)
+	})
+
+	t.Run("create concurrently", func(t *testing.T) {
+		repo := newRepos(t).Products
+		ids := make([]uint, concurrentWriters)
+		errs := runConcurrently(func(n int) (err error) {
+			ids[n], err = repo.Create(&models.Product{Name: "fig", Symbol: "🍈", Price: 1, NumInStock: n})
+			return err
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error creating products concurrently: %s", err.Error())
+			}
+		}
+		expectDistinctIDs(t, ids)
+		if n, err := repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone}); err != nil || n != concurrentWriters {
+			t.Errorf("expected %d products, got %d (%v)", concurrentWriters, n, err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepos(t).Products
+		p, err := repo.GetByID(1)
+		expectNotFound(t, "reading a missing product", err)
+		if p != nil {
+			t.Errorf("expected no product, got %+v", p)
+		}
+		expectExists(t, repo.Exists, 1, false)
+		updated, err := repo.Update(&models.Product{Model: gormModel(1), Name: "plum", Version: 1}, nil)
+		expectNotFound(t, "updating a missing product", err)
+		if updated != nil {
+			t.Errorf("expected no product, got %+v", updated)
+		}
+		if err := repo.Delete(1); err != nil {
+			t.Errorf("expected deleting a missing product to do nothing, got %s", err.Error())
+		}
+		expectNotFound(t, "restoring a missing product", repo.Restore(1))
+		expectNotFound(t, "purging a missing product", repo.Purge(1))
+	})
+
+	t.Run("get by ids", func(t *testing.T) {
+		repo := newRepos(t).Products
+		products := createProducts(t, repo, testProducts())
+		got, err := repo.GetByIDs([]uint{products[3].ID, products[0].ID, products[3].ID, products[4].ID + 100})
+		if err != nil {
+			t.Fatalf("unexpected error reading products by id: %s", err.Error())
+		}
+		names := map[string]bool{}
+		for _, p := range got {
+			names[p.Name] = true
+		}
+		if len(got) != 2 || !names["cherry"] || !names["date"] {
+			t.Errorf("expected the cherry and the date once each, skipping the missing id, got %d products: %v", len(got), names)
+		}
+		if got, err := repo.GetByIDs([]uint{}); err != nil || len(got) != 0 {
+			t.Errorf("expected no products for no ids, got %d (%v)", len(got), err)
+		}
+	})
+
+	t.Run("exists all", func(t *testing.T) {
+		repo := newRepos(t).Products
+		products := createProducts(t, repo, testProducts()[:2])
+		missingID := products[1].ID + 100
+		for _, c := range []struct {
+			name string
+			ids  []uint
+			want []uint
+		}{
+			{"all existing", []uint{products[1].ID, products[0].ID}, nil},
+			{"some missing, in the order supplied", []uint{missingID + 1, products[0].ID, missingID}, []uint{missingID + 1, missingID}},
+			{"no ids", []uint{}, nil},
+		} {
+			missing, err := repo.ExistsAll(c.ids)
+			if err != nil {
+				t.Fatalf("unexpected error checking %s products exist: %s", c.name, err.Error())
+			}
+			if len(missing) != len(c.want) {
+				t.Errorf("expected %s products to be missing %v, got %v", c.name, c.want, missing)
+				continue
+			}
+			for i := range missing {
+				if missing[i] != c.want[i] {
+					t.Errorf("expected %s products to be missing %v, got %v", c.name, c.want, missing)
+					break
+				}
+			}
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		repo := newRepos(t).Products
+		products := createProducts(t, repo, testProducts())
+		ids := make([]uint, len(products))
+		for i, p := range products {
+			ids[i] = p.ID
+		}
+		byName := []repository.Sort{{Field: "name"}}
+		runSeekCases(t, []seekCase{
+			{name: "everything in id order", want: []int{0, 1, 2, 3, 4}, count: -1},
+			{name: "first page", seek: repository.PageSeekOptions{RecordLimit: 2}, want: []int{0, 1}, count: 5},
+			{name: "after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, RecordLimit: 2}, from: 1, want: []int{2, 3}, count: 3},
+			{name: "after the last", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, RecordLimit: 2}, from: 4, count: -1},
+			{name: "before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, RecordLimit: 2}, from: 3, want: []int{1, 2}, count: 3},
+			{name: "before the first", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, RecordLimit: 2}, from: 0, count: -1},
+			{name: "limit beyond the last", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, RecordLimit: 10}, from: 2, want: []int{3, 4}, count: -1},
+			{name: "sorted", seek: repository.PageSeekOptions{Sort: byName}, want: []int{1, 2, 0, 3, 4}, count: -1},
+			{name: "sorted after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, Sort: byName, StartKeys: []interface{}{"banana"}, RecordLimit: 2}, from: 2, want: []int{0, 3}, count: 3},
+			{name: "sorted descending before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, Sort: []repository.Sort{{Field: "name", Descending: true}}, StartKeys: []interface{}{"cherry"}, RecordLimit: 5}, from: 0, want: []int{4, 3}, count: -1},
+			{name: "ties broken by id", seek: repository.PageSeekOptions{Sort: []repository.Sort{{Field: "price"}}}, want: []int{1, 2, 4, 0, 3}, count: -1},
+			{name: "tie sorted after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, Sort: []repository.Sort{{Field: "price"}}, StartKeys: []interface{}{float64(1)}, RecordLimit: 2}, from: 1, want: []int{2, 4}, count: 4},
+			{name: "filtered", seek: repository.PageSeekOptions{Filters: []repository.Filter{{Field: "numinstock", Operator: repository.FilterOpGreater, Value: uint64(0)}}}, want: []int{0, 2, 3, 4}, count: -1},
+			{name: "filtered and sorted", seek: repository.PageSeekOptions{Filters: []repository.Filter{{Field: "price", Operator: repository.FilterOpLessOrEqual, Value: float64(2)}}, Sort: []repository.Sort{{Field: "numinstock", Descending: true}}}, want: []int{4, 2, 1}, count: -1},
+			{name: "filtered by substring ignoring case", seek: repository.PageSeekOptions{Filters: []repository.Filter{{Field: "name", Operator: repository.FilterOpContains, Value: "ERR"}}}, want: []int{0, 4}, count: -1},
+			{name: "filtered out", seek: repository.PageSeekOptions{Filters: []repository.Filter{{Field: "name", Operator: repository.FilterOpEqual, Value: "fig"}}}, count: -1},
+			{name: "invalid direction", seek: repository.PageSeekOptions{Direction: "sideways"}, wantErr: true},
+			{name: "missing start keys", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, Sort: byName}, wantErr: true},
+		}, ids, repo.Count, func(seek *repository.PageSeekOptions) ([]uint, error) {
+			products, err := repo.Fetch(seek)
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]uint, 0, len(products))
+			for _, p := range products {
+				ids = append(ids, p.ID)
+			}
+			return ids, nil
+		})
+	})
+
+	t.Run("selected fields", func(t *testing.T) {
+		repo := newRepos(t).Products
+		createProducts(t, repo, testProducts())
+		products, err := repo.Fetch(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Fields: []string{"name"}, Sort: []repository.Sort{{Field: "price"}}})
+		if err != nil {
+			t.Fatalf("unexpected error fetching products: %s", err.Error())
+		}
+		if len(products) != 5 {
+			t.Fatalf("expected 5 products, got %d", len(products))
+		}
+		for _, p := range products {
+			if p.ID == 0 || p.Name == "" || p.Price == 0 {
+				t.Errorf("expected the id, the selected field and the sort key to be read, got %+v", p)
+			}
+			if p.Symbol != "" || p.NumInStock != 0 || p.Version != 0 {
+				t.Errorf("expected the other fields to be left empty, got %+v", p)
+			}
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		for _, c := range []struct {
+			name   string
+			update models.Product
+			fields []string
+			want   models.Product
+		}{
+			{
+				name:   "every non-zero field",
+				update: models.Product{Name: "plum", Price: 0, NumInStock: 9},
+				want:   models.Product{Name: "plum", Symbol: "🍒", Price: 3, NumInStock: 9},
+			},
+			{
+				name:   "selected fields, even when zero",
+				update: models.Product{Name: "plum", Price: 2.5, NumInStock: 0},
+				fields: []string{"price", "num_in_stock"},
+				want:   models.Product{Name: "cherry", Symbol: "🍒", Price: 2.5, NumInStock: 0},
+			},
+			{
+				name:   "fields selected by their go name",
+				update: models.Product{Name: "plum", Symbol: "🫒"},
+				fields: []string{"Symbol"},
+				want:   models.Product{Name: "cherry", Symbol: "🫒", Price: 3, NumInStock: 10},
+			},
+		} {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				repo := newRepos(t).Products
+				created := createProducts(t, repo, testProducts()[:1])[0]
+				update := c.update
+				update.ID, update.Version = created.ID, created.Version
+				updated, err := repo.Update(&update, c.fields)
+				if err != nil {
+					t.Fatalf("unexpected error updating the product: %s", err.Error())
+				}
+				if update.Version != 2 {
+					t.Errorf("expected the version of the supplied product to be bumped to 2, got %d", update.Version)
+				}
+				if updated.ID != created.ID || updated.Name != c.want.Name || updated.Symbol != c.want.Symbol || updated.Price != c.want.Price || updated.NumInStock != c.want.NumInStock {
+					t.Errorf("expected the updated product to be %+v, got %+v", c.want, updated)
+				}
+				if updated.Version != 2 || updated.UpdatedAt.Before(created.UpdatedAt) || !updated.CreatedAt.Equal(created.CreatedAt) {
+					t.Errorf("expected the update to bump the version and the update time only, from %+v, got %+v", created, updated)
+				}
+				stored, err := repo.GetByID(created.ID)
+				if err != nil {
+					t.Fatalf("unexpected error reading the updated product: %s", err.Error())
+				}
+				if stored.Name != updated.Name || stored.Symbol != updated.Symbol || stored.Price != updated.Price || stored.NumInStock != updated.NumInStock || stored.Version != updated.Version {
+					t.Errorf("expected the updated product to be returned as stored, %+v, got %+v", stored, updated)
+				}
+			})
+		}
+	})
+
+	t.Run("update stale version", func(t *testing.T) {
+		repo := newRepos(t).Products
+		created := createProducts(t, repo, testProducts()[:1])[0]
+		if _, err := repo.Update(&models.Product{Model: gormModel(created.ID), Price: 4, Version: 1}, []string{"price"}); err != nil {
+			t.Fatalf("unexpected error updating the product: %s", err.Error())
+		}
+		stale := models.Product{Model: gormModel(created.ID), Price: 5, Version: 1}
+		updated, err := repo.Update(&stale, []string{"price"})
+		if !errors.Is(err, repository.ErrVersionConflict) {
+			t.Errorf("expected updating a stale version to fail with %v, got %v", repository.ErrVersionConflict, err)
+		}
+		if updated != nil || stale.Version != 1 {
+			t.Errorf("expected no product and the supplied version to be left alone, got %+v and version %d", updated, stale.Version)
+		}
+		if stored, err := repo.GetByID(created.ID); err != nil || stored.Price != 4 || stored.Version != 2 {
+			t.Errorf("expected the stored product to be left alone, got %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("update concurrently", func(t *testing.T) {
+		repo := newRepos(t).Products
+		created := createProducts(t, repo, testProducts()[:1])[0]
+		errs := runConcurrently(func(n int) error {
+			_, err := repo.Update(&models.Product{Model: gormModel(created.ID), NumInStock: 100 + n, Version: created.Version}, []string{"num_in_stock"})
+			return err
+		})
+		winner := expectOneWinner(t, errs)
+		if stored, err := repo.GetByID(created.ID); err != nil || stored.NumInStock != 100+winner || stored.Version != 2 {
+			t.Errorf("expected the winning update to be stored at version 2, got %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepos(t).Products
+		products := createProducts(t, repo, testProducts())
+		if err := repo.Delete(products[1].ID); err != nil {
+			t.Fatalf("unexpected error deleting a product: %s", err.Error())
+		}
+		_, err := repo.GetByID(products[1].ID)
+		expectNotFound(t, "reading a deleted product", err)
+		expectExists(t, repo.Exists, products[1].ID, false)
+		expectExists(t, repo.Exists, products[2].ID, true)
+		if n, err := repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone}); err != nil || n != 4 {
+			t.Errorf("expected 4 products left, got %d (%v)", n, err)
+		}
+		_, err = repo.Update(&models.Product{Model: gormModel(products[1].ID), Price: 2, Version: 1}, nil)
+		expectNotFound(t, "updating a deleted product", err)
+		if err := repo.Delete(products[1].ID); err != nil {
+			t.Errorf("expected deleting a deleted product to do nothing, got %s", err.Error())
+		}
+
+		// Deleted products are archived: still read by id, for the orders they are in, and listed in the inactive scope.
+		if missing, err := repo.ExistsAll([]uint{products[0].ID, products[1].ID}); err != nil || len(missing) != 1 || missing[0] != products[1].ID {
+			t.Errorf("expected only the deleted product to be missing, got %v (%v)", missing, err)
+		}
+		if got, err := repo.GetByIDs([]uint{products[1].ID}); err != nil || len(got) != 1 || got[0].Name != products[1].Name {
+			t.Errorf("expected the deleted product to be read by ids, got %v (%v)", got, err)
+		}
+		_, err = repo.GetByID(products[1].ID)
+		expectNotFound(t, "reading a deleted product once read by ids", err)
+		for scope, want := range map[string]int64{repository.ScopeActive: 4, repository.ScopeInactive: 1, repository.ScopeAll: 5} {
+			if n, err := repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Scope: scope}); err != nil || n != want {
+				t.Errorf("expected %d %s products, got %d (%v)", want, scope, n, err)
+			}
+		}
+		if got, err := repo.Fetch(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Scope: repository.ScopeInactive, RecordLimit: 10}); err != nil || len(got) != 1 || got[0].ID != products[1].ID {
+			t.Errorf("expected only the deleted product in the inactive scope, got %v (%v)", got, err)
+		}
+		expectNotFound(t, "restoring an active product", repo.Restore(products[0].ID))
+		expectNotFound(t, "purging an active product", repo.Purge(products[0].ID))
+
+		if err := repo.Restore(products[1].ID); err != nil {
+			t.Fatalf("unexpected error restoring a deleted product: %s", err.Error())
+		}
+		if got, err := repo.GetByID(products[1].ID); err != nil || got.Name != products[1].Name || got.Version != 1 {
+			t.Errorf("expected the restored product to be as it was, got %+v (%v)", got, err)
+		}
+
+		if err := repo.Delete(products[1].ID); err != nil {
+			t.Fatalf("unexpected error deleting a product: %s", err.Error())
+		}
+		if err := repo.Purge(products[1].ID); err != nil {
+			t.Fatalf("unexpected error purging a deleted product: %s", err.Error())
+		}
+		if n, err := repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Scope: repository.ScopeAll}); err != nil || n != 4 {
+			t.Errorf("expected the purged product to be gone from every scope, got a count of %d (%v)", n, err)
+		}
+		expectNotFound(t, "restoring a purged product", repo.Restore(products[1].ID))
+	})
+
+	t.Run("delete with items", func(t *testing.T) {
+		repos := newRepos(t)
+		items, _, products := createItems(t, repos)
+		if err := repos.Products.Delete(products[0]); err != nil {
+			t.Fatalf("unexpected error deleting a product: %s", err.Error())
+		}
+		// The items of an archived product are kept, and it can't be purged while they are.
+		for _, item := range items {
+			expectExists(t, repos.Items.Exists, item.ID, true)
+		}
+		if err := repos.Products.Purge(products[0]); err == nil {
+			t.Errorf("expected purging a product with items to fail")
+		}
+		if got, err := repos.Products.GetByIDs([]uint{products[0]}); err != nil || len(got) != 1 {
+			t.Errorf("expected the product to be kept, got %v (%v)", got, err)
+		}
+		if err := repos.Items.DeleteAll([]uint{items[0].ID, items[1].ID}); err != nil {
+			t.Fatalf("unexpected error deleting items: %s", err.Error())
+		}
+		if err := repos.Products.Purge(products[0]); err != nil {
+			t.Errorf("unexpected error purging a product without items: %s", err.Error())
+		}
+	})
+}
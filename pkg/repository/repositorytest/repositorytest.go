@@ -0,0 +1,168 @@
+// Package repositorytest provides conformance tests for implementations of the repository interfaces.
+// Each implementation runs them from its own tests, so they all behave the same way behind the interfaces:
+//
+//	func TestProductRepository(t *testing.T) {
+//		repositorytest.TestProductRepository(t, func(t *testing.T) *repository.Repositories {
+//			return memory.NewMemoryRepositories(memory.NewStore())
+//		})
+//	}
+package repositorytest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"gorm.io/gorm"
+)
+
+// NewRepositories returns repositories of every type of record with no records in them, for a single test.
+// The tests only use the repositories they are testing, and those holding the records those refer to.
+type NewRepositories func(t *testing.T) *repository.Repositories
+
+// Number of goroutines the concurrency tests write from at once.
+const concurrentWriters = 8
+
+// seekCase is a single case of the pagination tests: a seek through a fixed set of records, and the records it should find.
+type seekCase struct {
+	name string
+	seek repository.PageSeekOptions
+	// Index of the record to seek from, in the order the records were created. (ignored if not seeking)
+	from int
+	// Indexes of the records Fetch should return, in order.
+	want []int
+	// What Count should return. (-1 for the number of records in want)
+	count int64
+	// Whether the seek is invalid, and should fail.
+	wantErr bool
+}
+
+// runSeekCases runs the supplied pagination cases against records with the supplied ids, in the order they were created,
+// with the supplied functions counting the records matching a seek, and returning the ids of the records fetched with it.
+func runSeekCases(t *testing.T, cases []seekCase, ids []uint, count func(seek *repository.PageSeekOptions) (int64, error), fetch func(seek *repository.PageSeekOptions) ([]uint, error)) {
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			seek := c.seek
+			if seek.Direction == "" {
+				seek.Direction = repository.SeekDirectionNone
+			}
+			if seek.Direction != repository.SeekDirectionNone {
+				seek.StartId = ids[c.from]
+			}
+			n, err := count(&seek)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("expected counting with %+v to fail, got %d", seek, n)
+				}
+				if got, err := fetch(&seek); err == nil {
+					t.Errorf("expected fetching with %+v to fail, got ids %v", seek, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error counting: %s", err.Error())
+			}
+			want := make([]uint, 0, len(c.want))
+			for _, i := range c.want {
+				want = append(want, ids[i])
+			}
+			wantCount := c.count
+			if wantCount < 0 {
+				wantCount = int64(len(want))
+			}
+			if n != wantCount {
+				t.Errorf("expected a count of %d, got %d", wantCount, n)
+			}
+			got, err := fetch(&seek)
+			if err != nil {
+				t.Fatalf("unexpected error fetching: %s", err.Error())
+			}
+			if len(got) != 0 || len(want) != 0 {
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("expected ids %v, got %v", want, got)
+				}
+			}
+		})
+	}
+}
+
+// expectNotFound fails the supplied test if the supplied error isn't gorm.ErrRecordNotFound, as returned by every implementation for missing records.
+func expectNotFound(t *testing.T, op string, err error) {
+	t.Helper()
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("expected %s to fail with %v, got %v", op, gorm.ErrRecordNotFound, err)
+	}
+}
+
+// expectExists fails the supplied test if the supplied function doesn't report that the record with the supplied id exists, or doesn't.
+func expectExists(t *testing.T, exists func(id uint) (bool, error), id uint, want bool) {
+	t.Helper()
+	got, err := exists(id)
+	if err != nil {
+		t.Fatalf("unexpected error checking whether record %d exists: %s", id, err.Error())
+	}
+	if got != want {
+		t.Errorf("expected record %d to exist: %t, got %t", id, want, got)
+	}
+}
+
+// runConcurrently calls the supplied function from concurrentWriters goroutines at once, with the number of each,
+// and returns the errors they return.
+func runConcurrently(fn func(n int) error) []error {
+	start := make(chan struct{})
+	errs := make([]error, concurrentWriters)
+	done := make(chan struct{})
+	for n := 0; n < concurrentWriters; n++ {
+		go func(n int) {
+			<-start
+			errs[n] = fn(n)
+			done <- struct{}{}
+		}(n)
+	}
+	close(start)
+	for n := 0; n < concurrentWriters; n++ {
+		<-done
+	}
+	return errs
+}
+
+// expectOneWinner fails the supplied test unless exactly one of the supplied errors of racing compare and swap updates is nil,
+// and the others are all repository.ErrVersionConflict. Returns the number of the goroutine whose update won.
+func expectOneWinner(t *testing.T, errs []error) int {
+	t.Helper()
+	winner := -1
+	for n, err := range errs {
+		switch {
+		case err == nil && winner < 0:
+			winner = n
+		case err == nil:
+			t.Errorf("expected only one of the racing updates to succeed, got %d and %d", winner, n)
+		case !errors.Is(err, repository.ErrVersionConflict):
+			t.Errorf("expected the racing updates that lost to fail with %v, got %v", repository.ErrVersionConflict, err)
+		}
+	}
+	if winner < 0 {
+		t.Fatalf("expected one of the racing updates to succeed, got %v", errs)
+	}
+	return winner
+}
+
+// expectDistinctIDs fails the supplied test if any of the supplied ids is zero or given to more than one record.
+func expectDistinctIDs(t *testing.T, ids []uint) {
+	t.Helper()
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			t.Errorf("expected every created record to get its own id, got %v", ids)
+			return
+		}
+		seen[id] = true
+	}
+}
+
+// gormModel returns the model of the record with the supplied id, to update it.
+func gormModel(id uint) gorm.Model {
+	return gorm.Model{ID: id}
+}
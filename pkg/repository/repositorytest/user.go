@@ -0,0 +1,317 @@
+package repositorytest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+// testUsers are the users the user tests are run against, created in this order.
+func testUsers() []*models.User {
+	return []*models.User{
+		{Name: "carol", Role: roles.Employee},
+		{Name: "alice", Role: roles.Customer},
+		{Name: "bob", Role: roles.Customer},
+		{Name: "dave", Role: roles.Admin},
+	}
+}
+
+// createUsers creates the supplied users in the supplied repository, in order.
+func createUsers(t *testing.T, repo repository.User, users []*models.User) []*models.User {
+	t.Helper()
+	for _, u := range users {
+		if _, err := repo.Create(u); err != nil {
+			t.Fatalf("failed to create user %s: %s", u.Name, err.Error())
+		}
+	}
+	return users
+}
+
+// TestUserRepository tests that the user account repositories returned by the supplied function behave like every other user account repository.
+func TestUserRepository(t *testing.T, newRepos NewRepositories) {
+	t.Run("create", func(t *testing.T) {
+		repo := newRepos(t).Users
+		u := &models.User{Name: "alice", Role: roles.Customer}
+		if err := repo.HashPassword(u, "pa55word!"); err != nil {
+			t.Fatalf("unexpected error hashing a password: %s", err.Error())
+		}
+		id, err := repo.Create(u)
+		if err != nil {
+			t.Fatalf("unexpected error creating a user: %s", err.Error())
+		}
+		if id == 0 || id != u.ID || u.Version != 1 || u.CreatedAt.IsZero() {
+			t.Errorf("expected the created user to get an id, version 1 and its creation time, got id %d and %+v", id, u)
+		}
+		for _, get := range []struct {
+			name string
+			get  func() (*models.User, error)
+		}{
+			{"id", func() (*models.User, error) { return repo.GetByID(id) }},
+			{"username", func() (*models.User, error) { return repo.GetByUsername("alice") }},
+		} {
+			got, err := get.get()
+			if err != nil {
+				t.Fatalf("unexpected error reading the created user by %s: %s", get.name, err.Error())
+			}
+			if got.ID != id || got.Name != u.Name || got.Role != u.Role || got.Password != u.Password || got.Version != 1 {
+				t.Errorf("expected to read the user as created by %s, %+v, got %+v", get.name, u, got)
+			}
+		}
+		expectExists(t, repo.Exists, id, true)
+	})
+
+	t.Run("passwords", func(t *testing.T) {
+		repo := newRepos(t).Users
+		u := &models.User{Name: "alice"}
+		if err := repo.HashPassword(u, "pa55word!"); err != nil {
+			t.Fatalf("unexpected error hashing a password: %s", err.Error())
+		}
+		if u.Password == "" || u.Password == "pa55word!" {
+			t.Errorf("expected the password to be hashed, got %q", u.Password)
+		}
+		if err := repo.CheckPassword(u, "pa55word!"); err != nil {
+			t.Errorf("expected the password to match its hash, got %s", err.Error())
+		}
+		if err := repo.CheckPassword(u, "pa55word?"); err == nil {
+			t.Error("expected another password not to match the hash")
+		}
+	})
+
+	t.Run("create concurrently", func(t *testing.T) {
+		repo := newRepos(t).Users
+		ids := make([]uint, concurrentWriters)
+		errs := runConcurrently(func(n int) (err error) {
+			ids[n], err = repo.Create(&models.User{Name: "user" + string(rune('a'+n)), Role: roles.Customer})
+			return err
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error creating users concurrently: %s", err.Error())
+			}
+		}
+		expectDistinctIDs(t, ids)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo := newRepos(t).Users
+		u, err := repo.GetByID(1)
+		expectNotFound(t, "reading a missing user", err)
+		if u != nil {
+			t.Errorf("expected no user, got %+v", u)
+		}
+		_, err = repo.GetByUsername("alice")
+		expectNotFound(t, "reading a missing user by username", err)
+		expectExists(t, repo.Exists, 1, false)
+		updated, err := repo.Update(&models.User{Model: gormModel(1), Name: "bob", Version: 1}, nil)
+		expectNotFound(t, "updating a missing user", err)
+		if updated != nil {
+			t.Errorf("expected no user, got %+v", updated)
+		}
+		if err := repo.Delete(1); err != nil {
+			t.Errorf("expected deleting a missing user to do nothing, got %s", err.Error())
+		}
+		expectNotFound(t, "restoring a missing user", repo.Restore(1))
+		expectNotFound(t, "purging a missing user", repo.Purge(1))
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		repo := newRepos(t).Users
+		users := createUsers(t, repo, testUsers())
+		ids := make([]uint, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		byName := []repository.Sort{{Field: "name"}}
+		customers := []repository.Filter{{Field: "role", Operator: repository.FilterOpEqual, Value: roles.Customer}}
+		runSeekCases(t, []seekCase{
+			{name: "everything in id order", want: []int{0, 1, 2, 3}, count: -1},
+			{name: "after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, RecordLimit: 2}, from: 0, want: []int{1, 2}, count: 3},
+			{name: "before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, RecordLimit: 2}, from: 3, want: []int{1, 2}, count: 3},
+			{name: "sorted", seek: repository.PageSeekOptions{Sort: byName}, want: []int{1, 2, 0, 3}, count: -1},
+			{name: "sorted before", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionBefore, Sort: byName, StartKeys: []interface{}{"carol"}, RecordLimit: 1}, from: 0, want: []int{2}, count: 2},
+			{name: "filtered", seek: repository.PageSeekOptions{Filters: customers}, want: []int{1, 2}, count: -1},
+			{name: "filtered after", seek: repository.PageSeekOptions{Direction: repository.SeekDirectionAfter, Filters: customers}, from: 1, want: []int{2}, count: -1},
+			{name: "invalid direction", seek: repository.PageSeekOptions{Direction: "sideways"}, wantErr: true},
+			{name: "invalid scope", seek: repository.PageSeekOptions{Scope: "former"}, wantErr: true},
+		}, ids, repo.Count, func(seek *repository.PageSeekOptions) ([]uint, error) {
+			users, err := repo.Fetch(seek)
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]uint, 0, len(users))
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			return ids, nil
+		})
+	})
+
+	t.Run("update", func(t *testing.T) {
+		for _, c := range []struct {
+			name   string
+			update models.User
+			fields []string
+			want   models.User
+		}{
+			{
+				name:   "every non-zero field",
+				update: models.User{Role: roles.Admin},
+				want:   models.User{Name: "carol", Role: roles.Admin},
+			},
+			{
+				name:   "selected fields",
+				update: models.User{Name: "caroline", Role: roles.Admin},
+				fields: []string{"name"},
+				want:   models.User{Name: "caroline", Role: roles.Employee},
+			},
+		} {
+			c := c
+			t.Run(c.name, func(t *testing.T) {
+				repo := newRepos(t).Users
+				created := createUsers(t, repo, testUsers()[:1])[0]
+				update := c.update
+				update.ID, update.Version = created.ID, created.Version
+				updated, err := repo.Update(&update, c.fields)
+				if err != nil {
+					t.Fatalf("unexpected error updating the user: %s", err.Error())
+				}
+				if update.Version != 2 {
+					t.Errorf("expected the version of the supplied user to be bumped to 2, got %d", update.Version)
+				}
+				if updated.ID != created.ID || updated.Name != c.want.Name || updated.Role != c.want.Role || updated.Version != 2 {
+					t.Errorf("expected the updated user to be %+v at version 2, got %+v", c.want, updated)
+				}
+			})
+		}
+	})
+
+	t.Run("update stale version", func(t *testing.T) {
+		repo := newRepos(t).Users
+		created := createUsers(t, repo, testUsers()[:1])[0]
+		if _, err := repo.Update(&models.User{Model: gormModel(created.ID), Role: roles.Admin, Version: 1}, []string{"role"}); err != nil {
+			t.Fatalf("unexpected error updating the user: %s", err.Error())
+		}
+		stale := models.User{Model: gormModel(created.ID), Role: roles.Customer, Version: 1}
+		updated, err := repo.Update(&stale, []string{"role"})
+		if !errors.Is(err, repository.ErrVersionConflict) {
+			t.Errorf("expected updating a stale version to fail with %v, got %v", repository.ErrVersionConflict, err)
+		}
+		if updated != nil || stale.Version != 1 {
+			t.Errorf("expected no user and the supplied version to be left alone, got %+v and version %d", updated, stale.Version)
+		}
+		if stored, err := repo.GetByID(created.ID); err != nil || stored.Role != roles.Admin || stored.Version != 2 {
+			t.Errorf("expected the stored user to be left alone, got %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("update concurrently", func(t *testing.T) {
+		repo := newRepos(t).Users
+		created := createUsers(t, repo, testUsers()[:1])[0]
+		errs := runConcurrently(func(n int) error {
+			_, err := repo.Update(&models.User{Model: gormModel(created.ID), Name: "carol" + string(rune('a'+n)), Version: created.Version}, []string{"name"})
+			return err
+		})
+		winner := expectOneWinner(t, errs)
+		if stored, err := repo.GetByID(created.ID); err != nil || stored.Name != "carol"+string(rune('a'+winner)) || stored.Version != 2 {
+			t.Errorf("expected the winning update to be stored at version 2, got %+v (%v)", stored, err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepos(t).Users
+		users := createUsers(t, repo, testUsers())
+		bob := users[2]
+		if err := repo.Delete(bob.ID); err != nil {
+			t.Fatalf("unexpected error deleting a user: %s", err.Error())
+		}
+
+		// Deleted users are deactivated: left out of everything but the inactive scope, GetByIDUnscoped, Restore and Purge.
+		_, err := repo.GetByID(bob.ID)
+		expectNotFound(t, "reading a deleted user", err)
+		if got, err := repo.GetByIDUnscoped(bob.ID); err != nil || got.ID != bob.ID || !got.DeletedAt.Valid {
+			t.Errorf("expected the deleted user to be read unscoped, got %+v (%v)", got, err)
+		}
+		if got, err := repo.GetByIDUnscoped(users[0].ID); err != nil || got.ID != users[0].ID || got.DeletedAt.Valid {
+			t.Errorf("expected an active user to be read unscoped, got %+v (%v)", got, err)
+		}
+		_, err = repo.GetByUsername(bob.Name)
+		expectNotFound(t, "reading a deleted user by username", err)
+		expectExists(t, repo.Exists, bob.ID, false)
+		if got, err := repo.GetByIDs([]uint{users[0].ID, bob.ID}); err != nil || len(got) != 1 || got[0].ID != users[0].ID {
+			t.Errorf("expected only the active user to be read by id, got %v (%v)", got, err)
+		}
+		_, err = repo.Update(&models.User{Model: gormModel(bob.ID), Role: roles.Admin, Version: 1}, nil)
+		expectNotFound(t, "updating a deleted user", err)
+		expectNotFound(t, "restoring an active user", repo.Restore(users[0].ID))
+		expectNotFound(t, "purging an active user", repo.Purge(users[0].ID))
+		if err := repo.Delete(bob.ID); err != nil {
+			t.Errorf("expected deleting a deleted user to do nothing, got %s", err.Error())
+		}
+		ids := []uint{users[0].ID, users[1].ID, bob.ID, users[3].ID}
+		runSeekCases(t, []seekCase{
+			{name: "active", want: []int{0, 1, 3}, count: -1},
+			{name: "explicitly active", seek: repository.PageSeekOptions{Scope: repository.ScopeActive}, want: []int{0, 1, 3}, count: -1},
+			{name: "inactive", seek: repository.PageSeekOptions{Scope: repository.ScopeInactive}, want: []int{2}, count: -1},
+			{name: "all", seek: repository.PageSeekOptions{Scope: repository.ScopeAll}, want: []int{0, 1, 2, 3}, count: -1},
+			{name: "all after", seek: repository.PageSeekOptions{Scope: repository.ScopeAll, Direction: repository.SeekDirectionAfter}, from: 1, want: []int{2, 3}, count: -1},
+		}, ids, repo.Count, func(seek *repository.PageSeekOptions) ([]uint, error) {
+			users, err := repo.Fetch(seek)
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]uint, 0, len(users))
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			return ids, nil
+		})
+
+		if err := repo.Restore(bob.ID); err != nil {
+			t.Fatalf("unexpected error restoring a deleted user: %s", err.Error())
+		}
+		if got, err := repo.GetByUsername(bob.Name); err != nil || got.ID != bob.ID || got.Role != bob.Role {
+			t.Errorf("expected the restored user to be as it was, got %+v (%v)", got, err)
+		}
+
+		if err := repo.Delete(bob.ID); err != nil {
+			t.Fatalf("unexpected error deleting a user: %s", err.Error())
+		}
+		if err := repo.Purge(bob.ID); err != nil {
+			t.Fatalf("unexpected error purging a deleted user: %s", err.Error())
+		}
+		if n, err := repo.Count(&repository.PageSeekOptions{Direction: repository.SeekDirectionNone, Scope: repository.ScopeAll}); err != nil || n != 3 {
+			t.Errorf("expected the purged user to be gone from every scope, got a count of %d (%v)", n, err)
+		}
+		expectNotFound(t, "restoring a purged user", repo.Restore(bob.ID))
+		_, err = repo.GetByIDUnscoped(bob.ID)
+		expectNotFound(t, "reading a purged user unscoped", err)
+	})
+
+	t.Run("purge with orders", func(t *testing.T) {
+		repos := newRepos(t)
+		alice, bob := createOwners(t, repos)
+		orders := createOrders(t, repos.Orders, testOrders(alice, bob))
+		if err := repos.Users.Delete(bob); err != nil {
+			t.Fatalf("unexpected error deleting a user: %s", err.Error())
+		}
+		if err := repos.Users.Purge(bob); err != nil {
+			t.Fatalf("unexpected error purging a deleted user: %s", err.Error())
+		}
+		// The orders of the purged user are kept, owned by no one.
+		for i, o := range orders {
+			got, err := repos.Orders.GetByID(o.ID)
+			if err != nil {
+				t.Fatalf("unexpected error reading an order: %s", err.Error())
+			}
+			if owned := i%2 == 0; got.OwnedBy(alice) != owned || (!owned && got.OwnerID != nil) {
+				t.Errorf("expected only the purged user's orders to be left without an owner, got order %d owned by %v", i, got.OwnerID)
+			}
+		}
+		if got, err := repos.Orders.GetByOwnerID(bob); err != nil || len(got) != 0 {
+			t.Errorf("expected no orders owned by the purged user, got %v (%v)", orderIDs(got), err)
+		}
+	})
+}
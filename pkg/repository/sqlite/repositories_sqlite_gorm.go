@@ -0,0 +1,15 @@
+// Package sqlite provides the repositories of every type of record kept in an SQLite database file.
+package sqlite
+
+import (
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/postgres"
+	"gorm.io/gorm"
+)
+
+// NewSQLiteRepositories creates the SQLite repositories of every type of record, all using the supplied database session.
+// They are the gorm implementations written for postgres: gorm builds their queries in the dialect of the database,
+// and the few statements only postgres understands are only run against postgres.
+func NewSQLiteRepositories(db *gorm.DB) *repository.Repositories {
+	return postgres.NewPostgresRepositories(db)
+}
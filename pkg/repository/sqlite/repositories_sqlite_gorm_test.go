@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+
+	sqlitedriver "github.com/tragicpixel/fruitbar/pkg/driver/sqlite"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/repositorytest"
+)
+
+// newTestRepositories returns SQLite repositories keeping their records in a new database file of the supplied test.
+func newTestRepositories(t *testing.T) *repository.Repositories {
+	db, err := sqlitedriver.OpenConnection(&sqlitedriver.SQLiteConnectionConfig{Path: filepath.Join(t.TempDir(), "fruitbar.db")})
+	if err != nil {
+		t.Fatalf("failed to open the test database: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.SQLite.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	for _, model := range []interface{}{&models.User{}, &models.Product{}, &models.Order{}, &models.Item{}} {
+		if err := sqlitedriver.SetupTables(db, model, true); err != nil {
+			t.Fatalf("failed to create the table of %T: %s", model, err.Error())
+		}
+	}
+	return NewSQLiteRepositories(db.SQLite)
+}
+
+func TestOrderRepository(t *testing.T) {
+	repositorytest.TestOrderRepository(t, newTestRepositories)
+}
+
+func TestItemRepository(t *testing.T) {
+	repositorytest.TestItemRepository(t, newTestRepositories)
+}
+
+func TestProductRepository(t *testing.T) {
+	repositorytest.TestProductRepository(t, newTestRepositories)
+}
+
+func TestUserRepository(t *testing.T) {
+	repositorytest.TestUserRepository(t, newTestRepositories)
+}
@@ -6,8 +6,10 @@ import (
 
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/query"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // TODO: Remove context
@@ -25,17 +27,17 @@ func NewPostgresUserRepo(db *gorm.DB) repository.User {
 }
 
 func (r *PostgresUserRepo) Count(seek *repository.PageSeekOptions) (count int64, err error) {
-	var result *gorm.DB
-	switch seek.Direction {
-	case repository.SeekDirectionBefore:
-		result = r.DB.Model(&models.User{}).Where("ID < ?", seek.StartId).Count(&count)
-	case repository.SeekDirectionAfter:
-		result = r.DB.Model(&models.User{}).Where("ID > ?", seek.StartId).Count(&count)
-	case repository.SeekDirectionNone:
-		result = r.DB.Model(&models.User{}).Count(&count)
-	default:
-		return -1, errors.New("invalid seek direction")
+	db, err := r.scoped(seek.Scope)
+	if err != nil {
+		return -1, err
+	}
+	if db, err = query.Filter(db, seek.Filters, repository.UserFields); err != nil {
+		return -1, err
+	}
+	if db, err = query.Seek(db, seek, repository.UserFields); err != nil {
+		return -1, err
 	}
+	result := db.Model(&models.User{}).Count(&count)
 	if result.Error != nil {
 		return -1, result.Error
 	}
@@ -43,20 +45,32 @@ func (r *PostgresUserRepo) Count(seek *repository.PageSeekOptions) (count int64,
 }
 
 func (r *PostgresUserRepo) Fetch(seek *repository.PageSeekOptions) (users []*models.User, err error) {
-	var result *gorm.DB
-	switch seek.Direction {
-	case repository.SeekDirectionBefore:
-		result = r.DB.Limit(seek.RecordLimit).Where("ID < ?", seek.StartId).Find(&users)
-	case repository.SeekDirectionAfter:
-		result = r.DB.Limit(seek.RecordLimit).Where("ID > ?", seek.StartId).Find(&users)
-	case repository.SeekDirectionNone:
-		result = r.DB.Limit(seek.RecordLimit).Find(&users)
-	default:
-		return nil, errors.New("invalid seek direction")
+	db, err := r.scoped(seek.Scope)
+	if err != nil {
+		return nil, err
+	}
+	if db, err = query.Filter(db, seek.Filters, repository.UserFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Seek(db, seek, repository.UserFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Order(db, seek, repository.UserFields); err != nil {
+		return nil, err
+	}
+	if db, err = query.Select(db, seek, repository.UserFields); err != nil {
+		return nil, err
 	}
+	result := db.Limit(seek.RecordLimit).Find(&users)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	if seek.Direction == repository.SeekDirectionBefore {
+		// Put the users closest to the start of the seek back in sort order.
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+	}
 	return users, nil
 }
 
@@ -78,6 +92,24 @@ func (r *PostgresUserRepo) GetByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
+func (r *PostgresUserRepo) GetByIDUnscoped(id uint) (*models.User, error) {
+	var user models.User
+	result := r.DB.Unscoped().First(&user, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+func (r *PostgresUserRepo) GetByIDs(ids []uint) ([]*models.User, error) {
+	var users []*models.User
+	result := r.DB.Where("id IN ?", ids).Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
+
 func (r *PostgresUserRepo) GetByUsername(uname string) (*models.User, error) {
 	var user models.User
 	result := r.DB.Limit(1).Where("name = ?", uname).First(&user)
@@ -88,7 +120,9 @@ func (r *PostgresUserRepo) GetByUsername(uname string) (*models.User, error) {
 }
 
 func (r *PostgresUserRepo) Create(u *models.User) (uint, error) {
-	result := r.DB.Create(&u)
+	u.Version = 1
+	// The user's orders are written through the order repository.
+	result := r.DB.Omit(clause.Associations).Create(&u)
 	if result.Error != nil {
 		return 0, result.Error
 	}
@@ -96,38 +130,74 @@ func (r *PostgresUserRepo) Create(u *models.User) (uint, error) {
 }
 
 func (r *PostgresUserRepo) Update(u *models.User, fields []string) (*models.User, error) {
-	_, err := r.GetByID(u.ID)
-	if err != nil {
-		return nil, err
-	}
+	// Compare and swap: only update the user if it still has the version it was read with, bumping the version as part of the update.
+	expected := u.Version
+	u.Version = expected + 1
+	db := r.DB.Model(u).Omit(clause.Associations).Where("version = ?", expected)
 	if len(fields) > 0 { // Partial update
-		result := r.DB.Model(u).Select(fields).Updates(u)
-		if result.Error != nil {
-			return nil, err
-		}
-	} else { // Full update
-		result := r.DB.Model(u).Updates(u)
-		if result.Error != nil {
+		db = db.Select(append(append([]string{}, fields...), "version"))
+	}
+	result := db.Updates(u)
+	if result.Error != nil {
+		u.Version = expected
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		u.Version = expected
+		// Either the user is gone, or someone else updated it first.
+		if _, err := r.GetByID(u.ID); err != nil {
 			return nil, err
 		}
+		return nil, repository.ErrVersionConflict
 	}
-	updated, err := r.GetByID(u.ID) // TODO: fix, doesn't return the updated product??
-	if err != nil {
-		return nil, err
-	}
-	return updated, nil
+	return r.GetByID(u.ID)
 }
 
 func (r *PostgresUserRepo) Delete(id uint) error {
-	// swap between these two based on some flag, set the flag in the deployment, so you can have different options for dev/test/prod builds
-	//result := r.DB.Delete(&models.User{}, id) // soft delete
-	result := r.DB.Unscoped().Delete(&models.User{}, id) // hard delete
+	// Soft delete: orders keep pointing at the user, so the row must stay until it is explicitly purged.
+	result := r.DB.Delete(&models.User{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *PostgresUserRepo) Restore(id uint) error {
+	result := r.DB.Unscoped().Model(&models.User{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
 	if result.Error != nil {
 		return result.Error
 	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
 	return nil
 }
 
+func (r *PostgresUserRepo) Purge(id uint) error {
+	result := r.DB.Unscoped().Where("deleted_at IS NOT NULL").Delete(&models.User{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// scoped returns a query limited to the users in the supplied scope.
+func (r *PostgresUserRepo) scoped(scope string) (*gorm.DB, error) {
+	switch scope {
+	case "", repository.ScopeActive:
+		return r.DB, nil
+	case repository.ScopeInactive:
+		return r.DB.Unscoped().Where("deleted_at IS NOT NULL"), nil
+	case repository.ScopeAll:
+		return r.DB.Unscoped(), nil
+	default:
+		return nil, errors.New("invalid scope")
+	}
+}
+
 func (r *PostgresUserRepo) HashPassword(u *models.User, pass string) error {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(pass), 14)
 	if err != nil {
@@ -14,14 +14,23 @@ type User interface {
 	Exists(id uint) (bool, error)
 	// GetByID finds and returns an individual user with the supplied id. Returns nil on error.
 	GetByID(id uint) (*models.User, error)
+	// GetByIDUnscoped returns the user with the supplied id, whether it is active or deactivated.
+	GetByIDUnscoped(id uint) (*models.User, error)
+	// GetByIDs returns the active users with any of the supplied ids, in a single query. Ids that don't exist are skipped.
+	GetByIDs(ids []uint) ([]*models.User, error)
 	// GetByID finds and returns an individual user with the supplied username. Returns nil on error.
 	GetByUsername(uname string) (*models.User, error)
 	// Create creates a new user and places it in the repository. Returns the ID of the newly created user, -1 on error.
 	Create(u *models.User) (uint, error)
 	// Update updates an existing user in the repository. Returns nil on error.
+	// The update only happens if the stored user still has the supplied user's version, otherwise ErrVersionConflict is returned. The version is bumped by the update.
 	Update(u *models.User, fields []string) (*models.User, error)
-	// Delete removes an existing user with the supplied id from the repository. Returns true on success, false on error.
+	// Delete deactivates an existing user with the supplied id. Deactivated users are excluded from every other operation except GetByIDUnscoped, Restore and Purge.
 	Delete(id uint) error
+	// Restore reactivates the deactivated user with the supplied id.
+	Restore(id uint) error
+	// Purge permanently removes the deactivated user with the supplied id from the repository.
+	Purge(id uint) error
 	// HashPassword hashes the supplied password and updates the supplied user's password to the hashed version.
 	HashPassword(u *models.User, pass string) error
 	// CheckPassword checks if the supplied user's *hashed* password matches the supplied raw (plain text) password.
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/tragicpixel/fruitbar/pkg/driver"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+)
+
+// setupAuditDB checks that the database has the audit log table every service appends to.
+// If init is true, will create the table if it does not already exist, and make sure it can only be appended to.
+func setupAuditDB(db *driver.DB, init bool) error {
+	err := setupTables(db, &models.AuditEntry{}, init)
+	if err != nil {
+		msg := "failed to set up the AuditEntry model table: " + err.Error()
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	if init {
+		err = setupAppendOnlyTable(db, &models.AuditEntry{})
+		if err != nil {
+			msg := "failed to make the AuditEntry model table append-only: " + err.Error()
+			log.Error(msg)
+			return errors.New(msg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/tragicpixel/fruitbar/pkg/driver"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+)
+
+// setupIdempotencyDB checks that the database has the table the responses to requests made with an idempotency key are stored in.
+// If init is true, will create the table if it does not already exist.
+func setupIdempotencyDB(db *driver.DB, init bool) error {
+	err := setupTables(db, &models.IdempotencyRecord{}, init)
+	if err != nil {
+		msg := "failed to set up the IdempotencyRecord model table: " + err.Error()
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	return nil
+}
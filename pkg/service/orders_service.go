@@ -3,132 +3,102 @@ package service
 import (
 	"github.com/tragicpixel/fruitbar/pkg/driver"
 	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	sqlitedriver "github.com/tragicpixel/fruitbar/pkg/driver/sqlite"
 	"github.com/tragicpixel/fruitbar/pkg/handler"
 	"github.com/tragicpixel/fruitbar/pkg/models"
-	"github.com/tragicpixel/fruitbar/pkg/utils/cors"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/cache"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
 	"github.com/tragicpixel/fruitbar/pkg/utils/json"
 	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
 
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // OrdersService holds all the pieces necessary to run the data entry service for the fruitbar application.
 type OrdersService struct {
-	Router          *mux.Router
-	Handler         *handler.Order
-	UserHandler     *handler.User
-	DB              *driver.DB
-	Port            int
-	SalesTaxPercent float64
+	Router      *mux.Router
+	Handler     *handler.Order
+	UserHandler *handler.User
+	// Handler making the unsafe endpoints safe to retry.
+	IdempotencyHandler *handler.Idempotency
+	// Connection to the database the records are kept in. (nil if they are kept in memory)
+	DB *driver.DB
+	// Repositories of the service's records.
+	Repos *repository.Repositories
+	Port  int
+	// Repository the service reads products through, keeping them in a cache. (nil if the records are kept in memory)
+	ProductCache *cache.CachedProductRepo
+	// How much of the traffic of the service is validated against its OpenAPI document.
+	OpenAPIValidation openapi.Mode
+	SalesTaxPercent   float64
 }
 
 type OrdersServiceConfig struct {
 	DatabaseConnection *pgdriver.PostgresConnectionConfig
 	Port               int
 	SalesTaxPercent    float64
+	// How long the responses to requests made with an Idempotency-Key header are kept for replay. (defaults to 24 hours)
+	IdempotencyKeyTTL time.Duration
+	// How much of the traffic of the service is validated against its OpenAPI document. (defaults to none)
+	OpenAPIValidation openapi.Mode
+	// Where the service keeps its records. (defaults to postgres, using DatabaseConnection)
+	Storage Storage
+	// Connection to the SQLite database file the records are kept in, when they are kept in one. (read from the environment if nil)
+	SQLiteConnection *sqlitedriver.SQLiteConnectionConfig
+	// Password of the admin user a service keeping its records in memory or in SQLite starts with. (a random one is generated and logged if empty)
+	AdminPassword string
+	// Store the records are kept in when they are kept in memory, so services run in the same process can share them. (a new one if nil)
+	MemoryStore *memory.Store
+	// Cache of the products the service reads, unless the records are kept in memory.
+	ProductCache cache.Config
 }
 
+// Paths of the orders service's endpoints. Path parameters are in braces.
 const (
-	ordersAPIBaseRoute               = "/orders"
-	ordersCreateAPIRoute             = ordersAPIBaseRoute
-	ordersReadAPIRoute               = ordersAPIBaseRoute
-	ordersUpdateAPIRoute             = ordersAPIBaseRoute
-	ordersDeleteAPIRoute             = ordersAPIBaseRoute
+	ordersAPIBaseRoute               = apiVersionPrefix + "/orders"
+	ordersAPIRoute                   = ordersAPIBaseRoute + "/{id}"
+	ordersItemsAPIRoute              = ordersAPIRoute + "/items"
+	ordersItemAPIRoute               = ordersItemsAPIRoute + "/{itemId}"
+	ordersRepriceAPIRoute            = ordersAPIRoute + "/reprice"
+	ordersBatchAPIRoute              = ordersAPIBaseRoute + "/batch"
 	ordersPageMaxRecordLimitAPIRoute = ordersAPIBaseRoute + "/page-max-record-limit"
 	ordersHealthAPIRoute             = ordersAPIBaseRoute + "/health"
-)
-
-func (s *OrdersService) getOrdersEndpointOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Orders Options",
-		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-	}
-}
-func (s *OrdersService) getCreateAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Create Order",
-		AllowedMethods: []string{http.MethodPost},
-	}
-}
-func (s *OrdersService) getReadAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Read Order",
-		AllowedMethods: []string{http.MethodGet},
-	}
-}
-func (s *OrdersService) getUpdateAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Update Order",
-		AllowedMethods: []string{http.MethodPut},
-	}
-}
-func (s *OrdersService) getDeleteAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Delete Order",
-		AllowedMethods: []string{http.MethodDelete},
-	}
-}
-func (s *OrdersService) getPageMaxRecordLimitAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Page Max Record Limit",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
-func (s *OrdersService) getHealthCheckAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Health Check",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
+	ordersMetricsAPIRoute            = ordersAPIBaseRoute + "/metrics"
 
-func (s *OrdersService) getCreateAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getCreateAPIOptions(), s.UserHandler.IsAuthorized(s.Handler.CreateOrder))
-}
-func (s *OrdersService) getReadAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getReadAPIOptions(), s.UserHandler.IsAuthorized(s.Handler.GetOrders))
-}
-func (s *OrdersService) getUpdateAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getUpdateAPIOptions(), s.UserHandler.IsAuthorized(s.Handler.UpdateOrder))
-}
-func (s *OrdersService) getDeleteAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getDeleteAPIOptions(), s.UserHandler.IsAuthorized(s.Handler.DeleteOrder))
-}
-func (s *OrdersService) getPageMaxRecordLimitAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getPageMaxRecordLimitAPIOptions(), s.Handler.GetPageMaxRecordLimit)
-}
-func (s *OrdersService) getHealthCheckAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getHealthCheckAPIOptions(), s.CheckHealth)
-}
+	// Deprecated, unversioned paths, which take the ids as query parameters.
+	legacyOrdersAPIBaseRoute               = "/orders"
+	legacyOrdersBatchAPIRoute              = legacyOrdersAPIBaseRoute + "/batch"
+	legacyOrdersPageMaxRecordLimitAPIRoute = legacyOrdersAPIBaseRoute + "/page-max-record-limit"
+	legacyOrdersHealthAPIRoute             = legacyOrdersAPIBaseRoute + "/health"
+)
 
 // NewOrdersService creates a new instance of a data entry service.
 // Returns nil on error.
 func NewOrdersService(config *OrdersServiceConfig) (*OrdersService, error) {
 	s := OrdersService{}
 
-	db, err := pgdriver.OpenConnection(config.DatabaseConnection)
+	db, repos, err := openStorage("orders", config.Storage, config.DatabaseConnection, config.SQLiteConnection, config.MemoryStore, setupOrdersServiceDB, config.AdminPassword)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to the orders service database: %s", err.Error())
+		return nil, err
 	}
 
+	repos, s.ProductCache = cacheProducts(db, repos, config.ProductCache)
 	s.DB = db
-	err = setupOrdersServiceDB(s.DB, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set up the orders service database: %s", err.Error())
-	}
-
-	s.Handler = handler.NewOrderHandler(db)
-	s.UserHandler = handler.NewUserHandler(db)
+	s.Repos = repos
+	s.Handler = handler.NewOrderHandler(repos)
+	s.IdempotencyHandler = handler.NewIdempotencyHandler(repos, config.IdempotencyKeyTTL)
+	s.UserHandler = handler.NewUserHandler(repos)
+	s.OpenAPIValidation = config.OpenAPIValidation
 	s.Router = s.NewOrdersServiceRouter(db)
 	s.Port = config.Port
 	s.SalesTaxPercent = config.SalesTaxPercent
@@ -138,151 +108,329 @@ func NewOrdersService(config *OrdersServiceConfig) (*OrdersService, error) {
 
 // NewOrdersServiceRouter creates and returns a new http router for the data entry service.
 func (s *OrdersService) NewOrdersServiceRouter(db *driver.DB) *mux.Router {
-	r := mux.NewRouter()
+	return newRouter("Fruitbar Orders API", s.routes(), s.OpenAPIValidation)
+}
 
-	r.HandleFunc(ordersAPIBaseRoute, cors.SendPreflightHeaders(s.getOrdersEndpointOptions(), nil)).Methods(http.MethodOptions)
-	// swagger:operation POST /orders/ orders createOrder
-	//
-	// Create a new order.
-	//
-	// ---
-	// parameters:
-	// - name: order
-	//   in: body
-	//   description: New order to create. Id, CreatedAt, DeletedAt, UpdatedAt fields will be ignored.
-	//   required: true
-	//   "$ref": "#/definitions/order"
-	// security:
-	// - bearer: []
-	// responses:
-	//   '201':
-	//     description: Successfully created an order.
-	//     "$ref": "#/responses/jsonResponse"
-	//     examples:
-	//       application/json: { "ok": 2 }
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(ordersCreateAPIRoute, s.getCreateAPIHandler()).Methods(s.getCreateAPIOptions().AllowedMethods...)
-	// swagger:operation GET /orders/ orders getOrder
-	//
-	// Get an order by ID, or a paginated listing of all orders.
-	//
-	// ---
-	// parameters:
-	// - name: id
-	//   in: query
-	//   description: id of order to retrieve.
-	//   required: false
-	//   schema:
-	//     type: int
-	// security:
-	// - bearer: []
-	// responses:
-	//   '200':
-	//     description: Successfully retrieved an order.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(ordersReadAPIRoute, s.getReadAPIHandler()).Methods(s.getReadAPIOptions().AllowedMethods...)
-	// swagger:operation PUT /orders/ orders updateOrder
-	//
-	// Update an existing order.
-	//
-	// ---
-	// parameters:
-	// - name: order
-	//   in: body
-	//   description: Order fields to update. CreatedAt, DeletedAt, UpdatedAt fields will be ignored.
-	//   required: true
-	//   schema:
-	//     $ref: "#/definitions/order"
-	// security:
-	// - bearer: []
-	// responses:
-	//   '200':
-	//     description: Successfully updated an existing order.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(ordersUpdateAPIRoute, s.getUpdateAPIHandler()).Methods(s.getUpdateAPIOptions().AllowedMethods...)
-	// swagger:operation DELETE /orders/ orders deleteOrder
-	//
-	// Delete an existing order.
-	//
-	// ---
-	// parameters:
-	// - name: id
-	//   in: query
-	//   description: id of order to delete.
-	//   required: true
-	//   schema:
-	//     type: int
-	// security:
-	// - bearer: []
-	// responses:
-	//   '204':
-	//     description: Successfully deleted an existing order.
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(ordersDeleteAPIRoute, s.getDeleteAPIHandler()).Methods(s.getDeleteAPIOptions().AllowedMethods...)
-	// swagger:operation GET /orders/page-max-record-limit orders getPageMaxRecordLimit
-	//
-	// Returns an integer that is the maximum number of records that can be returned in one page.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The page max records limit was returned successfully.
-	r.HandleFunc(ordersHealthAPIRoute, s.getPageMaxRecordLimitAPIHandler()).Methods(s.getPageMaxRecordLimitAPIOptions().AllowedMethods...)
-	// swagger:operation GET /orders/health orders checkHealth
-	//
-	// Checks the health of the service.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The health check was completed.
-	//     "$ref": "#/responses/healthCheckResponse"
-	r.HandleFunc(ordersHealthAPIRoute, s.getHealthCheckAPIHandler()).Methods(s.getHealthCheckAPIOptions().AllowedMethods...)
+// routes returns the route table of the orders service.
+func (s *OrdersService) routes() []Route {
+	return []Route{
+		{
+			Name:       "Create Order",
+			Method:     http.MethodPost,
+			Path:       ordersAPIBaseRoute,
+			LegacyPath: legacyOrdersAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.CreateOrder)),
+			Doc: openapi.Operation{
+				ID:      "createOrder",
+				Tags:    []string{"orders"},
+				Summary: "Create a new order.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+				},
+				Body: &openapi.Body{Description: "New order to create. Id, CreatedAt, DeletedAt, UpdatedAt fields will be ignored. Items are charged the current price of their product.", Required: true, Model: models.Order{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusCreated, Description: "Successfully created an order.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "List Orders",
+			Method:     http.MethodGet,
+			Path:       ordersAPIBaseRoute,
+			LegacyPath: legacyOrdersAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.Handler.GetOrders),
+			Doc: openapi.Operation{
+				ID:      "listOrders",
+				Tags:    []string{"orders"},
+				Summary: "Get a paginated listing of all orders.",
+				Params: []openapi.Param{
+					{Name: "filter", In: openapi.InQuery, Description: "Only list records matching all of these conditions, separated by semicolons. (e.g. total>20;createdat>=2026-10-01)", Type: "string"},
+					{Name: "sort", In: openapi.InQuery, Description: "Fields to sort the listing by, separated by commas and prefixed with - to sort descending. (e.g. -total)", Type: "string"},
+					{Name: "cursor", In: openapi.InQuery, Description: "Cursor of the page to return, from the next or prev field of a previous page.", Type: "string"},
+					{Name: "limit", In: openapi.InQuery, Description: "Maximum number of records to return.", Type: "integer"},
+					{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas. The id is always returned.", Type: "string"},
+					{Name: "include", In: openapi.InQuery, Description: "Related records to embed, separated by commas. One of: items (default), items.product, owner", Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved a page of orders.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:    "Read Order",
+			Method:  http.MethodGet,
+			Path:    ordersAPIRoute,
+			Handler: s.UserHandler.IsAuthorized(s.Handler.GetOrders),
+			Doc: openapi.Operation{
+				ID:      "getOrder",
+				Tags:    []string{"orders"},
+				Summary: "Get an order by ID.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the order.", Type: "integer"},
+					{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas. The id is always returned.", Type: "string"},
+					{Name: "include", In: openapi.InQuery, Description: "Related records to embed, separated by commas. One of: items (default), items.product, owner", Type: "string"},
+					{Name: "If-None-Match", In: openapi.InHeader, Description: "ETag of the order as it was last read. If the order is unchanged, a 304 Not Modified is sent instead.", Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved the order.", Model: json.Response{}},
+					{Status: http.StatusNotModified, Description: "The order is unchanged since it was last read."},
+					{Status: http.StatusForbidden, Description: "Not enough privileges to read the order.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "Order not found.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:    "Read Order Items",
+			Method:  http.MethodGet,
+			Path:    ordersItemsAPIRoute,
+			Handler: s.UserHandler.IsAuthorized(s.Handler.GetOrderItems),
+			Doc: openapi.Operation{
+				ID:      "getOrderItems",
+				Tags:    []string{"orders"},
+				Summary: "Get the items of an order.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the order.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved the items of the order.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "Not enough privileges to read the order.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "Order not found.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:    "Read Order Item",
+			Method:  http.MethodGet,
+			Path:    ordersItemAPIRoute,
+			Handler: s.UserHandler.IsAuthorized(s.Handler.GetOrderItems),
+			Doc: openapi.Operation{
+				ID:      "getOrderItem",
+				Tags:    []string{"orders"},
+				Summary: "Get a single item of an order.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the order.", Type: "integer"},
+					{Name: "itemId", In: openapi.InPath, Description: "id of the item.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved the item.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "Not enough privileges to read the order.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "Order or item not found.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Update Order",
+			Method:     http.MethodPut,
+			Path:       ordersAPIRoute,
+			LegacyPath: legacyOrdersAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.UpdateOrder)),
+			Doc: openapi.Operation{
+				ID:      "updateOrder",
+				Tags:    []string{"orders"},
+				Summary: "Update an existing order.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the order.", Type: "integer"},
+					idempotencyKeyParam,
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the order as it was read. The request is refused if the order has changed since.", Required: true, Type: "string"},
+				},
+				Body: &openapi.Body{Description: "Order fields to update. CreatedAt, DeletedAt, UpdatedAt fields will be ignored. Items keep the price they were ordered at, and the totals are calculated from them.", Required: true, Model: models.Order{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully updated an existing order.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The order has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Patch Order",
+			Method:     http.MethodPatch,
+			Path:       ordersAPIRoute,
+			LegacyPath: legacyOrdersAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.PatchOrder)),
+			Doc: openapi.Operation{
+				ID:          "patchOrder",
+				Tags:        []string{"orders"},
+				Summary:     "Patch an existing order with a JSON Merge Patch (application/merge-patch+json) or a JSON Patch (application/json-patch+json).",
+				Description: "The patched order is validated before anything is stored.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of order to patch.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the order as it was read. The request is refused if the order has changed since.", Required: true, Type: "string"},
+				},
+				Body: &openapi.Body{Description: "Patch to apply to the order. Read-only fields (ID, CreatedAt, UpdatedAt, DeletedAt, subtotal, tax, total, owner) can't be changed; the totals are recalculated from the items.", Required: true, MediaTypes: []string{"application/merge-patch+json", "application/json-patch+json"}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully patched an existing order.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request, malformed patch or the patched order is invalid.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "Order not found.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "The patch can't be applied to the order, e.g. a test operation failed or a path doesn't exist.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The order has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusUnsupportedMediaType, Description: "Content-Type is not a supported patch format.", Model: json.Response{}},
+					{Status: http.StatusUnprocessableEntity, Description: "The patch changes a read-only field, or the patched order doesn't decode.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Delete Order",
+			Method:     http.MethodDelete,
+			Path:       ordersAPIRoute,
+			LegacyPath: legacyOrdersAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.DeleteOrder)),
+			Doc: openapi.Operation{
+				ID:      "deleteOrder",
+				Tags:    []string{"orders"},
+				Summary: "Delete an existing order.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of order to delete.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the order as it was read. The request is refused if the order has changed since.", Required: true, Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusNoContent, Description: "Successfully deleted an existing order."},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The order has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:    "Reprice Order",
+			Method:  http.MethodPost,
+			Path:    ordersRepriceAPIRoute,
+			Handler: s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.UserHandler.HasRole(s.Handler.RepriceOrder, roles.Employee))),
+			Doc: openapi.Operation{
+				ID:          "repriceOrder",
+				Tags:        []string{"orders"},
+				Summary:     "Charge each item of a pending order the current price of its product.",
+				Description: "Items are otherwise charged the price of their product when they were ordered. The totals of the order are recalculated.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of order to reprice.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the order as it was read. The request is refused if the order has changed since.", Required: true, Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully repriced the order.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "Order not found.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "The order isn't pending.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The order has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Batch Orders",
+			Method:     http.MethodPost,
+			Path:       ordersBatchAPIRoute,
+			LegacyPath: legacyOrdersBatchAPIRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.BatchOrders)),
+			Doc: openapi.Operation{
+				ID:      "batchOrders",
+				Tags:    []string{"orders"},
+				Summary: "Create, update, patch and delete many orders in one request.",
+				Description: "Each operation is run as the single order request it stands for, and its result is returned in the same position of the data array, " +
+					"with its HTTP status and the response it would have had on its own. Each operation is checked with the same permissions as the single order request it stands for. " +
+					"A best-effort batch applies every operation it can, and always succeeds. " +
+					"An atomic batch is all-or-nothing: when an operation fails, the batch fails with that operation's status, and every other operation is marked 424 (not applied).",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+				},
+				Body: &openapi.Body{Description: "Operations to run, in order. Updates, patches and deletes need the id and version of the order; patch data is a JSON Merge Patch object or a JSON Patch array.", Required: true, Model: models.Batch{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The batch was run. The data array holds the status and response of each operation.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request, or an operation of an atomic batch was invalid.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Description: "An operation of an atomic batch failed with this status. Nothing was applied.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Page Max Record Limit",
+			Method:     http.MethodGet,
+			Path:       ordersPageMaxRecordLimitAPIRoute,
+			LegacyPath: legacyOrdersPageMaxRecordLimitAPIRoute,
+			Handler:    s.Handler.GetPageMaxRecordLimit,
+			Doc: openapi.Operation{
+				ID:      "getPageMaxRecordLimit",
+				Tags:    []string{"orders"},
+				Summary: "Returns an integer that is the maximum number of records that can be returned in one page.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The page max records limit was returned successfully.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "Health Check",
+			Method:     http.MethodGet,
+			Path:       ordersHealthAPIRoute,
+			LegacyPath: legacyOrdersHealthAPIRoute,
+			Handler:    s.CheckHealth,
+			Doc: openapi.Operation{
+				ID:      "checkHealth",
+				Tags:    []string{"orders"},
+				Summary: "Checks the health of the service.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The health check was completed.", Model: map[string]bool{}},
+				},
+			},
+		},
+		{
+			Name:    "Metrics",
+			Method:  http.MethodGet,
+			Path:    ordersMetricsAPIRoute,
+			Handler: s.GetMetrics,
+			Doc: openapi.Operation{
+				ID:      "getMetrics",
+				Tags:    []string{"orders"},
+				Summary: "Returns the hit and miss counts of the service's caches, by name. A cache is null if the service doesn't keep one.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The metrics were returned successfully.", Model: map[string]*cache.Stats{}},
+				},
+			},
+		},
+	}
+}
 
-	return r
+// GetMetrics writes a response in JSON containing the statistics of the orders service's caches to the user.
+func (s *OrdersService) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	writeCacheMetrics(w, s.ProductCache)
 }
 
 // CheckHealth checks the health of the data entry service and writes a response in JSON to the user.
@@ -290,7 +438,12 @@ func (s *OrdersService) NewOrdersServiceRouter(db *driver.DB) *mux.Router {
 func (s *OrdersService) CheckHealth(w http.ResponseWriter, r *http.Request) {
 	var err error
 	log.Info("Checking orders service health...")
-	db, err := s.DB.Postgres.DB()
+	if s.DB == nil {
+		log.Info("orders service health check passed: records are kept in memory")
+		json.WriteResponse(w, http.StatusOK, map[string]bool{"ok": true})
+		return
+	}
+	db, err := s.DB.SQL().DB()
 	if err != nil {
 		log.Error("orders service health check failed: Error getting SQLDB from gorm DB: " + err.Error())
 		json.WriteResponse(w, http.StatusOK, map[string]bool{"ok": false})
@@ -309,18 +462,142 @@ func (s *OrdersService) CheckHealth(w http.ResponseWriter, r *http.Request) {
 // If init is true, will create the tables if they do not already exist.
 func setupOrdersServiceDB(db *driver.DB, init bool) error {
 	log.Info("Setting up the orders service database...")
-	err := pgdriver.SetupTables(db, &models.Order{}, init)
+	// Orders refer to their owner, and items to their product, so those tables are set up first, if the users and products services haven't yet.
+	err := setupTables(db, &models.User{}, init)
+	if err != nil {
+		msg := "failed to set up the User model table" + err.Error()
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	err = setupTables(db, &models.Product{}, init)
+	if err != nil {
+		msg := "failed to set up the Product model table" + err.Error()
+		log.Error(msg)
+		return errors.New(msg)
+	}
+	if init && db.SQL().Migrator().HasTable(&models.Order{}) {
+		// Orders of purged owners used to keep their owner's id (or be left with an owner id of zero), which the foreign key to their owner doesn't allow.
+		err = detachOrdersOfMissingOwners(db)
+		if err != nil {
+			msg := "failed to detach the orders of missing owners: " + err.Error()
+			log.Error(msg)
+			return errors.New(msg)
+		}
+	}
+	err = setupTables(db, &models.Order{}, init)
 	if err != nil {
 		msg := "failed to set up the Orders model table" + err.Error()
 		log.Error(msg)
 		return errors.New(msg)
 	}
-	err = pgdriver.SetupTables(db, &models.Item{}, init)
+	// Items used to be charged the current price of their product, so items stored before they kept the price they were ordered at are given the current one.
+	snapshotMissing := init && db.SQL().Migrator().HasTable(&models.Item{}) && !db.SQL().Migrator().HasColumn(&models.Item{}, "unit_price")
+	// Deleting a product used to delete its items, so the foreign key to the product is dropped and set up again to refuse it instead.
+	if init && db.SQL().Migrator().HasTable(&models.Item{}) {
+		err = restrictItemProductDeletion(db)
+		if err != nil {
+			msg := "failed to stop deleting the items of deleted products: " + err.Error()
+			log.Error(msg)
+			return errors.New(msg)
+		}
+		// Items used to outlive their order and their product, which the foreign keys to them don't allow.
+		err = repairOrphanedItems(db, !snapshotMissing)
+		if err != nil {
+			msg := "failed to repair the items of missing orders and products: " + err.Error()
+			log.Error(msg)
+			return errors.New(msg)
+		}
+	}
+	err = setupTables(db, &models.Item{}, init)
 	if err != nil {
 		msg := "failed to set up the Items model table" + err.Error()
 		log.Error(msg)
 		return errors.New(msg)
 	}
+	if snapshotMissing {
+		product := func(column string) clause.Expr {
+			return gorm.Expr("(SELECT " + column + " FROM products WHERE products.id = items.product_id)")
+		}
+		err = db.SQL().Session(&gorm.Session{AllowGlobalUpdate: true}).Model(&models.Item{}).Updates(map[string]interface{}{
+			"unit_price":     product("price"),
+			"product_name":   product("name"),
+			"product_symbol": product("symbol"),
+			"total":          product("items.quantity * price"),
+		}).Error
+		if err != nil {
+			msg := "failed to price the existing items: " + err.Error()
+			log.Error(msg)
+			return errors.New(msg)
+		}
+		log.Info("Priced the existing items at the current price of their product")
+	}
+	err = setupAuditDB(db, init)
+	if err != nil {
+		return err
+	}
+	err = setupIdempotencyDB(db, init)
+	if err != nil {
+		return err
+	}
 	log.Info("Successfully set up the database for the orders service")
 	return nil
 }
+
+// detachOrdersOfMissingOwners detaches the orders whose owner no longer exists from it, in the database the supplied driver is connected to,
+// so the foreign key to their owner can be added. They are kept, owned by no one, like the orders of a user purged now.
+func detachOrdersOfMissingOwners(db *driver.DB) error {
+	result := db.SQL().Model(&models.Order{}).Where("owner_id IS NOT NULL AND owner_id NOT IN (SELECT id FROM users)").Update("owner_id", nil)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Info(fmt.Sprintf("Detached %d orders from owners that no longer exist", result.RowsAffected))
+	}
+	return nil
+}
+
+// repairOrphanedItems makes the items in the database the supplied driver is connected to satisfy the foreign keys to their order and their product.
+// Items of an order that no longer exists belong to nothing anyone can read, so they are deleted. Items of a product that no longer exists
+// are part of an order's history, so the product is brought back as an archived product, which can't be ordered again.
+// It is named and priced from the items' snapshot of it, if the supplied hasSnapshot says they have one, or as a deleted product at no price otherwise.
+func repairOrphanedItems(db *driver.DB, hasSnapshot bool) error {
+	result := db.SQL().Unscoped().Where("order_id NOT IN (SELECT id FROM orders)").Delete(&models.Item{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Info(fmt.Sprintf("Deleted %d items of orders that no longer exist", result.RowsAffected))
+	}
+
+	columns := "MAX(product_name), MAX(product_symbol), MAX(unit_price)"
+	if !hasSnapshot {
+		columns = "'Deleted product ' || product_id, '?', 0"
+	}
+	now := time.Now()
+	result = db.SQL().Exec("INSERT INTO products (id, created_at, updated_at, deleted_at, name, symbol, price, num_in_stock, version) "+
+		"SELECT product_id, ?, ?, ?, "+columns+", 0, 1 FROM items WHERE product_id NOT IN (SELECT id FROM products) GROUP BY product_id", now, now, now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		log.Info(fmt.Sprintf("Restored %d products that no longer existed as archived products, for the orders that have items of them", result.RowsAffected))
+	}
+	return nil
+}
+
+// restrictItemProductDeletion drops the foreign key from the items to their product, in the database the supplied driver is connected to,
+// if it still deletes the items of a deleted product. Setting up the items table adds it back, refusing to delete products that have items.
+func restrictItemProductDeletion(db *driver.DB) error {
+	var rule string
+	var err error
+	if db.SQLite != nil {
+		err = db.SQL().Raw(`SELECT on_delete FROM pragma_foreign_key_list('items') WHERE "table" = 'products'`).Scan(&rule).Error
+	} else {
+		err = db.SQL().Raw("SELECT delete_rule FROM information_schema.referential_constraints WHERE constraint_name = 'fk_items_product'").Scan(&rule).Error
+	}
+	if err != nil || rule != "CASCADE" {
+		return err
+	}
+	log.Info("Dropping the foreign key deleting the items of deleted products...")
+	return db.SQL().Migrator().DropConstraint(&models.Item{}, "Product")
+}
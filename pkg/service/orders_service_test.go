@@ -0,0 +1,86 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	sqlitedriver "github.com/tragicpixel/fruitbar/pkg/driver/sqlite"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"gorm.io/gorm"
+)
+
+func TestSetupOrdersServiceDB_danglingReferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fruitbar.db")
+
+	// A database from before the foreign keys, holding records that refer to ones that no longer exist.
+	old, err := gorm.Open(sqlite.Open("file:"+path), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true})
+	if err != nil {
+		t.Fatalf("failed to open the test database: %s", err.Error())
+	}
+	if err := old.AutoMigrate(&models.User{}, &models.Product{}, &models.Order{}, &models.Item{}); err != nil {
+		t.Fatalf("failed to create the tables: %s", err.Error())
+	}
+	for _, sql := range []string{
+		"INSERT INTO users (id, name, role, version) VALUES (1, 'alice', 'customer', 1)",
+		"INSERT INTO products (id, name, symbol, price, num_in_stock, version) VALUES (1, 'apple', '🍎', 1.5, 10, 1)",
+		"INSERT INTO orders (id, owner_id, cash, subtotal, tax_rate, tax, total, status, version) VALUES (1, 1, 1, 7, 0, 0, 7, 'pending', 1)",
+		"INSERT INTO orders (id, owner_id, cash, subtotal, tax_rate, tax, total, status, version) VALUES (2, 99, 1, 0, 0, 0, 0, 'pending', 1)",
+		"INSERT INTO orders (id, owner_id, cash, subtotal, tax_rate, tax, total, status, version) VALUES (3, 0, 1, 0, 0, 0, 0, 'pending', 1)",
+		"INSERT INTO items (id, order_id, product_id, quantity, unit_price, product_name, product_symbol, total) VALUES (1, 1, 1, 2, 1.5, 'apple', '🍎', 3)",
+		"INSERT INTO items (id, order_id, product_id, quantity, unit_price, product_name, product_symbol, total) VALUES (2, 1, 7, 2, 2, 'kiwi', '🥝', 4)",
+		"INSERT INTO items (id, order_id, product_id, quantity, unit_price, product_name, product_symbol, total) VALUES (3, 0, 1, 1, 1.5, 'apple', '🍎', 1.5)",
+	} {
+		if err := old.Exec(sql).Error; err != nil {
+			t.Fatalf("failed to seed the test database: %s", err.Error())
+		}
+	}
+	if sqlDB, err := old.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	db, err := sqlitedriver.OpenConnection(&sqlitedriver.SQLiteConnectionConfig{Path: path})
+	if err != nil {
+		t.Fatalf("failed to open the test database: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.SQLite.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := setupOrdersServiceDB(db, true); err != nil {
+		t.Fatalf("expected the database to be migrated, got error: %s", err.Error())
+	}
+
+	// Orders of missing owners are kept, owned by no one.
+	var orders []*models.Order
+	if err := db.SQLite.Order("id").Find(&orders).Error; err != nil {
+		t.Fatalf("unexpected error reading the orders: %s", err.Error())
+	}
+	if len(orders) != 3 || orders[0].OwnerID == nil || *orders[0].OwnerID != 1 || orders[1].OwnerID != nil || orders[2].OwnerID != nil {
+		t.Errorf("expected only the orders of missing owners to be detached, got %+v", orders)
+	}
+	// Items of missing orders are deleted, and missing products of items are brought back archived, as the items knew them.
+	var items []*models.Item
+	if err := db.SQLite.Order("id").Find(&items).Error; err != nil {
+		t.Fatalf("unexpected error reading the items: %s", err.Error())
+	}
+	if len(items) != 2 || items[0].ID != 1 || items[1].ID != 2 {
+		t.Errorf("expected only the item of a missing order to be deleted, got %+v", items)
+	}
+	var kiwi models.Product
+	if err := db.SQLite.Unscoped().First(&kiwi, 7).Error; err != nil {
+		t.Fatalf("expected the missing product to be brought back, got error: %s", err.Error())
+	}
+	if kiwi.Name != "kiwi" || kiwi.Symbol != "🥝" || kiwi.Price != 2 || !kiwi.DeletedAt.Valid {
+		t.Errorf("expected an archived kiwi at 2, got %+v", kiwi)
+	}
+
+	// The foreign keys are in place.
+	if err := db.SQLite.Create(&models.Item{OrderID: 1, ProductID: 42, Quantity: 1}).Error; err == nil {
+		t.Errorf("expected an item of a missing product to be refused")
+	}
+	if err := db.SQLite.Model(&models.Order{}).Where("id = ?", 1).Update("owner_id", 99).Error; err == nil {
+		t.Errorf("expected an order of a missing owner to be refused")
+	}
+}
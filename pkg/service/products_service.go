@@ -3,16 +3,20 @@ package service
 import (
 	"github.com/tragicpixel/fruitbar/pkg/driver"
 	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	sqlitedriver "github.com/tragicpixel/fruitbar/pkg/driver/sqlite"
 	"github.com/tragicpixel/fruitbar/pkg/handler"
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/models/roles"
-	"github.com/tragicpixel/fruitbar/pkg/utils/cors"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/cache"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
 	"github.com/tragicpixel/fruitbar/pkg/utils/json"
 	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
 
 	"errors"
-	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -22,112 +26,75 @@ type ProductsService struct {
 	Router      *mux.Router
 	Handler     *handler.Product
 	UserHandler *handler.User
-	DB          *driver.DB
-	Port        int
+	// Handler making the unsafe endpoints safe to retry.
+	IdempotencyHandler *handler.Idempotency
+	// Connection to the database the records are kept in. (nil if they are kept in memory)
+	DB *driver.DB
+	// Repositories of the service's records.
+	Repos *repository.Repositories
+	Port  int
+	// Repository the service reads products through, keeping them in a cache. (nil if the records are kept in memory)
+	ProductCache *cache.CachedProductRepo
+	// How much of the traffic of the service is validated against its OpenAPI document.
+	OpenAPIValidation openapi.Mode
 }
 
 type ProductsServiceConfig struct {
 	DatabaseConnection *pgdriver.PostgresConnectionConfig
 	Port               int
+	// How long the responses to requests made with an Idempotency-Key header are kept for replay. (defaults to 24 hours)
+	IdempotencyKeyTTL time.Duration
+	// How much of the traffic of the service is validated against its OpenAPI document. (defaults to none)
+	OpenAPIValidation openapi.Mode
+	// Where the service keeps its records. (defaults to postgres, using DatabaseConnection)
+	Storage Storage
+	// Connection to the SQLite database file the records are kept in, when they are kept in one. (read from the environment if nil)
+	SQLiteConnection *sqlitedriver.SQLiteConnectionConfig
+	// Password of the admin user a service keeping its records in memory or in SQLite starts with. (a random one is generated and logged if empty)
+	AdminPassword string
+	// Store the records are kept in when they are kept in memory, so services run in the same process can share them. (a new one if nil)
+	MemoryStore *memory.Store
+	// Cache of the products the service reads, unless the records are kept in memory.
+	ProductCache cache.Config
 }
 
+// Paths of the products service's endpoints. Path parameters are in braces.
 const (
-	productsAPIBaseRoute               = "/products"
-	productsCreateAPIRoute             = productsAPIBaseRoute
-	productsReadAPIRoute               = productsAPIBaseRoute
-	productsUpdateAPIRoute             = productsAPIBaseRoute
-	productsDeleteAPIRoute             = productsAPIBaseRoute
+	productsAPIBaseRoute               = apiVersionPrefix + "/products"
+	productsAPIRoute                   = productsAPIBaseRoute + "/{id}"
+	productsRestoreAPIRoute            = productsAPIRoute + "/restore"
+	productsPurgeAPIRoute              = productsAPIRoute + "/purge"
+	productsBatchAPIRoute              = productsAPIBaseRoute + "/batch"
 	productsPageMaxRecordLimitAPIRoute = productsAPIBaseRoute + "/page-max-record-limit"
 	productsHealthAPIRoute             = productsAPIBaseRoute + "/health"
-)
+	productsMetricsAPIRoute            = productsAPIBaseRoute + "/metrics"
 
-func (s *ProductsService) getProductsEndpointOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Products Options",
-		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-	}
-}
-func (s *ProductsService) getCreateAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Create Product",
-		AllowedMethods: []string{http.MethodPost},
-	}
-}
-func (s *ProductsService) getReadAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Read Product",
-		AllowedMethods: []string{http.MethodGet},
-	}
-}
-func (s *ProductsService) getUpdateAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Update Product",
-		AllowedMethods: []string{http.MethodPut},
-	}
-}
-func (s *ProductsService) getDeleteAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Delete Product",
-		AllowedMethods: []string{http.MethodDelete},
-	}
-}
-func (s *ProductsService) getPageMaxRecordLimitAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Page Max Record Limit",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
-func (s *ProductsService) getHealthCheckAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Health Check",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
-
-func (s *ProductsService) getCreateAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getCreateAPIOptions(), s.UserHandler.IsAuthorized(s.UserHandler.HasRole(s.Handler.CreateProduct, roles.Admin)))
-}
-func (s *ProductsService) getReadAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getReadAPIOptions(), s.UserHandler.IsAuthorized(s.Handler.GetProducts))
-}
-func (s *ProductsService) getUpdateAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getUpdateAPIOptions(), s.UserHandler.IsAuthorized(s.UserHandler.HasRole(s.Handler.UpdateProduct, roles.Admin)))
-}
-func (s *ProductsService) getDeleteAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getDeleteAPIOptions(), s.UserHandler.IsAuthorized(s.UserHandler.HasRole(s.Handler.DeleteProduct, roles.Admin)))
-}
-func (s *ProductsService) getPageMaxRecordLimitAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getPageMaxRecordLimitAPIOptions(), s.Handler.GetPageMaxRecordLimit)
-}
-func (s *ProductsService) getHealthCheckAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getHealthCheckAPIOptions(), s.CheckHealth)
-}
+	// Deprecated, unversioned paths, which take the ids as query parameters.
+	legacyProductsAPIBaseRoute               = "/products"
+	legacyProductsRestoreAPIRoute            = legacyProductsAPIBaseRoute + "/restore"
+	legacyProductsPurgeAPIRoute              = legacyProductsAPIBaseRoute + "/purge"
+	legacyProductsBatchAPIRoute              = legacyProductsAPIBaseRoute + "/batch"
+	legacyProductsPageMaxRecordLimitAPIRoute = legacyProductsAPIBaseRoute + "/page-max-record-limit"
+	legacyProductsHealthAPIRoute             = legacyProductsAPIBaseRoute + "/health"
+)
 
 // NewProductsService creates a new instance of a product listing service.
 // Returns nil on error.
 func NewProductsService(config *ProductsServiceConfig) (*ProductsService, error) {
 	s := ProductsService{}
 
-	db, err := pgdriver.OpenConnection(config.DatabaseConnection)
+	db, repos, err := openStorage("products", config.Storage, config.DatabaseConnection, config.SQLiteConnection, config.MemoryStore, setupProductsServiceDB, config.AdminPassword)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to the products service database: %s", err.Error())
+		return nil, err
 	}
 
+	repos, s.ProductCache = cacheProducts(db, repos, config.ProductCache)
 	s.DB = db
-	err = setupProductsServiceDB(s.DB, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set up the products service database: %s", err.Error())
-	}
-
-	s.Handler = handler.NewProductHandler(db)
-	s.UserHandler = handler.NewUserHandler(db)
+	s.Repos = repos
+	s.Handler = handler.NewProductHandler(repos)
+	s.IdempotencyHandler = handler.NewIdempotencyHandler(repos, config.IdempotencyKeyTTL)
+	s.UserHandler = handler.NewUserHandler(repos)
+	s.OpenAPIValidation = config.OpenAPIValidation
 	s.Router = s.NewProductsServiceRouter(db)
 	s.Port = config.Port
 
@@ -136,151 +103,305 @@ func NewProductsService(config *ProductsServiceConfig) (*ProductsService, error)
 
 // NewProductsServiceRouter creates and returns a new http router for the product listing service.
 func (s *ProductsService) NewProductsServiceRouter(db *driver.DB) *mux.Router {
-	r := mux.NewRouter()
+	return newRouter("Fruitbar Products API", s.routes(), s.OpenAPIValidation)
+}
 
-	r.HandleFunc(productsAPIBaseRoute, cors.SendPreflightHeaders(s.getProductsEndpointOptions(), nil)).Methods(http.MethodOptions)
-	// swagger:operation POST /products products createProduct
-	//
-	// Create a new product.
-	//
-	// ---
-	// parameters:
-	// - name: product
-	//   in: body
-	//   description: New product to create. Id, CreatedAt, DeletedAt, UpdatedAt fields will be ignored.
-	//   required: true
-	//   "$ref": "#/definitions/product"
-	// security:
-	// - bearer: []
-	// responses:
-	//   '201':
-	//     description: Successfully created a product.
-	//     "$ref": "#/responses/jsonResponse"
-	//     examples:
-	//       application/json: { "ok": 2 }
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(productsCreateAPIRoute, s.getCreateAPIHandler()).Methods(s.getCreateAPIOptions().AllowedMethods...)
-	// swagger:operation GET /products products getProduct
-	//
-	// Get a product by ID, or a paginated listing of all products.
-	//
-	// ---
-	// parameters:
-	// - name: id
-	//   in: query
-	//   description: id of product to retrieve.
-	//   required: false
-	//   schema:
-	//     type: int
-	// security:
-	// - bearer: []
-	// responses:
-	//   '200':
-	//     description: Successfully retrieved a product.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(productsReadAPIRoute, s.getReadAPIHandler()).Methods(s.getReadAPIOptions().AllowedMethods...)
-	// swagger:operation PUT /products products updateProduct
-	//
-	// Update an existing product.
-	//
-	// ---
-	// parameters:
-	// - name: product
-	//   in: body
-	//   description: Product fields to update. CreatedAt, DeletedAt, UpdatedAt fields will be ignored.
-	//   required: true
-	//   schema:
-	//     $ref: "#/definitions/product"
-	// security:
-	// - bearer: []
-	// responses:
-	//   '200':
-	//     description: Successfully updated an existing product.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(productsUpdateAPIRoute, s.getUpdateAPIHandler()).Methods(s.getUpdateAPIOptions().AllowedMethods...)
-	// swagger:operation DELETE /products products deleteProduct
-	//
-	// Delete an existing product.
-	//
-	// ---
-	// parameters:
-	// - name: id
-	//   in: query
-	//   description: id of product to delete.
-	//   required: true
-	//   schema:
-	//     type: int
-	// security:
-	// - bearer: []
-	// responses:
-	//   '204':
-	//     description: Successfully deleted an existing product.
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(productsDeleteAPIRoute, s.getDeleteAPIHandler()).Methods(s.getDeleteAPIOptions().AllowedMethods...)
-	// swagger:operation GET /products/page-max-record-limit products getPageMaxRecordLimit
-	//
-	// Returns an integer that is the maximum number of records that can be returned in one page.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The page max records limit was returned successfully.
-	r.HandleFunc(productsPageMaxRecordLimitAPIRoute, s.getPageMaxRecordLimitAPIHandler()).Methods(s.getPageMaxRecordLimitAPIOptions().AllowedMethods...)
-	// swagger:operation GET /products/health products checkHealth
-	//
-	// Checks the health of the service.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The health check was completed.
-	//     "$ref": "#/responses/healthCheckResponse"
-	r.HandleFunc(productsHealthAPIRoute, s.getHealthCheckAPIHandler()).Methods(s.getHealthCheckAPIOptions().AllowedMethods...)
+// routes returns the route table of the products service.
+func (s *ProductsService) routes() []Route {
+	return []Route{
+		{
+			Name:       "Create Product",
+			Method:     http.MethodPost,
+			Path:       productsAPIBaseRoute,
+			LegacyPath: legacyProductsAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.UserHandler.HasRole(s.Handler.CreateProduct, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "createProduct",
+				Tags:    []string{"products"},
+				Summary: "Create a new product.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+				},
+				Body: &openapi.Body{Description: "New product to create. Id, CreatedAt, DeletedAt, UpdatedAt fields will be ignored.", Required: true, Model: models.Product{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusCreated, Description: "Successfully created a product.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "List Products",
+			Method:     http.MethodGet,
+			Path:       productsAPIBaseRoute,
+			LegacyPath: legacyProductsAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.Handler.GetProducts),
+			Doc: openapi.Operation{
+				ID:      "listProducts",
+				Tags:    []string{"products"},
+				Summary: "Get a paginated listing of all products.",
+				Params: []openapi.Param{
+					{Name: "status", In: openapi.InQuery, Description: "Which products to list, one of active (default), inactive (archived) or all. Only admins can list archived products.", Type: "string"},
+					{Name: "filter", In: openapi.InQuery, Description: "Only list records matching all of these conditions, separated by semicolons. (e.g. price<2;name~apple)", Type: "string"},
+					{Name: "sort", In: openapi.InQuery, Description: "Fields to sort the listing by, separated by commas and prefixed with - to sort descending. (e.g. name)", Type: "string"},
+					{Name: "cursor", In: openapi.InQuery, Description: "Cursor of the page to return, from the next or prev field of a previous page.", Type: "string"},
+					{Name: "limit", In: openapi.InQuery, Description: "Maximum number of records to return.", Type: "integer"},
+					{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas. The id is always returned.", Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved a page of products.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:    "Read Product",
+			Method:  http.MethodGet,
+			Path:    productsAPIRoute,
+			Handler: s.UserHandler.IsAuthorized(s.Handler.GetProducts),
+			Doc: openapi.Operation{
+				ID:      "getProduct",
+				Tags:    []string{"products"},
+				Summary: "Get a product by ID.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the product.", Type: "integer"},
+					{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas. The id is always returned.", Type: "string"},
+					{Name: "If-None-Match", In: openapi.InHeader, Description: "ETag of the product as it was last read. If the product is unchanged, a 304 Not Modified is sent instead.", Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved the product.", Model: json.Response{}},
+					{Status: http.StatusNotModified, Description: "The product is unchanged since it was last read."},
+					{Status: http.StatusNotFound, Description: "Product not found.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Update Product",
+			Method:     http.MethodPut,
+			Path:       productsAPIRoute,
+			LegacyPath: legacyProductsAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.UserHandler.HasRole(s.Handler.UpdateProduct, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "updateProduct",
+				Tags:    []string{"products"},
+				Summary: "Update an existing product.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the product.", Type: "integer"},
+					idempotencyKeyParam,
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the product as it was read. The request is refused if the product has changed since.", Required: true, Type: "string"},
+				},
+				Body: &openapi.Body{Description: "Product fields to update. CreatedAt, DeletedAt, UpdatedAt fields will be ignored.", Required: true, Model: models.Product{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully updated an existing product.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The product has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Patch Product",
+			Method:     http.MethodPatch,
+			Path:       productsAPIRoute,
+			LegacyPath: legacyProductsAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.UserHandler.HasRole(s.Handler.PatchProduct, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:          "patchProduct",
+				Tags:        []string{"products"},
+				Summary:     "Patch an existing product with a JSON Merge Patch (application/merge-patch+json) or a JSON Patch (application/json-patch+json).",
+				Description: "The patched product is validated before anything is stored.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of product to patch.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the product as it was read. The request is refused if the product has changed since.", Required: true, Type: "string"},
+				},
+				Body: &openapi.Body{Description: "Patch to apply to the product. Read-only fields (ID, CreatedAt, UpdatedAt, DeletedAt) can't be changed.", Required: true, MediaTypes: []string{"application/merge-patch+json", "application/json-patch+json"}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully patched an existing product.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request, malformed patch or the patched product is invalid.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "Product not found.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "The patch can't be applied to the product, e.g. a test operation failed or a path doesn't exist.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The product has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusUnsupportedMediaType, Description: "Content-Type is not a supported patch format.", Model: json.Response{}},
+					{Status: http.StatusUnprocessableEntity, Description: "The patch changes a read-only field, or the patched product doesn't decode.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Delete Product",
+			Method:     http.MethodDelete,
+			Path:       productsAPIRoute,
+			LegacyPath: legacyProductsAPIBaseRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.UserHandler.HasRole(s.Handler.DeleteProduct, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:          "deleteProduct",
+				Tags:        []string{"products"},
+				Summary:     "Archive an existing product.",
+				Description: "Archived products are hidden from the catalog and can't be ordered, but the orders they are in still refer to them. They can be restored, or purged if they aren't in any order.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of product to delete.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the product as it was read. The request is refused if the product has changed since.", Required: true, Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusNoContent, Description: "Successfully archived an existing product."},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The product has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Restore Product",
+			Method:     http.MethodPost,
+			Path:       productsRestoreAPIRoute,
+			LegacyPath: legacyProductsRestoreAPIRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.UserHandler.HasRole(s.Handler.RestoreProduct, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:      "restoreProduct",
+				Tags:    []string{"products"},
+				Summary: "Put an archived product back in the catalog.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of product to restore.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully restored the product.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "No archived product with that id exists.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Purge Product",
+			Method:     http.MethodDelete,
+			Path:       productsPurgeAPIRoute,
+			LegacyPath: legacyProductsPurgeAPIRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.UserHandler.HasRole(s.Handler.PurgeProduct, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:      "purgeProduct",
+				Tags:    []string{"products"},
+				Summary: "Permanently remove an archived product, which isn't in any order.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of product to purge.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully purged the product.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "No archived product with that id exists.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "The product isn't archived, or it is in an order.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Batch Products",
+			Method:     http.MethodPost,
+			Path:       productsBatchAPIRoute,
+			LegacyPath: legacyProductsBatchAPIRoute,
+			Handler:    s.UserHandler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.UserHandler.HasRole(s.Handler.BatchProducts, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "batchProducts",
+				Tags:    []string{"products"},
+				Summary: "Create, update, patch and delete many products in one request.",
+				Description: "Each operation is run as the single product request it stands for, and its result is returned in the same position of the data array, " +
+					"with its HTTP status and the response it would have had on its own. Requires the admin role. " +
+					"A best-effort batch applies every operation it can, and always succeeds. " +
+					"An atomic batch is all-or-nothing: when an operation fails, the batch fails with that operation's status, and every other operation is marked 424 (not applied).",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+				},
+				Body: &openapi.Body{Description: "Operations to run, in order. Updates, patches and deletes need the id and version of the product; patch data is a JSON Merge Patch object or a JSON Patch array.", Required: true, Model: models.Batch{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The batch was run. The data array holds the status and response of each operation.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request, or an operation of an atomic batch was invalid.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Description: "An operation of an atomic batch failed with this status. Nothing was applied.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Page Max Record Limit",
+			Method:     http.MethodGet,
+			Path:       productsPageMaxRecordLimitAPIRoute,
+			LegacyPath: legacyProductsPageMaxRecordLimitAPIRoute,
+			Handler:    s.Handler.GetPageMaxRecordLimit,
+			Doc: openapi.Operation{
+				ID:      "getPageMaxRecordLimit",
+				Tags:    []string{"products"},
+				Summary: "Returns an integer that is the maximum number of records that can be returned in one page.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The page max records limit was returned successfully.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "Health Check",
+			Method:     http.MethodGet,
+			Path:       productsHealthAPIRoute,
+			LegacyPath: legacyProductsHealthAPIRoute,
+			Handler:    s.CheckHealth,
+			Doc: openapi.Operation{
+				ID:      "checkHealth",
+				Tags:    []string{"products"},
+				Summary: "Checks the health of the service.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The health check was completed.", Model: map[string]bool{}},
+				},
+			},
+		},
+		{
+			Name:    "Metrics",
+			Method:  http.MethodGet,
+			Path:    productsMetricsAPIRoute,
+			Handler: s.GetMetrics,
+			Doc: openapi.Operation{
+				ID:      "getMetrics",
+				Tags:    []string{"products"},
+				Summary: "Returns the hit and miss counts of the service's caches, by name. A cache is null if the service doesn't keep one.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The metrics were returned successfully.", Model: map[string]*cache.Stats{}},
+				},
+			},
+		},
+	}
+}
 
-	return r
+// GetMetrics writes a response in JSON containing the statistics of the products service's caches to the user.
+func (s *ProductsService) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	writeCacheMetrics(w, s.ProductCache)
 }
 
 // CheckHealth checks the health of the product listing service and writes a response in JSON to the user.
@@ -288,7 +409,12 @@ func (s *ProductsService) NewProductsServiceRouter(db *driver.DB) *mux.Router {
 func (s *ProductsService) CheckHealth(w http.ResponseWriter, r *http.Request) {
 	var err error
 	log.Info("Checking products service health...")
-	db, err := s.DB.Postgres.DB()
+	if s.DB == nil {
+		log.Info("products service health check passed: records are kept in memory")
+		json.WriteResponse(w, http.StatusOK, map[string]bool{"ok": true})
+		return
+	}
+	db, err := s.DB.SQL().DB()
 	if err != nil {
 		log.Error("products service health check failed: Error getting SQLDB from gorm DB: " + err.Error())
 		json.WriteResponse(w, http.StatusOK, map[string]bool{"ok": false})
@@ -307,12 +433,20 @@ func (s *ProductsService) CheckHealth(w http.ResponseWriter, r *http.Request) {
 // If init is true, will create the tables if they do not already exist.
 func setupProductsServiceDB(db *driver.DB, init bool) error {
 	log.Info("Setting up the products service database...")
-	err := pgdriver.SetupTables(db, &models.Product{}, init)
+	err := setupTables(db, &models.Product{}, init)
 	if err != nil {
 		msg := "failed to set up the Product model table" + err.Error()
 		log.Error(msg)
 		return errors.New(msg)
 	}
+	err = setupAuditDB(db, init)
+	if err != nil {
+		return err
+	}
+	err = setupIdempotencyDB(db, init)
+	if err != nil {
+		return err
+	}
 	log.Info("Successfully set up the database for the products service")
 	return nil
 }
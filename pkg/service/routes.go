@@ -0,0 +1,183 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/utils/cors"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
+
+	"github.com/gorilla/mux"
+)
+
+// Route describes a single endpoint of a service. A service's route table is the single source of truth for its router,
+// the CORS preflight responses it sends and its OpenAPI document.
+type Route struct {
+	// Name of the endpoint, as it appears in the logs and docs. (e.g. Create Order)
+	Name string
+	// HTTP method of the endpoint.
+	Method string
+	// Path of the endpoint in the versioned API, with its path parameters in braces. (e.g. /v1/orders/{id})
+	Path string
+	// Path of the deprecated, unversioned endpoint the route replaces, which takes the path parameters as query parameters instead. (optional)
+	LegacyPath string
+	// Handles requests to the endpoint. Path parameters are passed to it as query parameters of the same name, so it serves both paths.
+	Handler http.HandlerFunc
+	// Description of the endpoint in the OpenAPI document of the service.
+	Doc openapi.Operation
+}
+
+// Prefix of the paths of every endpoint in the current version of the API.
+const apiVersionPrefix = "/v1"
+
+// When the unversioned endpoints were deprecated, and when they may be removed. Sent in the Deprecation and Sunset headers of their responses.
+var (
+	legacyRoutesDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+	legacyRoutesSunsetAt     = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// Path of the OpenAPI document of each service.
+const openAPIDocumentRoute = "/openapi.json"
+
+// Idempotency-Key header parameter of the unsafe endpoints. (see handler.Idempotency)
+var idempotencyKeyParam = openapi.Param{
+	Name:        "Idempotency-Key",
+	In:          openapi.InHeader,
+	Description: "Unique key for the request, so it can be safely retried. Retries with the same key get the response to the first request. A key reused for a different request gets a 422, and a retry made while the first request is still being handled a 409.",
+	Type:        "string",
+}
+
+// Matches a path parameter in a route's path. Every path parameter of the API is a record id.
+var pathParamPattern = regexp.MustCompile(`{(\w+)}`)
+
+// newRouter creates and returns a new http router serving the supplied route table, along with the CORS preflight requests for each path,
+// an index of the routes at the root of the versioned API and the OpenAPI document of the routes, titled with the supplied title.
+// Requests (and responses) are validated against the document as much as the supplied validation mode says.
+func newRouter(title string, routes []Route, validation openapi.Mode) *mux.Router {
+	doc := newDocument(title, routes)
+	r := mux.NewRouter()
+	r.Use(httputils.RequestID)
+	r.Use(json.NegotiateErrorFormat)
+	r.Use(openapi.NewValidator(doc).Validate(validation))
+
+	// Methods allowed on each path, sent in the response to its CORS preflight requests.
+	var paths []string
+	allowed := map[string][]string{}
+	for _, route := range routes {
+		for _, path := range []string{route.Path, route.LegacyPath} {
+			if path == "" {
+				continue
+			}
+			if _, ok := allowed[path]; !ok {
+				paths = append(paths, path)
+				allowed[path] = []string{http.MethodOptions}
+			}
+			allowed[path] = append(allowed[path], route.Method)
+		}
+	}
+	for _, path := range paths {
+		opts := cors.Options{AllowedURL: UI_URL, APIName: path + " Options", AllowedMethods: allowed[path]}
+		r.HandleFunc(muxPath(path), cors.SendPreflightHeaders(opts, nil)).Methods(http.MethodOptions)
+	}
+	for _, route := range routes {
+		opts := cors.Options{AllowedURL: UI_URL, APIName: route.Name, AllowedMethods: allowed[route.Path]}
+		r.HandleFunc(muxPath(route.Path), cors.SendPreflightHeaders(opts, pathParamsAsQuery(route.Handler))).Methods(route.Method)
+		if route.LegacyPath != "" {
+			opts = cors.Options{AllowedURL: UI_URL, APIName: route.Name, AllowedMethods: allowed[route.LegacyPath]}
+			r.HandleFunc(route.LegacyPath, cors.SendPreflightHeaders(opts, deprecated(route.Path, route.Handler))).Methods(route.Method)
+		}
+	}
+	r.HandleFunc(apiVersionPrefix, cors.SendPreflightHeaders(cors.Options{AllowedURL: UI_URL, APIName: "Routes", AllowedMethods: []string{http.MethodGet}}, getRoutes(routes))).Methods(http.MethodGet)
+	r.HandleFunc(openAPIDocumentRoute, cors.SendPreflightHeaders(cors.Options{AllowedURL: UI_URL, APIName: "OpenAPI Document", AllowedMethods: []string{http.MethodGet}}, getDocument(doc))).Methods(http.MethodGet)
+	return r
+}
+
+// newDocument returns the OpenAPI document of the supplied route table, titled with the supplied title. The unversioned paths are described as deprecated.
+func newDocument(title string, routes []Route) *openapi.Document {
+	doc := openapi.NewDocument(title, strings.TrimPrefix(apiVersionPrefix, "/"))
+	for _, route := range routes {
+		doc.AddOperation(route.Method, route.Path, route.Doc)
+		if route.LegacyPath != "" {
+			doc.AddOperation(route.Method, route.LegacyPath, route.Doc.Legacy())
+		}
+	}
+	return doc
+}
+
+// getDocument returns an http handler sending a response containing the supplied OpenAPI document.
+func getDocument(doc *openapi.Document) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.WriteResponse(w, http.StatusOK, doc)
+	}
+}
+
+// muxPath returns the supplied route path with its path parameters restricted to ids, as the router matches them.
+// This keeps paths like /v1/orders/batch from being taken for the order with the id "batch".
+func muxPath(path string) string {
+	return pathParamPattern.ReplaceAllString(path, "{$1:[0-9]+}")
+}
+
+// pathParamsAsQuery passes the path parameters of requests to the supplied http handler as query parameters of the same name,
+// replacing any query parameter with that name.
+func pathParamsAsQuery(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if len(vars) > 0 {
+			query := r.URL.Query()
+			for name, value := range vars {
+				query.Set(name, value)
+			}
+			r.URL.RawQuery = query.Encode()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecated marks the responses of the supplied http handler as coming from a deprecated endpoint, replaced by the one at the supplied versioned path:
+// the Deprecation and Sunset headers say when it was deprecated and may be removed, and the Link header points at its successor.
+func deprecated(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyRoutesDeprecatedAt.Unix()))
+		w.Header().Set("Sunset", legacyRoutesSunsetAt.Format(http.TimeFormat))
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successorPath(successor, r)))
+		log.Info(fmt.Sprintf("Deprecated endpoint %s %s called, use %s instead", r.Method, r.URL.Path, successor))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// successorPath returns the supplied versioned path, with its path parameters filled in from the query parameters of the supplied http request when they are set.
+func successorPath(path string, r *http.Request) string {
+	query := r.URL.Query()
+	return pathParamPattern.ReplaceAllStringFunc(path, func(param string) string {
+		if value := query.Get(strings.Trim(param, "{}")); value != "" {
+			return value
+		}
+		return param
+	})
+}
+
+// RouteDoc describes a route of a service, in the index of its routes.
+type RouteDoc struct {
+	Name   string `json:"name"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Deprecated, unversioned path of the route. (if any)
+	LegacyPath string `json:"legacyPath,omitempty"`
+}
+
+// getRoutes returns an http handler sending a response containing an index of the supplied route table.
+func getRoutes(routes []Route) http.HandlerFunc {
+	docs := make([]RouteDoc, len(routes))
+	for i, route := range routes {
+		docs[i] = RouteDoc{Name: route.Name, Method: route.Method, Path: route.Path, LegacyPath: route.LegacyPath}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.WriteResponse(w, http.StatusOK, json.Response{Data: docs})
+	}
+}
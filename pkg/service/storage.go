@@ -0,0 +1,165 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tragicpixel/fruitbar/pkg/driver"
+	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	sqlitedriver "github.com/tragicpixel/fruitbar/pkg/driver/sqlite"
+	"github.com/tragicpixel/fruitbar/pkg/models"
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/cache"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
+	pgrepo "github.com/tragicpixel/fruitbar/pkg/repository/postgres"
+	sqliterepo "github.com/tragicpixel/fruitbar/pkg/repository/sqlite"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"gorm.io/gorm"
+)
+
+// Storage is where a service keeps its records.
+type Storage string
+
+const (
+	// StoragePostgres keeps the records in the postgres database shared by the services.
+	StoragePostgres Storage = "postgres"
+	// StorageMemory keeps the records in memory, for tests and local development. They are lost when the service stops,
+	// and each service has its own unless they run in the same process and share a store, so a user only exists in the service it was created in,
+	// apart from the admin user every service starts with.
+	StorageMemory Storage = "memory"
+	// StorageSQLite keeps the records in an SQLite database file, which every service can share, so a whole instance runs from a single file.
+	StorageSQLite Storage = "sqlite"
+)
+
+// Name of the admin user a service keeping its records in memory or in SQLite starts with, so it can be used without setting up a database first.
+const adminUsername = "admin"
+
+// ParseStorage parses the supplied name of a storage. Empty is StoragePostgres.
+func ParseStorage(name string) (Storage, error) {
+	switch Storage(name) {
+	case "":
+		return StoragePostgres, nil
+	case StoragePostgres, StorageMemory, StorageSQLite:
+		return Storage(name), nil
+	default:
+		return "", fmt.Errorf("storage is invalid, expected one of: %s, %s, %s got %s", StoragePostgres, StorageMemory, StorageSQLite, name)
+	}
+}
+
+// openStorage opens the supplied storage of the named service, and returns the repositories of its records.
+// Postgres and SQLite are connected to with the supplied connection configuration of each and set up with the supplied function;
+// the database connection is returned too. Memory has no database connection, and is the supplied store, or a new one if nil.
+// SQLite and memory are given an admin user if they have none, with the supplied password or a random one which is logged.
+func openStorage(service string, storage Storage, connection *pgdriver.PostgresConnectionConfig, sqliteConnection *sqlitedriver.SQLiteConnectionConfig, store *memory.Store, setup func(db *driver.DB, init bool) error, adminPassword string) (*driver.DB, *repository.Repositories, error) {
+	switch storage {
+	case "", StoragePostgres:
+		db, err := pgdriver.OpenConnection(connection)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to the %s service database: %s", service, err.Error())
+		}
+		err = setup(db, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up the %s service database: %s", service, err.Error())
+		}
+		return db, pgrepo.NewPostgresRepositories(db.Postgres), nil
+	case StorageSQLite:
+		if sqliteConnection == nil {
+			sqliteConnection = sqlitedriver.NewSQLiteConnectionConfigFromEnv()
+		}
+		db, err := sqlitedriver.OpenConnection(sqliteConnection)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open the %s service database: %s", service, err.Error())
+		}
+		err = setup(db, true)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up the %s service database: %s", service, err.Error())
+		}
+		repos := sqliterepo.NewSQLiteRepositories(db.SQLite)
+		// Every service sets up the users table, so it can create the admin user, even if only the users service uses it.
+		if err := sqlitedriver.SetupTables(db, &models.User{}, true); err != nil {
+			return nil, nil, fmt.Errorf("failed to set up the %s service database: %s", service, err.Error())
+		}
+		if err := createAdmin(repos, adminPassword); err != nil {
+			return nil, nil, fmt.Errorf("failed to create the %s service admin user: %s", service, err.Error())
+		}
+		return db, repos, nil
+	case StorageMemory:
+		log.Info(fmt.Sprintf("Keeping the %s service records in memory; they will be lost when it stops", service))
+		if store == nil {
+			store = memory.NewStore()
+		}
+		repos := memory.NewMemoryRepositories(store)
+		if err := createAdmin(repos, adminPassword); err != nil {
+			return nil, nil, fmt.Errorf("failed to create the %s service admin user: %s", service, err.Error())
+		}
+		return nil, repos, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage '%s'", storage)
+	}
+}
+
+// createAdmin creates the admin user in the supplied repositories, with the supplied password, or a random one which is logged.
+// Does nothing if they already have it.
+func createAdmin(repos *repository.Repositories, password string) error {
+	if _, err := repos.Users.GetByUsername(adminUsername); err == nil {
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if password == "" {
+		b := make([]byte, 12)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		password = base64.RawURLEncoding.EncodeToString(b)
+		log.Info(fmt.Sprintf("Log in as %s with the password %s", adminUsername, password))
+	}
+	admin := models.User{Name: adminUsername, Role: roles.Admin}
+	if err := repos.Users.HashPassword(&admin, password); err != nil {
+		return err
+	}
+	_, err := repos.Users.Create(&admin)
+	return err
+}
+
+// setupTables checks that the database the supplied driver is connected to, postgres or SQLite, has a table for the supplied object.
+// If init is true, will create the table if it does not already exist.
+func setupTables(db *driver.DB, object interface{}, init bool) error {
+	if db.SQLite != nil {
+		return sqlitedriver.SetupTables(db, object, init)
+	}
+	return pgdriver.SetupTables(db, object, init)
+}
+
+// setupAppendOnlyTable makes the table of the supplied object append-only, in the database the supplied driver is connected to, postgres or SQLite.
+func setupAppendOnlyTable(db *driver.DB, object interface{}) error {
+	if db.SQLite != nil {
+		return sqlitedriver.SetupAppendOnlyTable(db, object)
+	}
+	return pgdriver.SetupAppendOnlyTable(db, object)
+}
+
+// cacheProducts returns the supplied repositories of a service reading products through a cache configured by the supplied configuration,
+// along with the cached product repository, unless the service has no database connection, as its records are already in memory.
+func cacheProducts(db *driver.DB, repos *repository.Repositories, config cache.Config) (*repository.Repositories, *cache.CachedProductRepo) {
+	if db == nil {
+		return repos, nil
+	}
+	products := cache.NewCachedProductRepo(repos.Products, config)
+	return cache.NewCachedRepositories(repos, products), products
+}
+
+// writeCacheMetrics writes a response in JSON containing the statistics of the supplied cached product repository, or null if it is nil, to the supplied http response writer.
+func writeCacheMetrics(w http.ResponseWriter, products *cache.CachedProductRepo) {
+	metrics := map[string]*cache.Stats{"products": nil}
+	if products != nil {
+		stats := products.Stats()
+		metrics["products"] = &stats
+	}
+	json.WriteResponse(w, http.StatusOK, metrics)
+}
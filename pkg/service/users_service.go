@@ -4,142 +4,101 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/tragicpixel/fruitbar/pkg/driver"
 	pgdriver "github.com/tragicpixel/fruitbar/pkg/driver/postgres"
+	sqlitedriver "github.com/tragicpixel/fruitbar/pkg/driver/sqlite"
 	"github.com/tragicpixel/fruitbar/pkg/handler"
 	"github.com/tragicpixel/fruitbar/pkg/models"
 	"github.com/tragicpixel/fruitbar/pkg/models/roles"
-	"github.com/tragicpixel/fruitbar/pkg/utils/cors"
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/repository/memory"
 	"github.com/tragicpixel/fruitbar/pkg/utils/json"
 	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc"
+	"github.com/tragicpixel/fruitbar/pkg/utils/openapi"
 )
 
 // UsersService holds all the pieces necessary to run the authentication service for the fruitbar application.
 type UsersService struct {
-	Router  *mux.Router
-	Handler *handler.User
-	DB      *driver.DB
-	Port    int
+	Router      *mux.Router
+	Handler     *handler.User
+	OIDCHandler *handler.OIDC
+	// Handler for reading the audit log shared by all the services.
+	AuditHandler *handler.Audit
+	// Handler making the unsafe endpoints safe to retry.
+	IdempotencyHandler *handler.Idempotency
+	// Connection to the database the records are kept in. (nil if they are kept in memory)
+	DB *driver.DB
+	// Repositories of the service's records.
+	Repos *repository.Repositories
+	Port  int
+	// How much of the traffic of the service is validated against its OpenAPI document.
+	OpenAPIValidation openapi.Mode
 }
 
 type UsersServiceConfig struct {
 	DatabaseConnection *pgdriver.PostgresConnectionConfig
 	Port               int
+	// Configuration for signing in through an external OpenID Connect identity provider. (nil disables it)
+	OIDC *oidc.Config
+	// What happens to a user's orders when the user is purged. (defaults to retaining them)
+	OrderRetention handler.OrderRetentionPolicy
+	// How long the responses to requests made with an Idempotency-Key header are kept for replay. (defaults to 24 hours)
+	IdempotencyKeyTTL time.Duration
+	// How much of the traffic of the service is validated against its OpenAPI document. (defaults to none)
+	OpenAPIValidation openapi.Mode
+	// Where the service keeps its records. (defaults to postgres, using DatabaseConnection)
+	Storage Storage
+	// Connection to the SQLite database file the records are kept in, when they are kept in one. (read from the environment if nil)
+	SQLiteConnection *sqlitedriver.SQLiteConnectionConfig
+	// Password of the admin user a service keeping its records in memory or in SQLite starts with. (a random one is generated and logged if empty)
+	AdminPassword string
+	// Store the records are kept in when they are kept in memory, so services run in the same process can share them. (a new one if nil)
+	MemoryStore *memory.Store
 }
 
+// Paths of the users service's endpoints. Path parameters are in braces.
 const (
-	usersAPIBaseRoute               = "/users"
-	usersCreateAPIRoute             = usersAPIBaseRoute
-	usersReadAPIRoute               = usersAPIBaseRoute
-	usersUpdateAPIRoute             = usersAPIBaseRoute
-	usersDeleteAPIRoute             = usersAPIBaseRoute
+	usersAPIBaseRoute               = apiVersionPrefix + "/users"
+	usersAPIRoute                   = usersAPIBaseRoute + "/{id}"
+	usersBatchAPIRoute              = usersAPIBaseRoute + "/batch"
+	usersRestoreAPIRoute            = usersAPIRoute + "/restore"
+	usersPurgeAPIRoute              = usersAPIRoute + "/purge"
+	usersExportAPIRoute             = usersAPIRoute + "/export"
+	usersEraseAPIRoute              = usersAPIRoute + "/erase"
+	usersImpersonateAPIRoute        = usersAPIRoute + "/impersonate"
 	usersLoginAPIRoute              = usersAPIBaseRoute + "/login"
 	usersPasswordFormatAPIRoute     = usersAPIBaseRoute + "/password-format"
 	usersListRolesAPIRoute          = usersAPIBaseRoute + "/list-roles"
 	usersPageMaxRecordLimitAPIRoute = usersAPIBaseRoute + "/page-max-record-limit"
 	usersHealthAPIRoute             = usersAPIBaseRoute + "/health"
-)
+	usersOIDCLoginAPIRoute          = usersAPIBaseRoute + "/oidc/login"
+	usersOIDCCallbackAPIRoute       = usersAPIBaseRoute + "/oidc/callback"
 
-func (s *UsersService) getUsersEndpointOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Users Options",
-		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
-	}
-}
-func (s *UsersService) getCreateAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Create User",
-		AllowedMethods: []string{http.MethodPost},
-	}
-}
-func (s *UsersService) getReadAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Read User",
-		AllowedMethods: []string{http.MethodGet},
-	}
-}
-func (s *UsersService) getUpdateAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Update User",
-		AllowedMethods: []string{http.MethodPut},
-	}
-}
-func (s *UsersService) getDeleteAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Delete User",
-		AllowedMethods: []string{http.MethodDelete},
-	}
-}
-func (s *UsersService) getLoginAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Login User",
-		AllowedMethods: []string{http.MethodPost, http.MethodOptions},
-	}
-}
-func (s *UsersService) getPasswordFormatAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Password Format",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
-func (s *UsersService) getListRolesAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "List Roles",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
-func (s *UsersService) getPageMaxRecordLimitAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Page Max Record Limit",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
-func (s *UsersService) getHealthCheckAPIOptions() cors.Options {
-	return cors.Options{
-		AllowedURL:     UI_URL,
-		APIName:        "Health Check",
-		AllowedMethods: []string{http.MethodGet, http.MethodOptions},
-	}
-}
+	auditAPIBaseRoute   = apiVersionPrefix + "/audit"
+	auditVerifyAPIRoute = auditAPIBaseRoute + "/verify"
 
-func (s *UsersService) getCreateAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getCreateAPIOptions(), s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.CreateUser, roles.Admin)))
-}
-func (s *UsersService) getReadAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getReadAPIOptions(), s.Handler.IsAuthorized(s.Handler.GetUsers))
-}
-func (s *UsersService) getUpdateAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getUpdateAPIOptions(), s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.UpdateUser, roles.Admin)))
-}
-func (s *UsersService) getDeleteAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getDeleteAPIOptions(), s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.DeleteUser, roles.Admin)))
-}
-func (s *UsersService) getLoginAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getLoginAPIOptions(), s.Handler.Login)
-}
-func (s *UsersService) getPasswordFormatAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getPasswordFormatAPIOptions(), s.Handler.GetPasswordFormatMessage)
-}
-func (s *UsersService) getListRolesAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getListRolesAPIOptions(), s.Handler.GetRolesList)
-}
-func (s *UsersService) getPageMaxRecordLimitAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getPageMaxRecordLimitAPIOptions(), s.Handler.GetPageMaxRecordLimit)
-}
-func (s *UsersService) getHealthCheckAPIHandler() func(http.ResponseWriter, *http.Request) {
-	return cors.SendPreflightHeaders(s.getHealthCheckAPIOptions(), s.CheckHealth)
-}
+	// Deprecated, unversioned paths, which take the ids as query parameters.
+	legacyUsersAPIBaseRoute               = "/users"
+	legacyUsersBatchAPIRoute              = legacyUsersAPIBaseRoute + "/batch"
+	legacyUsersRestoreAPIRoute            = legacyUsersAPIBaseRoute + "/restore"
+	legacyUsersPurgeAPIRoute              = legacyUsersAPIBaseRoute + "/purge"
+	legacyUsersExportAPIRoute             = legacyUsersAPIBaseRoute + "/export"
+	legacyUsersEraseAPIRoute              = legacyUsersAPIBaseRoute + "/erase"
+	legacyUsersImpersonateAPIRoute        = legacyUsersAPIBaseRoute + "/impersonate"
+	legacyUsersLoginAPIRoute              = legacyUsersAPIBaseRoute + "/login"
+	legacyUsersPasswordFormatAPIRoute     = legacyUsersAPIBaseRoute + "/password-format"
+	legacyUsersListRolesAPIRoute          = legacyUsersAPIBaseRoute + "/list-roles"
+	legacyUsersPageMaxRecordLimitAPIRoute = legacyUsersAPIBaseRoute + "/page-max-record-limit"
+	legacyUsersHealthAPIRoute             = legacyUsersAPIBaseRoute + "/health"
+	legacyUsersOIDCLoginAPIRoute          = legacyUsersAPIBaseRoute + "/oidc/login"
+	legacyUsersOIDCCallbackAPIRoute       = legacyUsersAPIBaseRoute + "/oidc/callback"
+	legacyAuditAPIBaseRoute               = "/audit"
+	legacyAuditVerifyAPIRoute             = legacyAuditAPIBaseRoute + "/verify"
+)
 
 // NewUsersService creates a new instance of a users service.
 // Returns nil on error.
@@ -147,18 +106,30 @@ func NewUsersService(config *UsersServiceConfig) (*UsersService, error) {
 	s := UsersService{}
 
 	// sqldb is service name of postgres container in docker-compose
-	db, err := pgdriver.OpenConnection(config.DatabaseConnection)
+	db, repos, err := openStorage("user", config.Storage, config.DatabaseConnection, config.SQLiteConnection, config.MemoryStore, SetupUsersServiceDB, config.AdminPassword)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to the user service database: %s", err.Error())
+		return nil, err
 	}
 
 	s.DB = db
-	err = SetupUsersServiceDB(s.DB, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to set up the user service database: %s", err.Error())
+	s.Repos = repos
+	s.Handler = handler.NewUserHandler(repos)
+	s.IdempotencyHandler = handler.NewIdempotencyHandler(repos, config.IdempotencyKeyTTL)
+	s.AuditHandler = handler.NewAuditHandler(repos)
+	if config.OrderRetention != "" {
+		err = s.Handler.SetOrderRetentionPolicy(config.OrderRetention)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure the users service: %s", err.Error())
+		}
 	}
-
-	s.Handler = handler.NewUserHandler(db)
+	if config.OIDC != nil {
+		provider, err := oidc.NewProvider(*config.OIDC, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up the OIDC provider: %s", err.Error())
+		}
+		s.OIDCHandler = handler.NewOIDCHandler(repos, provider)
+	}
+	s.OpenAPIValidation = config.OpenAPIValidation
 	s.Router = s.NewUsersServiceRouter(db)
 	s.Port = config.Port
 
@@ -167,200 +138,524 @@ func NewUsersService(config *UsersServiceConfig) (*UsersService, error) {
 
 // NewUsersServiceRouter creates and returns a new http router for the users service.
 func (s *UsersService) NewUsersServiceRouter(db *driver.DB) *mux.Router {
-	r := mux.NewRouter()
-
-	r.HandleFunc(usersAPIBaseRoute, cors.SendPreflightHeaders(s.getUsersEndpointOptions(), nil)).Methods(http.MethodOptions)
-	// swagger:operation POST /users/login users authUser
-	//
-	// Log a user in and return a JWT.
-	//
-	// ---
-	// parameters:
-	// - name: user
-	//   in: body
-	//   description: Credentials of the user to verify.
-	//   required: true
-	//   "$ref": "#/definitions/user"
-	// responses:
-	//   '200':
-	//     description: Successfully logged in.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(usersLoginAPIRoute, s.getLoginAPIHandler()).Methods(s.getLoginAPIOptions().AllowedMethods...)
-	// swagger:operation POST /users/register users createUser
-	//
-	// Create a new user.
-	//
-	// ---
-	// parameters:
-	// - name: user
-	//   in: body
-	//   description: New user to create. Id, CreatedAt, DeletedAt, UpdatedAt fields will be ignored.
-	//   required: true
-	//   "$ref": "#/definitions/user"
-	// responses:
-	//   '200':
-	//     description: Successfully created a user. Id of the newly created user is not returned.
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(usersCreateAPIRoute, s.getCreateAPIHandler()).Methods(s.getCreateAPIOptions().AllowedMethods...)
-	// swagger:operation GET /users users getUser
-	//
-	// Get a single user by ID, or a paginated array of all users.
-	//
-	// ---
-	// parameters:
-	// - name: id
-	//   in: query
-	//   description: id of user to retrieve.
-	//   required: false
-	//   schema:
-	//     type: int
-	// security:
-	// - bearer: []
-	// responses:
-	//   '200':
-	//     description: Successfully retrieved a user.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(usersReadAPIRoute, s.getReadAPIHandler()).Methods(s.getReadAPIOptions().AllowedMethods...)
-	// swagger:operation PUT /users users updateUser
-	//
-	// Update an existing uuser.
-	//
-	// ---
-	// parameters:
-	// - name: user
-	//   in: body
-	//   description: user fields to update. CreatedAt, DeletedAt, UpdatedAt fields will be ignored.
-	//   required: true
-	//   schema:
-	//     $ref: "#/definitions/user"
-	// security:
-	// - bearer: []
-	// responses:
-	//   '200':
-	//     description: Successfully updated an existing user.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(usersUpdateAPIRoute, s.getUpdateAPIHandler()).Methods(s.getUpdateAPIOptions().AllowedMethods...)
-	// swagger:operation DELETE /users users deleteUser
-	//
-	// Delete an existing user.
-	//
-	// ---
-	// parameters:
-	// - name: id
-	//   in: query
-	//   description: id of user to delete.
-	//   required: true
-	//   schema:
-	//     type: int
-	// security:
-	// - bearer: []
-	// responses:
-	//   '204':
-	//     description: Successfully deleted an existing user.
-	//   '400':
-	//     description: Invalid request.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '401':
-	//     description: Not authorized.
-	//   '403':
-	//     description: No authorization header provided.
-	//   '405':
-	//     description: HTTP method not allowed.
-	//   '413':
-	//     description: Request body too large.
-	//     "$ref": "#/responses/jsonResponse"
-	//   '500':
-	//     description: Internal server error.
-	//     "$ref": "#/responses/jsonResponse"
-	r.HandleFunc(usersDeleteAPIRoute, s.getDeleteAPIHandler()).Methods(s.getDeleteAPIOptions().AllowedMethods...)
-	// swagger:operation GET /users/password-format users getPasswordFormat
-	//
-	// Returns an array of strings where each item is a requirement for a valid password.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The password format message was returned successfully.
-	r.HandleFunc(usersPasswordFormatAPIRoute, s.getPasswordFormatAPIHandler()).Methods(s.getPasswordFormatAPIOptions().AllowedMethods...)
-	// swagger:operation GET /users/list-roles users getRolesList
-	//
-	// Returns an array of strings where each item is a valid role for a user.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The roles list was returned successfully.
-	r.HandleFunc(usersListRolesAPIRoute, s.getListRolesAPIHandler()).Methods(s.getListRolesAPIOptions().AllowedMethods...)
-	// swagger:operation GET /users/page-max-records-limit users getPageMaxRecordsLimit
-	//
-	// Returns an integer that is the maximum number of records that can be returned in one page.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The page max records limit was returned successfully.
-	r.HandleFunc(usersPageMaxRecordLimitAPIRoute, s.getPageMaxRecordLimitAPIHandler()).Methods(s.getPageMaxRecordLimitAPIOptions().AllowedMethods...)
-	// swagger:operation GET /users/health users checkHealth
-	//
-	// Checks the health of the service and sends a response indicating if the health check passed.
-	//
-	// ---
-	// responses:
-	//   '200':
-	//     description: The health check was completed.
-	//     "$ref": "#/responses/healthCheckResponse"
-	r.HandleFunc(usersHealthAPIRoute, s.getHealthCheckAPIHandler()).Methods(s.getHealthCheckAPIOptions().AllowedMethods...)
+	return newRouter("Fruitbar Users API", s.routes(), s.OpenAPIValidation)
+}
 
-	return r
+// routes returns the route table of the users service. The OIDC routes are only served when OIDC is configured.
+func (s *UsersService) routes() []Route {
+	routes := []Route{
+		{
+			Name:       "Login User",
+			Method:     http.MethodPost,
+			Path:       usersLoginAPIRoute,
+			LegacyPath: legacyUsersLoginAPIRoute,
+			Handler:    s.Handler.Login,
+			Doc: openapi.Operation{
+				ID:      "authUser",
+				Tags:    []string{"users"},
+				Summary: "Log a user in and return a JWT.",
+				Body:    &openapi.Body{Description: "Credentials of the user to verify.", Required: true, Model: models.User{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully logged in.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "Create User",
+			Method:     http.MethodPost,
+			Path:       usersAPIBaseRoute,
+			LegacyPath: legacyUsersAPIBaseRoute,
+			Handler:    s.Handler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.HasRole(s.Handler.CreateUser, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "createUser",
+				Tags:    []string{"users"},
+				Summary: "Create a new user.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+				},
+				Body: &openapi.Body{Description: "New user to create. Id, CreatedAt, DeletedAt, UpdatedAt fields will be ignored.", Required: true, Model: models.User{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully created a user. Id of the newly created user is not returned.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "List Users",
+			Method:     http.MethodGet,
+			Path:       usersAPIBaseRoute,
+			LegacyPath: legacyUsersAPIBaseRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.GetUsers),
+			Doc: openapi.Operation{
+				ID:      "listUsers",
+				Tags:    []string{"users"},
+				Summary: "Get a paginated listing of all users.",
+				Params: []openapi.Param{
+					{Name: "status", In: openapi.InQuery, Description: "Which users to list, one of active (default), inactive or all. Only admins can list inactive users.", Type: "string"},
+					{Name: "filter", In: openapi.InQuery, Description: "Only list records matching all of these conditions, separated by semicolons. (e.g. role=customer)", Type: "string"},
+					{Name: "sort", In: openapi.InQuery, Description: "Fields to sort the listing by, separated by commas and prefixed with - to sort descending. (e.g. -createdat)", Type: "string"},
+					{Name: "cursor", In: openapi.InQuery, Description: "Cursor of the page to return, from the next or prev field of a previous page.", Type: "string"},
+					{Name: "limit", In: openapi.InQuery, Description: "Maximum number of records to return.", Type: "integer"},
+					{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas. The id is always returned.", Type: "string"},
+					{Name: "include", In: openapi.InQuery, Description: "Related records to embed, separated by commas. One of: orders, orders.items", Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved a page of users.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:    "Read User",
+			Method:  http.MethodGet,
+			Path:    usersAPIRoute,
+			Handler: s.Handler.IsAuthorized(s.Handler.GetUsers),
+			Doc: openapi.Operation{
+				ID:      "getUser",
+				Tags:    []string{"users"},
+				Summary: "Get a user by ID.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the user.", Type: "integer"},
+					{Name: "fields", In: openapi.InQuery, Description: "Fields to return, separated by commas. The id is always returned.", Type: "string"},
+					{Name: "include", In: openapi.InQuery, Description: "Related records to embed, separated by commas. One of: orders, orders.items", Type: "string"},
+					{Name: "If-None-Match", In: openapi.InHeader, Description: "ETag of the user as it was last read. If the user is unchanged, a 304 Not Modified is sent instead.", Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully retrieved the user.", Model: json.Response{}},
+					{Status: http.StatusNotModified, Description: "The user is unchanged since it was last read."},
+					{Status: http.StatusNotFound, Description: "User not found.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Update User",
+			Method:     http.MethodPut,
+			Path:       usersAPIRoute,
+			LegacyPath: legacyUsersAPIBaseRoute,
+			Handler:    s.Handler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.HasRole(s.Handler.UpdateUser, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "updateUser",
+				Tags:    []string{"users"},
+				Summary: "Update an existing uuser.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of the user.", Type: "integer"},
+					idempotencyKeyParam,
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the user as it was read. The request is refused if the user has changed since.", Required: true, Type: "string"},
+				},
+				Body: &openapi.Body{Description: "user fields to update. CreatedAt, DeletedAt, UpdatedAt fields will be ignored.", Required: true, Model: models.User{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully updated an existing user.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The user has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Patch User",
+			Method:     http.MethodPatch,
+			Path:       usersAPIRoute,
+			LegacyPath: legacyUsersAPIBaseRoute,
+			Handler:    s.Handler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.HasRole(s.Handler.PatchUser, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:          "patchUser",
+				Tags:        []string{"users"},
+				Summary:     "Patch an existing user with a JSON Merge Patch (application/merge-patch+json) or a JSON Patch (application/json-patch+json).",
+				Description: "The patched user is validated before anything is stored.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of user to patch.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the user as it was read. The request is refused if the user has changed since.", Required: true, Type: "string"},
+				},
+				Body: &openapi.Body{Description: "Patch to apply to the user. Read-only fields (ID, CreatedAt, UpdatedAt, DeletedAt, orders) can't be changed. The password is patched as if it were empty, so setting it sets a new password.", Required: true, MediaTypes: []string{"application/merge-patch+json", "application/json-patch+json"}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully patched an existing user.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request, malformed patch or the patched user is invalid.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "User not found.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "The patch can't be applied to the user, e.g. a test operation failed or a path doesn't exist.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The user has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusUnsupportedMediaType, Description: "Content-Type is not a supported patch format.", Model: json.Response{}},
+					{Status: http.StatusUnprocessableEntity, Description: "The patch changes a read-only field, or the patched user doesn't decode.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Delete User",
+			Method:     http.MethodDelete,
+			Path:       usersAPIRoute,
+			LegacyPath: legacyUsersAPIBaseRoute,
+			Handler:    s.Handler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.HasRole(s.Handler.DeleteUser, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "deleteUser",
+				Tags:    []string{"users"},
+				Summary: "Deactivate an existing user. The user can no longer log in, and their tokens are rejected.",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+					{Name: "id", In: openapi.InPath, Description: "id of user to delete.", Type: "integer"},
+					{Name: "If-Match", In: openapi.InHeader, Description: "ETag of the user as it was read. The request is refused if the user has changed since.", Required: true, Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully deactivated an existing user.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusPreconditionFailed, Description: "The user has changed since it was read.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Status: http.StatusPreconditionRequired, Description: "If-Match header missing.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Batch Users",
+			Method:     http.MethodPost,
+			Path:       usersBatchAPIRoute,
+			LegacyPath: legacyUsersBatchAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.IdempotencyHandler.Idempotent(s.Handler.HasRole(s.Handler.BatchUsers, roles.Admin))),
+			Doc: openapi.Operation{
+				ID:      "batchUsers",
+				Tags:    []string{"users"},
+				Summary: "Create, update, patch and delete many users in one request.",
+				Description: "Each operation is run as the single user request it stands for, and its result is returned in the same position of the data array, " +
+					"with its HTTP status and the response it would have had on its own. Requires the admin role. " +
+					"A best-effort batch applies every operation it can, and always succeeds. " +
+					"An atomic batch is all-or-nothing: when an operation fails, the batch fails with that operation's status, and every other operation is marked 424 (not applied).",
+				Params: []openapi.Param{
+					idempotencyKeyParam,
+				},
+				Body: &openapi.Body{Description: "Operations to run, in order. Updates, patches and deletes need the id and version of the user; patch data is a JSON Merge Patch object or a JSON Patch array.", Required: true, Model: models.Batch{}},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The batch was run. The data array holds the status and response of each operation.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request, or an operation of an atomic batch was invalid.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "No authorization header provided.", Model: json.Response{}},
+					{Status: http.StatusMethodNotAllowed, Description: "HTTP method not allowed.", Model: json.Response{}},
+					{Status: http.StatusRequestEntityTooLarge, Description: "Request body too large.", Model: json.Response{}},
+					{Description: "An operation of an atomic batch failed with this status. Nothing was applied.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Restore User",
+			Method:     http.MethodPost,
+			Path:       usersRestoreAPIRoute,
+			LegacyPath: legacyUsersRestoreAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.RestoreUser, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:      "restoreUser",
+				Tags:    []string{"users"},
+				Summary: "Reactivate a deactivated user.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of user to restore.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully restored the user.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "No deactivated user with that id exists.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Purge User",
+			Method:     http.MethodDelete,
+			Path:       usersPurgeAPIRoute,
+			LegacyPath: legacyUsersPurgeAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.PurgeUser, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:      "purgeUser",
+				Tags:    []string{"users"},
+				Summary: "Permanently remove a deactivated user. Their orders are retained or deleted according to the configured order retention policy.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of user to purge.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully purged the user.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "No deactivated user with that id exists.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "The user is still active.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Export User Data",
+			Method:     http.MethodGet,
+			Path:       usersExportAPIRoute,
+			LegacyPath: legacyUsersExportAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.ExportUserData),
+			Doc: openapi.Operation{
+				ID:          "exportUserData",
+				Tags:        []string{"users"},
+				Summary:     "Export all of the personal data held about a user: their account, orders, items and payment metadata.",
+				Description: "Users can export their own data. Every export is recorded in the audit log.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of user to export.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully exported the user's data.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "Not allowed to export this user's data.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "The user could not be found.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Erase User Data",
+			Method:     http.MethodPost,
+			Path:       usersEraseAPIRoute,
+			LegacyPath: legacyUsersEraseAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.EraseUserData, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:          "eraseUserData",
+				Tags:        []string{"users"},
+				Summary:     "Erase a user's personal data: the user is anonymized and deactivated, and card details are scrubbed from their orders.",
+				Description: "Order totals are kept. Every erasure is recorded in the audit log.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of user to erase.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully erased the user's data.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "The user could not be found.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Impersonate User",
+			Method:     http.MethodPost,
+			Path:       usersImpersonateAPIRoute,
+			LegacyPath: legacyUsersImpersonateAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.HasRole(s.Handler.Impersonate, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:          "impersonateUser",
+				Tags:        []string{"users"},
+				Summary:     "Get a token to act as a customer or employee for customer support. The token expires after 15 minutes and identifies the admin in its act claim.",
+				Description: "Every request made with it is audited under both identities, and it can't be used to change a password or role.",
+				Params: []openapi.Param{
+					{Name: "id", In: openapi.InPath, Description: "id of user to impersonate.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully created an impersonation token.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusForbidden, Description: "The user is an admin, or the client is already impersonating someone.", Model: json.Response{}},
+					{Status: http.StatusNotFound, Description: "The user could not be found.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Read Audit Log",
+			Method:     http.MethodGet,
+			Path:       auditAPIBaseRoute,
+			LegacyPath: legacyAuditAPIBaseRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.HasRole(s.AuditHandler.GetAuditEntries, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:      "readAuditLog",
+				Tags:    []string{"audit"},
+				Summary: "Read a page of the audit log of privileged actions, oldest first. Every filter is optional.",
+				Params: []openapi.Param{
+					{Name: "actorid", In: openapi.InQuery, Description: "Only entries for actions performed by the user with this id.", Type: "integer"},
+					{Name: "action", In: openapi.InQuery, Description: "Only entries for this action. (create, update, delete, restore, purge, export, erase)", Type: "string"},
+					{Name: "entitytype", In: openapi.InQuery, Description: "Only entries for actions on this type of entity. (user, order, product)", Type: "string"},
+					{Name: "entityid", In: openapi.InQuery, Description: "Only entries for actions on the entity with this id.", Type: "integer"},
+					{Name: "impersonatorid", In: openapi.InQuery, Description: "Only entries for actions performed by the admin with this id while impersonating another user.", Type: "integer"},
+					{Name: "requestid", In: openapi.InQuery, Description: "Only entries for actions performed in the request with this id. (X-Request-ID header)", Type: "string"},
+					{Name: "since", In: openapi.InQuery, Description: "Only entries created at or after this time. (RFC 3339)", Type: "string"},
+					{Name: "until", In: openapi.InQuery, Description: "Only entries created before this time. (RFC 3339)", Type: "string"},
+					{Name: "cursor", In: openapi.InQuery, Description: "Cursor of the page to return, from the next or prev field of a previous page.", Type: "string"},
+					{Name: "limit", In: openapi.InQuery, Description: "Maximum number of entries to return.", Type: "integer"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully read the audit log.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Verify Audit Log",
+			Method:     http.MethodGet,
+			Path:       auditVerifyAPIRoute,
+			LegacyPath: legacyAuditVerifyAPIRoute,
+			Handler:    s.Handler.IsAuthorized(s.Handler.HasRole(s.AuditHandler.VerifyAuditLog, roles.Admin)),
+			Doc: openapi.Operation{
+				ID:      "verifyAuditLog",
+				Tags:    []string{"audit"},
+				Summary: "Check the hash chain of the whole audit log, and return the number of entries verified.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The audit log is intact.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "Not authorized.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "An entry in the audit log has been changed or removed.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+				Secured: true,
+			},
+		},
+		{
+			Name:       "Password Format",
+			Method:     http.MethodGet,
+			Path:       usersPasswordFormatAPIRoute,
+			LegacyPath: legacyUsersPasswordFormatAPIRoute,
+			Handler:    s.Handler.GetPasswordFormatMessage,
+			Doc: openapi.Operation{
+				ID:      "getPasswordFormat",
+				Tags:    []string{"users"},
+				Summary: "Returns an array of strings where each item is a requirement for a valid password.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The password format message was returned successfully.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "List Roles",
+			Method:     http.MethodGet,
+			Path:       usersListRolesAPIRoute,
+			LegacyPath: legacyUsersListRolesAPIRoute,
+			Handler:    s.Handler.GetRolesList,
+			Doc: openapi.Operation{
+				ID:      "getRolesList",
+				Tags:    []string{"users"},
+				Summary: "Returns an array of strings where each item is a valid role for a user.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The roles list was returned successfully.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "Page Max Record Limit",
+			Method:     http.MethodGet,
+			Path:       usersPageMaxRecordLimitAPIRoute,
+			LegacyPath: legacyUsersPageMaxRecordLimitAPIRoute,
+			Handler:    s.Handler.GetPageMaxRecordLimit,
+			Doc: openapi.Operation{
+				ID:      "getPageMaxRecordLimit",
+				Tags:    []string{"users"},
+				Summary: "Returns an integer that is the maximum number of records that can be returned in one page.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The page max records limit was returned successfully.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "Health Check",
+			Method:     http.MethodGet,
+			Path:       usersHealthAPIRoute,
+			LegacyPath: legacyUsersHealthAPIRoute,
+			Handler:    s.CheckHealth,
+			Doc: openapi.Operation{
+				ID:      "checkHealth",
+				Tags:    []string{"users"},
+				Summary: "Checks the health of the service and sends a response indicating if the health check passed.",
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "The health check was completed.", Model: map[string]bool{}},
+				},
+			},
+		},
+	}
+	if s.OIDCHandler == nil {
+		return routes
+	}
+	return append(routes, []Route{
+		{
+			Name:       "OIDC Login",
+			Method:     http.MethodGet,
+			Path:       usersOIDCLoginAPIRoute,
+			LegacyPath: legacyUsersOIDCLoginAPIRoute,
+			Handler:    s.OIDCHandler.Login,
+			Doc: openapi.Operation{
+				ID:      "oidcLogin",
+				Tags:    []string{"users"},
+				Summary: "Redirects the client to the external identity provider to sign in.",
+				Responses: []openapi.Response{
+					{Status: http.StatusFound, Description: "Redirect to the identity provider's authorization endpoint."},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+			},
+		},
+		{
+			Name:       "OIDC Callback",
+			Method:     http.MethodGet,
+			Path:       usersOIDCCallbackAPIRoute,
+			LegacyPath: legacyUsersOIDCCallbackAPIRoute,
+			Handler:    s.OIDCHandler.Callback,
+			Doc: openapi.Operation{
+				ID:      "oidcCallback",
+				Tags:    []string{"users"},
+				Summary: "Completes sign in through the external identity provider and returns a JWT.",
+				Params: []openapi.Param{
+					{Name: "code", In: openapi.InQuery, Description: "Authorization code issued by the identity provider.", Required: true, Type: "string"},
+					{Name: "state", In: openapi.InQuery, Description: "State of the authorization request being completed.", Required: true, Type: "string"},
+				},
+				Responses: []openapi.Response{
+					{Status: http.StatusOK, Description: "Successfully logged in.", Model: json.Response{}},
+					{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+					{Status: http.StatusUnauthorized, Description: "The identity provider did not authenticate the user.", Model: json.Response{}},
+					{Status: http.StatusConflict, Description: "A user with the same name already exists.", Model: json.Response{}},
+					{Status: http.StatusInternalServerError, Description: "Internal server error.", Model: json.Response{}},
+				},
+			},
+		},
+	}...)
 }
 
 // SetupUsersServiceDB checks that the database schema is ready for the authentication service.
 // If init is true, will create the tables if they do not already exist.
 func SetupUsersServiceDB(db *driver.DB, init bool) error {
 	log.Info("Setting up the users service database...")
-	err := pgdriver.SetupTables(db, &models.User{}, init)
+	err := setupTables(db, &models.User{}, init)
 	if err != nil {
 		log.Error("failed to set up the User model table" + err.Error())
 		return errors.New("failed to set up the User model table: " + err.Error())
 	}
+	err = setupAuditDB(db, init)
+	if err != nil {
+		return err
+	}
+	err = setupIdempotencyDB(db, init)
+	if err != nil {
+		return err
+	}
+	err = setupTables(db, &models.ExternalIdentity{}, init)
+	if err != nil {
+		log.Error("failed to set up the ExternalIdentity model table" + err.Error())
+		return errors.New("failed to set up the ExternalIdentity model table: " + err.Error())
+	}
 	log.Info("Successfully set up the database for the users service")
 	return nil
 }
@@ -369,7 +664,12 @@ func SetupUsersServiceDB(db *driver.DB, init bool) error {
 func (s *UsersService) CheckHealth(w http.ResponseWriter, r *http.Request) {
 	var err error
 	log.Info("Checking users service health...")
-	db, err := s.DB.Postgres.DB()
+	if s.DB == nil {
+		log.Info("health check passed: records are kept in memory")
+		json.WriteResponse(w, http.StatusOK, map[string]bool{"ok": true})
+		return
+	}
+	db, err := s.DB.SQL().DB()
 	if err != nil {
 		log.Error("health check failed: Error getting SQLDB from gorm DB: " + err.Error())
 		json.WriteResponse(w, http.StatusOK, map[string]bool{"ok": false})
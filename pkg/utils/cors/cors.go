@@ -20,8 +20,8 @@ func Enable(w *http.ResponseWriter, url string) {
 // This gives the requestor all the information they need to make requests on the particular endpoint you are calling this function from.
 func SetPreflightHeaders(w *http.ResponseWriter, allowedMethods []string) {
 	(*w).Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
-	(*w).Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Access-Control-Allow-Credentials, Access-Control-Allow-Origin")
-	(*w).Header().Set("Access-Control-Expose-Headers", "Content-Range")
+	(*w).Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, "+httputils.IdempotencyKeyHeader+", Access-Control-Allow-Credentials, Access-Control-Allow-Origin, "+httputils.RequestIDHeader)
+	(*w).Header().Set("Access-Control-Expose-Headers", "Content-Range, Link, ETag, Deprecation, Sunset, "+httputils.IdempotentReplayedHeader+", "+httputils.RequestIDHeader)
 }
 
 type Options struct {
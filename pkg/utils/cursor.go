@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	jwtutils "github.com/tragicpixel/fruitbar/pkg/utils/jwt"
+)
+
+// Separates the payload of an encoded cursor from its signature.
+const cursorSignatureSeparator = "."
+
+var errInvalidCursor = errors.New("cursor is invalid or has been modified")
+
+// cursor holds the position in a sorted listing that a page of records starts from: the sort key values and ID of a record, and which side of it the page is on.
+// It is sent to clients encoded and signed, so they can't build one or change it.
+type cursor struct {
+	Direction string `json:"d"`
+	ID        uint   `json:"id"`
+	// Values of the record's sort keys, in the order of the sort.
+	Keys []json.RawMessage `json:"k,omitempty"`
+	// The sort the cursor was made for, as it appears in the sort query parameter.
+	Sort string `json:"s,omitempty"`
+}
+
+// EncodeCursor returns a signed, opaque cursor for the page of records on the supplied side of the supplied record, in the order set by the supplied sort.
+// The record's ID and sort key values are read from its JSON, at the path of each sort field in the supplied fields, or at its name if it has none. (ignoring case)
+func EncodeCursor(direction string, record interface{}, sorts []repository.Sort, fields map[string]repository.Field) (string, error) {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "", errors.New("failed to encode record for cursor: " + err.Error())
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return "", errors.New("record for cursor is not a JSON object: " + err.Error())
+	}
+
+	c := cursor{Direction: direction, Sort: sortString(sorts)}
+	if err := json.Unmarshal(lookupCursorKey(raw, []string{"id"}), &c.ID); err != nil {
+		return "", errors.New("record for cursor has no id")
+	}
+	for _, s := range sorts {
+		path := s.Field
+		if fields[s.Field].JSON != "" {
+			path = fields[s.Field].JSON
+		}
+		value := lookupCursorKey(raw, strings.Split(path, includePathSeparator))
+		if value == nil {
+			return "", fmt.Errorf("record for cursor has no '%s' field", s.Field)
+		}
+		c.Keys = append(c.Keys, value)
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + cursorSignatureSeparator + signCursor(encoded), nil
+}
+
+// lookupCursorKey returns the value at the supplied path in the supplied decoded JSON record, walking nested objects and ignoring case,
+// or nil if the record has no value there.
+func lookupCursorKey(record map[string]json.RawMessage, path []string) json.RawMessage {
+	object := record
+	for i, segment := range path {
+		var value json.RawMessage
+		for name, v := range object {
+			if strings.EqualFold(name, segment) {
+				value = v
+				break
+			}
+		}
+		if value == nil || i == len(path)-1 {
+			return value
+		}
+		object = nil
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// setCursor sets the start of the supplied page seek options from the supplied encoded cursor,
+// checking its signature and that it was made for the seek options' sort.
+func setCursor(opts *repository.PageSeekOptions, encoded string, fields map[string]repository.Field) error {
+	parts := strings.Split(encoded, cursorSignatureSeparator)
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(signCursor(parts[0]))) {
+		return errInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return errInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return errInvalidCursor
+	}
+	if c.Direction != repository.SeekDirectionAfter && c.Direction != repository.SeekDirectionBefore {
+		return errInvalidCursor
+	}
+	if c.Sort != sortString(opts.Sort) || len(c.Keys) != len(opts.Sort) {
+		return fmt.Errorf("cursor was made for a different %s; use the same %s as the page it came from", sortParamName, sortParamName)
+	}
+
+	keys := make([]interface{}, len(c.Keys))
+	for i, s := range opts.Sort {
+		if keys[i], err = decodeCursorKey(c.Keys[i], fields[s.Field].Type); err != nil {
+			return errInvalidCursor
+		}
+	}
+	opts.Direction = c.Direction
+	opts.StartId = c.ID
+	opts.StartKeys = keys
+	return nil
+}
+
+// decodeCursorKey converts the supplied sort key value from a cursor to the supplied field type. A null value is kept as nil.
+func decodeCursorKey(raw json.RawMessage, fieldType repository.FieldType) (interface{}, error) {
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	switch fieldType {
+	case repository.FieldTypeUint:
+		var v uint64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case repository.FieldTypeFloat:
+		var v float64
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case repository.FieldTypeString:
+		var v string
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case repository.FieldTypeBool:
+		var v bool
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	case repository.FieldTypeTime:
+		var v time.Time
+		err := json.Unmarshal(raw, &v)
+		return v, err
+	default:
+		return nil, fmt.Errorf("unsupported field type %d", fieldType)
+	}
+}
+
+// signCursor returns the signature of the supplied encoded cursor payload.
+func signCursor(payload string) string {
+	mac := hmac.New(sha256.New, []byte(jwtutils.GetSecretAuthToken().SecretKey))
+	// Prefix the payload, so a cursor signature can never be mistaken for any other signature made with the same key.
+	mac.Write([]byte("cursor:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sortString returns the supplied sort as it appears in the sort query parameter.
+func sortString(sorts []repository.Sort) string {
+	keys := make([]string, len(sorts))
+	for i, s := range sorts {
+		keys[i] = s.Field
+		if s.Descending {
+			keys[i] = sortDescendingPrefix + s.Field
+		}
+	}
+	return strings.Join(keys, sortSeparator)
+}
@@ -0,0 +1,32 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ETag returns the entity tag of the record with the supplied version.
+func ETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// MatchesETag returns whether the supplied If-Match or If-None-Match header value lists the supplied entity tag, or is "*".
+// With weak comparison (for If-None-Match) a weak tag W/"x" matches "x"; with strong comparison (for If-Match) weak tags never match.
+func MatchesETag(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
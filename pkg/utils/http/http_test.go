@@ -1,6 +1,9 @@
 package http
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -8,3 +11,65 @@ import (
 // when an HTTP request using a method that is not allowed is passed to the ValidateHttpRequestMethod function.
 func TestValidateHttpRequestMethod(t *testing.T) {
 }
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = GetRequestID(r)
+	}))
+
+	tests := map[string]struct {
+		header string
+		keep   bool
+	}{
+		"no id":       {header: "", keep: false},
+		"client id":   {header: "abc-123", keep: true},
+		"invalid id":  {header: "has spaces\n", keep: false},
+		"too long id": {header: strings.Repeat("a", maxRequestIDLength+1), keep: false},
+		"max length":  {header: strings.Repeat("a", maxRequestIDLength), keep: true},
+	}
+	for name, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			r.Header.Set(RequestIDHeader, test.header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if seen == "" {
+			t.Errorf("%s: expected request to be given an id", name)
+		}
+		if got := w.Header().Get(RequestIDHeader); got != seen {
+			t.Errorf("%s: expected response header %q got %q", name, seen, got)
+		}
+		if test.keep && seen != test.header {
+			t.Errorf("%s: expected client id %q to be kept, got %q", name, test.header, seen)
+		}
+		if !test.keep && seen == test.header {
+			t.Errorf("%s: expected client id %q to be replaced", name, test.header)
+		}
+	}
+}
+
+func TestMatchesETag(t *testing.T) {
+	etag := ETag(3)
+	tests := map[string]struct {
+		header string
+		weak   bool
+		want   bool
+	}{
+		"empty":              {header: "", want: false},
+		"match":              {header: `"3"`, want: true},
+		"mismatch":           {header: `"2"`, want: false},
+		"unquoted":           {header: `3`, want: false},
+		"any":                {header: "*", want: true},
+		"in list":            {header: `"1", "3"`, want: true},
+		"weak strong":        {header: `W/"3"`, weak: false, want: false},
+		"weak weak":          {header: `W/"3"`, weak: true, want: true},
+		"weak list mismatch": {header: `W/"1", W/"2"`, weak: true, want: false},
+	}
+	for name, test := range tests {
+		if got := MatchesETag(test.header, etag, test.weak); got != test.want {
+			t.Errorf("%s: MatchesETag(%q, %q, %v) = %v, want %v", name, test.header, etag, test.weak, got, test.want)
+		}
+	}
+}
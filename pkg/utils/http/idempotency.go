@@ -0,0 +1,7 @@
+package http
+
+// IdempotencyKeyHeader is the http header carrying the key a client made an unsafe request with, so the request can be safely retried.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is the http header set on a response that was replayed for a retried request, instead of handling the request again.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
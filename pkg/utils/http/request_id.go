@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the http header carrying the ID of a request, in both the request and its response.
+const RequestIDHeader = "X-Request-ID"
+
+// Maximum length of a request ID supplied by a client. Longer IDs are replaced.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID gives every request passing through it an ID, available from GetRequestID, and echoes it back in the response's X-Request-ID header.
+// An ID supplied by the client in the X-Request-ID header is kept, so a request can be traced across services.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// GetRequestID returns the ID given to the supplied http request by RequestID. Returns an empty string if it has none.
+func GetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a new random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID determines whether the supplied request ID is safe to keep: not empty, not too long, and printable ascii only.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
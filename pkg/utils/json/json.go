@@ -16,21 +16,10 @@ import (
 const (
 	// Maximum size in bytes of a request supplied to the application.
 	MAX_CREATE_REQUEST_SIZE_IN_BYTES = 1048576
+	// Maximum size in bytes of a batch request supplied to the application, which holds many records.
+	MAX_BATCH_REQUEST_SIZE_IN_BYTES = 16 * MAX_CREATE_REQUEST_SIZE_IN_BYTES
 )
 
-// swagger:response healthCheckResponse
-type _ struct {
-	body struct {
-		Ok bool `json:"ok"`
-	}
-}
-
-// swagger:response jsonResponse
-// Standard response in JSON from the application. (follows Google JSON Format)
-type _ struct {
-	body Response
-}
-
 // TODO: Rewrite this and the token logic to just use the data interface, remove the ID member as well
 // Response holds a response in JSON format.
 type Response struct {
@@ -42,6 +31,29 @@ type Response struct {
 	Token string `json:"token"`
 	// Any errors returned by the application.
 	Error *ErrorResponse `json:"error"`
+	// Position of the returned data in a paginated listing. (only set when listing)
+	Page *Page `json:"page,omitempty"`
+}
+
+// Page holds the position of a page of records in a paginated listing.
+type Page struct {
+	// Maximum number of records in the page.
+	Limit int `json:"limit"`
+	// Number of records in the page.
+	Count int `json:"count"`
+	// Number of records in the whole listing.
+	Total int64 `json:"total"`
+	// Cursor of the next page, to pass back in the cursor query parameter. (empty on the last page)
+	Next string `json:"next,omitempty"`
+	// Cursor of the previous page, to pass back in the cursor query parameter. (empty on the first page)
+	Prev string `json:"prev,omitempty"`
+}
+
+// BatchResult holds the result of a single operation in a batch request: the response the operation would have had on its own, and its HTTP status code.
+type BatchResult struct {
+	// HTTP status code of the operation.
+	Status int `json:"status"`
+	Response
 }
 
 // ErrorResponse holds an error response in JSON format. Can contain mulitple errors.
@@ -79,8 +91,11 @@ func (request *MalformedRequestError) Error() string {
 // WriteResponse encodes the specified response object as JSON and then writes it as a response to the supplied response writer, along with the supplied status.
 // If there are any errors in encoding or writing, an entry is written to the logs, and an internal server error is written to the page instead.
 func WriteResponse(w http.ResponseWriter, status int, response interface{}) {
+	// Headers set after the status is written are never sent.
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
-	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
 		log.Error(fmt.Sprintf("failed to encode json: %s", err.Error()))
@@ -140,8 +155,7 @@ func Decode(w http.ResponseWriter, r *http.Request, destination interface{}, max
 			return err
 		}
 	} else { // Json was decoded successfully
-		// TODO: want to rewrite so that multiple json objects of the same type can be decoded
-		// will need to change some other stuff downstream, for example update returns the updated struct, will need to return multiple in this case
+		// Many records are sent as a single batch object instead, see the batch endpoints.
 		err = decoder.Decode(&struct{}{}) // if request body only contained a single JSON object this will return io.EOF error
 		if err != io.EOF {
 			msg := "Request body must only contain a single JSON object"
@@ -174,12 +188,30 @@ func NewResponseWithError(code int, msg string) Response {
 	return Response{Error: &ErrorResponse{Code: code, Message: msg}}
 }
 
+// WriteErrorResponse writes an error response with the supplied status and message to the supplied response writer, and logs the supplied log message. (or the error message if there is none)
 func WriteErrorResponse(w http.ResponseWriter, status int, errMsg string, logMsg ...string) {
+	WriteError(w, Error{Status: status, Message: errMsg}, logMsg...)
+}
+
+// WriteTypedErrorResponse writes an error response of the supplied type, with the supplied status and message, to the supplied response writer,
+// and logs the supplied log message. (or the error message if there is none)
+func WriteTypedErrorResponse(w http.ResponseWriter, status int, errType ErrorType, errMsg string, logMsg ...string) {
+	WriteError(w, Error{Status: status, Type: errType, Message: errMsg}, logMsg...)
+}
+
+// WriteError writes the supplied error as a response to the supplied response writer, and logs the supplied log message. (or the error message if there is none)
+// The error is written in the problem details format if the client asked for it (see NegotiateErrorFormat), otherwise as a standard response.
+func WriteError(w http.ResponseWriter, e Error, logMsg ...string) {
 	if logMsg != nil {
 		log.Error(logMsg)
 	} else {
-		log.Error(errMsg)
+		log.Error(e.Message)
+	}
+	if pw := getProblemResponseWriter(w); pw != nil {
+		w.Header().Set("Content-Type", ProblemMediaType)
+		WriteResponse(w, e.Status, newProblem(e, pw.requestID))
+		return
 	}
-	r := Response{Error: &ErrorResponse{Code: status, Message: errMsg}}
+	r := Response{Error: &ErrorResponse{Code: e.Status, Message: e.Message}}
 	WriteResponse(w, r.Error.Code, r)
 }
@@ -0,0 +1,140 @@
+package json
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/golang/gddo/httputil/header"
+	httputils "github.com/tragicpixel/fruitbar/pkg/utils/http"
+)
+
+// ProblemMediaType is the media type of error responses in the RFC 7807 problem details format.
+const ProblemMediaType = "application/problem+json"
+
+// Prefix of the type URI of every problem type of the application, relative to the service it was returned by.
+const problemTypeBasePath = "/problems/"
+
+// ErrorType identifies a kind of error the application responds with, so clients can tell errors apart without reading their messages.
+// The zero value is an error with no more meaning than its HTTP status code.
+type ErrorType struct {
+	// Name of the type, making up the end of its problem type URI. (e.g. order-not-found)
+	Name string
+	// Short summary of the type, the same for every error of the type.
+	Title string
+}
+
+// URI returns the problem type URI of the error type.
+func (t ErrorType) URI() string {
+	if t.Name == "" {
+		return "about:blank"
+	}
+	return problemTypeBasePath + t.Name
+}
+
+// Error holds an error response of the application, independently of the format it is sent in.
+type Error struct {
+	// HTTP status code of the response.
+	Status int
+	// Kind of error. (optional)
+	Type ErrorType
+	// Error message, specific to this occurrence of the error.
+	Message string
+	// Parameters of the request that were invalid, and why. (optional)
+	InvalidParams []InvalidParam
+}
+
+// Problem holds an error response in the RFC 7807 problem details format.
+type Problem struct {
+	// URI identifying the kind of error. (about:blank when the HTTP status code says it all)
+	Type string `json:"type"`
+	// Short summary of the kind of error.
+	Title string `json:"title"`
+	// HTTP status code of the response.
+	Status int `json:"status"`
+	// Explanation of this occurrence of the error.
+	Detail string `json:"detail,omitempty"`
+	// ID of the request that failed. (see the X-Request-ID header)
+	Instance string `json:"instance,omitempty"`
+	// Parameters of the request that were invalid, and why.
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// InvalidParam holds the reason a single parameter of a request, such as a field of a record, is invalid.
+type InvalidParam struct {
+	// Name of the parameter. Fields nested in objects are named after the objects as well. (e.g. paymentinfo.cardinfo.cvv)
+	Name string `json:"name"`
+	// Why the parameter is invalid.
+	Reason string `json:"reason"`
+}
+
+// problemResponseWriter is an http response writer for a client that wants error responses in the problem details format.
+type problemResponseWriter struct {
+	http.ResponseWriter
+	// ID of the request being responded to.
+	requestID string
+}
+
+// Unwrap returns the http response writer the problem response writer wraps.
+func (w *problemResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// NegotiateErrorFormat makes the error responses to requests passing through it use the problem details format,
+// if the request's Accept header prefers application/problem+json to application/json. Otherwise the standard response is kept.
+// Must be called after httputils.RequestID, as problems name the request they occurred in.
+func NegotiateErrorFormat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if prefersProblems(r) {
+			w = &problemResponseWriter{ResponseWriter: w, requestID: httputils.GetRequestID(r)}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// prefersProblems determines whether the Accept header of the supplied http request explicitly prefers application/problem+json to application/json.
+func prefersProblems(r *http.Request) bool {
+	problemQ, jsonQ := 0.0, 0.0
+	for _, spec := range header.ParseAccept(r.Header, "Accept") {
+		switch strings.ToLower(spec.Value) {
+		case ProblemMediaType:
+			if spec.Q > problemQ {
+				problemQ = spec.Q
+			}
+		case "application/json":
+			if spec.Q > jsonQ {
+				jsonQ = spec.Q
+			}
+		}
+	}
+	return problemQ > 0 && problemQ >= jsonQ
+}
+
+// getProblemResponseWriter returns the problem response writer the supplied http response writer is, or wraps, if any.
+func getProblemResponseWriter(w http.ResponseWriter) *problemResponseWriter {
+	for {
+		switch writer := w.(type) {
+		case *problemResponseWriter:
+			return writer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// newProblem returns the supplied error in the problem details format, as an occurrence in the request with the supplied id.
+func newProblem(e Error, requestID string) Problem {
+	title := e.Type.Title
+	if title == "" {
+		title = http.StatusText(e.Status)
+	}
+	return Problem{
+		Type:          e.Type.URI(),
+		Title:         title,
+		Status:        e.Status,
+		Detail:        e.Message,
+		Instance:      requestID,
+		InvalidParams: e.InvalidParams,
+	}
+}
@@ -0,0 +1,401 @@
+// Package jsonpatch provides functions for applying JSON Merge Patch (RFC 7396) and JSON Patch (RFC 6902) documents to JSON documents.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Media type of a JSON Merge Patch document.
+	MergePatchMediaType = "application/merge-patch+json"
+	// Media type of a JSON Patch document.
+	JSONPatchMediaType = "application/json-patch+json"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch document is malformed: badly-formed JSON, an unknown operation or an invalid path.
+	ErrInvalidPatch = errors.New("invalid patch")
+	// ErrPatchConflict is returned when a well-formed patch can't be applied to the document: a path doesn't exist or a test operation failed.
+	ErrPatchConflict = errors.New("patch can't be applied")
+)
+
+// operation holds a single operation of a JSON Patch document.
+type operation struct {
+	Op   string  `json:"op"`
+	Path *string `json:"path"`
+	From *string `json:"from"`
+	// Left as-is until the operation is applied, so a null value can be told apart from a missing one.
+	Value json.RawMessage `json:"value"`
+}
+
+// MergePatch returns the supplied JSON document with the supplied JSON Merge Patch applied.
+// Members of the patch set to null are removed from the document, objects are merged recursively and any other value replaces the existing one.
+func MergePatch(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decode(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err.Error())
+	}
+	return json.Marshal(mergePatch(target, p))
+}
+
+// Apply returns the supplied JSON document with the operations of the supplied JSON Patch applied in order.
+// If any operation fails, none of them are applied.
+func Apply(doc []byte, patch []byte) ([]byte, error) {
+	target, err := decode(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: a JSON Patch must be an array of operations: %s", ErrInvalidPatch, err.Error())
+	}
+	for i, op := range ops {
+		if target, err = applyOperation(target, op); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+// ChangedFields returns the names of the top-level members that differ between the supplied JSON objects, in sorted order.
+// A member missing from one of the objects counts as changed.
+func ChangedFields(before []byte, after []byte) ([]string, error) {
+	var b, a map[string]interface{}
+	if err := json.Unmarshal(before, &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &a); err != nil {
+		return nil, err
+	}
+	var changed []string
+	for name, value := range b {
+		if other, ok := a[name]; !ok || !reflect.DeepEqual(value, other) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range a {
+		if _, ok := b[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// decode decodes the supplied JSON document into generic values.
+func decode(doc []byte) (interface{}, error) {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	// Keep numbers as they were written, so large ids survive the round trip.
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("document must only contain a single JSON value")
+	}
+	return v, nil
+}
+
+// mergePatch returns the supplied target with the supplied merge patch applied.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+	return t
+}
+
+// applyOperation returns the supplied document with the supplied JSON Patch operation applied.
+func applyOperation(doc interface{}, op operation) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", ErrInvalidPatch)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if len(op.Value) == 0 {
+			return nil, fmt.Errorf("%w: missing value", ErrInvalidPatch)
+		}
+		if value, err = decode(op.Value); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err.Error())
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", ErrInvalidPatch)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && isProperPrefix(from, path) {
+			return nil, fmt.Errorf("%w: can't move a value into one of its own children", ErrInvalidPatch)
+		}
+		if value, err = get(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		return add(doc, path, value)
+	case "remove":
+		return remove(doc, path)
+	case "replace":
+		if _, err := get(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	case "test":
+		existing, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(existing, value) {
+			return nil, fmt.Errorf("%w: test failed at %q", ErrPatchConflict, *op.Path)
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer returns the reference tokens of the supplied JSON Pointer (RFC 6901). The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with '/'", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		// Order matters: "~01" is "~1", not "/".
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// isProperPrefix returns whether the supplied path refers to a child of the supplied prefix.
+func isProperPrefix(prefix []string, path []string) bool {
+	if len(prefix) >= len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// arrayIndex returns the index referred to by the supplied token in an array of the supplied length.
+// When adding, the index may be one past the end, and "-" refers to the end of the array.
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return -1, fmt.Errorf("%w: %q is not an array index", ErrInvalidPatch, token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i > length || (i == length && !adding) {
+		return -1, fmt.Errorf("%w: array index %s is out of bounds", ErrPatchConflict, token)
+	}
+	return i, nil
+}
+
+// get returns the value at the supplied path of the supplied document.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: member %q doesn't exist", ErrPatchConflict, token)
+			}
+			doc = value
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[i]
+		default:
+			return nil, fmt.Errorf("%w: can't refer to %q inside a value that isn't an object or array", ErrPatchConflict, token)
+		}
+	}
+	return doc, nil
+}
+
+// add returns the supplied document with the supplied value added at the supplied path.
+// An existing object member is replaced, and a value added to an array is inserted at the index.
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return change(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("%w: can't add %q to a value that isn't an object or array", ErrPatchConflict, token)
+		}
+	})
+}
+
+// remove returns the supplied document with the value at the supplied path removed.
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: can't remove the whole document", ErrInvalidPatch)
+	}
+	return change(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("%w: member %q doesn't exist", ErrPatchConflict, token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("%w: can't remove %q from a value that isn't an object or array", ErrPatchConflict, token)
+		}
+	})
+}
+
+// change returns the supplied document with the parent of the value at the supplied (non-empty) path replaced by the result of the supplied function,
+// which is given the parent and the last token of the path.
+func change(doc interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = change(child, path[1:], fn); err != nil {
+		return nil, err
+	}
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(node), false)
+		node[i] = child
+	}
+	return doc, nil
+}
+
+// deepCopy returns a copy of the supplied value that shares no objects or arrays with it.
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for name, elem := range v {
+			c[name] = deepCopy(elem)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, elem := range v {
+			c[i] = deepCopy(elem)
+		}
+		return c
+	default:
+		return value
+	}
+}
+
+// equal returns whether the supplied values are equal JSON values. Numbers are compared by value, so 1 and 1.0 are equal.
+func equal(a interface{}, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		xf, xerr := x.Float64()
+		yf, yerr := y.Float64()
+		return xerr == nil && yerr == nil && xf == yf
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for name, elem := range x {
+			other, ok := y[name]
+			if !ok || !equal(elem, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
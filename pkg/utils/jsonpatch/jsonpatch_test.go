@@ -0,0 +1,152 @@
+package jsonpatch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const testOrder = `{"ID":7,"ownerid":3,"taxrate":0.1,"paymentinfo":{"cash":false,"cardinfo":{"number":"4111","zipcode":"12345"}},"items":[{"ID":1,"productid":2,"quantity":1},{"ID":2,"productid":5,"quantity":4}]}`
+
+func TestMergePatch(t *testing.T) {
+	tests := map[string]struct {
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		"replace member":      {doc: `{"a":1,"b":2}`, patch: `{"a":3}`, want: `{"a":3,"b":2}`},
+		"add member":          {doc: `{"a":1}`, patch: `{"b":"x"}`, want: `{"a":1,"b":"x"}`},
+		"remove member":       {doc: `{"a":1,"b":2}`, patch: `{"b":null}`, want: `{"a":1}`},
+		"merge nested":        {doc: `{"a":{"b":1,"c":2}}`, patch: `{"a":{"c":null,"d":3}}`, want: `{"a":{"b":1,"d":3}}`},
+		"replace array":       {doc: `{"a":[1,2,3]}`, patch: `{"a":[4]}`, want: `{"a":[4]}`},
+		"object over scalar":  {doc: `{"a":1}`, patch: `{"a":{"b":null,"c":2}}`, want: `{"a":{"c":2}}`},
+		"non-object patch":    {doc: `{"a":1}`, patch: `[1]`, want: `[1]`},
+		"large number intact": {doc: `{"id":9007199254740993}`, patch: `{}`, want: `{"id":9007199254740993}`},
+		"malformed":           {doc: `{"a":1}`, patch: `{"a":`, wantErr: ErrInvalidPatch},
+		"trailing value":      {doc: `{"a":1}`, patch: `{} {}`, wantErr: ErrInvalidPatch},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("MergePatch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MergePatch() unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MergePatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := map[string]struct {
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		"add member":              {doc: `{"a":1}`, patch: `[{"op":"add","path":"/b","value":[1]}]`, want: `{"a":1,"b":[1]}`},
+		"add replaces":            {doc: `{"a":1}`, patch: `[{"op":"add","path":"/a","value":2}]`, want: `{"a":2}`},
+		"add null":                {doc: `{"a":1}`, patch: `[{"op":"add","path":"/b","value":null}]`, want: `{"a":1,"b":null}`},
+		"insert in array":         {doc: `{"a":[1,3]}`, patch: `[{"op":"add","path":"/a/1","value":2}]`, want: `{"a":[1,2,3]}`},
+		"append to array":         {doc: `{"a":[1]}`, patch: `[{"op":"add","path":"/a/-","value":2}]`, want: `{"a":[1,2]}`},
+		"remove from array":       {doc: `{"a":[1,2,3]}`, patch: `[{"op":"remove","path":"/a/0"}]`, want: `{"a":[2,3]}`},
+		"remove member":           {doc: `{"a":1,"b":2}`, patch: `[{"op":"remove","path":"/b"}]`, want: `{"a":1}`},
+		"replace nested":          {doc: `{"a":{"b":[{"c":1}]}}`, patch: `[{"op":"replace","path":"/a/b/0/c","value":5}]`, want: `{"a":{"b":[{"c":5}]}}`},
+		"replace document":        {doc: `{"a":1}`, patch: `[{"op":"replace","path":"","value":{"b":2}}]`, want: `{"b":2}`},
+		"move":                    {doc: `{"a":{"b":1},"c":{}}`, patch: `[{"op":"move","from":"/a/b","path":"/c/d"}]`, want: `{"a":{},"c":{"d":1}}`},
+		"move in array":           {doc: `{"a":[1,2,3]}`, patch: `[{"op":"move","from":"/a/0","path":"/a/-"}]`, want: `{"a":[2,3,1]}`},
+		"copy is deep":            {doc: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, want: `{"a":{"b":1},"c":{"b":2}}`},
+		"test passes":             {doc: `{"a":[1,{"b":"x"}]}`, patch: `[{"op":"test","path":"/a","value":[1.0,{"b":"x"}]}]`, want: `{"a":[1,{"b":"x"}]}`},
+		"test null":               {doc: `{"a":null}`, patch: `[{"op":"test","path":"/a","value":null}]`, want: `{"a":null}`},
+		"escaped pointer":         {doc: `{"a/b":1,"c~d":2}`, patch: `[{"op":"remove","path":"/a~1b"},{"op":"replace","path":"/c~0d","value":3}]`, want: `{"c~d":3}`},
+		"unknown members ignored": {doc: `{"a":1}`, patch: `[{"op":"remove","path":"/a","note":"x"}]`, want: `{}`},
+
+		"test fails":            {doc: `{"a":1}`, patch: `[{"op":"remove","path":"/a"},{"op":"test","path":"/a","value":1}]`, wantErr: ErrPatchConflict},
+		"test type differs":     {doc: `{"a":1}`, patch: `[{"op":"test","path":"/a","value":"1"}]`, wantErr: ErrPatchConflict},
+		"remove missing":        {doc: `{"a":1}`, patch: `[{"op":"remove","path":"/b"}]`, wantErr: ErrPatchConflict},
+		"replace missing":       {doc: `{"a":1}`, patch: `[{"op":"replace","path":"/b","value":1}]`, wantErr: ErrPatchConflict},
+		"add missing parent":    {doc: `{"a":1}`, patch: `[{"op":"add","path":"/b/c","value":1}]`, wantErr: ErrPatchConflict},
+		"index out of bounds":   {doc: `{"a":[1]}`, patch: `[{"op":"add","path":"/a/2","value":1}]`, wantErr: ErrPatchConflict},
+		"leading zero index":    {doc: `{"a":[1,2]}`, patch: `[{"op":"remove","path":"/a/01"}]`, wantErr: ErrInvalidPatch},
+		"end of array remove":   {doc: `{"a":[1]}`, patch: `[{"op":"remove","path":"/a/-"}]`, wantErr: ErrInvalidPatch},
+		"move into own child":   {doc: `{"a":{"b":1}}`, patch: `[{"op":"move","from":"/a","path":"/a/b/c"}]`, wantErr: ErrInvalidPatch},
+		"unknown operation":     {doc: `{"a":1}`, patch: `[{"op":"increment","path":"/a"}]`, wantErr: ErrInvalidPatch},
+		"missing value":         {doc: `{"a":1}`, patch: `[{"op":"add","path":"/b"}]`, wantErr: ErrInvalidPatch},
+		"missing path":          {doc: `{"a":1}`, patch: `[{"op":"remove"}]`, wantErr: ErrInvalidPatch},
+		"relative path":         {doc: `{"a":1}`, patch: `[{"op":"remove","path":"a"}]`, wantErr: ErrInvalidPatch},
+		"not an array":          {doc: `{"a":1}`, patch: `{"op":"remove","path":"/a"}`, wantErr: ErrInvalidPatch},
+		"remove whole document": {doc: `{"a":1}`, patch: `[{"op":"remove","path":""}]`, wantErr: ErrInvalidPatch},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Apply() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Apply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApply_orderItems(t *testing.T) {
+	patch := `[
+		{"op":"test","path":"/items/1/productid","value":5},
+		{"op":"remove","path":"/items/0"},
+		{"op":"replace","path":"/items/0/quantity","value":2},
+		{"op":"add","path":"/items/-","value":{"productid":9,"quantity":1}}
+	]`
+	got, err := Apply([]byte(testOrder), []byte(patch))
+	if err != nil {
+		t.Fatalf("Apply() unexpected error: %v", err)
+	}
+	changed, err := ChangedFields([]byte(testOrder), got)
+	if err != nil {
+		t.Fatalf("ChangedFields() unexpected error: %v", err)
+	}
+	if want := []string{"items"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("ChangedFields() = %v, want %v", changed, want)
+	}
+	want := `{"ID":7,"items":[{"ID":2,"productid":5,"quantity":2},{"productid":9,"quantity":1}],"ownerid":3,"paymentinfo":{"cardinfo":{"number":"4111","zipcode":"12345"},"cash":false},"taxrate":0.1}`
+	if string(got) != want {
+		t.Errorf("Apply() = %s, want %s", got, want)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	tests := map[string]struct {
+		before string
+		after  string
+		want   []string
+	}{
+		"unchanged":      {before: testOrder, after: testOrder, want: nil},
+		"nested change":  {before: `{"a":{"b":1},"c":2}`, after: `{"a":{"b":2},"c":2}`, want: []string{"a"}},
+		"added, removed": {before: `{"a":1,"b":2}`, after: `{"b":2,"c":3}`, want: []string{"a", "c"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ChangedFields([]byte(tt.before), []byte(tt.after))
+			if err != nil {
+				t.Fatalf("ChangedFields() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChangedFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
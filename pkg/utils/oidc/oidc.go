@@ -0,0 +1,464 @@
+// Package oidc provides an OpenID Connect relying party for signing fruitbar users in through an external identity provider.
+// Only the authorization code flow with PKCE and RS256 signed ID tokens is supported.
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/utils"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Config holds the properties necessary to sign users in through an OpenID Connect identity provider.
+type Config struct {
+	// URL of the identity provider. The discovery document is fetched from IssuerURL + "/.well-known/openid-configuration".
+	IssuerURL string
+	// Client ID registered with the identity provider.
+	ClientID string
+	// Client secret registered with the identity provider. (optional for public clients)
+	ClientSecret string
+	// URL the identity provider sends the user back to after authenticating. Must point at the callback endpoint.
+	RedirectURL string
+	// Scopes to request in addition to "openid".
+	Scopes []string
+	// Name of the ID token claim holding the user's groups or roles at the identity provider.
+	RoleClaim string
+	// Maps values of the role claim to fruitbar roles. The most privileged match wins.
+	RoleMapping map[string]string
+}
+
+const (
+	// Name of environment variable containing the identity provider's issuer URL.
+	issuerURLEnv = "FRUITBAR_OIDC_ISSUER_URL"
+	// Name of environment variable containing the client ID.
+	clientIDEnv = "FRUITBAR_OIDC_CLIENT_ID"
+	// Name of environment variable containing the client secret.
+	clientSecretEnv = "FRUITBAR_OIDC_CLIENT_SECRET"
+	// Name of environment variable containing the redirect URL.
+	redirectURLEnv = "FRUITBAR_OIDC_REDIRECT_URL"
+	// Name of environment variable containing the role claim name.
+	roleClaimEnv = "FRUITBAR_OIDC_ROLE_CLAIM"
+	// Name of environment variable containing the role mapping, as comma separated claim=role pairs.
+	roleMappingEnv = "FRUITBAR_OIDC_ROLE_MAPPING"
+
+	discoveryPath = "/.well-known/openid-configuration"
+
+	// Length of time an authorization request is valid for before the user must start over.
+	authRequestTTL = 10 * time.Minute
+)
+
+// NewConfigFromEnv returns a new OpenID Connect configuration based on the values of environment variables.
+// Returns nil and no error if the issuer URL is not set, meaning OpenID Connect sign in is disabled.
+func NewConfigFromEnv() (*Config, error) {
+	issuer, err := utils.GetEnv(issuerURLEnv)
+	if err != nil {
+		return nil, nil
+	}
+	clientID, err := utils.GetEnv(clientIDEnv)
+	if err != nil {
+		return nil, errors.New("Failed to set OIDC client ID: " + err.Error())
+	}
+	redirectURL, err := utils.GetEnv(redirectURLEnv)
+	if err != nil {
+		return nil, errors.New("Failed to set OIDC redirect URL: " + err.Error())
+	}
+	secret, _ := utils.GetEnv(clientSecretEnv)
+	roleClaim, _ := utils.GetEnv(roleClaimEnv)
+	mappingStr, _ := utils.GetEnv(roleMappingEnv)
+	mapping, err := ParseRoleMapping(mappingStr)
+	if err != nil {
+		return nil, errors.New("Failed to set OIDC role mapping: " + err.Error())
+	}
+	return &Config{
+		IssuerURL:    issuer,
+		ClientID:     clientID,
+		ClientSecret: secret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"profile", "email"},
+		RoleClaim:    roleClaim,
+		RoleMapping:  mapping,
+	}, nil
+}
+
+// ParseRoleMapping parses a role mapping in the format "claimvalue=role,claimvalue=role".
+func ParseRoleMapping(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("role mapping entry is invalid, expected claimvalue=role got %s", pair)
+		}
+		mapping[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// Discovery holds the parts of an OpenID Connect discovery document used by the relying party.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider represents an OpenID Connect identity provider that has been discovered.
+type Provider struct {
+	conf      Config
+	discovery Discovery
+	client    *http.Client
+
+	lock sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+// NewProvider fetches the discovery document for the supplied configuration and returns a provider ready for use.
+func NewProvider(conf Config, client *http.Client) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p := &Provider{conf: conf, client: client, keys: make(map[string]*rsa.PublicKey)}
+	issuer := strings.TrimSuffix(conf.IssuerURL, "/")
+	err := p.getJSON(issuer+discoveryPath, &p.discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document: %s", err.Error())
+	}
+	if strings.TrimSuffix(p.discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document issuer %s does not match configured issuer %s", p.discovery.Issuer, conf.IssuerURL)
+	}
+	if p.discovery.AuthorizationEndpoint == "" || p.discovery.TokenEndpoint == "" || p.discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing a required endpoint")
+	}
+	return p, nil
+}
+
+// Issuer returns the issuer identifier of the provider, as stated in its discovery document.
+func (p *Provider) Issuer() string {
+	return p.discovery.Issuer
+}
+
+// Config returns the configuration the provider was created with.
+func (p *Provider) Config() Config {
+	return p.conf
+}
+
+// AuthRequest holds the secrets generated for a single authorization request, which are needed again to complete it.
+type AuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+	Expires      time.Time
+}
+
+// NewAuthRequest generates the state, nonce and PKCE code verifier for a new authorization request.
+func NewAuthRequest() (*AuthRequest, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	return &AuthRequest{State: state, Nonce: nonce, CodeVerifier: verifier, Expires: time.Now().Add(authRequestTTL)}, nil
+}
+
+// CodeChallenge returns the S256 PKCE code challenge for the supplied code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to in order to authenticate at the provider.
+func (p *Provider) AuthCodeURL(a *AuthRequest) string {
+	scopes := append([]string{"openid"}, p.conf.Scopes...)
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.conf.ClientID)
+	v.Set("redirect_uri", p.conf.RedirectURL)
+	v.Set("scope", strings.Join(scopes, " "))
+	v.Set("state", a.State)
+	v.Set("nonce", a.Nonce)
+	v.Set("code_challenge", CodeChallenge(a.CodeVerifier))
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.discovery.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// TokenResponse holds the response from the provider's token endpoint.
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Exchange trades the supplied authorization code and PKCE code verifier for tokens at the provider's token endpoint.
+func (p *Provider) Exchange(code string, verifier string) (*TokenResponse, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.conf.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.conf.ClientID)
+	req, err := http.NewRequest(http.MethodPost, p.discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.conf.ClientID), url.QueryEscape(p.conf.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	var tokens TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %s", err.Error())
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response does not contain an id_token")
+	}
+	return &tokens, nil
+}
+
+// Claims holds the claims of a verified ID token.
+type Claims map[string]interface{}
+
+// Subject returns the subject identifier of the authenticated user.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+// String returns the value of the supplied claim if it is a string, or an empty string otherwise.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns the value of the supplied claim as a slice of strings. A single string value is returned as a slice of one.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of the supplied raw ID token, and returns its claims.
+func (p *Provider) VerifyIDToken(raw string, nonce string) (Claims, error) {
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg()}}
+	token, err := parser.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.getKey(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id token: %s", err.Error())
+	}
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("couldn't parse claims")
+	}
+	claims := Claims(mapClaims)
+	if claims.String("iss") != p.discovery.Issuer {
+		return nil, fmt.Errorf("id token issuer %s does not match %s", claims.String("iss"), p.discovery.Issuer)
+	}
+	if !utils.IsStringInSlice(p.conf.ClientID, claims.Strings("aud")) {
+		return nil, errors.New("id token audience does not contain the client ID")
+	}
+	if _, ok := mapClaims["exp"]; !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+	if claims.String("nonce") != nonce {
+		return nil, errors.New("id token nonce does not match the authorization request")
+	}
+	if claims.Subject() == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return claims, nil
+}
+
+// Role returns the fruitbar role for the supplied claims, based on the configured role claim and mapping.
+// If more than one value of the role claim is mapped, the most privileged role wins. Defaults to the customer role.
+func (p *Provider) Role(claims Claims) string {
+	role := roles.Customer
+	if p.conf.RoleClaim == "" {
+		return role
+	}
+	for _, value := range claims.Strings(p.conf.RoleClaim) {
+		mapped, ok := p.conf.RoleMapping[value]
+		if !ok || roles.IsValid(mapped) != nil {
+			continue
+		}
+		if outranks, _ := roles.HasRole(mapped, role); outranks {
+			role = mapped
+		}
+	}
+	return role
+}
+
+// getKey returns the provider's signing key with the supplied key ID, refreshing the key set if the key is unknown.
+func (p *Provider) getKey(kid string) (*rsa.PublicKey, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	err := p.refreshKeys()
+	if err != nil {
+		return nil, err
+	}
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("signing key %q not found in provider key set", kid)
+}
+
+// findKey returns the cached key with the supplied ID. If no key ID is supplied, the only cached key is returned.
+// Assumes the lock is held.
+func (p *Provider) findKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// jwk holds a single RSA JSON web key.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// refreshKeys replaces the cached keys with the provider's current key set.
+// Assumes the lock is held.
+func (p *Provider) refreshKeys() error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := p.getJSON(p.discovery.JWKSURI, &set)
+	if err != nil {
+		return fmt.Errorf("failed to fetch provider key set: %s", err.Error())
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("key %q has an invalid modulus: %s", k.Kid, err.Error())
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return fmt.Errorf("key %q has an invalid exponent: %s", k.Kid, err.Error())
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+	return nil
+}
+
+// getJSON fetches the supplied URL and decodes the JSON response body into the supplied destination.
+func (p *Provider) getJSON(url string, destination interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(destination)
+}
+
+// StateStore holds pending authorization requests until the user returns from the provider.
+type StateStore struct {
+	lock     sync.Mutex
+	requests map[string]*AuthRequest
+}
+
+// NewStateStore creates a new, empty store of pending authorization requests.
+func NewStateStore() *StateStore {
+	return &StateStore{requests: make(map[string]*AuthRequest)}
+}
+
+// Save stores the supplied authorization request, and removes any requests that have expired.
+func (s *StateStore) Save(a *AuthRequest) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for state, pending := range s.requests {
+		if now.After(pending.Expires) {
+			delete(s.requests, state)
+		}
+	}
+	s.requests[a.State] = a
+}
+
+// Take removes and returns the pending authorization request with the supplied state.
+// Returns false if there is no such request or it has expired. A request can only be taken once.
+func (s *StateStore) Take(state string) (*AuthRequest, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a, ok := s.requests[state]
+	if !ok {
+		return nil, false
+	}
+	delete(s.requests, state)
+	if time.Now().After(a.Expires) {
+		return nil, false
+	}
+	return a, true
+}
+
+// randomString returns a URL-safe string encoding the supplied number of random bytes.
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %s", err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
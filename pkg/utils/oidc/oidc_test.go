@@ -0,0 +1,146 @@
+package oidc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc/oidctest"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestAuthorizationCodeFlow_oidc(t *testing.T) {
+	m := oidctest.NewProvider(t)
+	p, err := oidc.NewProvider(m.Config(), nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %s", err.Error())
+	}
+	states := oidc.NewStateStore()
+	a, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatalf("error creating auth request: %s", err.Error())
+	}
+	states.Save(a)
+
+	code, state := oidctest.Authorize(t, p.AuthCodeURL(a))
+	pending, ok := states.Take(state)
+	if !ok {
+		t.Fatalf("expected pending auth request for state %s", state)
+	}
+	if _, ok := states.Take(state); ok {
+		t.Errorf("expected auth request to only be taken once")
+	}
+
+	tokens, err := p.Exchange(code, pending.CodeVerifier)
+	if err != nil {
+		t.Fatalf("error exchanging code: %s", err.Error())
+	}
+	claims, err := p.VerifyIDToken(tokens.IDToken, pending.Nonce)
+	if err != nil {
+		t.Fatalf("error verifying id token: %s", err.Error())
+	}
+	if claims.Subject() != "employee-42" {
+		t.Errorf("expected subject %s got %s", "employee-42", claims.Subject())
+	}
+	if role := p.Role(claims); role != roles.Employee {
+		t.Errorf("expected role %s got %s", roles.Employee, role)
+	}
+}
+
+func TestExchangeWrongCodeVerifier_oidc(t *testing.T) {
+	m := oidctest.NewProvider(t)
+	p, err := oidc.NewProvider(m.Config(), nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %s", err.Error())
+	}
+	a, _ := oidc.NewAuthRequest()
+	code, _ := oidctest.Authorize(t, p.AuthCodeURL(a))
+	if _, err := p.Exchange(code, "not-the-verifier"); err == nil {
+		t.Errorf("expected exchange with the wrong code verifier to fail")
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens_oidc(t *testing.T) {
+	m := oidctest.NewProvider(t)
+	p, err := oidc.NewProvider(m.Config(), nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %s", err.Error())
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   m.Server.URL,
+			"aud":   oidctest.ClientID,
+			"sub":   "someone",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+	if _, err := p.VerifyIDToken(m.Sign(valid(), oidctest.KeyID), "nonce"); err != nil {
+		t.Fatalf("expected valid token to verify, got: %s", err.Error())
+	}
+
+	tests := map[string]struct {
+		modify func(jwt.MapClaims)
+		kid    string
+		nonce  string
+	}{
+		"wrong nonce":    {modify: func(c jwt.MapClaims) {}, kid: oidctest.KeyID, nonce: "other"},
+		"wrong issuer":   {modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, kid: oidctest.KeyID, nonce: "nonce"},
+		"wrong audience": {modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, kid: oidctest.KeyID, nonce: "nonce"},
+		"expired":        {modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, kid: oidctest.KeyID, nonce: "nonce"},
+		"no expiry":      {modify: func(c jwt.MapClaims) { delete(c, "exp") }, kid: oidctest.KeyID, nonce: "nonce"},
+		"unknown key":    {modify: func(c jwt.MapClaims) {}, kid: "other-key", nonce: "nonce"},
+	}
+	for name, test := range tests {
+		claims := valid()
+		test.modify(claims)
+		if _, err := p.VerifyIDToken(m.Sign(claims, test.kid), test.nonce); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, valid())
+	signed, _ := hmac.SignedString([]byte("secret"))
+	if _, err := p.VerifyIDToken(signed, "nonce"); err == nil {
+		t.Errorf("expected HS256 token to be rejected")
+	}
+}
+
+func TestRoleMapping_oidc(t *testing.T) {
+	m := oidctest.NewProvider(t)
+	p, err := oidc.NewProvider(m.Config(), nil)
+	if err != nil {
+		t.Fatalf("error discovering provider: %s", err.Error())
+	}
+	tests := []struct {
+		groups   interface{}
+		expected string
+	}{
+		{groups: nil, expected: roles.Customer},
+		{groups: "staff", expected: roles.Customer},
+		{groups: "fruitbar-admins", expected: roles.Admin},
+		{groups: []interface{}{"fruitbar-employees", "fruitbar-admins"}, expected: roles.Admin},
+		{groups: []interface{}{"fruitbar-admins", "fruitbar-employees"}, expected: roles.Admin},
+	}
+	for _, test := range tests {
+		role := p.Role(oidc.Claims{"groups": test.groups})
+		if role != test.expected {
+			t.Errorf("groups %v: expected role %s got %s", test.groups, test.expected, role)
+		}
+	}
+}
+
+func TestParseRoleMapping_oidc(t *testing.T) {
+	mapping, err := oidc.ParseRoleMapping("admins=admin, staff=employee")
+	if err != nil {
+		t.Fatalf("error parsing role mapping: %s", err.Error())
+	}
+	if mapping["admins"] != roles.Admin || mapping["staff"] != roles.Employee {
+		t.Errorf("unexpected role mapping: %v", mapping)
+	}
+	if _, err := oidc.ParseRoleMapping("admins"); err == nil {
+		t.Errorf("expected role mapping without a role to be rejected")
+	}
+}
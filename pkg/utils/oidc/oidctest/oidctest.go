@@ -0,0 +1,151 @@
+// Package oidctest provides a mock OpenID Connect identity provider, for testing the code signing users in through one.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/models/roles"
+	"github.com/tragicpixel/fruitbar/pkg/utils/oidc"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// Client ID the provider's configuration is registered with.
+	ClientID = "fruitbar"
+	// URL the provider sends the user agent back to, according to its configuration.
+	RedirectURL = "http://localhost:8001/users/oidc/callback"
+	// ID of the key the provider signs its ID tokens with.
+	KeyID = "test-key"
+)
+
+// Provider is a minimal OpenID Connect identity provider that authenticates every request as the subject of its claims.
+type Provider struct {
+	Server *httptest.Server
+	// Claims of the ID tokens the provider issues, besides the standard ones. Change them between authorizations to sign in as someone else.
+	Claims jwt.MapClaims
+
+	key   *rsa.PrivateKey
+	lock  sync.Mutex
+	codes map[string]url.Values // authorization request parameters, by issued code
+}
+
+// NewProvider starts a new mock provider, authenticating every request as employee-42, preferred username jdoe,
+// in the fruitbar-employees group. It is stopped when the supplied test ends.
+func NewProvider(t *testing.T) *Provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating key: %s", err.Error())
+	}
+	m := &Provider{key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+	m.Server = httptest.NewServer(mux)
+	m.Claims = jwt.MapClaims{
+		"sub":                "employee-42",
+		"preferred_username": "jdoe",
+		"groups":             []string{"staff", "fruitbar-employees"},
+	}
+	t.Cleanup(m.Server.Close)
+	return m
+}
+
+// Config returns the configuration of a client of the provider, mapping the fruitbar-employees and fruitbar-admins groups to roles.
+func (m *Provider) Config() oidc.Config {
+	return oidc.Config{
+		IssuerURL:   m.Server.URL,
+		ClientID:    ClientID,
+		RedirectURL: RedirectURL,
+		RoleClaim:   "groups",
+		RoleMapping: map[string]string{"fruitbar-employees": roles.Employee, "fruitbar-admins": roles.Admin},
+	}
+}
+
+// Sign returns an ID token holding the supplied claims, signed with the key with the supplied id. (KeyID for the provider's key)
+func (m *Provider) Sign(claims jwt.MapClaims, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, _ := token.SignedString(m.key)
+	return signed
+}
+
+// Authorize sends a user agent to the supplied authorization URL of the provider, and returns the code and state it is redirected back with.
+func Authorize(t *testing.T, authCodeURL string) (code string, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authCodeURL)
+	if err != nil {
+		t.Fatalf("error requesting authorization: %s", err.Error())
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing redirect: %s", err.Error())
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func (m *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(oidc.Discovery{
+		Issuer:                m.Server.URL,
+		AuthorizationEndpoint: m.Server.URL + "/authorize",
+		TokenEndpoint:         m.Server.URL + "/token",
+		JWKSURI:               m.Server.URL + "/jwks",
+	})
+}
+
+func (m *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	m.lock.Lock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = query
+	m.lock.Unlock()
+	redirect := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+func (m *Provider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	m.lock.Lock()
+	authRequest, ok := m.codes[r.Form.Get("code")]
+	delete(m.codes, r.Form.Get("code"))
+	claims := jwt.MapClaims{}
+	for k, v := range m.Claims {
+		claims[k] = v
+	}
+	m.lock.Unlock()
+	if !ok || oidc.CodeChallenge(r.Form.Get("code_verifier")) != authRequest.Get("code_challenge") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	claims["iss"] = m.Server.URL
+	claims["aud"] = []string{authRequest.Get("client_id")}
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["iat"] = time.Now().Unix()
+	claims["nonce"] = authRequest.Get("nonce")
+	json.NewEncoder(w).Encode(oidc.TokenResponse{AccessToken: "access", TokenType: "Bearer", IDToken: m.Sign(claims, KeyID)})
+}
+
+func (m *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string][]map[string]string{"keys": {{
+		"kid": KeyID,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+	}}})
+}
@@ -0,0 +1,238 @@
+// Package openapi describes the application's services in OpenAPI 3.1 documents, built from their route tables and the types of their models,
+// and validates the requests and responses of a service against its document.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// Version of the OpenAPI specification the documents follow.
+const Version = "3.1.0"
+
+// Name of the security scheme of the endpoints that need a JSON Web Token.
+const bearerSecurityScheme = "bearer"
+
+// Locations of the parameters of an operation.
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// Operation describes a single endpoint of a service, in its route table.
+type Operation struct {
+	// Unique name of the operation, used by code generators. (e.g. createOrder)
+	ID string
+	// Tags grouping the operation with others in the docs. (e.g. orders)
+	Tags []string
+	// Short summary of what the operation does.
+	Summary string
+	// Longer explanation of the operation. (optional)
+	Description string
+	// Path, query and header parameters of the operation.
+	Params []Param
+	// Request body of the operation. (nil if it takes none)
+	Body *Body
+	// Possible responses of the operation.
+	Responses []Response
+	// Whether the operation needs a JSON Web Token in the Authorization header.
+	Secured bool
+	// Whether the operation is deprecated, and may be removed.
+	Deprecated bool
+}
+
+// Param describes a path, query or header parameter of an operation.
+type Param struct {
+	Name string
+	// Location of the parameter: InPath, InQuery or InHeader.
+	In          string
+	Description string
+	Required    bool
+	// JSON Schema type of the parameter's value: string or integer.
+	Type string
+}
+
+// Body describes the request body of an operation.
+type Body struct {
+	Description string
+	Required    bool
+	// Media types the body may be sent in. (defaults to application/json)
+	MediaTypes []string
+	// Value of the type the body decodes into. (e.g. models.Order{}) Nil if the body may be any JSON value, such as a patch.
+	Model interface{}
+}
+
+// Response describes a possible response of an operation.
+type Response struct {
+	// HTTP status code of the response. Zero stands for any status not described by the other responses.
+	Status      int
+	Description string
+	// Value of the type the response body is encoded from. (nil if the response has no body)
+	Model interface{}
+}
+
+// Legacy returns the operation as served at a deprecated, unversioned path, which takes the path parameters as optional query parameters instead.
+func (op Operation) Legacy() Operation {
+	legacy := op
+	legacy.ID += "Legacy"
+	legacy.Deprecated = true
+	legacy.Params = make([]Param, len(op.Params))
+	for i, param := range op.Params {
+		if param.In == InPath {
+			param.In = InQuery
+			param.Required = false
+		}
+		legacy.Params[i] = param
+	}
+	return legacy
+}
+
+// Document holds an OpenAPI document, describing the endpoints of a service.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+
+	// Names of the schemas in the components, by the type they describe.
+	schemaNames map[reflect.Type]string
+}
+
+// Info holds metadata about the API described by a document.
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem holds the operations on a single path, by lowercase HTTP method.
+type PathItem map[string]*OperationObject
+
+// OperationObject holds the description of a single operation in a document.
+type OperationObject struct {
+	OperationID string                    `json:"operationId,omitempty"`
+	Tags        []string                  `json:"tags,omitempty"`
+	Summary     string                    `json:"summary,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Parameters  []ParameterObject         `json:"parameters,omitempty"`
+	RequestBody *RequestBodyObject        `json:"requestBody,omitempty"`
+	Responses   map[string]ResponseObject `json:"responses"`
+	Security    []map[string][]string     `json:"security,omitempty"`
+	Deprecated  bool                      `json:"deprecated,omitempty"`
+}
+
+// ParameterObject holds the description of a single parameter of an operation in a document.
+type ParameterObject struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBodyObject holds the description of the request body of an operation in a document.
+type RequestBodyObject struct {
+	Description string                     `json:"description,omitempty"`
+	Required    bool                       `json:"required,omitempty"`
+	Content     map[string]MediaTypeObject `json:"content"`
+}
+
+// ResponseObject holds the description of a possible response of an operation in a document.
+type ResponseObject struct {
+	Description string                     `json:"description"`
+	Content     map[string]MediaTypeObject `json:"content,omitempty"`
+}
+
+// MediaTypeObject holds the schema of a request or response body sent in a given media type.
+type MediaTypeObject struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the schemas and security schemes the operations of a document refer to.
+type Components struct {
+	Schemas         map[string]*Schema              `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecuritySchemeObject `json:"securitySchemes,omitempty"`
+}
+
+// SecuritySchemeObject holds the description of how clients authenticate to the operations of a document.
+type SecuritySchemeObject struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+// NewDocument creates and returns a new, empty OpenAPI document for the API with the supplied title and version.
+func NewDocument(title string, version string) *Document {
+	return &Document{
+		OpenAPI: Version,
+		Info:    Info{Title: title, Version: version},
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]SecuritySchemeObject{
+				bearerSecurityScheme: {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+		schemaNames: map[reflect.Type]string{},
+	}
+}
+
+// AddOperation adds the supplied operation on the supplied method and path to the document, along with the schemas of its models.
+// The error responses of the operation are described in both the standard format and the problem details format. (see json.NegotiateErrorFormat)
+func (d *Document) AddOperation(method string, path string, op Operation) {
+	o := &OperationObject{
+		OperationID: op.ID,
+		Tags:        op.Tags,
+		Summary:     op.Summary,
+		Description: op.Description,
+		Responses:   map[string]ResponseObject{},
+		Deprecated:  op.Deprecated,
+	}
+	for _, param := range op.Params {
+		o.Parameters = append(o.Parameters, ParameterObject{
+			Name:        param.Name,
+			In:          param.In,
+			Description: param.Description,
+			Required:    param.Required || param.In == InPath,
+			Schema:      &Schema{Type: SchemaType{param.Type}},
+		})
+	}
+	if op.Body != nil {
+		mediaTypes := op.Body.MediaTypes
+		if len(mediaTypes) == 0 {
+			mediaTypes = []string{"application/json"}
+		}
+		o.RequestBody = &RequestBodyObject{Description: op.Body.Description, Required: op.Body.Required, Content: map[string]MediaTypeObject{}}
+		for _, mediaType := range mediaTypes {
+			o.RequestBody.Content[mediaType] = MediaTypeObject{Schema: d.SchemaOf(op.Body.Model)}
+		}
+	}
+	for _, response := range op.Responses {
+		status := "default"
+		if response.Status != 0 {
+			status = strconv.Itoa(response.Status)
+		}
+		r := ResponseObject{Description: response.Description}
+		if response.Model != nil {
+			r.Content = map[string]MediaTypeObject{"application/json": {Schema: d.SchemaOf(response.Model)}}
+			if response.Status == 0 || response.Status >= http.StatusBadRequest {
+				r.Content[json.ProblemMediaType] = MediaTypeObject{Schema: d.SchemaOf(json.Problem{})}
+			}
+		}
+		o.Responses[status] = r
+	}
+	if op.Secured {
+		o.Security = []map[string][]string{{bearerSecurityScheme: {}}}
+	}
+
+	item, ok := d.Paths[path]
+	if !ok {
+		item = PathItem{}
+		d.Paths[path] = item
+	}
+	item[strings.ToLower(method)] = o
+}
@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	encjson "encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"gorm.io/gorm"
+)
+
+type testItem struct {
+	gorm.Model
+	Quantity int       `json:"quantity"`
+	Price    float64   `json:"price"`
+	Stock    uint      `json:"stock"`
+	Owner    *testUser `json:"owner,omitempty"`
+	internal string
+}
+
+type testUser struct {
+	Name    string      `json:"name"`
+	Items   []*testItem `json:"items"`
+	Ignored string      `json:"-"`
+	Seen    time.Time   `json:"seen"`
+}
+
+// newTestDocument returns a document with a create, read and list operation on items, the read one taking the id in the path.
+func newTestDocument() *Document {
+	doc := NewDocument("Test API", "v1")
+	responses := []Response{
+		{Status: http.StatusOK, Description: "OK", Model: json.Response{}},
+		{Status: http.StatusBadRequest, Description: "Invalid request.", Model: json.Response{}},
+	}
+	doc.AddOperation(http.MethodPost, "/v1/items", Operation{ID: "createItem", Body: &Body{Required: true, Model: testItem{}}, Responses: responses})
+	doc.AddOperation(http.MethodGet, "/v1/items", Operation{
+		ID:        "listItems",
+		Params:    []Param{{Name: "limit", In: InQuery, Type: "integer"}, {Name: "sort", In: InQuery, Required: true, Type: "string"}},
+		Responses: responses,
+	})
+	read := Operation{ID: "getItem", Params: []Param{{Name: "id", In: InPath, Type: "integer"}}, Responses: responses}
+	doc.AddOperation(http.MethodGet, "/v1/items/{id}", read)
+	doc.AddOperation(http.MethodGet, "/v1/items/batch", Operation{ID: "getBatch", Responses: responses})
+	doc.AddOperation(http.MethodGet, "/items", read.Legacy())
+	return doc
+}
+
+func TestSchemaOf(t *testing.T) {
+	doc := newTestDocument()
+	item := doc.Components.Schemas["testItem"]
+	if item == nil {
+		t.Fatalf("expected testItem schema in components, got %v", doc.Components.Schemas)
+	}
+	tests := map[string]struct {
+		prop  string
+		types []string
+		ref   bool
+	}{
+		"embedded id":   {prop: "ID", types: []string{"integer"}},
+		"embedded time": {prop: "CreatedAt", types: []string{"string"}},
+		"deleted at":    {prop: "DeletedAt", types: []string{"string", "null"}},
+		"int":           {prop: "quantity", types: []string{"integer"}},
+		"float":         {prop: "price", types: []string{"number"}},
+		"pointer":       {prop: "owner", ref: true},
+	}
+	for name, test := range tests {
+		s := item.Properties[test.prop]
+		if s == nil {
+			t.Errorf("%s: expected property %q", name, test.prop)
+			continue
+		}
+		if test.ref {
+			if len(s.AnyOf) != 2 || s.AnyOf[0].Ref != schemaRefPrefix+"testUser" {
+				t.Errorf("%s: expected nullable reference to testUser, got %+v", name, s)
+			}
+			continue
+		}
+		if strings.Join(s.Type, ",") != strings.Join(test.types, ",") {
+			t.Errorf("%s: expected types %v got %v", name, test.types, s.Type)
+		}
+	}
+	if _, ok := item.Properties["internal"]; ok {
+		t.Errorf("expected unexported field to be left out")
+	}
+	if item.Properties["stock"].Minimum == nil {
+		t.Errorf("expected unsigned field to have a minimum")
+	}
+	user := doc.Components.Schemas["testUser"]
+	if user == nil || user.Properties["items"].Items.AnyOf[0].Ref != schemaRefPrefix+"testItem" {
+		t.Errorf("expected testUser to refer back to testItem, got %+v", user)
+	}
+	if _, ok := user.Properties["Ignored"]; ok {
+		t.Errorf("expected field tagged - to be left out")
+	}
+	if _, ok := doc.Paths["/v1/items"]["get"].Responses["400"].Content[json.ProblemMediaType]; !ok {
+		t.Errorf("expected error response to be described as problem details too")
+	}
+	if _, ok := doc.Paths["/v1/items"]["get"].Responses["200"].Content[json.ProblemMediaType]; ok {
+		t.Errorf("expected success response not to be described as problem details")
+	}
+
+	encoded, err := encjson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("failed to encode document: %s", err.Error())
+	}
+	if !strings.Contains(string(encoded), `"openapi":"3.1.0"`) || !strings.Contains(string(encoded), `"type":["string","null"]`) {
+		t.Errorf("unexpected document encoding: %s", encoded)
+	}
+}
+
+func TestLegacy(t *testing.T) {
+	op := newTestDocument().Paths["/items"]["get"]
+	if !op.Deprecated || op.OperationID != "getItemLegacy" {
+		t.Errorf("expected deprecated legacy operation, got %+v", op)
+	}
+	if param := op.Parameters[0]; param.In != InQuery || param.Required {
+		t.Errorf("expected path parameter to become an optional query parameter, got %+v", param)
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	v := NewValidator(newTestDocument())
+	tests := map[string]struct {
+		method  string
+		target  string
+		body    string
+		invalid []string
+	}{
+		"valid body":          {method: http.MethodPost, target: "/v1/items", body: `{"quantity": 2, "owner": {"name": "a"}}`},
+		"case insensitive":    {method: http.MethodPost, target: "/v1/items", body: `{"Quantity": 2, "id": 3}`},
+		"unknown field":       {method: http.MethodPost, target: "/v1/items", body: `{"colour": "red"}`, invalid: []string{"colour"}},
+		"wrong type":          {method: http.MethodPost, target: "/v1/items", body: `{"quantity": "2", "price": 1}`, invalid: []string{"quantity"}},
+		"fractional integer":  {method: http.MethodPost, target: "/v1/items", body: `{"quantity": 2.5}`, invalid: []string{"quantity"}},
+		"negative unsigned":   {method: http.MethodPost, target: "/v1/items", body: `{"stock": -1}`, invalid: []string{"stock"}},
+		"nested":              {method: http.MethodPost, target: "/v1/items", body: `{"owner": {"items": [{"quantity": true}]}}`, invalid: []string{"owner.items[0].quantity"}},
+		"null pointer":        {method: http.MethodPost, target: "/v1/items", body: `{"owner": null}`},
+		"not an object":       {method: http.MethodPost, target: "/v1/items", body: `[1]`, invalid: []string{"body"}},
+		"empty body":          {method: http.MethodPost, target: "/v1/items", body: "", invalid: []string{"body"}},
+		"malformed body":      {method: http.MethodPost, target: "/v1/items", body: `{"quantity"`},
+		"valid query":         {method: http.MethodGet, target: "/v1/items?sort=name&limit=5"},
+		"missing query":       {method: http.MethodGet, target: "/v1/items", invalid: []string{"sort"}},
+		"non-integer query":   {method: http.MethodGet, target: "/v1/items?sort=name&limit=five", invalid: []string{"limit"}},
+		"path param":          {method: http.MethodGet, target: "/v1/items/3"},
+		"literal over param":  {method: http.MethodGet, target: "/v1/items/batch"},
+		"non-integer path":    {method: http.MethodGet, target: "/v1/items/three", invalid: []string{"id"}},
+		"legacy query param":  {method: http.MethodGet, target: "/items?id=3"},
+		"unknown operation":   {method: http.MethodDelete, target: "/v1/items/3"},
+		"unknown path":        {method: http.MethodGet, target: "/v2/items"},
+		"ignored media types": {method: http.MethodPost, target: "/v1/items", body: `quantity=2`},
+	}
+	for name, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if name == "ignored media types" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else if test.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		invalid := v.ValidateRequest(r)
+		var names []string
+		for _, param := range invalid {
+			names = append(names, param.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(test.invalid, ",") {
+			t.Errorf("%s: expected invalid %v got %v", name, test.invalid, invalid)
+		}
+		var rest strings.Builder
+		if r.Body != nil {
+			buf := make([]byte, len(test.body)+1)
+			n, _ := r.Body.Read(buf)
+			rest.Write(buf[:n])
+		}
+		if rest.String() != test.body {
+			t.Errorf("%s: expected body %q to be kept for the handler, got %q", name, test.body, rest.String())
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	v := NewValidator(newTestDocument())
+	tests := map[string]struct {
+		mode     Mode
+		target   string
+		status   int
+		response string
+		want     int
+		errType  string
+	}{
+		"off":                       {mode: Off, target: "/v1/items?limit=x", status: http.StatusOK, response: `{"data": 1}`, want: http.StatusOK},
+		"invalid request":           {mode: ValidateRequests, target: "/v1/items?sort=a&limit=x", status: http.StatusOK, response: `{"data": 1}`, want: http.StatusBadRequest, errType: "/problems/request-invalid"},
+		"responses not checked":     {mode: ValidateRequests, target: "/v1/items?sort=a", status: http.StatusTeapot, response: `{"data": 1}`, want: http.StatusTeapot},
+		"valid response":            {mode: ValidateRequestsAndResponses, target: "/v1/items?sort=a", status: http.StatusOK, response: `{"data": [1], "id": "", "token": "", "error": null}`, want: http.StatusOK},
+		"undocumented status":       {mode: ValidateRequestsAndResponses, target: "/v1/items?sort=a", status: http.StatusTeapot, response: `{"data": 1}`, want: http.StatusInternalServerError, errType: "/problems/response-invalid"},
+		"invalid response body":     {mode: ValidateRequestsAndResponses, target: "/v1/items?sort=a", status: http.StatusOK, response: `{"id": 3}`, want: http.StatusInternalServerError, errType: "/problems/response-invalid"},
+		"unknown response property": {mode: ValidateRequestsAndResponses, target: "/v1/items?sort=a", status: http.StatusOK, response: `{"records": []}`, want: http.StatusInternalServerError, errType: "/problems/response-invalid"},
+	}
+	for name, test := range tests {
+		handler := json.NegotiateErrorFormat(v.Validate(test.mode)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"1"`)
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.response))
+		})))
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		r.Header.Set("Accept", json.ProblemMediaType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: expected status %d got %d", name, test.want, w.Code)
+		}
+		if test.errType == "" {
+			if w.Body.String() != test.response {
+				t.Errorf("%s: expected response %q to be sent as is, got %q", name, test.response, w.Body.String())
+			}
+			continue
+		}
+		var problem json.Problem
+		if err := encjson.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+			t.Errorf("%s: failed to decode problem: %s", name, err.Error())
+			continue
+		}
+		if problem.Type != test.errType || len(problem.InvalidParams) == 0 {
+			t.Errorf("%s: expected %s problem listing the mismatches, got %+v", name, test.errType, problem)
+		}
+		if w.Header().Get("ETag") != "" {
+			t.Errorf("%s: expected headers of the replaced response to be dropped", name)
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := map[string]struct {
+		want Mode
+		err  bool
+	}{
+		"":         {want: Off},
+		"off":      {want: Off},
+		"requests": {want: ValidateRequests},
+		"TEST":     {want: ValidateRequestsAndResponses},
+		"all":      {err: true},
+	}
+	for name, test := range tests {
+		mode, err := ParseMode(name)
+		if (err != nil) != test.err || mode != test.want {
+			t.Errorf("%q: expected mode %d (error %t) got %d (%v)", name, test.want, test.err, mode, err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package openapi
+
+import (
+	encjson "encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Schema holds a JSON Schema, describing the JSON values of a type. Only the keywords the application's types need are supported.
+type Schema struct {
+	// Reference to a schema in the components of the document. (e.g. #/components/schemas/Order)
+	Ref    string     `json:"$ref,omitempty"`
+	Type   SchemaType `json:"type,omitempty"`
+	Format string     `json:"format,omitempty"`
+	// Minimum value of a number.
+	Minimum    *float64           `json:"minimum,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	// Schema of the properties of an object not listed in its properties, or false if there may be none.
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	Items                *Schema     `json:"items,omitempty"`
+	// Schemas the value must match at least one of.
+	AnyOf []*Schema `json:"anyOf,omitempty"`
+}
+
+// SchemaType holds the JSON types a value may have. A single type is encoded as a string, as is usual.
+type SchemaType []string
+
+// MarshalJSON encodes the schema type as a string if it holds a single type, otherwise as an array.
+func (t SchemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return encjson.Marshal(t[0])
+	}
+	return encjson.Marshal([]string(t))
+}
+
+// Prefix of the references to the schemas in the components of a document.
+const schemaRefPrefix = "#/components/schemas/"
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	rawJSONType   = reflect.TypeOf(encjson.RawMessage{})
+	marshalerType = reflect.TypeOf((*encjson.Marshaler)(nil)).Elem()
+)
+
+// SchemaOf returns the schema of the JSON encoding of the supplied value's type, adding the schemas of the named structs it holds to the components of the document.
+func (d *Document) SchemaOf(model interface{}) *Schema {
+	return d.schemaOf(reflect.TypeOf(model))
+}
+
+// schemaOf returns the schema of the JSON encoding of values of the supplied type.
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	switch {
+	case t == nil, t == rawJSONType:
+		return &Schema{}
+	case t == timeType:
+		return &Schema{Type: SchemaType{"string"}, Format: "date-time"}
+	case t == deletedAtType:
+		return &Schema{Type: SchemaType{"string", "null"}, Format: "date-time"}
+	case t.Kind() != reflect.Ptr && (t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType)):
+		// Types encoding themselves may be encoded as anything.
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(d.schemaOf(t.Elem()))
+	case reflect.Bool:
+		return &Schema{Type: SchemaType{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: SchemaType{"integer"}}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min := 0.0
+		return &Schema{Type: SchemaType{"integer"}, Minimum: &min}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: SchemaType{"number"}}
+	case reflect.String:
+		return &Schema{Type: SchemaType{"string"}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: SchemaType{"string"}, Format: "byte"}
+		}
+		// Nil slices are encoded as null.
+		return &Schema{Type: SchemaType{"array", "null"}, Items: d.schemaOf(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: SchemaType{"array"}, Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: SchemaType{"object", "null"}, AdditionalProperties: d.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return &Schema{Ref: schemaRefPrefix + d.componentName(t)}
+	default: // interfaces
+		return &Schema{}
+	}
+}
+
+// componentName returns the name of the schema of the supplied named struct type in the components of the document, adding it if it is not there yet.
+// Types with the same name in different packages are told apart by the name of their package.
+func (d *Document) componentName(t reflect.Type) string {
+	if name, ok := d.schemaNames[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := d.Components.Schemas[name]; taken {
+		name = path.Base(t.PkgPath()) + name
+	}
+	d.schemaNames[t] = name
+	// Reserve the name first, so types referring to themselves refer to the schema being built.
+	d.Components.Schemas[name] = &Schema{}
+	*d.Components.Schemas[name] = *d.structSchema(t)
+	return name
+}
+
+// structSchema returns the schema of the JSON encoding of the supplied struct type, as an object holding its exported fields.
+// Fields of embedded structs without a JSON name are promoted, as encoding/json does.
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: SchemaType{"object"}, Properties: map[string]*Schema{}, AdditionalProperties: false}
+	d.addFields(s, t)
+	return s
+}
+
+// addFields adds the exported fields of the supplied struct type to the properties of the supplied object schema.
+func (d *Document) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				d.addFields(s, fieldType)
+				continue
+			}
+		}
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = d.schemaOf(field.Type)
+	}
+}
+
+// nullable returns the supplied schema, allowing null values as well.
+func nullable(s *Schema) *Schema {
+	switch {
+	case s.Ref != "":
+		return &Schema{AnyOf: []*Schema{s, {Type: SchemaType{"null"}}}}
+	case len(s.Type) == 0 || hasType(s, "null"):
+		return s
+	default:
+		s.Type = append(s.Type, "null")
+		return s
+	}
+}
+
+// hasType determines whether the supplied schema allows values of the supplied JSON type.
+func hasType(s *Schema, jsonType string) bool {
+	for _, t := range s.Type {
+		if t == jsonType {
+			return true
+		}
+	}
+	return false
+}
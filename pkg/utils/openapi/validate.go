@@ -0,0 +1,414 @@
+package openapi
+
+import (
+	"bytes"
+	encjson "encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/gddo/httputil/header"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+	"github.com/tragicpixel/fruitbar/pkg/utils/log"
+)
+
+// Mode is how much of the traffic of a service is validated against its document.
+type Mode int
+
+const (
+	// Nothing is validated.
+	Off Mode = iota
+	// Requests are validated, and those not matching the document are refused.
+	ValidateRequests
+	// Requests and responses are validated, and responses not matching the document are replaced with an error. Only meant for tests.
+	ValidateRequestsAndResponses
+)
+
+// ParseMode returns the validation mode with the supplied name: off (or empty), requests, or test.
+func ParseMode(name string) (Mode, error) {
+	switch strings.ToLower(name) {
+	case "", "off":
+		return Off, nil
+	case "requests":
+		return ValidateRequests, nil
+	case "test":
+		return ValidateRequestsAndResponses, nil
+	default:
+		return Off, fmt.Errorf("unknown OpenAPI validation mode '%s', must be one of: off, requests, test", name)
+	}
+}
+
+// Types of the errors sent when a request or response doesn't match the document.
+var (
+	requestInvalid  = json.ErrorType{Name: "request-invalid", Title: "The request does not match the API description."}
+	responseInvalid = json.ErrorType{Name: "response-invalid", Title: "The response does not match the API description."}
+)
+
+// Validator validates requests and responses against the operations of a document.
+type Validator struct {
+	doc    *Document
+	routes []validatorRoute
+}
+
+// validatorRoute holds an operation of a document, along with the segments of its path.
+type validatorRoute struct {
+	method   string
+	segments []string
+	op       *OperationObject
+}
+
+// NewValidator creates and returns a new validator for the supplied document.
+func NewValidator(doc *Document) *Validator {
+	v := &Validator{doc: doc}
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for method, op := range doc.Paths[path] {
+			v.routes = append(v.routes, validatorRoute{method: strings.ToUpper(method), segments: strings.Split(path, "/"), op: op})
+		}
+	}
+	return v
+}
+
+// findOperation returns the operation of the document the supplied http request is for, along with the values of its path parameters.
+// When many paths match, the one with the most literal segments is picked, so /v1/orders/batch is not taken for an order.
+// Returns nil if the document has no such operation.
+func (v *Validator) findOperation(r *http.Request) (*OperationObject, map[string]string) {
+	segments := strings.Split(r.URL.Path, "/")
+	var found *OperationObject
+	var foundParams map[string]string
+	best := -1
+	for _, route := range v.routes {
+		if route.method != r.Method || len(route.segments) != len(segments) {
+			continue
+		}
+		params := map[string]string{}
+		literals := 0
+		matches := true
+		for i, segment := range route.segments {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				params[strings.Trim(segment, "{}")] = segments[i]
+			} else if segment == segments[i] {
+				literals++
+			} else {
+				matches = false
+				break
+			}
+		}
+		if matches && literals > best {
+			found, foundParams, best = route.op, params, literals
+		}
+	}
+	return found, foundParams
+}
+
+// ValidateRequest validates the parameters and body of the supplied http request against the operation it is for,
+// and returns the ones that don't match it. Header parameters are only checked if sent, as the handlers refuse missing ones with a more specific status.
+// The request body is read, and replaced with an identical one.
+func (v *Validator) ValidateRequest(r *http.Request) []json.InvalidParam {
+	op, pathParams := v.findOperation(r)
+	if op == nil {
+		return nil
+	}
+	var invalid []json.InvalidParam
+	query := r.URL.Query()
+	for _, param := range op.Parameters {
+		var value string
+		var sent bool
+		switch param.In {
+		case InPath:
+			value, sent = pathParams[param.Name]
+		case InQuery:
+			value, sent = query.Get(param.Name), query.Get(param.Name) != ""
+		case InHeader:
+			value, sent = r.Header.Get(param.Name), r.Header.Get(param.Name) != ""
+		}
+		if !sent {
+			if param.Required && param.In != InHeader {
+				invalid = append(invalid, json.InvalidParam{Name: param.Name, Reason: fmt.Sprintf("%s parameter is required", param.In)})
+			}
+			continue
+		}
+		if hasType(param.Schema, "integer") {
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				invalid = append(invalid, json.InvalidParam{Name: param.Name, Reason: fmt.Sprintf("%s parameter must be an integer", param.In)})
+			}
+		}
+	}
+	if op.RequestBody != nil {
+		invalid = append(invalid, v.validateRequestBody(r, op.RequestBody)...)
+	}
+	return invalid
+}
+
+// validateRequestBody validates the body of the supplied http request against the supplied request body description, and returns the parts that don't match it.
+// Bodies in media types the operation doesn't take, too large or badly-formed bodies are left to the handler to refuse.
+func (v *Validator) validateRequestBody(r *http.Request, body *RequestBodyObject) []json.InvalidParam {
+	mediaType := "application/json"
+	if r.Header.Get("Content-Type") != "" {
+		mediaType, _ = header.ParseValueAndParams(r.Header, "Content-Type")
+	}
+	content, ok := body.Content[mediaType]
+	if !ok || r.Body == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, json.MAX_BATCH_REQUEST_SIZE_IN_BYTES+1))
+	if err != nil {
+		return nil
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if len(data) > json.MAX_BATCH_REQUEST_SIZE_IN_BYTES {
+		return nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		if body.Required {
+			return []json.InvalidParam{{Name: "body", Reason: "request body is required"}}
+		}
+		return nil
+	}
+	value, ok := decodeJSON(data)
+	if !ok {
+		return nil
+	}
+	return v.validateValue(value, content.Schema, "")
+}
+
+// ValidateResponse validates the supplied response to the supplied http request against the operation the request is for,
+// and returns the parts that don't match it.
+func (v *Validator) ValidateResponse(r *http.Request, status int, h http.Header, body []byte) []json.InvalidParam {
+	op, _ := v.findOperation(r)
+	if op == nil {
+		return nil
+	}
+	response, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		response, ok = op.Responses["default"]
+	}
+	if !ok {
+		return []json.InvalidParam{{Name: "status", Reason: fmt.Sprintf("status %d is not a documented response", status)}}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	if len(response.Content) == 0 {
+		return []json.InvalidParam{{Name: "body", Reason: fmt.Sprintf("status %d is documented without a body", status)}}
+	}
+	mediaType, _ := header.ParseValueAndParams(h, "Content-Type")
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return []json.InvalidParam{{Name: "Content-Type", Reason: fmt.Sprintf("media type '%s' is not documented for status %d", mediaType, status)}}
+	}
+	value, ok := decodeJSON(body)
+	if !ok {
+		return []json.InvalidParam{{Name: "body", Reason: "response body is not valid JSON"}}
+	}
+	return v.validateValue(value, content.Schema, "")
+}
+
+// decodeJSON decodes the supplied JSON value, keeping numbers as they were written. Returns false if it is not valid JSON.
+func decodeJSON(data []byte) (interface{}, bool) {
+	decoder := encjson.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// validateValue validates the supplied decoded JSON value against the supplied schema, and returns the parts of the value that don't match it,
+// named after the supplied name of the value. (e.g. paymentinfo.cardinfo, or empty for the whole body)
+// Object properties are matched case-insensitively, as encoding/json decodes them.
+func (v *Validator) validateValue(value interface{}, s *Schema, name string) []json.InvalidParam {
+	if s.Ref != "" {
+		s = v.doc.Components.Schemas[strings.TrimPrefix(s.Ref, schemaRefPrefix)]
+		if s == nil {
+			return nil
+		}
+	}
+	if len(s.AnyOf) > 0 {
+		var closest []json.InvalidParam
+		for i, option := range s.AnyOf {
+			invalid := v.validateValue(value, option, name)
+			if len(invalid) == 0 {
+				return nil
+			}
+			if i == 0 || len(invalid) < len(closest) {
+				closest = invalid
+			}
+		}
+		return closest
+	}
+
+	paramName := name
+	if paramName == "" {
+		paramName = "body"
+	}
+	if len(s.Type) > 0 && !hasType(s, jsonType(value)) && !(jsonType(value) == "integer" && hasType(s, "number")) {
+		return []json.InvalidParam{{Name: paramName, Reason: "must be of type " + strings.Join(s.Type, " or ")}}
+	}
+
+	switch value := value.(type) {
+	case encjson.Number:
+		if s.Minimum != nil {
+			if n, err := value.Float64(); err == nil && n < *s.Minimum {
+				return []json.InvalidParam{{Name: paramName, Reason: fmt.Sprintf("must be at least %v", *s.Minimum)}}
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			return nil
+		}
+		var invalid []json.InvalidParam
+		for i, item := range value {
+			invalid = append(invalid, v.validateValue(item, s.Items, fmt.Sprintf("%s[%d]", name, i))...)
+		}
+		return invalid
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var invalid []json.InvalidParam
+		for _, key := range keys {
+			propName := key
+			if name != "" {
+				propName = name + "." + key
+			}
+			if prop := property(s, key); prop != nil {
+				invalid = append(invalid, v.validateValue(value[key], prop, propName)...)
+			} else if additional, ok := s.AdditionalProperties.(*Schema); ok {
+				invalid = append(invalid, v.validateValue(value[key], additional, propName)...)
+			} else if s.AdditionalProperties == false {
+				invalid = append(invalid, json.InvalidParam{Name: propName, Reason: "unknown field"})
+			}
+		}
+		return invalid
+	}
+	return nil
+}
+
+// property returns the schema of the property of the supplied object schema with the supplied name, ignoring case. Returns nil if there is none.
+func property(s *Schema, name string) *Schema {
+	if prop, ok := s.Properties[name]; ok {
+		return prop
+	}
+	for propName, prop := range s.Properties {
+		if strings.EqualFold(propName, name) {
+			return prop
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON type of the supplied decoded JSON value. Numbers without a fractional part are integers.
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case encjson.Number:
+		if n, err := value.Float64(); err == nil && n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// Validate returns a middleware validating the requests passing through it, and their responses, against the document as much as the supplied mode says.
+// Invalid requests are refused with a 400 Bad Request listing what doesn't match the document. Invalid responses are logged, and replaced with a
+// 500 Internal Server Error listing the same, so tests catch the document drifting from the handlers. Requests for operations not in the document are let through.
+// Must be called after json.NegotiateErrorFormat, so the errors are sent in the format the client asked for.
+func (v *Validator) Validate(mode Mode) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if mode == Off {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if invalid := v.ValidateRequest(r); len(invalid) > 0 {
+				json.WriteError(w, json.Error{
+					Status:        http.StatusBadRequest,
+					Type:          requestInvalid,
+					Message:       "request does not match the API description: " + describe(invalid),
+					InvalidParams: invalid,
+				})
+				return
+			}
+			if mode != ValidateRequestsAndResponses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			if invalid := v.ValidateResponse(r, rec.status, w.Header(), rec.body.Bytes()); len(invalid) > 0 {
+				for name := range w.Header() {
+					if !strings.HasPrefix(name, "Access-Control-") && name != "X-Request-Id" {
+						w.Header().Del(name)
+					}
+				}
+				msg := fmt.Sprintf("response to %s %s does not match the API description: %s", r.Method, r.URL.Path, describe(invalid))
+				json.WriteError(w, json.Error{Status: http.StatusInternalServerError, Type: responseInvalid, Message: msg, InvalidParams: invalid})
+				return
+			}
+			w.WriteHeader(rec.status)
+			if _, err := w.Write(rec.body.Bytes()); err != nil {
+				log.Error(fmt.Sprintf("failed to write: %s", err.Error()))
+			}
+		})
+	}
+}
+
+// describe returns the supplied invalid parameters as a single line.
+func describe(invalid []json.InvalidParam) string {
+	reasons := make([]string, len(invalid))
+	for i, param := range invalid {
+		reasons[i] = param.Name + ": " + param.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// responseRecorder is an http response writer holding back the response written to it, so it can be validated before it is sent.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// WriteHeader records the supplied status, if none was written before.
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+}
+
+// Write records the supplied part of the response body.
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(b)
+}
+
+// Unwrap returns the http response writer the response recorder wraps.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+// GetPeekSeekOptions returns a copy of the supplied page seek options that fetches one more record than the page holds,
+// so whether there is another page beyond it can be told without counting. Pass the number of records fetched to TrimPage.
+func GetPeekSeekOptions(seek *repository.PageSeekOptions) *repository.PageSeekOptions {
+	peek := *seek
+	peek.RecordLimit++
+	return &peek
+}
+
+// TrimPage returns the bounds of the page within the supplied number of records, fetched with the options from GetPeekSeekOptions,
+// and whether there are more records beyond the page in the direction of the seek.
+func TrimPage(seek *repository.PageSeekOptions, fetched int) (start int, end int, more bool) {
+	if fetched <= seek.RecordLimit {
+		return 0, fetched, false
+	}
+	if seek.Direction == repository.SeekDirectionBefore {
+		// Records fetched before the start of the seek are in sort order, so the extra record is the first one.
+		return fetched - seek.RecordLimit, fetched, true
+	}
+	return 0, seek.RecordLimit, true
+}
+
+// GetPage returns the position of the page of the supplied number of records between the supplied first and last records (nil for an empty page),
+// fetched with the supplied page seek options, out of the supplied total. more is whether there are records beyond the page in the direction of the seek.
+// The cursors of the page are read from the records' fields, as described by the supplied whitelist.
+func GetPage(seek *repository.PageSeekOptions, first interface{}, last interface{}, count int, more bool, total int64, fields map[string]repository.Field) (page *json.Page, err error) {
+	page = &json.Page{Limit: seek.RecordLimit, Count: count, Total: total}
+	if first == nil || last == nil {
+		return page, nil
+	}
+	// Coming from a cursor means there are records on the side of the page it came from.
+	hasNext := more || seek.Direction == repository.SeekDirectionBefore
+	hasPrev := (more && seek.Direction == repository.SeekDirectionBefore) || seek.Direction == repository.SeekDirectionAfter
+	if hasNext {
+		if page.Next, err = EncodeCursor(repository.SeekDirectionAfter, last, seek.Sort, fields); err != nil {
+			return nil, err
+		}
+	}
+	if hasPrev {
+		if page.Prev, err = EncodeCursor(repository.SeekDirectionBefore, first, seek.Sort, fields); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// SetPageLinkHeader sets the Link header (RFC 8288) of the supplied http response writer to the URLs of the pages next to the supplied page,
+// which was requested by the supplied http request.
+func SetPageLinkHeader(w http.ResponseWriter, r *http.Request, page *json.Page) {
+	var links []string
+	if page.Next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, page.Next)))
+	}
+	if page.Prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, page.Prev)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// pageURL returns the URL of the supplied http request, moved to the page at the supplied cursor.
+func pageURL(r *http.Request, cursor string) string {
+	u := *r.URL
+	query := u.Query()
+	query.Del(beforeIdParamName)
+	query.Del(afterIdParamName)
+	query.Set(cursorParamName, cursor)
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
@@ -53,12 +53,35 @@ func GetPageSeekOptionsByName(r *http.Request, beforeIdParam string, afterIdPara
 }
 
 const (
+	// Deprecated: raw ids only mark a position in id order, use cursorParamName instead.
 	beforeIdParamName = "before_id"
-	afterIdParamName  = "after_id"
-	limitParamName    = "limit"
+	// Deprecated: raw ids only mark a position in id order, use cursorParamName instead.
+	afterIdParamName = "after_id"
+	cursorParamName  = "cursor"
+	limitParamName   = "limit"
 )
 
-// GetPageSeekOptions returns the page seek options for the supplied http request and maximum record limit using standardized names for the query parameters.
-func GetPageSeekOptions(r *http.Request, maxLimit int) (opts *repository.PageSeekOptions, err error) {
-	return GetPageSeekOptionsByName(r, beforeIdParamName, afterIdParamName, limitParamName, maxLimit)
+// GetPageSeekOptions returns the page seek options for the supplied http request and maximum record limit using standardized names for the query parameters,
+// including the filter, sort and cursor. Only the supplied fields can be filtered or sorted on.
+func GetPageSeekOptions(r *http.Request, maxLimit int, fields map[string]repository.Field) (opts *repository.PageSeekOptions, err error) {
+	opts, err = GetPageSeekOptionsByName(r, beforeIdParamName, afterIdParamName, limitParamName, maxLimit)
+	if err != nil {
+		return nil, err
+	}
+	if err = GetQueryOptions(r, opts, fields); err != nil {
+		return nil, err
+	}
+	if !r.URL.Query().Has(cursorParamName) {
+		if opts.Direction != repository.SeekDirectionNone && len(opts.Sort) > 0 {
+			return nil, fmt.Errorf("%s and %s can't be used with %s, use %s instead", beforeIdParamName, afterIdParamName, sortParamName, cursorParamName)
+		}
+		return opts, nil
+	}
+	if opts.Direction != repository.SeekDirectionNone {
+		return nil, fmt.Errorf("%s can't be used with %s or %s", cursorParamName, beforeIdParamName, afterIdParamName)
+	}
+	if err = setCursor(opts, r.URL.Query().Get(cursorParamName), fields); err != nil {
+		return nil, err
+	}
+	return opts, nil
 }
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+	"github.com/tragicpixel/fruitbar/pkg/utils/json"
+)
+
+type testRecord struct {
+	ID        uint      `json:"ID"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	Total     float64   `json:"total"`
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	sorts := []repository.Sort{{Field: "total", Descending: true}, {Field: "createdat"}}
+	record := testRecord{ID: 42, CreatedAt: time.Date(2026, 10, 1, 12, 30, 0, 123456000, time.UTC), Total: 20.5}
+	encoded, err := EncodeCursor(repository.SeekDirectionAfter, record, sorts, repository.OrderFields)
+	if err != nil {
+		t.Fatalf("unexpected error encoding cursor: %s", err.Error())
+	}
+
+	opts := &repository.PageSeekOptions{Sort: sorts}
+	if err := setCursor(opts, encoded, repository.OrderFields); err != nil {
+		t.Fatalf("unexpected error decoding cursor: %s", err.Error())
+	}
+	if opts.Direction != repository.SeekDirectionAfter || opts.StartId != 42 {
+		t.Errorf("expected to seek after id 42, got %s %d", opts.Direction, opts.StartId)
+	}
+	if len(opts.StartKeys) != 2 || opts.StartKeys[0] != 20.5 || !opts.StartKeys[1].(time.Time).Equal(record.CreatedAt) {
+		t.Errorf("expected start keys [20.5 %s], got %v", record.CreatedAt, opts.StartKeys)
+	}
+}
+
+func TestCursorNestedKey(t *testing.T) {
+	type payment struct {
+		Cash bool `json:"cash"`
+	}
+	record := struct {
+		ID          uint    `json:"ID"`
+		PaymentInfo payment `json:"paymentInfo"`
+	}{ID: 7, PaymentInfo: payment{Cash: true}}
+	sorts := []repository.Sort{{Field: "cash"}}
+	encoded, err := EncodeCursor(repository.SeekDirectionAfter, record, sorts, repository.OrderFields)
+	if err != nil {
+		t.Fatalf("unexpected error encoding cursor: %s", err.Error())
+	}
+
+	opts := &repository.PageSeekOptions{Sort: sorts}
+	if err := setCursor(opts, encoded, repository.OrderFields); err != nil {
+		t.Fatalf("unexpected error decoding cursor: %s", err.Error())
+	}
+	if opts.StartId != 7 || len(opts.StartKeys) != 1 || opts.StartKeys[0] != true {
+		t.Errorf("expected to seek after id 7 paid in cash, got %d %v", opts.StartId, opts.StartKeys)
+	}
+	if _, err := EncodeCursor(repository.SeekDirectionAfter, testRecord{ID: 1}, sorts, repository.OrderFields); err == nil {
+		t.Errorf("expected an error encoding a cursor for a record without the sort field")
+	}
+}
+
+func TestCursorNullKey(t *testing.T) {
+	record := struct {
+		ID      uint  `json:"ID"`
+		OwnerID *uint `json:"ownerid"`
+	}{ID: 3}
+	sorts := []repository.Sort{{Field: "ownerid"}}
+	encoded, err := EncodeCursor(repository.SeekDirectionAfter, record, sorts, repository.OrderFields)
+	if err != nil {
+		t.Fatalf("unexpected error encoding cursor: %s", err.Error())
+	}
+
+	// The order of a purged owner is sought from a null owner, not from owner 0.
+	opts := &repository.PageSeekOptions{Sort: sorts}
+	if err := setCursor(opts, encoded, repository.OrderFields); err != nil {
+		t.Fatalf("unexpected error decoding cursor: %s", err.Error())
+	}
+	if opts.StartId != 3 || len(opts.StartKeys) != 1 || opts.StartKeys[0] != nil {
+		t.Errorf("expected to seek after id 3 with no owner, got %d %v", opts.StartId, opts.StartKeys)
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	sorts := []repository.Sort{{Field: "total"}}
+	encoded, err := EncodeCursor(repository.SeekDirectionAfter, testRecord{ID: 1, Total: 5}, sorts, repository.OrderFields)
+	if err != nil {
+		t.Fatalf("unexpected error encoding cursor: %s", err.Error())
+	}
+	parts := strings.Split(encoded, cursorSignatureSeparator)
+	forged, _ := EncodeCursor(repository.SeekDirectionAfter, testRecord{ID: 999, Total: 5}, sorts, repository.OrderFields)
+
+	tests := map[string]struct {
+		cursor string
+		sort   []repository.Sort
+	}{
+		"garbage":        {cursor: "not-a-cursor", sort: sorts},
+		"bad signature":  {cursor: parts[0] + cursorSignatureSeparator + "AAAA", sort: sorts},
+		"swapped body":   {cursor: strings.Split(forged, cursorSignatureSeparator)[0] + cursorSignatureSeparator + parts[1], sort: sorts},
+		"different sort": {cursor: encoded, sort: []repository.Sort{{Field: "total", Descending: true}}},
+		"no sort":        {cursor: encoded, sort: nil},
+	}
+	for name, test := range tests {
+		opts := &repository.PageSeekOptions{Sort: test.sort}
+		if err := setCursor(opts, test.cursor, repository.OrderFields); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTrimPage(t *testing.T) {
+	tests := map[string]struct {
+		direction  string
+		fetched    int
+		start, end int
+		more       bool
+	}{
+		"short page":        {direction: repository.SeekDirectionAfter, fetched: 2, start: 0, end: 2, more: false},
+		"full page":         {direction: repository.SeekDirectionAfter, fetched: 3, start: 0, end: 3, more: false},
+		"more after":        {direction: repository.SeekDirectionAfter, fetched: 4, start: 0, end: 3, more: true},
+		"more from start":   {direction: repository.SeekDirectionNone, fetched: 4, start: 0, end: 3, more: true},
+		"more before":       {direction: repository.SeekDirectionBefore, fetched: 4, start: 1, end: 4, more: true},
+		"short page before": {direction: repository.SeekDirectionBefore, fetched: 1, start: 0, end: 1, more: false},
+	}
+	for name, test := range tests {
+		seek := &repository.PageSeekOptions{RecordLimit: 3, Direction: test.direction}
+		start, end, more := TrimPage(seek, test.fetched)
+		if start != test.start || end != test.end || more != test.more {
+			t.Errorf("%s: expected %d-%d more %t, got %d-%d more %t", name, test.start, test.end, test.more, start, end, more)
+		}
+	}
+}
+
+func TestGetPage(t *testing.T) {
+	first, last := testRecord{ID: 1}, testRecord{ID: 3}
+	tests := map[string]struct {
+		direction        string
+		more             bool
+		empty            bool
+		hasNext, hasPrev bool
+	}{
+		"only page":          {direction: repository.SeekDirectionNone, more: false},
+		"first page":         {direction: repository.SeekDirectionNone, more: true, hasNext: true},
+		"middle page":        {direction: repository.SeekDirectionAfter, more: true, hasNext: true, hasPrev: true},
+		"last page":          {direction: repository.SeekDirectionAfter, more: false, hasPrev: true},
+		"going back":         {direction: repository.SeekDirectionBefore, more: true, hasNext: true, hasPrev: true},
+		"back to first page": {direction: repository.SeekDirectionBefore, more: false, hasNext: true},
+		"empty page":         {direction: repository.SeekDirectionAfter, empty: true},
+	}
+	for name, test := range tests {
+		seek := &repository.PageSeekOptions{RecordLimit: 3, Direction: test.direction}
+		var p *json.Page
+		var err error
+		if test.empty {
+			p, err = GetPage(seek, nil, nil, 0, test.more, 0, repository.OrderFields)
+		} else {
+			p, err = GetPage(seek, first, last, 3, test.more, 10, repository.OrderFields)
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err.Error())
+			continue
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/orders?limit=3&after_id=7", nil)
+		SetPageLinkHeader(w, r, p)
+		link := w.Header().Get("Link")
+		if (p.Next != "") != test.hasNext || strings.Contains(link, `rel="next"`) != test.hasNext {
+			t.Errorf("%s: expected next page %t, got %+v (link %q)", name, test.hasNext, p, link)
+		}
+		if (p.Prev != "") != test.hasPrev || strings.Contains(link, `rel="prev"`) != test.hasPrev {
+			t.Errorf("%s: expected previous page %t, got %+v (link %q)", name, test.hasPrev, p, link)
+		}
+		if test.hasNext {
+			u, _ := url.Parse(strings.TrimPrefix(strings.SplitN(link, ">", 2)[0], "<"))
+			if u.Query().Get(cursorParamName) != p.Next || u.Query().Has(afterIdParamName) || u.Query().Get(limitParamName) != "3" {
+				t.Errorf("%s: expected next link to keep the limit and replace after_id with the cursor, got %q", name, link)
+			}
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+const (
+	filterParamName = "filter"
+	sortParamName   = "sort"
+
+	// Separates the conditions in a filter, all of which must match.
+	filterSeparator = ";"
+	// Separates the keys in a sort.
+	sortSeparator = ","
+	// Prefix of a sort key that sorts from the highest value to the lowest.
+	sortDescendingPrefix = "-"
+
+	maxFilterConditions = 10
+	maxSortKeys         = 3
+)
+
+// Operators accepted in a filter condition, with the longer operators first so ">=" isn't read as ">".
+var filterOperators = []repository.FilterOperator{
+	repository.FilterOpGreaterOrEqual,
+	repository.FilterOpLessOrEqual,
+	repository.FilterOpNotEqual,
+	repository.FilterOpGreater,
+	repository.FilterOpLess,
+	repository.FilterOpEqual,
+	repository.FilterOpContains,
+}
+
+// Layouts accepted for the value of a time field.
+var filterTimeLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// GetQueryOptions sets the filters and sort of the supplied page seek options from the filter and sort query parameters of the supplied http request,
+// e.g. ?filter=total>20;createdat>=2026-10-01&sort=-total
+// Only the supplied fields can be filtered or sorted on.
+func GetQueryOptions(r *http.Request, opts *repository.PageSeekOptions, fields map[string]repository.Field) (err error) {
+	query := r.URL.Query()
+	if opts.Filters, err = ParseFilter(query.Get(filterParamName), fields); err != nil {
+		return err
+	}
+	if opts.Sort, err = ParseSort(query.Get(sortParamName), fields); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseFilter parses the supplied filter, a list of conditions such as total>20 separated by semicolons, against the supplied fields.
+// Returns an error if a condition is malformed, or names a field that isn't in the supplied fields.
+func ParseFilter(filter string, fields map[string]repository.Field) ([]repository.Filter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+	conditions := strings.Split(filter, filterSeparator)
+	if len(conditions) > maxFilterConditions {
+		return nil, fmt.Errorf("%s can have at most %d conditions", filterParamName, maxFilterConditions)
+	}
+	filters := make([]repository.Filter, 0, len(conditions))
+	for _, condition := range conditions {
+		f, err := parseFilterCondition(condition, fields)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, *f)
+	}
+	return filters, nil
+}
+
+// ParseSort parses the supplied sort, a list of field names separated by commas and prefixed with - to sort descending, against the supplied fields.
+// Returns an error if a key is malformed or repeated, or names a field that isn't in the supplied fields.
+func ParseSort(sort string, fields map[string]repository.Field) ([]repository.Sort, error) {
+	if sort == "" {
+		return nil, nil
+	}
+	keys := strings.Split(sort, sortSeparator)
+	if len(keys) > maxSortKeys {
+		return nil, fmt.Errorf("%s can have at most %d keys", sortParamName, maxSortKeys)
+	}
+	sorts := make([]repository.Sort, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		s := repository.Sort{Field: strings.ToLower(strings.TrimSpace(key))}
+		if strings.HasPrefix(s.Field, sortDescendingPrefix) {
+			s.Field = strings.TrimPrefix(s.Field, sortDescendingPrefix)
+			s.Descending = true
+		}
+		if _, ok := fields[s.Field]; !ok {
+			return nil, fmt.Errorf("cannot sort by '%s'; valid fields are: %s", s.Field, fieldNames(fields))
+		}
+		if seen[s.Field] {
+			return nil, fmt.Errorf("cannot sort by '%s' more than once", s.Field)
+		}
+		seen[s.Field] = true
+		sorts = append(sorts, s)
+	}
+	return sorts, nil
+}
+
+// parseFilterCondition parses a single filter condition, such as total>20, against the supplied fields.
+func parseFilterCondition(condition string, fields map[string]repository.Field) (*repository.Filter, error) {
+	for _, op := range filterOperators {
+		i := strings.Index(condition, string(op))
+		if i < 1 {
+			continue
+		}
+		// Make sure a shorter operator isn't matched inside a longer one that comes earlier in the condition, e.g. "=" in "a!=b".
+		name := condition[:i]
+		if strings.ContainsAny(name, "<>=!~") {
+			continue
+		}
+		f := repository.Filter{Field: strings.ToLower(strings.TrimSpace(name)), Operator: op}
+		field, ok := fields[f.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot filter by '%s'; valid fields are: %s", f.Field, fieldNames(fields))
+		}
+		value, err := parseFilterValue(field.Type, op, condition[i+len(op):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter condition '%s': %s", condition, err.Error())
+		}
+		f.Value = value
+		return &f, nil
+	}
+	return nil, fmt.Errorf("invalid filter condition '%s'; expected a field, an operator (%s) and a value", condition, operatorNames())
+}
+
+// parseFilterValue converts the supplied value to the supplied field type, checking the supplied operator can be used with it.
+func parseFilterValue(fieldType repository.FieldType, op repository.FilterOperator, value string) (interface{}, error) {
+	if op == repository.FilterOpContains && fieldType != repository.FieldTypeString {
+		return nil, fmt.Errorf("%s can only be used with text fields", op)
+	}
+	switch fieldType {
+	case repository.FieldTypeUint:
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a non-negative integer", value)
+		}
+		return v, nil
+	case repository.FieldTypeFloat:
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", value)
+		}
+		return v, nil
+	case repository.FieldTypeBool:
+		if op != repository.FilterOpEqual && op != repository.FilterOpNotEqual {
+			return nil, fmt.Errorf("%s can't be used with true/false fields", op)
+		}
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not true or false", value)
+		}
+		return v, nil
+	case repository.FieldTypeTime:
+		for _, layout := range filterTimeLayouts {
+			if v, err := time.Parse(layout, value); err == nil {
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("'%s' is not an RFC 3339 time or a yyyy-mm-dd date", value)
+	case repository.FieldTypeString:
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported field type %d", fieldType)
+	}
+}
+
+// fieldNames returns the names of the supplied fields as a comma separated list, in a stable order.
+func fieldNames(fields map[string]repository.Field) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// operatorNames returns the accepted filter operators as a space separated list.
+func operatorNames() string {
+	names := make([]string, len(filterOperators))
+	for i, op := range filterOperators {
+		names[i] = string(op)
+	}
+	return strings.Join(names, " ")
+}
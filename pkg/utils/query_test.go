@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := map[string]struct {
+		filter  string
+		want    []repository.Filter
+		wantErr bool
+	}{
+		"empty": {filter: "", want: nil},
+		"number and date": {
+			filter: "total>20;createdat>=2026-10-01",
+			want: []repository.Filter{
+				{Field: "total", Operator: repository.FilterOpGreater, Value: 20.0},
+				{Field: "createdat", Operator: repository.FilterOpGreaterOrEqual, Value: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		"not equal":           {filter: "ownerid!=3", want: []repository.Filter{{Field: "ownerid", Operator: repository.FilterOpNotEqual, Value: uint64(3)}}},
+		"case insensitive":    {filter: "OwnerID=3", want: []repository.Filter{{Field: "ownerid", Operator: repository.FilterOpEqual, Value: uint64(3)}}},
+		"bool":                {filter: "cash=true", want: []repository.Filter{{Field: "cash", Operator: repository.FilterOpEqual, Value: true}}},
+		"operator in value":   {filter: "name=a>=b", want: []repository.Filter{{Field: "name", Operator: repository.FilterOpEqual, Value: "a>=b"}}},
+		"contains":            {filter: "name~app", want: []repository.Filter{{Field: "name", Operator: repository.FilterOpContains, Value: "app"}}},
+		"unknown field":       {filter: "password=x", wantErr: true},
+		"no operator":         {filter: "total", wantErr: true},
+		"no field":            {filter: ">20", wantErr: true},
+		"bad number":          {filter: "total>lots", wantErr: true},
+		"negative id":         {filter: "ownerid=-1", wantErr: true},
+		"bad date":            {filter: "createdat>yesterday", wantErr: true},
+		"contains non-text":   {filter: "total~2", wantErr: true},
+		"ordered bool":        {filter: "cash>false", wantErr: true},
+		"too many conditions": {filter: "total>1;total>1;total>1;total>1;total>1;total>1;total>1;total>1;total>1;total>1;total>1", wantErr: true},
+	}
+	fields := map[string]repository.Field{
+		"total":     {Column: "total", Type: repository.FieldTypeFloat},
+		"createdat": {Column: "created_at", Type: repository.FieldTypeTime},
+		"ownerid":   {Column: "owner_id", Type: repository.FieldTypeUint},
+		"cash":      {Column: "cash", Type: repository.FieldTypeBool},
+		"name":      {Column: "name", Type: repository.FieldTypeString},
+	}
+	for name, test := range tests {
+		got, err := ParseFilter(test.filter, fields)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", name, test.want, got)
+		}
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := map[string]struct {
+		sort    string
+		want    []repository.Sort
+		wantErr bool
+	}{
+		"empty":         {sort: "", want: nil},
+		"descending":    {sort: "-total", want: []repository.Sort{{Field: "total", Descending: true}}},
+		"several keys":  {sort: "ownerid,-total", want: []repository.Sort{{Field: "ownerid"}, {Field: "total", Descending: true}}},
+		"unknown field": {sort: "-password", wantErr: true},
+		"repeated key":  {sort: "total,-total", wantErr: true},
+		"too many keys": {sort: "total,ownerid,createdat,cash", wantErr: true},
+	}
+	fields := map[string]repository.Field{
+		"total":     {Column: "total", Type: repository.FieldTypeFloat},
+		"createdat": {Column: "created_at", Type: repository.FieldTypeTime},
+		"ownerid":   {Column: "owner_id", Type: repository.FieldTypeUint},
+		"cash":      {Column: "cash", Type: repository.FieldTypeBool},
+	}
+	for name, test := range tests {
+		got, err := ParseSort(test.sort, fields)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %+v, got %+v", name, test.want, got)
+		}
+	}
+}
+
+func TestGetQueryOptions(t *testing.T) {
+	r := httptest.NewRequest("GET", "/orders?filter=total%3E20&sort=-total", nil)
+	seek := &repository.PageSeekOptions{}
+	if err := GetQueryOptions(r, seek, repository.OrderFields); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(seek.Filters) != 1 || seek.Filters[0].Field != "total" {
+		t.Errorf("expected a filter on total, got %+v", seek.Filters)
+	}
+	if len(seek.Sort) != 1 || !seek.Sort[0].Descending {
+		t.Errorf("expected a descending sort on total, got %+v", seek.Sort)
+	}
+}
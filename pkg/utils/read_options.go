@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+const (
+	fieldsParamName  = "fields"
+	includeParamName = "include"
+
+	// Separates the names in the fields and include query parameters.
+	readOptionsSeparator = ","
+	// Separates the relations in an include path, e.g. items.product.
+	includePathSeparator = "."
+)
+
+// ReadOptions holds which fields of the requested records to return, and which related records to embed in them.
+type ReadOptions struct {
+	// Names of the fields to return, as they appear in the whitelist. Empty returns every field.
+	Fields []string
+	// Paths of the related records to embed, e.g. items.product. The parents of a path are always included too.
+	Include []string
+}
+
+// GetReadOptions returns the read options set by the fields and include query parameters of the supplied http request, e.g. ?fields=id,total&include=items.product
+// Only the supplied fields can be selected, and only the supplied relations included.
+// The default relations are included when the include query parameter isn't set; set it empty to include none.
+func GetReadOptions(r *http.Request, fields map[string]repository.Field, relations []string, defaultInclude []string) (*ReadOptions, error) {
+	query := r.URL.Query()
+	opts := &ReadOptions{}
+	for _, name := range splitReadOption(query.Get(fieldsParamName)) {
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("cannot select field '%s'; valid fields are: %s", name, fieldNames(fields))
+		}
+		if !IsStringInSlice(name, opts.Fields) {
+			opts.Fields = append(opts.Fields, name)
+		}
+	}
+
+	include := defaultInclude
+	if query.Has(includeParamName) {
+		include = splitReadOption(query.Get(includeParamName))
+	}
+	for _, path := range include {
+		if !IsStringInSlice(path, relations) {
+			if len(relations) == 0 {
+				return nil, errors.New("there is nothing to include")
+			}
+			return nil, fmt.Errorf("cannot include '%s'; valid relations are: %s", path, strings.Join(relations, ", "))
+		}
+		// Embedding items.product means embedding the items to hold the products.
+		segments := strings.Split(path, includePathSeparator)
+		for i := range segments {
+			parent := strings.Join(segments[:i+1], includePathSeparator)
+			if !IsStringInSlice(parent, opts.Include) {
+				opts.Include = append(opts.Include, parent)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// Includes returns whether the related records at the supplied path should be embedded.
+func (o *ReadOptions) Includes(path string) bool {
+	return IsStringInSlice(path, o.Include)
+}
+
+// Select returns the fields to read from the repository: the selected fields, plus the supplied fields needed to check permissions or load relations.
+// Returns nil, to read every field, if no fields were selected.
+func (o *ReadOptions) Select(required ...string) []string {
+	if len(o.Fields) == 0 {
+		return nil
+	}
+	return append(append([]string{}, o.Fields...), required...)
+}
+
+// GetSparseData returns the supplied records (a slice of records or a single record) with only the fields selected in the supplied read options,
+// plus their ID and any included relations, which are always returned in full. Returns the records unchanged if no fields were selected.
+func GetSparseData(data interface{}, opts *ReadOptions, fields map[string]repository.Field) (interface{}, error) {
+	if len(opts.Fields) == 0 {
+		return data, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.New("failed to encode records: " + err.Error())
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return nil, errors.New("failed to decode records: " + err.Error())
+	}
+
+	// Relations are embedded under the name of the first relation in their path.
+	paths := [][]string{{"id"}}
+	for _, name := range opts.Fields {
+		path := name
+		if fields[name].JSON != "" {
+			path = fields[name].JSON
+		}
+		paths = append(paths, strings.Split(path, includePathSeparator))
+	}
+	for _, include := range opts.Include {
+		paths = append(paths, strings.Split(include, includePathSeparator)[:1])
+	}
+
+	switch records := decoded.(type) {
+	case []interface{}:
+		sparse := make([]interface{}, len(records))
+		for i, record := range records {
+			sparse[i] = sparseRecord(record, paths)
+		}
+		return sparse, nil
+	default:
+		return sparseRecord(records, paths), nil
+	}
+}
+
+// sparseRecord returns a copy of the supplied decoded JSON record holding only the values at the supplied paths. Names are matched ignoring case.
+func sparseRecord(record interface{}, paths [][]string) interface{} {
+	fields, ok := record.(map[string]interface{})
+	if !ok {
+		return record
+	}
+	sparse := make(map[string]interface{})
+	for _, path := range paths {
+		src, dst := fields, sparse
+		for i, segment := range path {
+			key, value, found := lookupJSONField(src, segment)
+			if !found {
+				break
+			}
+			if i == len(path)-1 {
+				dst[key] = value
+				break
+			}
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				break
+			}
+			if _, ok := dst[key].(map[string]interface{}); !ok {
+				dst[key] = make(map[string]interface{})
+			}
+			src, dst = nested, dst[key].(map[string]interface{})
+		}
+	}
+	return sparse
+}
+
+// lookupJSONField returns the key and value of the field in the supplied decoded JSON object with the supplied name, ignoring case.
+func lookupJSONField(fields map[string]interface{}, name string) (key string, value interface{}, found bool) {
+	if value, ok := fields[name]; ok {
+		return name, value, true
+	}
+	for key, value := range fields {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
+
+// splitReadOption returns the lowercased names in the supplied fields or include query parameter.
+func splitReadOption(option string) []string {
+	var names []string
+	for _, name := range strings.Split(option, readOptionsSeparator) {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/tragicpixel/fruitbar/pkg/repository"
+)
+
+func TestGetReadOptions(t *testing.T) {
+	relations := []string{"items", "items.product", "owner"}
+	tests := map[string]struct {
+		query       string
+		wantFields  []string
+		wantInclude []string
+		wantErr     bool
+	}{
+		"defaults":         {query: "", wantInclude: []string{"items"}},
+		"no includes":      {query: "include=", wantInclude: nil},
+		"fields":           {query: "fields=ID,total,total", wantFields: []string{"id", "total"}, wantInclude: []string{"items"}},
+		"nested include":   {query: "include=items.product,owner", wantInclude: []string{"items", "items.product", "owner"}},
+		"unknown field":    {query: "fields=password", wantErr: true},
+		"unknown relation": {query: "include=product", wantErr: true},
+	}
+	for name, test := range tests {
+		r := httptest.NewRequest("GET", "/orders?"+test.query, nil)
+		opts, err := GetReadOptions(r, repository.OrderFields, relations, []string{"items"})
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %+v", name, opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(opts.Fields, test.wantFields) || !reflect.DeepEqual(opts.Include, test.wantInclude) {
+			t.Errorf("%s: expected fields %v include %v, got fields %v include %v", name, test.wantFields, test.wantInclude, opts.Fields, opts.Include)
+		}
+	}
+}
+
+func TestGetSparseData(t *testing.T) {
+	type paymentInfo struct {
+		Cash   bool   `json:"cash"`
+		Number string `json:"number"`
+	}
+	type record struct {
+		ID          uint        `json:"ID"`
+		OwnerID     uint        `json:"ownerid"`
+		Total       float64     `json:"total"`
+		PaymentInfo paymentInfo `json:"paymentinfo"`
+		Items       []int       `json:"items"`
+	}
+	records := []*record{{ID: 1, OwnerID: 2, Total: 9.5, PaymentInfo: paymentInfo{Cash: true, Number: "4111"}, Items: []int{7}}}
+
+	unchanged, err := GetSparseData(records, &ReadOptions{}, repository.OrderFields)
+	if err != nil || !reflect.DeepEqual(unchanged, records) {
+		t.Errorf("expected records to be unchanged without fields, got %+v (%v)", unchanged, err)
+	}
+
+	opts := &ReadOptions{Fields: []string{"total", "cash"}, Include: []string{"items"}}
+	sparse, err := GetSparseData(records, opts, repository.OrderFields)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	want := []interface{}{map[string]interface{}{
+		"ID":          1.0,
+		"total":       9.5,
+		"paymentinfo": map[string]interface{}{"cash": true},
+		"items":       []interface{}{7.0},
+	}}
+	if !reflect.DeepEqual(sparse, want) {
+		t.Errorf("expected %+v, got %+v", want, sparse)
+	}
+}